@@ -11,9 +11,14 @@ import (
 	"github.com/ddvk/rmfakecloud/internal/app/hub"
 	"github.com/ddvk/rmfakecloud/internal/config"
 	"github.com/ddvk/rmfakecloud/internal/hwr"
+	"github.com/ddvk/rmfakecloud/internal/idempotency"
+	"github.com/ddvk/rmfakecloud/internal/jobs"
+	"github.com/ddvk/rmfakecloud/internal/metrics"
 	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/fs"
+	"github.com/ddvk/rmfakecloud/internal/storage/s3"
 	"github.com/ddvk/rmfakecloud/internal/ui"
+	"github.com/ddvk/rmfakecloud/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,6 +29,7 @@ const (
 	syncVersionKey = "SyncVersion"
 	Version10      = 10
 	Version15      = 15
+	rootBlob       = "root"
 )
 
 // App web app
@@ -35,9 +41,23 @@ type App struct {
 	userStorer    storage.UserStorer
 	metaStorer    storage.MetadataStorer
 	blobStorer    storage.BlobStorage
+	blobProvider  storage.BlobProvider
+	auditStorer   storage.AuditStorer
 	hub           *hub.Hub
 	codeConnector CodeConnector
 	hwrClient     *hwr.HWRClient
+	readiness     *readiness
+	idempotency   *idempotency.Store
+	disk          *diskMonitor
+	stop          chan struct{}
+	// gc of the blobs, it fails with ErrorNotSupported when they aren't in the data dir
+	gc garbageCollector
+	// jobs the background jobs, shared with the ui
+	jobs *jobs.Registry
+	// webhooks nil unless a webhook is configured
+	webhooks *webhook.Dispatcher
+	// storage the blob and document routes, it sweeps the abandoned uploads
+	storage *fs.App
 }
 
 // Start starts the app
@@ -53,8 +73,23 @@ func (app *App) Start() {
 			Certificates: []tls.Certificate{
 				app.cfg.Certificate,
 			},
+			MinVersion:   app.cfg.TLSMinVersion,
+			CipherSuites: app.cfg.TLSCipherSuites,
 		}
 	}
+	if app.cfg.ExpiredPurgeAfter > 0 {
+		go app.purgeExpired()
+	}
+	if app.cfg.DiskCheckInterval > 0 {
+		go app.disk.run(app.stop)
+	}
+	if app.webhooks != nil {
+		go app.webhooks.Run(app.stop)
+	}
+	if app.cfg.GCInterval > 0 {
+		go app.scheduleGarbageCollection(app.cfg.GCInterval)
+	}
+	go app.storage.SweepUploads(app.stop)
 	if !app.cfg.TrustProxy {
 		app.router.SetTrustedProxies(nil)
 	}
@@ -83,6 +118,7 @@ func (app *App) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	// app.hub.Stop()
+	close(app.stop)
 	if err := app.srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server Shutdown:", err)
 	}
@@ -136,15 +172,57 @@ func NewApp(cfg *config.Config) App {
 		userStorer:    fsStorage,
 		metaStorer:    fsStorage,
 		blobStorer:    fsStorage,
+		auditStorer:   fsStorage,
 		hub:           ntfHub,
 		codeConnector: codeConnector,
 		hwrClient: &hwr.HWRClient{
 			Cfg: cfg,
 		},
+		readiness:   newReadiness(cfg.ReadyCheckInterval, cfg.ReadyCheckTimeout),
+		idempotency: idempotency.NewStore(cfg.IdempotencyWindow),
+		jobs:        jobs.NewRegistry(),
+		disk:        newDiskMonitor(cfg),
+		stop:        make(chan struct{}),
+	}
+	router.Use(app.disk.middleware())
+	app.readiness.add("filesystem", fsStorage)
+	app.blobProvider = fsStorage
+	app.gc = fsStorage
+	if cfg.StorageProvider == config.StorageProviderS3 {
+		s3Storage, err := s3.NewStorage(cfg.S3Config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Info("Synced blobs and documents are stored in the bucket: ", cfg.S3Config.Bucket)
+		app.blobProvider = fsStorage.LimitToQuota(s3Storage)
+		if len(cfg.EncryptionKey) > 0 {
+			log.Warn("the encryption key only applies to the data dir, the objects in the bucket are not encrypted")
+		}
+		// the ui reads and changes the same tree as the devices
+		fsStorage.SetBlobProvider(s3Storage)
+		app.readiness.add("s3", s3Storage)
+	}
+	uiApp := ui.New(cfg, fsStorage, codeConnector, ntfHub, fsStorage, fsStorage, fsStorage, fsStorage)
+	uiApp.SetJobs(app.jobs)
+	if cfg.WebhookURL != "" {
+		app.webhooks, err = webhook.New(cfg)
+		if err != nil {
+			log.Fatal("webhook: ", err)
+		}
+		fsStorage.RegisterEventListener(app.webhooks)
+		uiApp.SetWebhooks(app.webhooks)
 	}
-	uiApp := ui.New(cfg, fsStorage, codeConnector, ntfHub, fsStorage, fsStorage)
 
-	storageapp := fs.NewApp(cfg, fsStorage)
+	storageapp := fs.NewApp(cfg, fsStorage, app.blobProvider)
+	app.storage = storageapp
+	if cfg.Metrics {
+		registry := metrics.NewRegistry()
+		// before the routes, all of them are measured
+		router.Use(registry.Middleware())
+		storageapp.ExportMetrics(registry)
+		router.GET("/metrics", registry.Handler())
+		log.Info("Prometheus metrics are served at /metrics")
+	}
 
 	app.registerRoutes(router)
 	storageapp.RegisterRoutes(router)
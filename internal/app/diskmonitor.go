@@ -0,0 +1,175 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	diskOK       = "ok"
+	diskLow      = "low"
+	diskCritical = "critical"
+
+	webhookTimeout = 10 * time.Second
+)
+
+// maintenanceExempt writes which don't store anything, still allowed when read only
+var maintenanceExempt = []string{"/token/", "/ui/api/login", "/v1/reports", "/settings/"}
+
+// diskEvent sent to the webhook
+type diskEvent struct {
+	Level       string    `json:"level"`
+	Path        string    `json:"path"`
+	Free        uint64    `json:"free"`
+	Total       uint64    `json:"total"`
+	FreePercent float64   `json:"freePercent"`
+	ReadOnly    bool      `json:"readOnly"`
+	Time        time.Time `json:"time"`
+}
+
+// diskMonitor checks the free space of the data dir
+type diskMonitor struct {
+	cfg    *config.Config
+	client *http.Client
+	// space returns the free and total bytes, diskSpace unless testing
+	space func(path string) (uint64, uint64, error)
+	level string
+	// readOnly 1 while uploads are refused
+	readOnly int32
+}
+
+func newDiskMonitor(cfg *config.Config) *diskMonitor {
+	return &diskMonitor{
+		cfg:    cfg,
+		client: &http.Client{Timeout: webhookTimeout},
+		space:  diskSpace,
+		level:  diskOK,
+	}
+}
+
+func (m *diskMonitor) diskLevel(freePercent float64) string {
+	switch {
+	case freePercent < m.cfg.DiskCriticalPercent:
+		return diskCritical
+	case freePercent < m.cfg.DiskWarnPercent:
+		return diskLow
+	}
+	return diskOK
+}
+
+// check logs while the space is low, the webhook is called when the level changes
+func (m *diskMonitor) check(now time.Time) {
+	free, total, err := m.space(m.cfg.DataDir)
+	if err != nil {
+		log.Error("disk monitor: ", err)
+		return
+	}
+	if total == 0 {
+		return
+	}
+	freePercent := float64(free) * 100 / float64(total)
+	level := m.diskLevel(freePercent)
+
+	readOnly := level == diskCritical && m.cfg.DiskCriticalReadOnly
+	if readOnly {
+		atomic.StoreInt32(&m.readOnly, 1)
+	} else {
+		atomic.StoreInt32(&m.readOnly, 0)
+	}
+
+	msg := fmt.Sprintf("disk monitor: %s has %.1f%% free (%d MB of %d MB)", m.cfg.DataDir, freePercent, free>>20, total>>20)
+	switch level {
+	case diskCritical:
+		if readOnly {
+			msg += ", uploads are refused until there is more space"
+		}
+		log.Error(msg)
+	case diskLow:
+		log.Warn(msg)
+	default:
+		if m.level != diskOK {
+			log.Info(msg)
+		}
+	}
+
+	if level == m.level {
+		return
+	}
+	m.level = level
+	if m.cfg.DiskWebhook == "" {
+		return
+	}
+	err = m.notify(diskEvent{
+		Level:       level,
+		Path:        m.cfg.DataDir,
+		Free:        free,
+		Total:       total,
+		FreePercent: freePercent,
+		ReadOnly:    readOnly,
+		Time:        now.UTC(),
+	})
+	if err != nil {
+		log.Error("disk monitor webhook: ", err)
+	}
+}
+
+func (m *diskMonitor) notify(event diskEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := m.client.Post(m.cfg.DiskWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// run checks at the configured interval, until stop is closed
+func (m *diskMonitor) run(stop <-chan struct{}) {
+	m.check(time.Now())
+	ticker := time.NewTicker(m.cfg.DiskCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.check(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// middleware refuses the writes while the disk is critically low
+func (m *diskMonitor) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if atomic.LoadInt32(&m.readOnly) == 0 {
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		for _, prefix := range maintenanceExempt {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				return
+			}
+		}
+		c.Header("Retry-After", strconv.Itoa(int(m.cfg.DiskCheckInterval.Seconds())))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "the server is low on disk space, uploads are disabled"})
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestDiskMonitor(t *testing.T) {
+	var events []diskEvent
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := diskEvent{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+	}))
+	defer webhook.Close()
+
+	cfg := &config.Config{
+		DataDir:              t.TempDir(),
+		DiskCheckInterval:    time.Minute,
+		DiskWarnPercent:      10,
+		DiskCriticalPercent:  5,
+		DiskWebhook:          webhook.URL,
+		DiskCriticalReadOnly: true,
+	}
+	m := newDiskMonitor(cfg)
+	var free uint64
+	m.space = func(string) (uint64, uint64, error) {
+		return free, 100, nil
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(m.middleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.PUT("/blobstorage", ok)
+	router.GET("/blobstorage", ok)
+	router.POST("/token/json/2/user/new", ok)
+	status := func(method, path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	tests := []struct {
+		free     uint64
+		level    string
+		events   int
+		readOnly bool
+	}{
+		{50, diskOK, 0, false},
+		{8, diskLow, 1, false},
+		{7, diskLow, 1, false},
+		{3, diskCritical, 2, true},
+		{2, diskCritical, 2, true},
+		{40, diskOK, 3, false},
+	}
+	for _, tt := range tests {
+		free = tt.free
+		m.check(time.Now())
+		if m.level != tt.level {
+			t.Errorf("%d%% free: level %s, want %s", tt.free, m.level, tt.level)
+		}
+		if len(events) != tt.events {
+			t.Errorf("%d%% free: %d webhook calls, want %d", tt.free, len(events), tt.events)
+		}
+		wantUpload := http.StatusOK
+		if tt.readOnly {
+			wantUpload = http.StatusServiceUnavailable
+		}
+		if got := status(http.MethodPut, "/blobstorage"); got != wantUpload {
+			t.Errorf("%d%% free: upload %d, want %d", tt.free, got, wantUpload)
+		}
+		if status(http.MethodGet, "/blobstorage") != http.StatusOK || status(http.MethodPost, "/token/json/2/user/new") != http.StatusOK {
+			t.Errorf("%d%% free: downloads and tokens are always allowed", tt.free)
+		}
+	}
+	if events[1].Level != diskCritical || !events[1].ReadOnly {
+		t.Errorf("unexpected event: %+v", events[1])
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package app
+
+import "syscall"
+
+// diskSpace the bytes available to unprivileged users and the size of the volume
+func diskSpace(path string) (free, total uint64, err error) {
+	stat := syscall.Statfs_t{}
+	err = syscall.Statfs(path, &stat)
+	if err != nil {
+		return
+	}
+	free = uint64(stat.Bavail) * uint64(stat.Bsize)
+	total = uint64(stat.Blocks) * uint64(stat.Bsize)
+	return
+}
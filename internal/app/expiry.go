@@ -0,0 +1,55 @@
+package app
+
+import (
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+const purgeInterval = time.Hour
+
+// purgeExpiredUsers removes the accounts which expired longer than the grace period ago
+func (app *App) purgeExpiredUsers(now time.Time) {
+	users, err := app.userStorer.GetUsers()
+	if err != nil {
+		log.Error("purge: ", err)
+		return
+	}
+	for _, u := range users {
+		if u.ExpiresAt.IsZero() || now.Before(u.ExpiresAt.Add(app.cfg.ExpiredPurgeAfter)) {
+			continue
+		}
+		log.Info("purging expired account: ", u.ID, " expired at: ", u.ExpiresAt)
+		err = app.userStorer.RemoveUser(u.ID)
+		if err != nil {
+			log.Error("purge: ", u.ID, err)
+			continue
+		}
+		err = app.auditStorer.RecordAudit(&storage.AuditEntry{
+			Time:     now.UTC(),
+			Actor:    "system",
+			Category: storage.AuditUser,
+			Action:   "purge",
+			Target:   u.ID,
+			Params:   map[string]string{"expiresAt": u.ExpiresAt.Format(time.RFC3339)},
+		})
+		if err != nil {
+			log.Warn("can't record the audit entry: ", err)
+		}
+	}
+}
+
+// purgeExpired checks for expired accounts every hour, until the app stops
+func (app *App) purgeExpired() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			app.purgeExpiredUsers(now)
+		case <-app.stop:
+			return
+		}
+	}
+}
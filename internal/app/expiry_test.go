@@ -0,0 +1,52 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/fs"
+)
+
+func TestPurgeExpiredUsers(t *testing.T) {
+	cfg := &config.Config{DataDir: t.TempDir(), ExpiredPurgeAfter: 24 * time.Hour}
+	cfg.AuditLog = cfg.DataDir + "/audit.log"
+	storer := fs.NewStorage(cfg)
+	app := &App{cfg: cfg, userStorer: storer, auditStorer: storer}
+
+	now := time.Now()
+	users := map[string]time.Time{
+		"forever": {},
+		"active":  now.Add(time.Hour),
+		"grace":   now.Add(-time.Hour),
+		"overdue": now.Add(-48 * time.Hour),
+	}
+	for id, expiresAt := range users {
+		u, err := model.NewUser(id, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.ExpiresAt = expiresAt
+		if err = storer.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app.purgeExpiredUsers(now)
+
+	for id := range users {
+		_, err := storer.GetUser(id)
+		if removed := err != nil; removed != (id == "overdue") {
+			t.Errorf("%s removed: %t", id, removed)
+		}
+	}
+	entries, err := storer.AuditLog(storage.AuditUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != "purge" || entries[0].Target != "overdue" {
+		t.Errorf("unexpected audit entries %+v", entries)
+	}
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/jobs"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// garbageCollector removes the unreachable blobs of a user
+type garbageCollector interface {
+	GarbageCollect(uid string, dryRun bool, progress func(storage.GCStats)) (storage.GCStats, error)
+}
+
+// collectGarbage runs the garbage collection for the sync15 users one after the other, as jobs like the ones
+// started from the ui. The users who are syncing or have a gc running are left for the next run
+func (app *App) collectGarbage(now time.Time) {
+	users, err := app.userStorer.GetUsers()
+	if err != nil {
+		log.Error("gc: ", err)
+		return
+	}
+	for _, u := range users {
+		if !u.Sync15 {
+			continue
+		}
+		uid := u.ID
+		done := make(chan struct{})
+		_, err := app.jobs.Start(jobs.KindGC, uid, func(update func(interface{})) error {
+			defer close(done)
+			return app.collectUserGarbage(uid, now, update)
+		})
+		if err == jobs.ErrorAlreadyRunning {
+			log.Info("gc: ", uid, ": already running, skipped")
+			continue
+		}
+		if err != nil {
+			log.Error("gc: ", uid, ": ", err)
+			continue
+		}
+		<-done
+	}
+}
+
+// collectUserGarbage runs the garbage collection for the user and records what it reclaimed
+func (app *App) collectUserGarbage(uid string, now time.Time, update func(interface{})) error {
+	stats, err := app.gc.GarbageCollect(uid, false, func(stats storage.GCStats) {
+		update(stats)
+	})
+	if err == storage.ErrorSyncInProgress {
+		log.Info("gc: ", uid, ": syncing, skipped")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stats.Reclaimed == 0 {
+		return nil
+	}
+	params := map[string]string{
+		"reclaimed":  strconv.Itoa(stats.Reclaimed),
+		"bytesFreed": strconv.FormatInt(stats.BytesFreed, 10),
+	}
+	if stats.Archive != "" {
+		params["archive"] = stats.Archive
+	}
+	err = app.auditStorer.RecordAudit(&storage.AuditEntry{
+		Time:     now.UTC(),
+		Actor:    "system",
+		Category: storage.AuditMaintenance,
+		Action:   "gc",
+		Target:   uid,
+		Params:   params,
+	})
+	if err != nil {
+		log.Warn("can't record the audit entry: ", err)
+	}
+	return nil
+}
+
+// scheduleGarbageCollection runs the garbage collection every interval, until the app stops
+func (app *App) scheduleGarbageCollection(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			app.collectGarbage(now)
+		case <-app.stop:
+			return
+		}
+	}
+}
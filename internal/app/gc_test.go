@@ -0,0 +1,74 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/jobs"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/fs"
+)
+
+type fakeCollector map[string]error
+
+func (f fakeCollector) GarbageCollect(uid string, dryRun bool, progress func(storage.GCStats)) (storage.GCStats, error) {
+	err, ok := f[uid]
+	if !ok {
+		panic("collected " + uid)
+	}
+	if err != nil {
+		return storage.GCStats{}, err
+	}
+	return storage.GCStats{Scanned: 3, Reclaimed: 2, BytesFreed: 20}, nil
+}
+
+func TestCollectGarbage(t *testing.T) {
+	cfg := &config.Config{DataDir: t.TempDir()}
+	cfg.AuditLog = cfg.DataDir + "/audit.log"
+	storer := fs.NewStorage(cfg)
+	app := &App{cfg: cfg, userStorer: storer, auditStorer: storer, jobs: jobs.NewRegistry(), gc: fakeCollector{
+		"synced":  nil,
+		"syncing": storage.ErrorSyncInProgress,
+	}}
+
+	for id, sync15 := range map[string]bool{"synced": true, "syncing": true, "sync10": false, "busy": true} {
+		u, err := model.NewUser(id, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.Sync15 = sync15
+		if err = storer.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// started from the ui, the scheduled run leaves it
+	release := make(chan struct{})
+	defer close(release)
+	if _, err := app.jobs.Start(jobs.KindGC, "busy", func(func(interface{})) error {
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	app.collectGarbage(time.Now())
+
+	collected := map[string]bool{}
+	for _, job := range app.jobs.List() {
+		collected[job.Key] = job.Kind == jobs.KindGC
+	}
+	if !collected["synced"] || !collected["syncing"] || collected["sync10"] {
+		t.Errorf("unexpected jobs %+v", app.jobs.List())
+	}
+
+	entries, err := storer.AuditLog(storage.AuditMaintenance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Target != "synced" || entries[0].Params["reclaimed"] != "2" {
+		t.Errorf("unexpected audit entries %+v", entries)
+	}
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"mime/multipart"
@@ -18,6 +19,7 @@ import (
 	"github.com/ddvk/rmfakecloud/internal/hwr"
 	"github.com/ddvk/rmfakecloud/internal/integrations"
 	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/models"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
@@ -28,6 +30,8 @@ import (
 const (
 	internalErrorMessage = "Internal Error"
 	handlerLog           = "[handler] "
+	accountExpired       = "account expired"
+	accountDisabled      = "account disabled"
 	// a way to invalidate the user token
 	tokenVersion = 10
 )
@@ -85,6 +89,20 @@ func (app *App) newDevice(c *gin.Context) {
 		return
 	}
 	log.Info("Request: ", tokenRequest, "Token for:", uid)
+	if user, err := app.userStorer.GetUser(uid); err == nil && user != nil {
+		if user.Expired() {
+			log.Warn("account expired: ", uid)
+			c.String(http.StatusForbidden, accountExpired)
+			c.Abort()
+			return
+		}
+		if user.Disabled {
+			log.Warn("account disabled: ", uid)
+			c.String(http.StatusForbidden, accountDisabled)
+			c.Abort()
+			return
+		}
+	}
 
 	// generate the JWT token
 	claims := &DeviceClaims{
@@ -93,6 +111,7 @@ func (app *App) newDevice(c *gin.Context) {
 		UserID:     uid,
 		StandardClaims: jwt.StandardClaims{
 			Audience: APIUsage,
+			IssuedAt: time.Now().Unix(),
 		},
 	}
 
@@ -137,6 +156,23 @@ func (app *App) newUserToken(c *gin.Context) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
+	if user.Expired() {
+		log.Warn("account expired: ", uid)
+		c.String(http.StatusForbidden, accountExpired)
+		c.Abort()
+		return
+	}
+	if user.Disabled {
+		log.Warn("account disabled: ", uid)
+		c.String(http.StatusForbidden, accountDisabled)
+		c.Abort()
+		return
+	}
+	if user.TokenRevoked(deviceToken.IssuedAt) {
+		log.Warn("device token revoked: ", uid, " device: ", deviceToken.DeviceID)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
 
 	scopes := []string{"intgr", "screenshare", "hwcmail:-1", "mail:-1"}
 
@@ -268,31 +304,61 @@ func (app *App) uploadDoc(c *gin.Context) {
 	//HACK:
 	if syncVer == Version15 {
 		log.Info("sync 15 upload")
-		_, err := app.blobStorer.CreateBlobDocument(uid, fileName, "", f)
+		d, err := app.blobStorer.CreateBlobDocument(uid, fileName, "", f)
 		if err != nil {
 			log.Error(handlerLog, err)
+			if errors.Is(err, storage.ErrorRejected) {
+				badReq(c, err.Error())
+				return
+			}
+			if errors.Is(err, storage.ErrorNameTaken) {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
 			internalError(c, "cant upload document")
 			return
 		}
-		app.hub.NotifySync(uid, deviceID)
+		if d.Action != storage.ActionSkipped {
+			app.hub.NotifySync(uid, deviceID)
+		}
+		c.JSON(http.StatusOK, uploadResult(d))
 	} else {
 		log.Info("sync 10 upload")
 		d, err := app.docStorer.CreateDocument(uid, fileName, "", f)
 		if err != nil {
 			log.Error(handlerLog, err)
+			if errors.Is(err, storage.ErrorRejected) {
+				badReq(c, err.Error())
+				return
+			}
+			if errors.Is(err, storage.ErrorNameTaken) {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
 			internalError(c, "cant upload document")
 			return
 		}
-		ntf := hub.DocumentNotification{
-			Parent:  "",
-			ID:      d.ID,
-			Type:    d.Type,
-			Name:    d.Name,
-			Version: 1,
+		if d.Action != storage.ActionSkipped {
+			ntf := hub.DocumentNotification{
+				Parent:  "",
+				ID:      d.ID,
+				Type:    d.Type,
+				Name:    d.Name,
+				Version: 1,
+			}
+			app.hub.Notify(uid, deviceID, ntf, hub.DocAddedEvent)
 		}
-		app.hub.Notify(uid, deviceID, ntf, hub.DocAddedEvent)
+		c.JSON(http.StatusOK, uploadResult(d))
 	}
-	c.Status(http.StatusOK)
+}
+
+// uploadResult what happened to the uploaded document
+func uploadResult(d *storage.Document) gin.H {
+	result := gin.H{"name": d.Name, "action": d.Action}
+	if d.Warning != "" {
+		result["warning"] = d.Warning
+	}
+	return result
 }
 
 type emailForm struct {
@@ -531,11 +597,43 @@ func (app *App) syncComplete(c *gin.Context) {
 	uid := c.GetString(userIDKey)
 	deviceID := c.GetString(deviceIDKey)
 
+	app.observeGeneration(uid, deviceID)
+
 	var res messages.SyncCompleted
 	res.ID = app.hub.NotifySync(uid, deviceID)
 	c.JSON(http.StatusOK, res)
 }
 
+// observeGeneration tracks the root generation the device has seen
+func (app *App) observeGeneration(uid, deviceID string) {
+	generation, err := app.rootGeneration(uid)
+	if err != nil {
+		log.Warn("can't get the generation: ", err)
+		return
+	}
+	app.hub.ObserveGeneration(uid, deviceID, generation)
+}
+
+// rootGeneration the current generation, the root is only loaded when the provider can't tell it otherwise
+func (app *App) rootGeneration(uid string) (int64, error) {
+	if r, ok := app.blobProvider.(storage.RootGenerationReader); ok {
+		generation, err := r.RootGeneration(uid)
+		if err != storage.ErrorNotSupported {
+			return generation, err
+		}
+	}
+	reader, generation, err := app.blobProvider.LoadBlob(uid, rootBlob)
+	switch err {
+	case nil:
+		reader.Close()
+	case storage.ErrorNotFound:
+		// nothing synced yet
+	default:
+		return 0, err
+	}
+	return generation, nil
+}
+
 func formatExpires(t time.Time) string {
 	return t.UTC().Format(time.RFC3339Nano)
 }
@@ -553,6 +651,10 @@ func (app *App) blobStorageDownload(c *gin.Context) {
 		return
 	}
 
+	if req.RelativePath == rootBlob {
+		app.observeGeneration(uid, c.GetString(deviceIDKey))
+	}
+
 	url, exp, err := app.blobStorer.GetBlobURL(uid, req.RelativePath, "read")
 	if err != nil {
 		log.Error(err)
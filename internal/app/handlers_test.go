@@ -0,0 +1,48 @@
+package app
+
+import (
+	"io"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/app/hub"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+// generationProvider tells the generation of the root, loading it fails the test
+type generationProvider struct {
+	storage.BlobProvider
+	t          *testing.T
+	generation int64
+}
+
+func (p *generationProvider) RootGeneration(uid string) (int64, error) {
+	return p.generation, nil
+}
+
+func (p *generationProvider) LoadBlob(uid, blobID string) (io.ReadCloser, int64, error) {
+	p.t.Errorf("loaded %s", blobID)
+	return nil, 0, storage.ErrorNotFound
+}
+
+// rootlessProvider can't tell the generation and has no root
+type rootlessProvider struct {
+	storage.BlobProvider
+}
+
+func (p *rootlessProvider) LoadBlob(uid, blobID string) (io.ReadCloser, int64, error) {
+	return nil, 0, storage.ErrorNotFound
+}
+
+func TestObserveGeneration(t *testing.T) {
+	app := &App{hub: &hub.Hub{}, blobProvider: &generationProvider{t: t, generation: 4}}
+	app.observeGeneration("user", "tablet")
+	if devices := app.hub.DeviceGenerations("user"); len(devices) != 1 || devices[0].Generation != 4 {
+		t.Errorf("devices %v", devices)
+	}
+
+	app.blobProvider = &rootlessProvider{}
+	app.observeGeneration("user", "tablet")
+	if devices := app.hub.DeviceGenerations("user"); len(devices) != 1 || devices[0].Generation != 0 {
+		t.Errorf("without a root: devices %v", devices)
+	}
+}
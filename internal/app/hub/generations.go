@@ -0,0 +1,56 @@
+package hub
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeviceGeneration the root generation a device last observed
+type DeviceGeneration struct {
+	DeviceID   string
+	Generation int64
+	LastSeen   time.Time
+}
+
+// generations per user, per device, kept in memory only:
+// after a restart a device is listed again once it syncs
+type generations struct {
+	lock    sync.Mutex
+	devices map[string]map[string]*DeviceGeneration
+}
+
+// ObserveGeneration records that the device synced the generation
+func (h *Hub) ObserveGeneration(uid, deviceID string, generation int64) {
+	g := &h.generations
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.devices == nil {
+		g.devices = make(map[string]map[string]*DeviceGeneration)
+	}
+	devices, ok := g.devices[uid]
+	if !ok {
+		devices = make(map[string]*DeviceGeneration)
+		g.devices[uid] = devices
+	}
+	devices[deviceID] = &DeviceGeneration{
+		DeviceID:   deviceID,
+		Generation: generation,
+		LastSeen:   time.Now(),
+	}
+}
+
+// DeviceGenerations the devices of the user that synced since the server started
+func (h *Hub) DeviceGenerations(uid string) []DeviceGeneration {
+	g := &h.generations
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	result := make([]DeviceGeneration, 0, len(g.devices[uid]))
+	for _, d := range g.devices[uid] {
+		result = append(result, *d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].DeviceID < result[j].DeviceID })
+	return result
+}
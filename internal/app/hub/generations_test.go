@@ -0,0 +1,31 @@
+package hub
+
+import "testing"
+
+func TestDeviceGenerations(t *testing.T) {
+	h := &Hub{}
+	if devices := h.DeviceGenerations("user"); len(devices) != 0 {
+		t.Fatalf("nothing synced yet: %v", devices)
+	}
+
+	h.ObserveGeneration("user", "tablet", 1)
+	h.ObserveGeneration("user", "phone", 2)
+	h.ObserveGeneration("user", "tablet", 3)
+	h.ObserveGeneration("other", "desktop", 7)
+
+	devices := h.DeviceGenerations("user")
+	if len(devices) != 2 {
+		t.Fatalf("devices %v", devices)
+	}
+	for i, want := range []struct {
+		id         string
+		generation int64
+	}{{"phone", 2}, {"tablet", 3}} {
+		if devices[i].DeviceID != want.id || devices[i].Generation != want.generation || devices[i].LastSeen.IsZero() {
+			t.Errorf("device %d: %+v, want %s at %d", i, devices[i], want.id, want.generation)
+		}
+	}
+	if other := h.DeviceGenerations("other"); len(other) != 1 || other[0].Generation != 7 {
+		t.Errorf("other user: %v", other)
+	}
+}
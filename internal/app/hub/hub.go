@@ -33,6 +33,7 @@ type Hub struct {
 	additions     chan *wsClient
 	removals      chan *wsClient
 	notifications chan ntf
+	generations   generations
 }
 
 // NotifySync sends a message to all connected clients 1.5
@@ -30,6 +30,33 @@ func (app *App) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		uid := strings.TrimPrefix(claims.Profile.UserID, "auth0|")
+		user, err := app.userStorer.GetUser(uid)
+		if err != nil || user == nil {
+			log.Warn(authLog, "user not found: ", uid, " ", err)
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
+		if user.Expired() {
+			log.Warn(authLog, "account expired: ", uid)
+			c.String(http.StatusForbidden, accountExpired)
+			c.Abort()
+			return
+		}
+		if user.Disabled {
+			log.Warn(authLog, "account disabled: ", uid)
+			c.String(http.StatusForbidden, accountDisabled)
+			c.Abort()
+			return
+		}
+		if user.TokenRevoked(claims.IssuedAt) {
+			log.Warn(authLog, "token revoked: ", uid, " device: ", claims.DeviceID)
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
+
 		scopes := strings.Fields(claims.Scopes)
 
 		var isSync15 = false
@@ -45,7 +72,6 @@ func (app *App) authMiddleware() gin.HandlerFunc {
 			c.Set(syncVersionKey, Version10)
 		}
 
-		uid := strings.TrimPrefix(claims.Profile.UserID, "auth0|")
 		c.Set(userIDKey, uid)
 		c.Set(deviceIDKey, claims.DeviceID)
 		log.Infof("%s UserId: %s deviceId: %s newSync: %t", authLog, uid, claims.DeviceID, isSync15)
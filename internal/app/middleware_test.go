@@ -0,0 +1,79 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage/fs"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{DataDir: t.TempDir(), JWTSecretKey: []byte("secret")}
+	storer := fs.NewStorage(cfg)
+	app := &App{cfg: cfg, userStorer: storer, metaStorer: storer}
+
+	now := time.Now()
+	for id, update := range map[string]func(*model.User){
+		"active":   func(u *model.User) {},
+		"disabled": func(u *model.User) { u.Disabled = true },
+		"expired":  func(u *model.User) { u.ExpiresAt = now.Add(-time.Hour) },
+		"revoked":  func(u *model.User) { u.TokensRevokedAt = now.Add(-time.Minute) },
+	} {
+		u, err := model.NewUser(id, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		update(u)
+		if err = storer.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := gin.New()
+	authRoutes := router.Group("/")
+	authRoutes.Use(app.authMiddleware())
+	authRoutes.GET("/document-storage/json/2/docs", app.listDocuments)
+
+	tests := []struct {
+		uid      string
+		issuedAt time.Time
+		code     int
+	}{
+		{"active", now.Add(-time.Hour), http.StatusOK},
+		{"revoked", now, http.StatusOK},
+		{"revoked", now.Add(-time.Hour), http.StatusUnauthorized},
+		{"disabled", now, http.StatusForbidden},
+		{"expired", now, http.StatusForbidden},
+		{"missing", now, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		claims := &UserClaims{
+			Profile: Auth0profile{UserID: "auth0|" + tt.uid},
+			Scopes:  syncDefault,
+			StandardClaims: jwt.StandardClaims{
+				IssuedAt: tt.issuedAt.Unix(),
+				Audience: APIUsage,
+			},
+			Version: tokenVersion,
+		}
+		token, err := common.SignClaims(claims, cfg.JWTSecretKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/document-storage/json/2/docs", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s issued %s: status %d, want %d", tt.uid, tt.issuedAt.Format(time.RFC3339), w.Code, tt.code)
+		}
+	}
+}
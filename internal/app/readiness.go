@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type namedChecker struct {
+	name    string
+	checker storage.HealthChecker
+}
+
+// readiness checks the storage backends, at most once per interval
+type readiness struct {
+	interval time.Duration
+	timeout  time.Duration
+	checkers []namedChecker
+
+	lock      sync.Mutex
+	lastCheck time.Time
+	failures  map[string]string
+}
+
+func newReadiness(interval, timeout time.Duration) *readiness {
+	return &readiness{
+		interval: interval,
+		timeout:  timeout,
+	}
+}
+
+func (r *readiness) add(name string, checker storage.HealthChecker) {
+	r.checkers = append(r.checkers, namedChecker{name: name, checker: checker})
+}
+
+// check returns the backends that failed, by name
+func (r *readiness) check(ctx context.Context) map[string]string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.failures != nil && time.Since(r.lastCheck) < r.interval {
+		return r.failures
+	}
+
+	failures := make(map[string]string)
+	for _, c := range r.checkers {
+		checkCtx, cancel := context.WithTimeout(ctx, r.timeout)
+		err := c.checker.CheckHealth(checkCtx)
+		cancel()
+		if err != nil {
+			log.Warnf("readiness: %s: %v", c.name, err)
+			failures[c.name] = err.Error()
+		}
+	}
+	r.failures = failures
+	r.lastCheck = time.Now()
+	return failures
+}
+
+func (app *App) readyz(c *gin.Context) {
+	failures := app.readiness.check(c.Request.Context())
+	if len(failures) > 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "errors": failures})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
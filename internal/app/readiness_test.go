@@ -0,0 +1,36 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeChecker struct {
+	err   error
+	calls int
+}
+
+func (f *fakeChecker) CheckHealth(ctx context.Context) error {
+	f.calls++
+	return f.err
+}
+
+func TestReadiness(t *testing.T) {
+	ok := &fakeChecker{}
+	broken := &fakeChecker{err: errors.New("credentials expired")}
+	r := newReadiness(time.Hour, time.Second)
+	r.add("fs", ok)
+	r.add("s3", broken)
+
+	failures := r.check(context.Background())
+	if len(failures) != 1 || failures["s3"] != "credentials expired" {
+		t.Errorf("unexpected failures %v", failures)
+	}
+
+	r.check(context.Background())
+	if ok.calls != 1 || broken.calls != 1 {
+		t.Errorf("the result should be reused within the interval, calls: %d %d", ok.calls, broken.calls)
+	}
+}
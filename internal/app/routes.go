@@ -29,6 +29,9 @@ func (app *App) registerRoutes(router *gin.Engine) {
 		sysmb := ms.Sys / mb
 		c.String(http.StatusOK, "Working, %d clients, gn: %d, mem: %dkb sys: %dmb", count, gnum, live, sysmb)
 	})
+	// the storage backends are reachable
+	router.GET("/readyz", app.readyz)
+
 	// register  a new device
 	router.POST("/token/json/2/device/new", app.newDevice)
 
@@ -73,7 +76,7 @@ func (app *App) registerRoutes(router *gin.Engine) {
 		// document notifications
 		authRoutes.GET("/notifications/ws/json/1", app.connectWebSocket)
 
-		authRoutes.PUT("/document-storage/json/2/upload/request", app.uploadRequest)
+		authRoutes.PUT("/document-storage/json/2/upload/request", app.idempotency.Middleware(userIDKey), app.uploadRequest)
 
 		authRoutes.PUT("/document-storage/json/2/upload/update-status", app.updateStatus)
 
@@ -87,7 +90,7 @@ func (app *App) registerRoutes(router *gin.Engine) {
 		authRoutes.POST("/api/v1/page", app.handleHwr)
 
 		// upload docs from ext
-		authRoutes.POST("/doc/v1/files", app.uploadDoc)
+		authRoutes.POST("/doc/v1/files", app.idempotency.Middleware(userIDKey), app.uploadDoc)
 
 		//livesync
 		authRoutes.GET("/livesync/ws/json/2/:authid/sub", func(c *gin.Context) {
@@ -3,9 +3,12 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/ddvk/rmfakecloud/internal/config"
 	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/fs"
 	log "github.com/sirupsen/logrus"
 )
@@ -34,6 +37,10 @@ func (cli *Cli) SetUser(args []string) {
 	pass := userParam.String("p", "", "password")
 	admin := userParam.Bool("a", false, "isadmmin")
 	sync15 := userParam.Bool("s", false, "should the user use the new sync")
+	downloadLimit := userParam.Int("download-limit", 0, "download bandwidth in KB/s, 0 server default, -1 unlimited")
+	uploadLimit := userParam.Int("upload-limit", 0, "upload bandwidth in KB/s, 0 server default, -1 unlimited")
+	quota := userParam.Int64("quota", 0, "storage quota in bytes, 0 server default, -1 unlimited")
+	expires := userParam.String("expires", "", "the account expires on this date (2006-01-02), never removes the expiry")
 
 	userParam.Parse(args)
 	if *username == "" {
@@ -61,12 +68,184 @@ func (cli *Cli) SetUser(args []string) {
 	}
 	usr.IsAdmin = *admin
 	usr.Sync15 = *sync15
+	userParam.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "download-limit":
+			usr.DownloadLimit = *downloadLimit
+		case "upload-limit":
+			usr.UploadLimit = *uploadLimit
+		case "quota":
+			usr.Quota = *quota
+		case "expires":
+			if *expires == "never" {
+				usr.ExpiresAt = time.Time{}
+				return
+			}
+			expiresAt, err := time.Parse("2006-01-02", *expires)
+			if err != nil {
+				log.Fatal("expires: ", err)
+			}
+			usr.ExpiresAt = expiresAt.UTC()
+		}
+	})
 
 	err = cli.storage.UpdateUser(usr)
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Info("Updated/created the user")
+
+	params := map[string]string{
+		"admin":  strconv.FormatBool(usr.IsAdmin),
+		"sync15": strconv.FormatBool(usr.Sync15),
+	}
+	if *pass != "" {
+		params["password"] = "changed"
+	}
+	if !usr.ExpiresAt.IsZero() {
+		params["expiresAt"] = usr.ExpiresAt.Format(time.RFC3339)
+	}
+	err = cli.storage.RecordAudit(&storage.AuditEntry{
+		Time:     time.Now().UTC(),
+		Actor:    "cli",
+		Category: storage.AuditUser,
+		Action:   "set",
+		Target:   usr.ID,
+		Params:   params,
+	})
+	if err != nil {
+		log.Warn("can't record the audit entry: ", err)
+	}
+}
+
+// GarbageCollect removes the unreachable blobs of a user, with -dry-run only lists them
+func (cli *Cli) GarbageCollect(args []string) {
+	gcParam := flag.NewFlagSet("gc", flag.ExitOnError)
+	username := gcParam.String("u", "", "username")
+	dryRun := gcParam.Bool("dry-run", false, "only list the blobs which would be removed")
+
+	gcParam.Parse(args)
+	if *username == "" {
+		gcParam.PrintDefaults()
+		return
+	}
+	if _, err := cli.storage.GetUser(*username); err != nil {
+		log.Fatal(err)
+	}
+
+	stats, err := cli.storage.GarbageCollect(*username, *dryRun, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, blob := range stats.Blobs {
+		fmt.Println(blob)
+	}
+	if *dryRun {
+		fmt.Printf("scanned %d blobs, %d can be removed (%d bytes)\n", stats.Scanned, stats.Reclaimed, stats.BytesFreed)
+		return
+	}
+	fmt.Printf("scanned %d blobs, removed %d (%d bytes)\n", stats.Scanned, stats.Reclaimed, stats.BytesFreed)
+
+	err = cli.storage.RecordAudit(&storage.AuditEntry{
+		Time:     time.Now().UTC(),
+		Actor:    "cli",
+		Category: storage.AuditMaintenance,
+		Action:   "gc",
+		Target:   *username,
+		Params: map[string]string{
+			"reclaimed":  strconv.Itoa(stats.Reclaimed),
+			"bytesFreed": strconv.FormatInt(stats.BytesFreed, 10),
+		},
+	})
+	if err != nil {
+		log.Warn("can't record the audit entry: ", err)
+	}
+}
+
+// Encrypt encrypts the plaintext documents and blobs in the data dir with the configured key
+func (cli *Cli) Encrypt(args []string) {
+	encryptParam := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	username := encryptParam.String("u", "", "username, all users if not set")
+
+	encryptParam.Parse(args)
+	if len(cli.storage.Cfg.EncryptionKey) == 0 {
+		log.Fatal("no encryption key is set")
+	}
+
+	for _, uid := range cli.userIDs(*username) {
+		stats, err := cli.storage.EncryptExisting(uid)
+		if err != nil {
+			log.Fatal(uid, ": ", err)
+		}
+		fmt.Printf("%s: encrypted %d files, %d already were\n", uid, stats.Encrypted, stats.Skipped)
+
+		err = cli.storage.RecordAudit(&storage.AuditEntry{
+			Time:     time.Now().UTC(),
+			Actor:    "cli",
+			Category: storage.AuditMaintenance,
+			Action:   "encrypt",
+			Target:   uid,
+			Params: map[string]string{
+				"encrypted": strconv.Itoa(stats.Encrypted),
+			},
+		})
+		if err != nil {
+			log.Warn("can't record the audit entry: ", err)
+		}
+	}
+}
+
+// Share links the identical sync15 blobs stored before, to store them once
+func (cli *Cli) Share(args []string) {
+	shareParam := flag.NewFlagSet("share", flag.ExitOnError)
+	username := shareParam.String("u", "", "username, all users if not set")
+
+	shareParam.Parse(args)
+	if len(cli.storage.Cfg.EncryptionKey) > 0 {
+		log.Fatal("the encrypted blobs can't be shared")
+	}
+
+	for _, uid := range cli.userIDs(*username) {
+		stats, err := cli.storage.ShareExisting(uid)
+		if err != nil {
+			log.Fatal(uid, ": ", err)
+		}
+		fmt.Printf("%s: shared %d blobs, %d bytes saved, %d don't match their id\n", uid, stats.Shared, stats.BytesSaved, stats.Skipped)
+
+		err = cli.storage.RecordAudit(&storage.AuditEntry{
+			Time:     time.Now().UTC(),
+			Actor:    "cli",
+			Category: storage.AuditMaintenance,
+			Action:   "share",
+			Target:   uid,
+			Params: map[string]string{
+				"shared":     strconv.Itoa(stats.Shared),
+				"bytesSaved": strconv.FormatInt(stats.BytesSaved, 10),
+			},
+		})
+		if err != nil {
+			log.Warn("can't record the audit entry: ", err)
+		}
+	}
+}
+
+// userIDs the user, all users if empty
+func (cli *Cli) userIDs(username string) []string {
+	if username != "" {
+		if _, err := cli.storage.GetUser(username); err != nil {
+			log.Fatal(err)
+		}
+		return []string{username}
+	}
+	users, err := cli.storage.GetUsers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	uids := make([]string, 0, len(users))
+	for _, u := range users {
+		uids = append(uids, u.ID)
+	}
+	return uids
 }
 
 // Cli cli interface
@@ -76,11 +255,8 @@ type Cli struct {
 
 // New creates
 func New(cfg *config.Config) *Cli {
-	storage := &fs.FileSystemStorage{
-		Cfg: cfg,
-	}
 	return &Cli{
-		storage: storage,
+		storage: fs.NewStorage(cfg),
 	}
 
 }
@@ -96,6 +272,12 @@ func (cli *Cli) Handle(args []string) bool {
 		case "listusers":
 			cli.ListUsers(otherarg)
 		case "rmuser":
+		case "gc":
+			cli.GarbageCollect(otherarg)
+		case "encrypt":
+			cli.Encrypt(otherarg)
+		case "share":
+			cli.Share(otherarg)
 		default:
 			log.Warn("unknown command: ", cmd)
 		}
@@ -109,5 +291,8 @@ func Usage() string {
 	return `Commands:
 	setuser		create users / reset passwords
 	listusers	list available users
+	gc		remove the unreachable sync15 blobs of a user
+	encrypt		encrypt the plaintext documents and blobs with the RM_ENCRYPTION_KEY
+	share		store the identical sync15 blobs written before once
 `
 }
@@ -4,13 +4,19 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"net/mail"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ddvk/rmfakecloud/internal/email"
+	"github.com/ddvk/rmfakecloud/internal/storage"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -26,9 +32,92 @@ const (
 	// WriteStorageExpirationInMinutes time the token is valid
 	WriteStorageExpirationInMinutes = 5
 
+	// StorageProviderFS the blobs are files in the data dir
+	StorageProviderFS = "fs"
+	// StorageProviderS3 the blobs are objects in an s3 bucket
+	StorageProviderS3 = "s3"
+	// DefaultS3Region the region when none is set
+	DefaultS3Region = "us-east-1"
+
 	// DefaultHost fake url
 	DefaultHost = "local.appspot.com"
 
+	// OrphanIgnore leave orphaned documents alone
+	OrphanIgnore = "ignore"
+	// OrphanRecover link orphaned documents into a recovery folder
+	OrphanRecover = "recover"
+	// OrphanDelete delete orphaned documents
+	OrphanDelete = "delete"
+	// NameCollisionAllow allow documents with the same name in a folder
+	NameCollisionAllow = "allow"
+	// NameCollisionSuffix append " (2)" etc to the name
+	NameCollisionSuffix = "suffix"
+	// NameCollisionSkip don't import the document
+	NameCollisionSkip = "skip"
+	// NameCollisionReject refuse uploads, renames and moves onto a taken name
+	NameCollisionReject = "reject"
+	// ProtectedReject reject password protected pdfs and epubs with drm
+	ProtectedReject = "reject"
+	// ProtectedFlag store them, with a warning
+	ProtectedFlag = "flag"
+	// RootConflictStrict reject a root upload based on an older generation
+	RootConflictStrict = "strict"
+	// RootConflictMerge merge the root upload when it changes other documents than the current root
+	RootConflictMerge = "merge"
+	// GCModeDelete the garbage collection removes the unreachable blobs
+	GCModeDelete = "delete"
+	// GCModeArchive the garbage collection moves them to the user's .gc-archive
+	GCModeArchive = "archive"
+	// CompressionDeflate the sync15 blobs are compressed with deflate
+	CompressionDeflate = "deflate"
+
+	// DefaultReadyCheckInterval the results of /readyz are reused this long
+	DefaultReadyCheckInterval = 30 * time.Second
+	// DefaultReadyCheckTimeout of each backend check
+	DefaultReadyCheckTimeout = 5 * time.Second
+
+	// DefaultPDFImageQuality jpeg quality of downscaled images
+	DefaultPDFImageQuality = 80
+
+	// DefaultOrphanGracePeriod how old an orphan has to be before acting on it
+	DefaultOrphanGracePeriod = 7 * 24 * time.Hour
+	// DefaultGCGracePeriod unreachable blobs younger than this are kept by the garbage collection
+	DefaultGCGracePeriod = 24 * time.Hour
+	// DefaultRootHistory generations of the sync15 root which can be restored
+	DefaultRootHistory = 10
+
+	// DefaultInstanceName the title of the web ui
+	DefaultInstanceName = "rmfakecloud"
+
+	// DefaultAuditLog file name of the audit log in the data dir
+	DefaultAuditLog = "audit.log"
+	// AuditLogOff disables the audit log
+	AuditLogOff = "off"
+
+	// RobotsNoIndex keep search engines away from the web ui
+	RobotsNoIndex = "noindex"
+	// RobotsOff serve the bundled robots.txt, allow indexing
+	RobotsOff = "off"
+
+	// DefaultDiskCheckInterval how often the free space of the data dir is checked
+	DefaultDiskCheckInterval = 5 * time.Minute
+	// DefaultDiskWarnPercent free space below which warnings are logged
+	DefaultDiskWarnPercent = 10
+	// DefaultDiskCriticalPercent free space below which it is critical
+	DefaultDiskCriticalPercent = 5
+
+	// DefaultIdempotencyWindow how long the responses to uploads with an Idempotency-Key are kept
+	DefaultIdempotencyWindow = 24 * time.Hour
+
+	// DefaultURLMaxTTL signed blob urls expiring further in the future are rejected
+	DefaultURLMaxTTL = 15 * time.Minute
+
+	// DefaultExportCacheSizeMB memory used for caching exported documents
+	DefaultExportCacheSizeMB = 32
+
+	// DefaultWebhookMaxAttempts deliveries of a webhook event before it is given up
+	DefaultWebhookMaxAttempts = 8
+
 	// EnvLogLevel environment variable for the log level
 	EnvLogLevel = "LOGLEVEL"
 	// EnvLogFormat type of log format
@@ -42,11 +131,30 @@ const (
 	envTLSCert = "TLS_CERT"
 	// envTLSKey the path of the private key
 	envTLSKey = "TLS_KEY"
+	// envTLSMinVersion the oldest tls version accepted
+	envTLSMinVersion = "RM_TLS_MIN_VERSION"
+	// envTLSCipherSuites comma separated list of the allowed cipher suites
+	envTLSCipherSuites = "RM_TLS_CIPHERS"
 
 	// auth
 	envJWTSecretKey     = "JWT_SECRET_KEY"
 	envRegistrationOpen = "OPEN_REGISTRATION"
 
+	// envStorageProvider where the synced blobs and documents are kept
+	envStorageProvider = "RM_STORAGE_PROVIDER"
+	// envStorageDriver the same as envStorageProvider, which wins when both are set
+	envStorageDriver = "STORAGE_DRIVER"
+	// envS3Endpoint url of the s3 server e.g. https://s3.eu-west-1.amazonaws.com
+	envS3Endpoint = "RM_S3_ENDPOINT"
+	// envS3Bucket bucket for the objects
+	envS3Bucket = "RM_S3_BUCKET"
+	// envS3Region region for signing the requests
+	envS3Region = "RM_S3_REGION"
+	// envS3AccessKey access key id
+	envS3AccessKey = "RM_S3_ACCESS_KEY"
+	// envS3SecretKey secret access key
+	envS3SecretKey = "RM_S3_SECRET_KEY"
+
 	// envSMTPServer the mail server
 	envSMTPServer = "RM_SMTP_SERVER"
 	// envSMTPUsername the username for the mail server
@@ -72,8 +180,123 @@ const (
 	EnvLogFile     = "RM_LOGFILE"
 	envHTTPSCookie = "RM_HTTPS_COOKIE"
 	envTrustProxy  = "RM_TRUST_PROXY"
+
+	// envOrphanPolicy what to do with documents not referenced by any root
+	envOrphanPolicy = "RM_ORPHAN_POLICY"
+	// envOrphanGracePeriod min age of an orphan before it is recovered/deleted
+	envOrphanGracePeriod = "RM_ORPHAN_GRACE_PERIOD"
+	// envGCGracePeriod min age of an unreachable blob before the garbage collection removes it
+	envGCGracePeriod = "RM_GC_GRACE_PERIOD"
+	// envGCMode delete or archive the unreachable blobs
+	envGCMode = "RM_GC_MODE"
+	// envGCInterval how often the garbage collection runs for all users, 0 never
+	envGCInterval = "RM_GC_INTERVAL"
+	// envRootHistory how many generations of the root can be restored
+	envRootHistory = "RM_ROOT_HISTORY"
+
+	// envWebhookURL gets a signed POST for each document event
+	envWebhookURL = "RM_WEBHOOK_URL"
+	// envWebhookSecret key of the hmac signature of the webhook payloads
+	envWebhookSecret = "RM_WEBHOOK_SECRET"
+	// envWebhookEvents comma separated events sent to the webhook, empty for all
+	envWebhookEvents = "RM_WEBHOOK_EVENTS"
+	// envWebhookMaxAttempts deliveries of an event before it is moved to the failed ones
+	envWebhookMaxAttempts = "RM_WEBHOOK_MAX_ATTEMPTS"
+
+	// envOIDCIssuer the openid connect provider the web ui can log in with
+	envOIDCIssuer = "RM_OIDC_ISSUER"
+	// envOIDCClientID the client registered at the provider
+	envOIDCClientID = "RM_OIDC_CLIENT_ID"
+	// envOIDCClientSecret the secret of the client
+	envOIDCClientSecret = "RM_OIDC_CLIENT_SECRET"
+	// envOIDCAutoCreate create a local user on the first login of an unknown subject
+	envOIDCAutoCreate = "RM_OIDC_AUTO_CREATE"
+
+	// branding of the web ui
+	envInstanceName = "RM_INSTANCE_NAME"
+	envLogoURL      = "RM_LOGO_URL"
+	envThemeColor   = "RM_THEME_COLOR"
+
+	// envDiskCheckInterval how often the free space is checked, 0 disables it
+	envDiskCheckInterval = "RM_DISK_CHECK_INTERVAL"
+	// envDiskWarnPercent warn below this much free space
+	envDiskWarnPercent = "RM_DISK_WARN_PERCENT"
+	// envDiskCriticalPercent critical below this much free space
+	envDiskCriticalPercent = "RM_DISK_CRITICAL_PERCENT"
+	// envDiskWebhook gets a POST when the free space level changes
+	envDiskWebhook = "RM_DISK_WEBHOOK"
+	// envDiskCriticalReadOnly refuse uploads while the free space is critical
+	envDiskCriticalReadOnly = "RM_DISK_CRITICAL_READONLY"
+
+	// envIdempotencyWindow how long the upload responses are replayed, 0 disables it
+	envIdempotencyWindow = "RM_IDEMPOTENCY_WINDOW"
+	// envURLMaxTTL the longest a signed blob url may be valid
+	envURLMaxTTL = "RM_URL_MAX_TTL"
+	// envURLSignatureStrict reject the signed blob urls of the old format
+	envURLSignatureStrict = "RM_URL_SIGNATURE_STRICT"
+	// envEncryptionKey master key of the encryption at rest, 32 bytes hex encoded
+	envEncryptionKey = "RM_ENCRYPTION_KEY"
+	// envBlobCompression compress the sync15 blobs written
+	envBlobCompression = "RM_BLOB_COMPRESSION"
+	// envExportCacheSize size of the export cache in MB
+	envExportCacheSize = "RM_EXPORT_CACHE_SIZE"
+
+	// envPDFImageMaxPPI downscale images in uploaded pdfs above this resolution
+	envPDFImageMaxPPI = "RM_PDF_IMAGE_MAX_PPI"
+	// envPDFImageQuality jpeg quality of the downscaled images
+	envPDFImageQuality = "RM_PDF_IMAGE_QUALITY"
+
+	// envDownloadLimit default download bandwidth per user in KB/s
+	envDownloadLimit = "RM_DOWNLOAD_LIMIT"
+	// envUploadLimit default upload bandwidth per user in KB/s
+	envUploadLimit = "RM_UPLOAD_LIMIT"
+
+	// envQuota default storage quota per user in bytes
+	envQuota = "RM_QUOTA"
+
+	// envReadyCheckInterval how often /readyz checks the storage backends
+	envReadyCheckInterval = "RM_READY_CHECK_INTERVAL"
+	// envReadyCheckTimeout how long a backend check may take
+	envReadyCheckTimeout = "RM_READY_CHECK_TIMEOUT"
+	// envMetrics serve the prometheus metrics of the requests and the storage at /metrics
+	envMetrics = "RM_METRICS"
+
+	// envBlobCacheMaxAge how long clients may cache content blobs
+	envBlobCacheMaxAge = "RM_BLOB_CACHE_MAX_AGE"
+	// envBlobHashCheck verify the x-goog-hash of uploaded blobs and send it with the downloads
+	envBlobHashCheck = "RM_BLOB_HASH_CHECK"
+	// envBlobReadAhead how many files of a document are read ahead when its index is downloaded
+	envBlobReadAhead = "RM_BLOB_READAHEAD"
+
+	// envDefaultFolders created for every new user, comma separated
+	envDefaultFolders = "RM_DEFAULT_FOLDERS"
+
+	// envIngestProcessors which processors run on uploads, in order
+	envIngestProcessors = "RM_INGEST_PROCESSORS"
+
+	// envAccountExpiryDays new accounts expire after this many days
+	envAccountExpiryDays = "RM_ACCOUNT_EXPIRY_DAYS"
+	// envExpiredPurgeAfter the data of expired accounts is removed after this grace period
+	envExpiredPurgeAfter = "RM_EXPIRED_PURGE_AFTER"
+
+	// envAuditLog path of the audit log of admin actions
+	envAuditLog = "RM_AUDIT_LOG"
+
+	// envRobots noindex, off or the path of a custom robots.txt
+	envRobots = "RM_ROBOTS"
+
+	// envRootConflict what to do when a device uploads a root based on an older generation
+	envRootConflict = "RM_ROOT_CONFLICT"
+
+	// envProtectedUploads what to do with password protected or drm uploads
+	envProtectedUploads = "RM_PROTECTED_UPLOADS"
+
+	// envNameCollision what to do when an imported document's name is taken
+	envNameCollision = "RM_NAME_COLLISION"
 )
 
+var themeColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
 // Config config
 type Config struct {
 	Port              string
@@ -84,12 +307,111 @@ type Config struct {
 	JWTSecretKey      []byte
 	JWTRandom         bool
 	Certificate       tls.Certificate
+	TLSMinVersion     uint16
+	TLSCipherSuites   []uint16
 	SMTPConfig        *email.SMTPConfig
 	LogFile           string
 	HWRApplicationKey string
 	HWRHmac           string
 	HTTPSCookie       bool
 	TrustProxy        bool
+	OrphanPolicy      string
+	OrphanGracePeriod time.Duration
+	GCGracePeriod     time.Duration
+	GCMode            string
+	GCInterval        time.Duration
+	Branding          Branding
+	ExportCacheSize   int64
+	// DownloadLimit/UploadLimit per user in KB/s, Quota in bytes, 0 is unlimited
+	DownloadLimit      int
+	UploadLimit        int
+	Quota              int64
+	ReadyCheckInterval time.Duration
+	ReadyCheckTimeout  time.Duration
+	// BlobCacheMaxAge 0 disables caching of content blobs
+	BlobCacheMaxAge time.Duration
+	// BlobReadAhead 0 disables the read ahead
+	BlobReadAhead int
+	// BlobHashCheck verify the x-goog-hash of the uploads and send it with the downloads
+	BlobHashCheck bool
+	// Metrics serve the prometheus metrics at /metrics
+	Metrics bool
+	// PDFImageMaxPPI 0 disables downscaling
+	PDFImageMaxPPI  float64
+	PDFImageQuality int
+	// DefaultFolders paths of the folders new users start with, subfolders separated by /
+	DefaultFolders []string
+	// IngestProcessors nil runs all in the registration order
+	IngestProcessors []string
+	// NameCollisionPolicy applies to uploads from the ui, email and the browser extension
+	NameCollisionPolicy string
+	// ProtectedUploadPolicy of password protected pdfs and epubs with drm
+	ProtectedUploadPolicy string
+	// AuditLog path of the audit log, empty when disabled
+	AuditLog string
+	// RootConflictPolicy of concurrent sync15 root uploads
+	RootConflictPolicy string
+	// RootHistory the last generations of the sync15 root the gc keeps and can be restored, 0 none
+	RootHistory int
+	// AccountExpiry of accounts created in the web ui, 0 never expire
+	AccountExpiry time.Duration
+	// ExpiredPurgeAfter 0 keeps the data of expired accounts
+	ExpiredPurgeAfter time.Duration
+	// DiskCheckInterval 0 disables the free space monitor
+	DiskCheckInterval time.Duration
+	// DiskWarnPercent, DiskCriticalPercent free space thresholds in percent
+	DiskWarnPercent     float64
+	DiskCriticalPercent float64
+	// DiskWebhook notified when the free space level changes, empty for none
+	DiskWebhook string
+	// DiskCriticalReadOnly refuse writes while the free space is critical
+	DiskCriticalReadOnly bool
+	// WebhookURL notified of the document events, empty for none
+	WebhookURL    string
+	WebhookSecret []byte
+	// WebhookEvents the events sent, nil for all
+	WebhookEvents      []string
+	WebhookMaxAttempts int
+	// OIDCIssuer the openid connect provider, empty for only the local accounts
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	// OIDCAutoCreate unknown subjects get a new user instead of being rejected
+	OIDCAutoCreate bool
+	// IdempotencyWindow uploads with the same Idempotency-Key are replayed for this long
+	IdempotencyWindow time.Duration
+	// URLMaxTTL signed blob urls expiring further in the future are rejected
+	URLMaxTTL time.Duration
+	// URLSignatureStrict only accept signed blob urls bound to the method
+	URLSignatureStrict bool
+	// EncryptionKey master key the keys of the users are derived from, nil stores everything in plaintext
+	EncryptionKey []byte
+	// BlobCompression how new sync15 blobs are compressed, empty not at all
+	BlobCompression string
+	// Robots RobotsNoIndex or RobotsOff
+	Robots string
+	// RobotsTxt a custom robots.txt, served instead of the generated one
+	RobotsTxt []byte
+	// StorageProvider StorageProviderFS or StorageProviderS3
+	StorageProvider string
+	// S3Config nil unless the storage provider is s3
+	S3Config *S3Config
+}
+
+// S3Config the bucket of the s3 storage provider
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// Branding customizations of the web ui
+type Branding struct {
+	InstanceName string
+	LogoURL      string
+	ThemeColor   string
 }
 
 // Verify verify
@@ -157,6 +479,17 @@ func FromEnv() *Config {
 			log.Fatal("unable to load certificate:", err)
 		}
 	}
+	tlsMinVersion, err := parseTLSVersion(os.Getenv(envTLSMinVersion))
+	if err != nil {
+		log.Fatal(envTLSMinVersion, ": ", err)
+	}
+	tlsCipherSuites, err := parseCipherSuites(os.Getenv(envTLSCipherSuites))
+	if err != nil {
+		log.Fatal(envTLSCipherSuites, ": ", err)
+	}
+	if tlsCipherSuites != nil && tlsMinVersion == tls.VersionTLS13 {
+		log.Warnf("%s: tls 1.3 cipher suites are not configurable, ignored", envTLSCipherSuites)
+	}
 	openRegistration, _ := strconv.ParseBool(os.Getenv(envRegistrationOpen))
 	httpsCookie, _ := strconv.ParseBool(os.Getenv(envHTTPSCookie))
 
@@ -166,6 +499,34 @@ func FromEnv() *Config {
 		uploadURL = "https://" + DefaultHost
 	}
 
+	storageProviderEnv := envStorageProvider
+	storageProvider := os.Getenv(envStorageProvider)
+	if storageProvider == "" {
+		storageProviderEnv = envStorageDriver
+		storageProvider = os.Getenv(envStorageDriver)
+	}
+	var s3Cfg *S3Config
+	switch storageProvider {
+	case "", StorageProviderFS:
+		storageProvider = StorageProviderFS
+	case StorageProviderS3:
+		s3Cfg = &S3Config{
+			Endpoint:  os.Getenv(envS3Endpoint),
+			Bucket:    os.Getenv(envS3Bucket),
+			Region:    os.Getenv(envS3Region),
+			AccessKey: os.Getenv(envS3AccessKey),
+			SecretKey: os.Getenv(envS3SecretKey),
+		}
+		if s3Cfg.Endpoint == "" || s3Cfg.Bucket == "" {
+			log.Fatalf("%s: %s and %s are needed", storageProviderEnv, envS3Endpoint, envS3Bucket)
+		}
+		if s3Cfg.Region == "" {
+			s3Cfg.Region = DefaultS3Region
+		}
+	default:
+		log.Fatalf("%s: unknown provider '%s'", storageProviderEnv, storageProvider)
+	}
+
 	// smtp
 	var smtpCfg *email.SMTPConfig
 	servername := os.Getenv(envSMTPServer)
@@ -196,6 +557,324 @@ func FromEnv() *Config {
 
 	trustProxy, _ := strconv.ParseBool(os.Getenv(envTrustProxy))
 
+	orphanPolicy := os.Getenv(envOrphanPolicy)
+	switch orphanPolicy {
+	case "":
+		orphanPolicy = OrphanIgnore
+	case OrphanIgnore, OrphanRecover, OrphanDelete:
+	default:
+		log.Fatalf("%s: unknown policy '%s'", envOrphanPolicy, orphanPolicy)
+	}
+
+	orphanGracePeriod := DefaultOrphanGracePeriod
+	if grace := os.Getenv(envOrphanGracePeriod); grace != "" {
+		orphanGracePeriod, err = time.ParseDuration(grace)
+		if err != nil {
+			log.Fatal(envOrphanGracePeriod, ": ", err)
+		}
+	}
+	gcGracePeriod := DefaultGCGracePeriod
+	if grace := os.Getenv(envGCGracePeriod); grace != "" {
+		gcGracePeriod, err = time.ParseDuration(grace)
+		if err != nil || gcGracePeriod <= 0 {
+			log.Fatalf("%s: invalid duration '%s'", envGCGracePeriod, grace)
+		}
+	}
+	gcMode := os.Getenv(envGCMode)
+	switch gcMode {
+	case "":
+		gcMode = GCModeDelete
+	case GCModeDelete, GCModeArchive:
+	default:
+		log.Fatalf("%s: unknown mode '%s'", envGCMode, gcMode)
+	}
+	var gcInterval time.Duration
+	if interval := os.Getenv(envGCInterval); interval != "" {
+		gcInterval, err = time.ParseDuration(interval)
+		if err != nil || gcInterval < 0 {
+			log.Fatalf("%s: invalid duration '%s'", envGCInterval, interval)
+		}
+	}
+	rootHistory := DefaultRootHistory
+	if history := os.Getenv(envRootHistory); history != "" {
+		rootHistory, err = strconv.Atoi(history)
+		if err != nil || rootHistory < 0 {
+			log.Fatalf("%s: invalid number of generations '%s'", envRootHistory, history)
+		}
+	}
+
+	branding := Branding{
+		InstanceName: os.Getenv(envInstanceName),
+		LogoURL:      os.Getenv(envLogoURL),
+		ThemeColor:   os.Getenv(envThemeColor),
+	}
+	if branding.InstanceName == "" {
+		branding.InstanceName = DefaultInstanceName
+	}
+	if branding.ThemeColor != "" && !themeColor.MatchString(branding.ThemeColor) {
+		log.Warnf("%s: '%s' is not a #rrggbb color, ignored", envThemeColor, branding.ThemeColor)
+		branding.ThemeColor = ""
+	}
+
+	exportCacheSize := int64(DefaultExportCacheSizeMB)
+	if size := os.Getenv(envExportCacheSize); size != "" {
+		exportCacheSize, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			log.Fatal(envExportCacheSize, ": ", err)
+		}
+	}
+
+	downloadLimit, err := parseBandwidthLimit(envDownloadLimit)
+	if err != nil {
+		log.Fatal(envDownloadLimit, ": ", err)
+	}
+	uploadLimit, err := parseBandwidthLimit(envUploadLimit)
+	if err != nil {
+		log.Fatal(envUploadLimit, ": ", err)
+	}
+
+	readyCheckInterval := DefaultReadyCheckInterval
+	if interval := os.Getenv(envReadyCheckInterval); interval != "" {
+		readyCheckInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			log.Fatal(envReadyCheckInterval, ": ", err)
+		}
+	}
+	readyCheckTimeout := DefaultReadyCheckTimeout
+	if timeout := os.Getenv(envReadyCheckTimeout); timeout != "" {
+		readyCheckTimeout, err = time.ParseDuration(timeout)
+		if err != nil || readyCheckTimeout <= 0 {
+			log.Fatalf("%s: invalid timeout '%s'", envReadyCheckTimeout, timeout)
+		}
+	}
+
+	var quota int64
+	if q := os.Getenv(envQuota); q != "" {
+		quota, err = strconv.ParseInt(q, 10, 64)
+		if err != nil || quota < 0 {
+			log.Fatalf("%s: invalid number of bytes '%s'", envQuota, q)
+		}
+	}
+
+	var blobCacheMaxAge time.Duration
+	if maxAge := os.Getenv(envBlobCacheMaxAge); maxAge != "" {
+		blobCacheMaxAge, err = time.ParseDuration(maxAge)
+		if err != nil {
+			log.Fatal(envBlobCacheMaxAge, ": ", err)
+		}
+	}
+
+	blobHashCheck, _ := strconv.ParseBool(os.Getenv(envBlobHashCheck))
+	metrics, _ := strconv.ParseBool(os.Getenv(envMetrics))
+	var blobReadAhead int
+	if readAhead := os.Getenv(envBlobReadAhead); readAhead != "" {
+		blobReadAhead, err = strconv.Atoi(readAhead)
+		if err != nil || blobReadAhead < 0 {
+			log.Fatalf("%s: invalid number of blobs '%s'", envBlobReadAhead, readAhead)
+		}
+	}
+
+	var pdfImageMaxPPI float64
+	if ppi := os.Getenv(envPDFImageMaxPPI); ppi != "" {
+		pdfImageMaxPPI, err = strconv.ParseFloat(ppi, 64)
+		if err != nil || pdfImageMaxPPI < 0 {
+			log.Fatalf("%s: invalid resolution '%s'", envPDFImageMaxPPI, ppi)
+		}
+	}
+	pdfImageQuality := DefaultPDFImageQuality
+	if quality := os.Getenv(envPDFImageQuality); quality != "" {
+		pdfImageQuality, err = strconv.Atoi(quality)
+		if err != nil || pdfImageQuality < 1 || pdfImageQuality > 100 {
+			log.Fatalf("%s: should be between 1 and 100", envPDFImageQuality)
+		}
+	}
+
+	var ingestProcessors []string
+	if processors, ok := os.LookupEnv(envIngestProcessors); ok {
+		ingestProcessors = make([]string, 0)
+		for _, p := range strings.Split(processors, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				ingestProcessors = append(ingestProcessors, p)
+			}
+		}
+	}
+
+	var defaultFolders []string
+	for _, folder := range strings.Split(os.Getenv(envDefaultFolders), ",") {
+		parts := make([]string, 0)
+		for _, name := range strings.Split(folder, "/") {
+			if name = strings.TrimSpace(name); name != "" {
+				parts = append(parts, name)
+			}
+		}
+		if len(parts) > 0 {
+			defaultFolders = append(defaultFolders, strings.Join(parts, "/"))
+		}
+	}
+
+	nameCollisionPolicy := os.Getenv(envNameCollision)
+	switch nameCollisionPolicy {
+	case "":
+		nameCollisionPolicy = NameCollisionAllow
+	case NameCollisionAllow, NameCollisionSuffix, NameCollisionSkip, NameCollisionReject:
+	default:
+		log.Fatalf("%s: unknown policy '%s'", envNameCollision, nameCollisionPolicy)
+	}
+
+	protectedUploadPolicy := os.Getenv(envProtectedUploads)
+	switch protectedUploadPolicy {
+	case "":
+		protectedUploadPolicy = ProtectedReject
+	case ProtectedReject, ProtectedFlag:
+	default:
+		log.Fatalf("%s: unknown policy '%s'", envProtectedUploads, protectedUploadPolicy)
+	}
+
+	rootConflictPolicy := os.Getenv(envRootConflict)
+	switch rootConflictPolicy {
+	case "":
+		rootConflictPolicy = RootConflictStrict
+	case RootConflictStrict, RootConflictMerge:
+	default:
+		log.Fatalf("%s: unknown policy '%s'", envRootConflict, rootConflictPolicy)
+	}
+	// they need the history and the files of the data dir
+	if storageProvider != StorageProviderFS {
+		if gcInterval > 0 {
+			log.Fatalf("%s: the garbage collection can't run with %s=%s", envGCInterval, storageProviderEnv, storageProvider)
+		}
+		if rootConflictPolicy == RootConflictMerge {
+			log.Fatalf("%s: roots can't be merged with %s=%s", envRootConflict, storageProviderEnv, storageProvider)
+		}
+	}
+
+	var accountExpiry time.Duration
+	if days := os.Getenv(envAccountExpiryDays); days != "" {
+		expiryDays, err := strconv.Atoi(days)
+		if err != nil || expiryDays < 0 {
+			log.Fatalf("%s: invalid number of days '%s'", envAccountExpiryDays, days)
+		}
+		accountExpiry = time.Duration(expiryDays) * 24 * time.Hour
+	}
+	var expiredPurgeAfter time.Duration
+	if grace := os.Getenv(envExpiredPurgeAfter); grace != "" {
+		expiredPurgeAfter, err = time.ParseDuration(grace)
+		if err != nil || expiredPurgeAfter < 0 {
+			log.Fatalf("%s: invalid duration '%s'", envExpiredPurgeAfter, grace)
+		}
+	}
+
+	auditLog := os.Getenv(envAuditLog)
+	switch auditLog {
+	case "":
+		auditLog = filepath.Join(dataDir, DefaultAuditLog)
+	case AuditLogOff:
+		auditLog = ""
+	}
+
+	diskCheckInterval := DefaultDiskCheckInterval
+	if interval := os.Getenv(envDiskCheckInterval); interval != "" {
+		diskCheckInterval, err = time.ParseDuration(interval)
+		if err != nil || diskCheckInterval < 0 {
+			log.Fatalf("%s: invalid duration '%s'", envDiskCheckInterval, interval)
+		}
+	}
+	diskWarnPercent, err := parsePercent(envDiskWarnPercent, DefaultDiskWarnPercent)
+	if err != nil {
+		log.Fatal(envDiskWarnPercent, ": ", err)
+	}
+	diskCriticalPercent, err := parsePercent(envDiskCriticalPercent, DefaultDiskCriticalPercent)
+	if err != nil {
+		log.Fatal(envDiskCriticalPercent, ": ", err)
+	}
+	if diskCriticalPercent > diskWarnPercent {
+		log.Fatalf("%s has to be lower than %s", envDiskCriticalPercent, envDiskWarnPercent)
+	}
+	diskCriticalReadOnly, _ := strconv.ParseBool(os.Getenv(envDiskCriticalReadOnly))
+
+	idempotencyWindow := DefaultIdempotencyWindow
+	if window := os.Getenv(envIdempotencyWindow); window != "" {
+		idempotencyWindow, err = time.ParseDuration(window)
+		if err != nil || idempotencyWindow < 0 {
+			log.Fatalf("%s: invalid duration '%s'", envIdempotencyWindow, window)
+		}
+	}
+	urlMaxTTL := DefaultURLMaxTTL
+	if ttl := os.Getenv(envURLMaxTTL); ttl != "" {
+		urlMaxTTL, err = time.ParseDuration(ttl)
+		if err != nil || urlMaxTTL <= 0 {
+			log.Fatalf("%s: invalid duration '%s'", envURLMaxTTL, ttl)
+		}
+	}
+	urlSignatureStrict, _ := strconv.ParseBool(os.Getenv(envURLSignatureStrict))
+
+	webhookURL := os.Getenv(envWebhookURL)
+	webhookSecret := os.Getenv(envWebhookSecret)
+	if webhookURL != "" && webhookSecret == "" {
+		log.Fatalf("%s: needs %s to sign the payloads", envWebhookURL, envWebhookSecret)
+	}
+	var webhookEvents []string
+	if events := os.Getenv(envWebhookEvents); events != "" {
+		for _, e := range strings.Split(events, ",") {
+			switch e = strings.TrimSpace(e); e {
+			case "":
+			case storage.EventDocumentUploaded, storage.EventDocumentDeleted, storage.EventRootGeneration:
+				webhookEvents = append(webhookEvents, e)
+			default:
+				log.Fatalf("%s: unknown event '%s'", envWebhookEvents, e)
+			}
+		}
+	}
+	webhookMaxAttempts := DefaultWebhookMaxAttempts
+	if attempts := os.Getenv(envWebhookMaxAttempts); attempts != "" {
+		webhookMaxAttempts, err = strconv.Atoi(attempts)
+		if err != nil || webhookMaxAttempts < 1 {
+			log.Fatalf("%s: invalid number '%s'", envWebhookMaxAttempts, attempts)
+		}
+	}
+
+	oidcIssuer := os.Getenv(envOIDCIssuer)
+	oidcClientID := os.Getenv(envOIDCClientID)
+	oidcClientSecret := os.Getenv(envOIDCClientSecret)
+	if oidcIssuer != "" {
+		if u, err := url.Parse(oidcIssuer); err != nil || u.Scheme == "" || u.Host == "" {
+			log.Fatalf("%s: invalid url '%s'", envOIDCIssuer, oidcIssuer)
+		}
+		if oidcClientID == "" {
+			log.Fatalf("%s: needs %s", envOIDCIssuer, envOIDCClientID)
+		}
+	}
+	oidcAutoCreate, _ := strconv.ParseBool(os.Getenv(envOIDCAutoCreate))
+
+	var encryptionKey []byte
+	if key := os.Getenv(envEncryptionKey); key != "" {
+		encryptionKey, err = hex.DecodeString(key)
+		if err != nil || len(encryptionKey) != 32 {
+			log.Fatalf("%s: needs 32 bytes hex encoded e.g. from 'openssl rand -hex 32'", envEncryptionKey)
+		}
+	}
+
+	blobCompression := os.Getenv(envBlobCompression)
+	switch blobCompression {
+	case "", CompressionDeflate:
+	default:
+		log.Fatalf("%s: unknown compression '%s'", envBlobCompression, blobCompression)
+	}
+
+	robots := os.Getenv(envRobots)
+	var robotsTxt []byte
+	switch robots {
+	case "":
+		robots = RobotsNoIndex
+	case RobotsNoIndex, RobotsOff:
+	default:
+		robotsTxt, err = os.ReadFile(robots)
+		if err != nil {
+			log.Fatal(envRobots, ": ", err)
+		}
+		robots = RobotsNoIndex
+	}
+
 	cfg := Config{
 		Port:              port,
 		StorageURL:        uploadURL,
@@ -203,16 +882,167 @@ func FromEnv() *Config {
 		JWTSecretKey:      dk,
 		JWTRandom:         jwtGenerated,
 		Certificate:       cert,
+		TLSMinVersion:     tlsMinVersion,
+		TLSCipherSuites:   tlsCipherSuites,
 		RegistrationOpen:  openRegistration,
 		SMTPConfig:        smtpCfg,
+		StorageProvider:   storageProvider,
+		S3Config:          s3Cfg,
 		HWRApplicationKey: os.Getenv(envHwrApplicationKey),
 		HWRHmac:           os.Getenv(envHwrHmac),
 		HTTPSCookie:       httpsCookie,
 		TrustProxy:        trustProxy,
+		OrphanPolicy:      orphanPolicy,
+		OrphanGracePeriod: orphanGracePeriod,
+		GCGracePeriod:     gcGracePeriod,
+		GCMode:            gcMode,
+		GCInterval:        gcInterval,
+		RootHistory:       rootHistory,
+		Branding:          branding,
+		ExportCacheSize:   exportCacheSize << 20,
+
+		DownloadLimit:   downloadLimit,
+		UploadLimit:     uploadLimit,
+		Quota:           quota,
+		BlobCacheMaxAge: blobCacheMaxAge,
+		BlobReadAhead:   blobReadAhead,
+		BlobHashCheck:   blobHashCheck,
+
+		ReadyCheckInterval: readyCheckInterval,
+		ReadyCheckTimeout:  readyCheckTimeout,
+		Metrics:            metrics,
+		PDFImageMaxPPI:     pdfImageMaxPPI,
+		PDFImageQuality:    pdfImageQuality,
+
+		IngestProcessors: ingestProcessors,
+		DefaultFolders:   defaultFolders,
+
+		NameCollisionPolicy:   nameCollisionPolicy,
+		ProtectedUploadPolicy: protectedUploadPolicy,
+		AuditLog:              auditLog,
+		RootConflictPolicy:    rootConflictPolicy,
+		AccountExpiry:         accountExpiry,
+		ExpiredPurgeAfter:     expiredPurgeAfter,
+		DiskCheckInterval:     diskCheckInterval,
+		DiskWarnPercent:       diskWarnPercent,
+		DiskCriticalPercent:   diskCriticalPercent,
+		DiskWebhook:           os.Getenv(envDiskWebhook),
+		DiskCriticalReadOnly:  diskCriticalReadOnly,
+		WebhookURL:            webhookURL,
+		WebhookSecret:         []byte(webhookSecret),
+		WebhookEvents:         webhookEvents,
+		WebhookMaxAttempts:    webhookMaxAttempts,
+		OIDCIssuer:            oidcIssuer,
+		OIDCClientID:          oidcClientID,
+		OIDCClientSecret:      oidcClientSecret,
+		OIDCAutoCreate:        oidcAutoCreate,
+		IdempotencyWindow:     idempotencyWindow,
+		URLMaxTTL:             urlMaxTTL,
+		URLSignatureStrict:    urlSignatureStrict,
+		EncryptionKey:         encryptionKey,
+		BlobCompression:       blobCompression,
+		Robots:                robots,
+		RobotsTxt:             robotsTxt,
 	}
 	return &cfg
 }
 
+// parsePercent 0-100 from the env var, def if not set
+func parsePercent(name string, def float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("%s is not a percentage", value)
+	}
+	return percent, nil
+}
+
+// parseBandwidthLimit KB/s from the env var, 0 if not set
+func parseBandwidthLimit(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 0 {
+		return 0, fmt.Errorf("negative limit %d", limit)
+	}
+	return limit, nil
+}
+
+// parseTLSVersion only 1.2 and up, older versions are insecure
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.0", "1.1":
+		return 0, fmt.Errorf("tls %s is insecure, use 1.2 or 1.3", version)
+	}
+	return 0, fmt.Errorf("unknown tls version '%s'", version)
+}
+
+// parseCipherSuites looks up cipher suites by their name e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+// nil means the go defaults
+func parseCipherSuites(names string) ([]uint16, error) {
+	if names == "" {
+		return nil, nil
+	}
+	secure := make(map[string]uint16)
+	tls13 := make(map[string]bool)
+	for _, c := range tls.CipherSuites() {
+		if onlyTLS13(c) {
+			tls13[c.Name] = true
+			continue
+		}
+		secure[c.Name] = c.ID
+	}
+	insecure := make(map[string]bool)
+	for _, c := range tls.InsecureCipherSuites() {
+		insecure[c.Name] = true
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if insecure[name] {
+			return nil, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+		if tls13[name] {
+			return nil, fmt.Errorf("cipher suite %s is a TLS 1.3 suite, they are not configurable", name)
+		}
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite '%s'", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// onlyTLS13 the suite can't be used below TLS 1.3, go ignores it in tls.Config.CipherSuites
+func onlyTLS13(c *tls.CipherSuite) bool {
+	for _, v := range c.SupportedVersions {
+		if v != tls.VersionTLS13 {
+			return false
+		}
+	}
+	return true
+}
+
 // EnvVars env vars usage
 func EnvVars() string {
 	return fmt.Sprintf(`
@@ -230,9 +1060,75 @@ General:
 	%s		Local storage folder (default: %s)
 	%s	Path to the server certificate.
 	%s		Path to the server certificate key.
+	%s	Minimum TLS version: 1.2, 1.3 (default: 1.2)
+	%s	Allowed TLS 1.2 cipher suites, comma separated (default: go's secure defaults)
 	%s	Write logs to file
 	%s Send auth cookie only via https
 	%s	Trust the proxy for X-Forwarded-For/X-Real-IP (set only if behind a proxy)
+	%s	Memory for caching exported documents in MB, 0 disables it (default: %d)
+	%s	Download bandwidth per user in KB/s, 0 unlimited (default: 0)
+	%s	Upload bandwidth per user in KB/s, 0 unlimited (default: 0)
+	%s	Storage quota per user in bytes, 0 unlimited (default: 0)
+	%s	How long the /readyz storage check results are reused (default: 30s)
+	%s	Timeout of each /readyz storage check (default: 5s)
+	%s	Serve prometheus metrics of the requests and the storage at /metrics (default: false)
+	%s	Cache-Control max-age of the sync15 content blobs e.g. 8760h, 0 disables it (default: 0)
+	%s	Files of a sync15 document read ahead when its index is downloaded, 0 disables it (default: 0)
+	%s	Verify the x-goog-hash of uploaded blobs and send it with the downloads (default: false)
+	%s	Folders every new user starts with, comma separated, subfolders with / e.g. Inbox,Projects/Work
+	%s	Processors run on uploaded documents, in order, empty disables all (default: naming,protection,downscale)
+	%s	Uploading a document with a taken name: allow, suffix, skip, reject (also renames and moves) (default: allow)
+	%s	Uploading a password protected pdf or an epub with drm: reject, flag (default: reject)
+	%s	Downscale images in uploaded pdfs above this resolution, 0 disables it (default: 0)
+	%s	Jpeg quality of the downscaled images, 1-100 (default: %d)
+	%s	Audit log of the admin actions, off disables it (default: $DATADIR/%s)
+	%s	Accounts created in the web ui expire after this many days, 0 never (default: 0)
+	%s	Remove the data of expired accounts after e.g. 720h, 0 keeps it (default: 0)
+	%s	How often the free disk space is checked, 0 disables it (default: 5m)
+	%s	Warn when the free disk space drops below this percentage (default: %d)
+	%s	Critical below this percentage (default: %d)
+	%s	Url that gets a POST when the free disk space level changes
+	%s	Refuse uploads while the free disk space is critical (default: false)
+	%s	Replay the responses to uploads with the same Idempotency-Key for this long, 0 disables it (default: 24h)
+	%s	Longest a signed blob url may be valid, longer ones are rejected (default: 15m)
+	%s	Reject the signed blob urls of the old format, not bound to the method (default: false)
+	%s	Encrypt the documents and blobs in the data dir with this key, 32 bytes hex encoded
+	%s	Compress the sync15 blobs written: deflate (default: none)
+	%s	Search engines: noindex, off, or the path of a custom robots.txt (default: noindex)
+
+Sync15 maintenance:
+	%s	What to do with orphaned documents: ignore, recover, delete (default: ignore)
+	%s	Min age of an orphan before it is recovered/deleted (default: 168h)
+	%s	Min age of an unreachable blob before the garbage collection removes it, the generations of the root this recent are kept completely (default: 24h)
+	%s	What the garbage collection does with the unreachable blobs: delete, archive (default: delete)
+	%s	Run the garbage collection for all sync15 users this often e.g. 24h, 0 never (default: 0)
+	%s	Generations of the root the garbage collection keeps completely, they can be restored (default: %d)
+	%s	A device uploads a root of an older generation: strict (412), merge (default: strict)
+
+Webhooks, on the changes of the documents:
+	%s	Url that gets a signed POST for each event
+	%s	Key of the hmac-sha256 signature in X-Rmfakecloud-Signature, required with the url
+	%s	Events sent, comma separated: document.uploaded, document.deleted, root.generation (default: all)
+	%s	Deliveries of an event, with backoff, before it is moved to the failed ones (default: %d)
+
+Single sign-on with OpenID Connect, alongside the local accounts:
+	%s	Url of the provider (issuer), e.g. https://keycloak/realms/home
+	%s	Client id, the redirect url to register is STORAGE_URL/ui/api/oidc/callback
+	%s	Client secret
+	%s	Create a local user on the first login of an unknown user (default: false)
+
+Web UI branding:
+	%s	Title of the instance (default: %s)
+	%s	Url of the logo to show
+	%s	Theme color (#rrggbb)
+
+Object storage, for the synced blobs and documents:
+	%s	fs or s3 (default: fs), also read from %s
+	%s	Url of the s3 server
+	%s	Bucket
+	%s	Region (default: %s)
+	%s	Access key id
+	%s	Secret access key
 
 Emails, smtp:
 	%s
@@ -257,9 +1153,80 @@ myScript hwr (needs a developer account):
 		DefaultDataDir,
 		envTLSCert,
 		envTLSKey,
+		envTLSMinVersion,
+		envTLSCipherSuites,
 		EnvLogFile,
 		envHTTPSCookie,
 		envTrustProxy,
+		envExportCacheSize,
+		DefaultExportCacheSizeMB,
+		envDownloadLimit,
+		envUploadLimit,
+		envQuota,
+		envReadyCheckInterval,
+		envReadyCheckTimeout,
+		envMetrics,
+		envBlobCacheMaxAge,
+		envBlobReadAhead,
+		envBlobHashCheck,
+		envDefaultFolders,
+		envIngestProcessors,
+		envNameCollision,
+		envProtectedUploads,
+		envPDFImageMaxPPI,
+		envPDFImageQuality,
+		DefaultPDFImageQuality,
+		envAuditLog,
+		DefaultAuditLog,
+		envAccountExpiryDays,
+		envExpiredPurgeAfter,
+		envDiskCheckInterval,
+		envDiskWarnPercent,
+		DefaultDiskWarnPercent,
+		envDiskCriticalPercent,
+		DefaultDiskCriticalPercent,
+		envDiskWebhook,
+		envDiskCriticalReadOnly,
+		envIdempotencyWindow,
+		envURLMaxTTL,
+		envURLSignatureStrict,
+		envEncryptionKey,
+		envBlobCompression,
+		envRobots,
+
+		envOrphanPolicy,
+		envOrphanGracePeriod,
+		envGCGracePeriod,
+		envGCMode,
+		envGCInterval,
+		envRootHistory,
+		DefaultRootHistory,
+		envRootConflict,
+
+		envWebhookURL,
+		envWebhookSecret,
+		envWebhookEvents,
+		envWebhookMaxAttempts,
+		DefaultWebhookMaxAttempts,
+
+		envOIDCIssuer,
+		envOIDCClientID,
+		envOIDCClientSecret,
+		envOIDCAutoCreate,
+
+		envInstanceName,
+		DefaultInstanceName,
+		envLogoURL,
+		envThemeColor,
+
+		envStorageProvider,
+		envStorageDriver,
+		envS3Endpoint,
+		envS3Bucket,
+		envS3Region,
+		DefaultS3Region,
+		envS3AccessKey,
+		envS3SecretKey,
 
 		envSMTPServer,
 		envSMTPUsername,
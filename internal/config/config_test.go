@@ -0,0 +1,53 @@
+package config
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func TestParseCipherSuites(t *testing.T) {
+	tests := []struct {
+		names   string
+		want    []uint16
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+			[]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, false},
+		{"TLS_RSA_WITH_RC4_128_SHA", nil, true},
+		{"TLS_BLAH", nil, true},
+		{"TLS_AES_128_GCM_SHA256", nil, true},
+		{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_CHACHA20_POLY1305_SHA256", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.names, func(t *testing.T) {
+			got, err := parseCipherSuites(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCipherSuites() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseCipherSuites() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("parseCipherSuites() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// a clear error, not an unknown suite
+	if _, err := parseCipherSuites("TLS_AES_256_GCM_SHA384"); err == nil || !strings.Contains(err.Error(), "TLS 1.3") {
+		t.Errorf("tls 1.3 suite: %v", err)
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	if v, err := parseTLSVersion(""); err != nil || v != tls.VersionTLS12 {
+		t.Errorf("default should be 1.2, got %x %v", v, err)
+	}
+	if _, err := parseTLSVersion("1.1"); err == nil {
+		t.Error("1.1 should be rejected")
+	}
+}
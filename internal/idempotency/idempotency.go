@@ -0,0 +1,211 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Header the client's key of the request, a retry sends the same one
+	Header = "Idempotency-Key"
+	// ReplayedHeader set on the responses which are replays
+	ReplayedHeader = "Idempotent-Replayed"
+
+	// responses bigger than this aren't remembered
+	maxBodySize = 1 << 20
+	// maxKeyLength longer keys are rejected
+	maxKeyLength = 255
+)
+
+type response struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+	// done false while the first request is still being processed
+	done bool
+	// fingerprint the hash of the method, path and body of the request
+	fingerprint string
+}
+
+// Store remembers the responses to requests with an idempotency key
+type Store struct {
+	lock      sync.Mutex
+	window    time.Duration
+	responses map[string]*response
+}
+
+// NewStore remembers the responses for the window, 0 disables it
+func NewStore(window time.Duration) *Store {
+	return &Store{
+		window:    window,
+		responses: make(map[string]*response),
+	}
+}
+
+// recorder keeps a copy of what the handler writes
+type recorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.record(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	r.record([]byte(s))
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (r *recorder) record(b []byte) {
+	if r.overflow {
+		return
+	}
+	if r.body.Len()+len(b) > maxBodySize {
+		r.overflow = true
+		r.body.Reset()
+		return
+	}
+	r.body.Write(b)
+}
+
+// hashingBody hashes the request body as the handler reads it
+type hashingBody struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+func (h *hashingBody) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	return n, err
+}
+
+// sum the hash of the whole body, the rest the handler didn't read is read now
+func (h *hashingBody) sum() string {
+	io.Copy(ioutil.Discard, h)
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
+
+// fingerprint hashes the request, the body as it is read
+func fingerprint(c *gin.Context) *hashingBody {
+	h := sha256.New()
+	io.WriteString(h, c.Request.Method+" "+c.Request.URL.Path+"\n")
+	body := c.Request.Body
+	if body == nil {
+		body = http.NoBody
+	}
+	hashed := &hashingBody{ReadCloser: body, hash: h}
+	c.Request.Body = hashed
+	return hashed
+}
+
+// Begin handles the replay of a request of the user, call it once the user is authenticated
+// false means the response was written, a replay or a conflict, and the handler has to return
+// otherwise done has to be deferred, a handler which panics is forgotten like one that failed.
+// A key reused for another request (method, path or body) is rejected with 422
+func (s *Store) Begin(c *gin.Context, uid string) (done func(), ok bool) {
+	key := c.GetHeader(Header)
+	if key == "" || s.window == 0 {
+		return func() {}, true
+	}
+	if len(key) > maxKeyLength {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": Header + " too long"})
+		return nil, false
+	}
+	// keys are per user and per endpoint
+	scoped := uid + " " + c.Request.Method + " " + c.FullPath() + " " + key
+	now := time.Now()
+
+	body := fingerprint(c)
+
+	s.lock.Lock()
+	s.cleanup(now)
+	if r, found := s.responses[scoped]; found {
+		s.lock.Unlock()
+		if !r.done {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this " + Header + " is in progress"})
+			return nil, false
+		}
+		if body.sum() != r.fingerprint {
+			log.Warn(Header, " reused for another request: ", key)
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": Header + " was used for another request"})
+			return nil, false
+		}
+		log.Info("replaying the response of ", Header, ": ", key)
+		for name, values := range r.header {
+			c.Writer.Header()[name] = values
+		}
+		c.Header(ReplayedHeader, "true")
+		c.Writer.WriteHeader(r.status)
+		c.Writer.Write(r.body)
+		c.Abort()
+		return nil, false
+	}
+	pending := &response{expires: now.Add(s.window)}
+	s.responses[scoped] = pending
+	s.lock.Unlock()
+
+	rec := &recorder{ResponseWriter: c.Writer}
+	c.Writer = rec
+	return func() {
+		// deferred, this is where a panicking handler is noticed
+		failure := recover()
+		if failure != nil {
+			defer panic(failure)
+		}
+		status := rec.Status()
+		var sum string
+		if failure == nil {
+			sum = body.sum()
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		// failures can be retried
+		if failure != nil || status < 200 || status >= 300 || rec.overflow {
+			if s.responses[scoped] == pending {
+				delete(s.responses, scoped)
+			}
+			return
+		}
+		pending.fingerprint = sum
+		pending.status = status
+		pending.header = rec.Header().Clone()
+		pending.body = rec.body.Bytes()
+		pending.done = true
+	}, true
+}
+
+// Middleware for routes whose user id is in the context under userKey
+func (s *Store) Middleware(userKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		done, ok := s.Begin(c, c.GetString(userKey))
+		if !ok {
+			return
+		}
+		defer done()
+		c.Next()
+	}
+}
+
+// cleanup forgets the expired responses
+func (s *Store) cleanup(now time.Time) {
+	for key, r := range s.responses {
+		if now.After(r.expires) {
+			delete(s.responses, key)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package idempotency
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReplay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewStore(time.Hour)
+	calls := 0
+	router := gin.New()
+	router.Use(gin.RecoveryWithWriter(ioutil.Discard))
+	router.POST("/upload", func(c *gin.Context) {
+		c.Set("uid", c.GetHeader("uid"))
+	}, store.Middleware("uid"), func(c *gin.Context) {
+		calls++
+		switch c.Query("fail") {
+		case "status":
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		case "panic":
+			panic("failed")
+		}
+		c.JSON(http.StatusOK, gin.H{"calls": calls})
+	})
+
+	upload := func(uid, key, query, sent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/upload"+query, strings.NewReader(sent))
+		req.Header.Set("uid", uid)
+		if key != "" {
+			req.Header.Set(Header, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		uid      string
+		key      string
+		query    string
+		sent     string
+		code     int
+		body     string
+		replayed bool
+	}{
+		{"first", "a", "k1", "", "doc", http.StatusOK, `{"calls":1}`, false},
+		{"retry", "a", "k1", "", "doc", http.StatusOK, `{"calls":1}`, true},
+		{"another body", "a", "k1", "", "other", http.StatusUnprocessableEntity, `{"error":"Idempotency-Key was used for another request"}`, false},
+		{"another key", "a", "k2", "", "doc", http.StatusOK, `{"calls":2}`, false},
+		{"another user", "b", "k1", "", "doc", http.StatusOK, `{"calls":3}`, false},
+		{"no key", "a", "", "", "doc", http.StatusOK, `{"calls":4}`, false},
+		{"failed", "a", "k3", "?fail=status", "doc", http.StatusInternalServerError, "", false},
+		{"failure is retried", "a", "k3", "", "doc", http.StatusOK, `{"calls":6}`, false},
+		{"panicked", "a", "k4", "?fail=panic", "doc", http.StatusInternalServerError, "", false},
+		{"panic is retried", "a", "k4", "", "doc", http.StatusOK, `{"calls":8}`, false},
+	}
+	for _, tt := range tests {
+		w := upload(tt.uid, tt.key, tt.query, tt.sent)
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.code)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: got %s, want %s", tt.name, w.Body.String(), tt.body)
+		}
+		if replayed := w.Header().Get(ReplayedHeader) != ""; replayed != tt.replayed {
+			t.Errorf("%s: replayed %t", tt.name, replayed)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	store := NewStore(time.Hour)
+	store.responses["old"] = &response{done: true, expires: time.Now().Add(-time.Minute)}
+	store.responses["new"] = &response{done: true, expires: time.Now().Add(time.Minute)}
+	store.cleanup(time.Now())
+	if _, ok := store.responses["old"]; ok {
+		t.Error("old should have expired")
+	}
+	if _, ok := store.responses["new"]; !ok {
+		t.Error("new should be kept")
+	}
+}
@@ -0,0 +1,140 @@
+package jobs
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// StatusRunning the job is running
+	StatusRunning = "running"
+	// StatusDone the job finished
+	StatusDone = "done"
+	// StatusFailed the job returned an error
+	StatusFailed = "failed"
+
+	// KindGC the garbage collection of the blobs of a user, the key is the user
+	KindGC = "gc"
+
+	// keep finished jobs around for this long
+	retention = time.Hour
+)
+
+// ErrorAlreadyRunning a job with the same key is running
+var ErrorAlreadyRunning = errors.New("already running")
+
+// ErrorNotFound no such job
+var ErrorNotFound = errors.New("job not found")
+
+// Job a tracked background job
+type Job struct {
+	ID       string      `json:"id"`
+	Kind     string      `json:"kind"`
+	Key      string      `json:"key"`
+	Status   string      `json:"status"`
+	Started  time.Time   `json:"started"`
+	Finished *time.Time  `json:"finished,omitempty"`
+	Progress interface{} `json:"progress,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// Func the work, reports its progress with update
+type Func func(update func(progress interface{})) error
+
+// Registry keeps track of background jobs
+type Registry struct {
+	lock    sync.Mutex
+	jobs    map[string]*Job
+	running map[string]string
+}
+
+// NewRegistry creates a registry
+func NewRegistry() *Registry {
+	return &Registry{
+		jobs:    make(map[string]*Job),
+		running: make(map[string]string),
+	}
+}
+
+// Start runs the job in the background, only one job per kind and key can run at a time
+func (r *Registry) Start(kind, key string, fn Func) (*Job, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	runningKey := kind + "/" + key
+	if id, ok := r.running[runningKey]; ok {
+		return r.snapshot(r.jobs[id]), ErrorAlreadyRunning
+	}
+	r.cleanup()
+
+	job := &Job{
+		ID:      uuid.NewString(),
+		Kind:    kind,
+		Key:     key,
+		Status:  StatusRunning,
+		Started: time.Now(),
+	}
+	r.jobs[job.ID] = job
+	r.running[runningKey] = job.ID
+
+	go func() {
+		err := fn(func(progress interface{}) {
+			r.lock.Lock()
+			job.Progress = progress
+			r.lock.Unlock()
+		})
+
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		now := time.Now()
+		job.Finished = &now
+		if err != nil {
+			log.Error("[jobs] ", kind, " ", key, " failed: ", err)
+			job.Status = StatusFailed
+			job.Error = err.Error()
+		} else {
+			job.Status = StatusDone
+		}
+		delete(r.running, runningKey)
+	}()
+	return r.snapshot(job), nil
+}
+
+// Get the current state of a job
+func (r *Registry) Get(id string) (*Job, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if job, ok := r.jobs[id]; ok {
+		return r.snapshot(job), nil
+	}
+	return nil, ErrorNotFound
+}
+
+// List all known jobs
+func (r *Registry) List() []*Job {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := make([]*Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		result = append(result, r.snapshot(job))
+	}
+	return result
+}
+
+func (r *Registry) snapshot(job *Job) *Job {
+	copy := *job
+	return &copy
+}
+
+// cleanup forgets finished jobs past the retention
+func (r *Registry) cleanup() {
+	for id, job := range r.jobs {
+		if job.Finished != nil && time.Since(*job.Finished) > retention {
+			delete(r.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitFinished polls the job until it is no longer running
+func waitFinished(t *testing.T, r *Registry, id string) *Job {
+	for i := 0; i < 100; i++ {
+		job, err := r.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != StatusRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("job still running: ", id)
+	return nil
+}
+
+func TestStart(t *testing.T) {
+	r := NewRegistry()
+	release := make(chan struct{})
+	job, err := r.Start(KindGC, "user", func(update func(interface{})) error {
+		update(1)
+		<-release
+		update(2)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusRunning || job.Kind != KindGC || job.Key != "user" || job.Finished != nil {
+		t.Errorf("started %+v", job)
+	}
+
+	close(release)
+	done := waitFinished(t, r, job.ID)
+	if done.Status != StatusDone || done.Finished == nil || done.Progress != 2 || done.Error != "" {
+		t.Errorf("done %+v", done)
+	}
+
+	failed, err := r.Start(KindGC, "user", func(func(interface{})) error {
+		return errors.New("broken")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done = waitFinished(t, r, failed.ID); done.Status != StatusFailed || done.Error != "broken" {
+		t.Errorf("failed %+v", done)
+	}
+	if jobs := r.List(); len(jobs) != 2 {
+		t.Errorf("jobs %v", jobs)
+	}
+	if _, err = r.Get("missing"); err != ErrorNotFound {
+		t.Errorf("missing job: %v", err)
+	}
+}
+
+func TestStartAlreadyRunning(t *testing.T) {
+	r := NewRegistry()
+	release := make(chan struct{})
+	running, err := r.Start(KindGC, "user", func(func(interface{})) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := r.Start(KindGC, "user", func(func(interface{})) error {
+		t.Error("ran twice")
+		return nil
+	})
+	if err != ErrorAlreadyRunning || job.ID != running.ID {
+		t.Errorf("second run: %v %+v", err, job)
+	}
+
+	// another key runs alongside
+	other, err := r.Start(KindGC, "other", func(func(interface{})) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFinished(t, r, other.ID)
+
+	close(release)
+	waitFinished(t, r, running.ID)
+	again, err := r.Start(KindGC, "user", func(func(interface{})) error { return nil })
+	if err != nil || again.ID == running.ID {
+		t.Errorf("after it finished: %v %+v", err, again)
+	}
+	waitFinished(t, r, again.ID)
+}
+
+func TestRetention(t *testing.T) {
+	r := NewRegistry()
+	release := make(chan struct{})
+	defer close(release)
+	running, err := r.Start(KindGC, "running", func(func(interface{})) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := r.Start(KindGC, "old", func(func(interface{})) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, err := r.Start(KindGC, "recent", func(func(interface{})) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFinished(t, r, old.ID)
+	waitFinished(t, r, recent.ID)
+
+	r.lock.Lock()
+	finished := time.Now().Add(-retention - time.Minute)
+	r.jobs[old.ID].Finished = &finished
+	r.lock.Unlock()
+
+	// the next start forgets the jobs that finished past the retention
+	next, err := r.Start(KindGC, "next", func(func(interface{})) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFinished(t, r, next.ID)
+	if _, err = r.Get(old.ID); err != ErrorNotFound {
+		t.Errorf("old job kept: %v", err)
+	}
+	for _, id := range []string{running.ID, recent.ID, next.ID} {
+		if _, err = r.Get(id); err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unmatchedRoute the route label of the requests no route matched, not the path to keep the series bounded
+const unmatchedRoute = "unmatched"
+
+// Middleware registers the metrics of all the requests and records them, use it before adding the routes
+func (r *Registry) Middleware() gin.HandlerFunc {
+	requests := r.NewCounterVec("rmfakecloud_http_requests_total",
+		"HTTP requests by route and status.", "method", "route", "status")
+	duration := r.NewHistogramVec("rmfakecloud_http_request_duration_seconds",
+		"Latency of the HTTP requests by route.", ExponentialBuckets(0.005, 2, 12), "method", "route")
+	authFailures := r.NewCounterVec("rmfakecloud_auth_failures_total",
+		"Requests rejected with 401 or 403, failed logins, device pairings and expired or revoked tokens.", "route")
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := c.Writer.Status()
+		requests.Inc(c.Request.Method, route, strconv.Itoa(status))
+		duration.Observe(time.Since(start).Seconds(), c.Request.Method, route)
+		if status == http.StatusUnauthorized || status == http.StatusForbidden {
+			authFailures.Inc(route)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType of the prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metric writes itself in the text exposition format
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry the metrics which are exported together
+type Registry struct {
+	lock    sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("metric registered twice: " + name)
+	}
+	r.metrics[name] = m
+}
+
+// Write writes all the metrics, sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.metrics[name].write(w)
+	}
+}
+
+// Handler serves the metrics to prometheus
+func (r *Registry) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", ContentType)
+		c.Status(http.StatusOK)
+		r.Write(c.Writer)
+	}
+}
+
+// series the values of one metric by label values
+type series struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (s *series) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+}
+
+// key joins the label values, the count must match the labels
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("%s: %d label values for %d labels", s.name, len(values), len(s.labels)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs the labels formatted for the sample line, extra is added last (le of the buckets)
+func (s *series) labelPairs(key string, extra ...string) string {
+	pairs := make([]string, 0, len(s.labels)+1)
+	if len(s.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, s.labels[i]+"="+quoteLabel(value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quoteLabel(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper the only escapes of label values in the exposition format,
+// other characters (unicode included) are written as they are
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabel the label value in quotes, escaped
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// CounterVec counters by label values
+type CounterVec struct {
+	series
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec registers a counter with the labels
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		series: series{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]float64),
+	}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds one to the counter of the label values
+func (c *CounterVec) Inc(values ...string) {
+	key := c.key(values)
+	c.lock.Lock()
+	c.values[key]++
+	c.lock.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatFloat(c.values[key]))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec histograms by label values, the buckets are the upper bounds
+type HistogramVec struct {
+	series
+	buckets []float64
+	lock    sync.Mutex
+	values  map[string]*histogram
+}
+
+// NewHistogramVec registers a histogram with the buckets and labels
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		series:  series{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	r.register(name, h)
+	return h
+}
+
+// Observe records the value in the histogram of the label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		hist := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatFloat(bound)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), hist.count)
+	}
+}
+
+// gaugeFunc a gauge computed when the metrics are read
+type gaugeFunc struct {
+	series
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is computed on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) {
+	r.register(name, &gaugeFunc{
+		series: series{name: name, help: help, kind: "gauge"},
+		value:  value,
+	})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value()))
+}
+
+// ExponentialBuckets count buckets, the first is start and each next one factor times bigger
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWrite(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("requests_total", "Requests.", "op", "result")
+	sizes := registry.NewHistogramVec("size_bytes", "Sizes.", []float64{10, 100}, "op")
+	registry.NewGaugeFunc("conflicts", "Conflicts.", func() float64 { return 2 })
+
+	requests.Inc("upload", "ok")
+	requests.Inc("upload", "ok")
+	requests.Inc("download", "notfound")
+	sizes.Observe(5, "upload")
+	sizes.Observe(50, "upload")
+	sizes.Observe(500, "upload")
+
+	var out bytes.Buffer
+	registry.Write(&out)
+	want := `# HELP conflicts Conflicts.
+# TYPE conflicts gauge
+conflicts 2
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{op="download",result="notfound"} 1
+requests_total{op="upload",result="ok"} 2
+# HELP size_bytes Sizes.
+# TYPE size_bytes histogram
+size_bytes_bucket{op="upload",le="10"} 1
+size_bytes_bucket{op="upload",le="100"} 2
+size_bytes_bucket{op="upload",le="+Inf"} 3
+size_bytes_sum{op="upload"} 555
+size_bytes_count{op="upload"} 3
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("requests_total", "Requests.", "path")
+	requests.Inc("a\\b \"c\"\nd ünï\t")
+
+	var out bytes.Buffer
+	registry.Write(&out)
+	// only backslash, quote and newline are escaped, not as go quotes them
+	want := `requests_total{path="a\\b \"c\"\nd ünï` + "\t" + `"} 1`
+	if !strings.Contains(out.String(), want+"\n") {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry := NewRegistry()
+	router := gin.New()
+	router.Use(registry.Middleware())
+	router.GET("/docs/:id", func(c *gin.Context) {
+		if c.Param("id") == "secret" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	for _, path := range []string{"/docs/a", "/docs/b", "/docs/secret", "/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var out bytes.Buffer
+	registry.Write(&out)
+	for _, want := range []string{
+		`rmfakecloud_auth_failures_total{route="/docs/:id"} 1`,
+		`rmfakecloud_http_requests_total{method="GET",route="/docs/:id",status="200"} 2`,
+		`rmfakecloud_http_requests_total{method="GET",route="/docs/:id",status="401"} 1`,
+		`rmfakecloud_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`rmfakecloud_http_request_duration_seconds_count{method="GET",route="/docs/:id"} 3`,
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("missing %s in\n%s", want, out.String())
+		}
+	}
+}
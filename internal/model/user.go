@@ -51,6 +51,19 @@ type User struct {
 	// Sync15 if the user should use this sync type (which uses a lot less bandwidth)
 	Sync15       bool
 	Integrations []IntegrationConfig
+	// DownloadLimit/UploadLimit in KB/s, 0 uses the server default, -1 is unlimited
+	DownloadLimit int `yaml:",omitempty"`
+	UploadLimit   int `yaml:",omitempty"`
+	// Quota in bytes, 0 uses the server default, -1 is unlimited
+	Quota int64 `yaml:",omitempty"`
+	// ExpiresAt the account can't log in after this, zero never expires
+	ExpiresAt time.Time `yaml:",omitempty"`
+	// Disabled the account can't log in or get tokens until it's enabled again
+	Disabled bool `yaml:",omitempty"`
+	// TokensRevokedAt the device tokens issued before this are rejected
+	TokensRevokedAt time.Time `yaml:",omitempty"`
+	// OIDCSubject the subject at the openid connect provider the user logs in with, empty for none
+	OIDCSubject string `yaml:",omitempty"`
 }
 
 // IntegrationConfig config for various integrations
@@ -144,6 +157,16 @@ func (u *User) SetPassword(raw string) (err error) {
 	return
 }
 
+// Expired the account expired and can't log in anymore
+func (u *User) Expired() bool {
+	return !u.ExpiresAt.IsZero() && time.Now().After(u.ExpiresAt)
+}
+
+// TokenRevoked the token issued at (unix seconds) was revoked, tokens without it count as issued before
+func (u *User) TokenRevoked(issuedAt int64) bool {
+	return !u.TokensRevokedAt.IsZero() && issuedAt < u.TokensRevokedAt.Unix()
+}
+
 // CheckPassword checks the password
 func (u *User) CheckPassword(raw string) (bool, error) {
 	parts := strings.Split(u.Password, "$")
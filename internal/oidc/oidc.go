@@ -0,0 +1,278 @@
+package oidc
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	scopes        = "openid email profile"
+	timeout       = 10 * time.Second
+	// keysRefreshInterval the keys are fetched again for an unknown kid, not more often than this
+	keysRefreshInterval = time.Minute
+)
+
+// ErrorInvalidToken the id token isn't signed by the provider or isn't for this client
+var ErrorInvalidToken = errors.New("invalid id token")
+
+// Identity the claims of the id token the users are mapped with
+type Identity struct {
+	// Subject the id of the user at the provider, it doesn't change
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// discovery the endpoints of the provider
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type idClaims struct {
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	jwt.RegisteredClaims
+}
+
+// Provider an openid connect provider for the authorization code flow
+// the endpoints and keys are fetched on first use, the server starts while the provider is down
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+
+	lock      sync.Mutex
+	endpoints *discovery
+	keys      map[string]*rsa.PublicKey
+	keysTime  time.Time
+}
+
+// New a provider of the issuer, redirectURL is the callback registered for the client
+func New(issuer, clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		client:       &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *Provider) getJSON(u string, v interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover the endpoints, with the lock held
+func (p *Provider) discover() (*discovery, error) {
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+	d := &discovery{}
+	err := p.getJSON(p.issuer+discoveryPath, d)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("the provider is %s, not %s", d.Issuer, p.issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("the provider doesn't support the authorization code flow")
+	}
+	p.endpoints = d
+	return d, nil
+}
+
+// AuthCodeURL where to send the browser to log in, the state and nonce come back to the callback
+func (p *Provider) AuthCodeURL(state, nonce string) (string, error) {
+	p.lock.Lock()
+	d, err := p.discover()
+	p.lock.Unlock()
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.clientID},
+		"redirect_uri":  {p.redirectURL},
+		"scope":         {scopes},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems the code of the callback and verifies the id token
+func (p *Provider) Exchange(code, nonce string) (*Identity, error) {
+	p.lock.Lock()
+	d, err := p.discover()
+	p.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.redirectURL},
+	}
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return nil, fmt.Errorf("token response, status %d: %w", resp.StatusCode, err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("token request: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("no id token in the response")
+	}
+	return p.Verify(token.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of the id token
+func (p *Provider) Verify(idToken, nonce string) (*Identity, error) {
+	claims := &idClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}))
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidToken, err)
+	}
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.issuer:
+		return nil, fmt.Errorf("%w: issued by %s", ErrorInvalidToken, claims.Issuer)
+	case !claims.VerifyAudience(p.clientID, true):
+		return nil, fmt.Errorf("%w: not for this client", ErrorInvalidToken)
+	case !claims.VerifyExpiresAt(time.Now(), true):
+		return nil, fmt.Errorf("%w: expired", ErrorInvalidToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: wrong nonce", ErrorInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrorInvalidToken)
+	}
+	return &Identity{
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// key the signing key with the kid, the keys are fetched again when it is unknown (rotated)
+func (p *Provider) key(kid string) (*rsa.PublicKey, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysTime) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown key %s", kid)
+	}
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = p.getJSON(d.JWKSURI, &set)
+	if err != nil {
+		return nil, err
+	}
+	p.keysTime = time.Now()
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := rsaKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", k.Kid, err)
+		}
+		p.keys[k.Kid] = key
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %s", kid)
+}
+
+func rsaKey(k jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
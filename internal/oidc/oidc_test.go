@@ -0,0 +1,147 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testProvider an identity provider which issues id tokens for the codes
+type testProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]*idClaims
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProvider{key: key, claims: make(map[string]*idClaims)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/auth",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			Kid: "k1",
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		claims, ok := p.claims[r.PostFormValue("code")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(t, claims)})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *testProvider) sign(t *testing.T, claims *idClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (p *testProvider) claimsFor(subject, nonce string) *idClaims {
+	return &idClaims{
+		Nonce: nonce,
+		Email: subject + "@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.URL,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{"client"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+}
+
+func TestAuthCodeFlow(t *testing.T) {
+	idp := newTestProvider(t)
+	defer idp.Close()
+	provider := New(idp.URL+"/", "client", "secret", "https://rmfakecloud/ui/api/oidc/callback")
+
+	authURL, err := provider.AuthCodeURL("state", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if q := u.Query(); u.Path != "/auth" || q.Get("client_id") != "client" || q.Get("state") != "state" || q.Get("nonce") != "nonce" {
+		t.Errorf("auth url %s", authURL)
+	}
+
+	idp.claims["code"] = idp.claimsFor("alice", "nonce")
+	identity, err := provider.Exchange("code", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "alice" || identity.Email != "alice@example.com" {
+		t.Errorf("identity %+v", identity)
+	}
+
+	if _, err = provider.Exchange("code", "other"); !errors.Is(err, ErrorInvalidToken) {
+		t.Errorf("wrong nonce: %v", err)
+	}
+	if _, err = provider.Exchange("unknown", "nonce"); err == nil {
+		t.Error("unknown code")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	idp := newTestProvider(t)
+	defer idp.Close()
+	provider := New(idp.URL, "client", "secret", "")
+
+	for name, change := range map[string]func(c *idClaims){
+		"other client": func(c *idClaims) { c.Audience = jwt.ClaimStrings{"other"} },
+		"other issuer": func(c *idClaims) { c.Issuer = "https://evil" },
+		"expired":      func(c *idClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) },
+		"no subject":   func(c *idClaims) { c.Subject = "" },
+	} {
+		claims := idp.claimsFor("alice", "nonce")
+		change(claims)
+		if _, err := provider.Verify(idp.sign(t, claims), "nonce"); !errors.Is(err, ErrorInvalidToken) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// signed with another key
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claimsFor("alice", "nonce"))
+	forged.Header["kid"] = "k1"
+	signed, _ := forged.SignedString(other)
+	if _, err := provider.Verify(signed, "nonce"); !errors.Is(err, ErrorInvalidToken) {
+		t.Errorf("forged: %v", err)
+	}
+}
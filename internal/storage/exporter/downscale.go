@@ -0,0 +1,39 @@
+package exporter
+
+import (
+	"errors"
+	"io"
+
+	pdf "github.com/unidoc/unipdf/v3/model"
+	"github.com/unidoc/unipdf/v3/model/optimize"
+)
+
+// ErrEncrypted the pdf is encrypted and can't be rewritten
+var ErrEncrypted = errors.New("the pdf is encrypted")
+
+// DownscaleImages rewrites the pdf with the embedded images above maxPPI resampled
+// quality is the jpeg quality of the resampled images
+func DownscaleImages(input io.ReadSeeker, output io.Writer, maxPPI float64, quality int) error {
+	reader, err := pdf.NewPdfReader(input)
+	if err != nil {
+		return err
+	}
+	encrypted, err := reader.IsEncrypted()
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		return ErrEncrypted
+	}
+
+	writer, err := reader.ToWriter(&pdf.ReaderToWriterOpts{})
+	if err != nil {
+		return err
+	}
+	writer.SetOptimizer(optimize.New(optimize.Options{
+		ImageUpperPPI:   maxPPI,
+		ImageQuality:    quality,
+		CompressStreams: true,
+	}))
+	return writer.Write(output)
+}
@@ -0,0 +1,72 @@
+package exporter
+
+import (
+	"errors"
+	"image"
+	"io"
+
+	pdf "github.com/unidoc/unipdf/v3/model"
+	"github.com/unidoc/unipdf/v3/render"
+)
+
+// ErrNoPage the document doesn't have the page
+var ErrNoPage = errors.New("no such page")
+
+// RenderPage renders a page of the pdf to an image width pixels wide
+// the page is clamped to the pages of the document, starting at 1
+func RenderPage(input io.ReadSeeker, page, width int) (image.Image, error) {
+	return renderPage(input, page, width, true)
+}
+
+// RenderExistingPage renders a page of the pdf like RenderPage,
+// ErrNoPage when the document doesn't have it
+func RenderExistingPage(input io.ReadSeeker, page, width int) (image.Image, error) {
+	return renderPage(input, page, width, false)
+}
+
+// PageCount the number of pages of the pdf
+func PageCount(input io.ReadSeeker) (int, error) {
+	reader, err := pdf.NewPdfReader(input)
+	if err != nil {
+		return 0, err
+	}
+	return reader.GetNumPages()
+}
+
+func renderPage(input io.ReadSeeker, page, width int, clamp bool) (image.Image, error) {
+	reader, err := pdf.NewPdfReader(input)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := reader.IsEncrypted()
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		return nil, ErrEncrypted
+	}
+
+	numPages, err := reader.GetNumPages()
+	if err != nil {
+		return nil, err
+	}
+	if page < 1 || page > numPages {
+		if !clamp {
+			return nil, ErrNoPage
+		}
+		if page > numPages {
+			page = numPages
+		}
+		if page < 1 {
+			page = 1
+		}
+	}
+	p, err := reader.GetPage(page)
+	if err != nil {
+		return nil, err
+	}
+
+	device := render.NewImageDevice()
+	device.OutputWidth = width
+	return device.Render(p)
+}
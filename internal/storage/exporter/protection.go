@@ -0,0 +1,73 @@
+package exporter
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	pdf "github.com/unidoc/unipdf/v3/model"
+)
+
+// fontObfuscation algorithms, encrypted fonts still render
+var fontObfuscation = map[string]bool{
+	"http://www.idpf.org/2008/embedding": true,
+	"http://ns.adobe.com/pdf/enc#RC":     true,
+}
+
+// PDFProtected the pdf needs a password to be opened
+// pdfs encrypted with an empty user password (permissions only) open fine
+func PDFProtected(input io.ReadSeeker) (bool, error) {
+	reader, err := pdf.NewPdfReader(input)
+	if err != nil {
+		return false, err
+	}
+	encrypted, err := reader.IsEncrypted()
+	if err != nil || !encrypted {
+		return false, err
+	}
+	ok, err := reader.Decrypt([]byte(""))
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+type epubEncryption struct {
+	EncryptedData []struct {
+		EncryptionMethod struct {
+			Algorithm string `xml:"Algorithm,attr"`
+		}
+	}
+}
+
+// EPUBProtected the epub has drm, i.e. encrypted content or a rights file
+func EPUBProtected(input io.ReaderAt, size int64) (bool, error) {
+	archive, err := zip.NewReader(input, size)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range archive.File {
+		switch strings.ToLower(f.Name) {
+		case "meta-inf/rights.xml":
+			return true, nil
+		case "meta-inf/encryption.xml":
+			r, err := f.Open()
+			if err != nil {
+				return false, err
+			}
+			encryption := epubEncryption{}
+			err = xml.NewDecoder(r).Decode(&encryption)
+			r.Close()
+			if err != nil {
+				return false, err
+			}
+			for _, e := range encryption.EncryptedData {
+				if !fontObfuscation[e.EncryptionMethod.Algorithm] {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,477 @@
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	accountManifestName = "manifest.json"
+	accountBlobsDir     = "blobs/"
+	accountDocumentsDir = "documents/"
+	accountVersion      = 1
+)
+
+// accountManifest the first entry of an account archive
+type accountManifest struct {
+	Version int       `json:"version"`
+	UserID  string    `json:"userId"`
+	Sync15  bool      `json:"sync15"`
+	Created time.Time `json:"created"`
+	// Root the hash of the root index, sync15 only
+	Root       string `json:"root,omitempty"`
+	Generation int64  `json:"generation,omitempty"`
+	Documents  int    `json:"documents"`
+}
+
+func writeManifest(w *tar.Writer, manifest *accountManifest) error {
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return writeNativeEntry(w, accountManifestName, int64(len(content)), bytes.NewReader(content))
+}
+
+// spooledFile a temp file which is removed when closed
+type spooledFile struct {
+	*os.File
+}
+
+func (f *spooledFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// openBlob a blob and its size, the ones of another provider are spooled to a temp file for the size
+func (fs *FileSystemStorage) openBlob(uid, hash string) (io.ReadCloser, int64, error) {
+	if fs.blobs == nil {
+		return fs.openStored(uid, path.Join(fs.getUserBlobPath(uid), common.Sanitize(hash)))
+	}
+	r, _, err := fs.blobs.LoadBlob(uid, hash)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+	tmp, err := ioutil.TempFile(fs.getUserPath(uid), ".tmp")
+	if err != nil {
+		return nil, 0, err
+	}
+	spooled := &spooledFile{tmp}
+	size, err := io.Copy(tmp, r)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spooled.Close()
+		return nil, 0, err
+	}
+	return spooled, size, nil
+}
+
+// readBlob the content of a blob, ErrorNotFound when the user doesn't have it
+func (fs *FileSystemStorage) readBlob(uid, hash string) ([]byte, error) {
+	r, _, err := fs.openBlob(uid, hash)
+	if os.IsNotExist(err) {
+		return nil, ErrorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// ExportBlobAccount writes a tar with the sync15 root, the document indexes and the files of the user
+func (fs *FileSystemStorage) ExportBlobAccount(uid string, w io.Writer) error {
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	err = writeManifest(tw, &accountManifest{
+		Version:    accountVersion,
+		UserID:     uid,
+		Sync15:     true,
+		Created:    time.Now().UTC(),
+		Root:       tree.Hash,
+		Generation: tree.Generation,
+		Documents:  len(tree.Docs),
+	})
+	if err != nil {
+		return err
+	}
+
+	hashes := make([]string, 0)
+	if tree.Hash != "" {
+		hashes = append(hashes, tree.Hash)
+	}
+	for _, doc := range tree.Docs {
+		hashes = append(hashes, doc.Hash)
+		for _, f := range doc.Files {
+			hashes = append(hashes, f.Hash)
+		}
+	}
+	written := make(map[string]bool)
+	for _, hash := range hashes {
+		if written[hash] {
+			continue
+		}
+		written[hash] = true
+		blob, size, err := fs.openBlob(uid, hash)
+		if err != nil {
+			return fmt.Errorf("blob %s: %w", hash, err)
+		}
+		err = writeNativeEntry(tw, accountBlobsDir+hash, size, blob)
+		blob.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ExportAccount writes a tar with the sync10 documents and their metadata
+func (fs *FileSystemStorage) ExportAccount(uid string, w io.Writer) error {
+	docs, err := fs.GetAllMetadata(uid)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	err = writeManifest(tw, &accountManifest{
+		Version:   accountVersion,
+		UserID:    uid,
+		Created:   time.Now().UTC(),
+		Documents: len(docs),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		id := common.Sanitize(doc.ID)
+		// the zip first, a document is complete once its metadata is there
+		archive, size, err := fs.openStored(uid, fs.getPathFromUser(uid, id+models.ZipFileExt))
+		switch {
+		case os.IsNotExist(err):
+			// folders
+		case err != nil:
+			return fmt.Errorf("document %s: %w", id, err)
+		default:
+			err = writeNativeEntry(tw, accountDocumentsDir+id+models.ZipFileExt, size, archive)
+			archive.Close()
+			if err != nil {
+				return err
+			}
+		}
+		content, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		err = writeNativeEntry(tw, accountDocumentsDir+id+models.MetadataFileExt, int64(len(content)), bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// archiveError the archive can't be read, unless it is over the quota
+func archiveError(err error) error {
+	if errors.Is(err, storage.ErrorOverQuota) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", storage.ErrorInvalidArchive, err)
+}
+
+// readManifest the first entry of the archive, it has to be of the same sync version
+func readManifest(tr *tar.Reader, sync15 bool) (*accountManifest, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, archiveError(err)
+	}
+	if hdr.Name != accountManifestName {
+		return nil, fmt.Errorf("%w: %s is not the first entry", storage.ErrorInvalidArchive, accountManifestName)
+	}
+	manifest := &accountManifest{}
+	err = json.NewDecoder(tr).Decode(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", storage.ErrorInvalidArchive, err)
+	}
+	if manifest.Version != accountVersion {
+		return nil, fmt.Errorf("%w: version %d", storage.ErrorInvalidArchive, manifest.Version)
+	}
+	if manifest.Sync15 != sync15 {
+		return nil, fmt.Errorf("%w: exported from a sync15 account: %t", storage.ErrorInvalidArchive, manifest.Sync15)
+	}
+	return manifest, nil
+}
+
+// archiveEntry the name of the file in the dir, no subdirs or hidden files
+func archiveEntry(hdr *tar.Header, dir string) (string, bool) {
+	if hdr.Typeflag != tar.TypeReg || !strings.HasPrefix(hdr.Name, dir) {
+		return "", false
+	}
+	name := strings.TrimPrefix(hdr.Name, dir)
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\") {
+		return "", false
+	}
+	return name, true
+}
+
+// ImportBlobAccount restores an archive of ExportBlobAccount, the documents are added to the ones
+// of the user, replacing those with the same id, as a new generation of the root
+func (fs *FileSystemStorage) ImportBlobAccount(uid string, r io.Reader) (*storage.AccountImport, error) {
+	defer fs.usageChanged(uid)
+	body, err := fs.limitToQuota(uid, r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(body)
+	manifest, err := readManifest(tr, true)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("importing the account of ", manifest.UserID, " generation ", manifest.Generation, " for ", uid)
+
+	result := &storage.AccountImport{
+		Documents: make([]string, 0),
+	}
+	// the indexes aren't named by the checksum of their content, they wait here until the root verifies them
+	indexes := make(map[string]string)
+	defer func() {
+		for _, tmpPath := range indexes {
+			os.Remove(tmpPath)
+		}
+	}()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, archiveError(err)
+		}
+		hash, ok := archiveEntry(hdr, accountBlobsDir)
+		if !ok {
+			log.Warn("import: skipping ", hdr.Name)
+			continue
+		}
+		if !isContentHash(hash) {
+			return nil, fmt.Errorf("%w: %s is not a blob", storage.ErrorInvalidArchive, hdr.Name)
+		}
+		tmpPath, checksum, err := fs.spoolEntry(uid, tr)
+		if err != nil {
+			return nil, archiveError(err)
+		}
+		if checksum != hash {
+			indexes[hash] = tmpPath
+			continue
+		}
+		err = fs.savePayload(uid, hash, tmpPath)
+		os.Remove(tmpPath)
+		if err != nil {
+			return nil, err
+		}
+		result.Blobs++
+	}
+	if manifest.Root != "" {
+		docs, err := fs.importIndex(uid, manifest.Root, indexes)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range docs {
+			files, err := fs.importIndex(uid, d.Hash, indexes)
+			if err != nil {
+				return nil, err
+			}
+			// the root mustn't point to files which are neither in the archive nor stored
+			for _, f := range files {
+				if err = fs.checkBlob(uid, f.Hash); err != nil {
+					return nil, fmt.Errorf("%w: document %s: %s: %v", storage.ErrorInvalidArchive, d.EntryName, f.EntryName, err)
+				}
+			}
+		}
+		result.Blobs += len(docs) + 1
+	}
+	if len(indexes) > 0 {
+		return nil, fmt.Errorf("%w: %d blobs don't match their hash", storage.ErrorInvalidArchive, len(indexes))
+	}
+	if manifest.Root == "" {
+		return result, nil
+	}
+
+	ls := &LocalBlobStorage{
+		fs:  fs,
+		uid: uid,
+	}
+	imported, err := rootDocuments(ls, manifest.Root)
+	if err != nil {
+		return nil, fmt.Errorf("%w: root index: %v", storage.ErrorInvalidArchive, err)
+	}
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	for id, entry := range imported {
+		doc := &models.HashDoc{}
+		err = doc.Mirror(entry, ls)
+		if err != nil {
+			return nil, fmt.Errorf("%w: document %s: %v", storage.ErrorInvalidArchive, id, err)
+		}
+		// replaced
+		tree.Remove(id)
+		err = tree.Add(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: document %s: %v", storage.ErrorInvalidArchive, id, err)
+		}
+		result.Documents = append(result.Documents, id)
+	}
+	err = fs.commitTree(uid, tree)
+	if err != nil {
+		return nil, err
+	}
+	result.Generation = tree.Generation
+	return result, nil
+}
+
+// spoolEntry writes the entry to a temp file, with the sha256 of its content
+func (fs *FileSystemStorage) spoolEntry(uid string, r io.Reader) (string, string, error) {
+	tmp, err := ioutil.TempFile(fs.getUserPath(uid), ".tmp")
+	if err != nil {
+		return "", "", err
+	}
+	defer tmp.Close()
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hasher), r)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	return tmp.Name(), hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// checkBlob whether the user has the blob
+func (fs *FileSystemStorage) checkBlob(uid, hash string) error {
+	r, _, err := fs.blobProvider().LoadBlob(uid, hash)
+	if err != nil {
+		return err
+	}
+	return r.Close()
+}
+
+// importIndex stores the spooled index when the hash of its entries is its name, an index the user
+// already has is read from the blobs. Returns the entries
+func (fs *FileSystemStorage) importIndex(uid, hash string, indexes map[string]string) ([]*models.HashEntry, error) {
+	if !isContentHash(hash) {
+		return nil, fmt.Errorf("%w: %s is not an index", storage.ErrorInvalidArchive, hash)
+	}
+	tmpPath, ok := indexes[hash]
+	if !ok {
+		r, _, err := fs.blobProvider().LoadBlob(uid, hash)
+		if err != nil {
+			return nil, fmt.Errorf("%w: index %s: %v", storage.ErrorInvalidArchive, hash, err)
+		}
+		defer r.Close()
+		entries, err := models.ParseIndex(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: index %s: %v", storage.ErrorInvalidArchive, hash, err)
+		}
+		return entries, nil
+	}
+
+	content, err := ioutil.ReadFile(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := models.ParseIndex(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("%w: index %s: %v", storage.ErrorInvalidArchive, hash, err)
+	}
+	// sorts the entries
+	checked := append([]*models.HashEntry(nil), entries...)
+	if entriesHash, err := models.HashEntries(checked); err != nil || entriesHash != hash {
+		return nil, fmt.Errorf("%w: index %s doesn't match its hash", storage.ErrorInvalidArchive, hash)
+	}
+	// not as a payload, it mustn't be shared under a name which isn't its checksum
+	err = fs.saveBlob(uid, hash, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(tmpPath)
+	delete(indexes, hash)
+	return entries, nil
+}
+
+// ImportAccount restores an archive of ExportAccount, documents with the same id are replaced
+func (fs *FileSystemStorage) ImportAccount(uid string, r io.Reader) (*storage.AccountImport, error) {
+	body, err := fs.limitToQuota(uid, r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(body)
+	manifest, err := readManifest(tr, false)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("importing the account of ", manifest.UserID, " for ", uid)
+
+	result := &storage.AccountImport{
+		Documents: make([]string, 0),
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, archiveError(err)
+		}
+		name, ok := archiveEntry(hdr, accountDocumentsDir)
+		ext := path.Ext(name)
+		id := strings.TrimSuffix(name, ext)
+		if !ok || (ext != models.ZipFileExt && ext != models.MetadataFileExt) || common.Sanitize(id) != id {
+			log.Warn("import: skipping ", hdr.Name)
+			continue
+		}
+		if ext == models.ZipFileExt {
+			err = fs.StoreDocument(uid, id, ioutil.NopCloser(tr))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		doc := &messages.RawMetadata{}
+		err = json.NewDecoder(tr).Decode(doc)
+		if err != nil || doc.ID != id {
+			return nil, fmt.Errorf("%w: metadata of %s", storage.ErrorInvalidArchive, id)
+		}
+		err = fs.UpdateMetadata(uid, doc)
+		if err != nil {
+			return nil, err
+		}
+		result.Documents = append(result.Documents, id)
+	}
+	return result, nil
+}
@@ -0,0 +1,254 @@
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+func TestBlobAccountRoundTrip(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	for _, uid := range []string{"from", "to"} {
+		if err := os.MkdirAll(fs.getUserBlobPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exported, err := fs.CreateBlobDocument("from", "notes.pdf", "", strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := fs.CreateBlobDocument("to", "other.pdf", "", strings.NewReader("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err = fs.ExportBlobAccount("from", &archive); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.ImportAccount("to", bytes.NewReader(archive.Bytes())); !errors.Is(err, storage.ErrorInvalidArchive) {
+		t.Errorf("imported as sync10: %v", err)
+	}
+
+	result, err := fs.ImportBlobAccount("to", bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Documents) != 1 || result.Documents[0] != exported.ID || result.Generation != 2 {
+		t.Errorf("result %+v", result)
+	}
+	tree, err := fs.GetTree("to")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Docs) != 2 || tree.Generation != 2 {
+		t.Fatalf("docs %d generation %d", len(tree.Docs), tree.Generation)
+	}
+	for _, id := range []string{exported.ID, existing.ID} {
+		if _, err = tree.FindDoc(id); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// importing again replaces the documents
+	if _, err = fs.ImportBlobAccount("to", bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if tree, _ = fs.GetTree("to"); len(tree.Docs) != 2 {
+		t.Errorf("docs %d", len(tree.Docs))
+	}
+}
+
+func TestImportMissingFile(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	for _, uid := range []string{"from", "to"} {
+		if err := os.MkdirAll(fs.getUserBlobPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fs.CreateBlobDocument("from", "notes.pdf", "", strings.NewReader("pdf")); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := fs.ExportBlobAccount("from", &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same archive without the pdf
+	sum := sha256.Sum256([]byte("pdf"))
+	payload := accountBlobsDir + hex.EncodeToString(sum[:])
+	var stripped bytes.Buffer
+	tr := tar.NewReader(&archive)
+	tw := tar.NewWriter(&stripped)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == payload {
+			continue
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.Copy(tw, tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.ImportBlobAccount("to", &stripped); !errors.Is(err, storage.ErrorInvalidArchive) {
+		t.Errorf("missing file: %v", err)
+	}
+	if tree, _ := fs.GetTree("to"); len(tree.Docs) != 0 {
+		t.Errorf("imported %d docs", len(tree.Docs))
+	}
+}
+
+// craftedArchive an account archive with the blobs
+func craftedArchive(t *testing.T, root string, blobs map[string]string) *bytes.Buffer {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	err := writeManifest(tw, &accountManifest{Version: accountVersion, Sync15: true, Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range blobs {
+		err = writeNativeEntry(tw, accountBlobsDir+name, int64(len(content)), strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &archive
+}
+
+func TestImportCraftedBlobs(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	if err := os.MkdirAll(fs.getUserBlobPath("to"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := fs.CreateBlobDocument("to", "notes.pdf", "", strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := fs.GetTree("to")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := tree.FindDoc(doc.ID)
+	payload := before.Files[len(before.Files)-1].Hash
+
+	for name, archive := range map[string]*bytes.Buffer{
+		"root pointer":          craftedArchive(t, "", map[string]string{rootFile: "evil"}),
+		"not a hash":            craftedArchive(t, "", map[string]string{"notes": "evil"}),
+		"content of other hash": craftedArchive(t, "", map[string]string{payload: "evil"}),
+		"root of the manifest":  craftedArchive(t, rootFile, nil),
+	} {
+		if _, err = fs.ImportBlobAccount("to", archive); !errors.Is(err, storage.ErrorInvalidArchive) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	ls := &LocalBlobStorage{fs: fs, uid: "to"}
+	if root, _, _ := ls.GetRootIndex(); root != tree.Hash {
+		t.Errorf("root replaced: %s", root)
+	}
+	content, err := fs.readStored("to", path.Join(fs.getUserBlobPath("to"), payload))
+	if err != nil || string(content) != "pdf" {
+		t.Errorf("blob replaced: %q %v", content, err)
+	}
+}
+
+func TestAccountRoundTrip(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	for _, uid := range []string{"from", "to"} {
+		if err := os.MkdirAll(fs.getUserPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := fs.StoreDocument("from", "doc", ioutil.NopCloser(strings.NewReader("zip")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, meta := range []*messages.RawMetadata{
+		{ID: "folder", VissibleName: "folder", Type: "CollectionType", Version: 1},
+		{ID: "doc", VissibleName: "notes", Type: "DocumentType", Parent: "folder", Version: 3},
+	} {
+		if err = fs.UpdateMetadata("from", meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var archive bytes.Buffer
+	if err = fs.ExportAccount("from", &archive); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.ImportBlobAccount("to", bytes.NewReader(archive.Bytes())); !errors.Is(err, storage.ErrorInvalidArchive) {
+		t.Errorf("imported as sync15: %v", err)
+	}
+	result, err := fs.ImportAccount("to", bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Documents) != 2 {
+		t.Errorf("result %+v", result)
+	}
+
+	meta, err := fs.GetMetadata("to", "doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.VissibleName != "notes" || meta.Parent != "folder" || meta.Version != 3 {
+		t.Errorf("metadata %+v", meta)
+	}
+	r, err := fs.GetDocument("to", "doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if content, _ := ioutil.ReadAll(r); string(content) != "zip" {
+		t.Errorf("content %q", content)
+	}
+}
+
+func TestImportOverQuota(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	for _, uid := range []string{"from", "to"} {
+		if err := os.MkdirAll(fs.getUserBlobPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := fs.CreateBlobDocument("from", "notes.pdf", "", strings.NewReader(strings.Repeat("pdf", 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err = fs.ExportBlobAccount("from", &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Cfg.Quota = 1000
+	if _, err = fs.ImportBlobAccount("to", &archive); !errors.Is(err, storage.ErrorOverQuota) {
+		t.Errorf("over quota: %v", err)
+	}
+}
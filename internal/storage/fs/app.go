@@ -1,18 +1,23 @@
 package fs
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/ddvk/rmfakecloud/internal/common"
 	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/idempotency"
+	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
@@ -30,25 +35,52 @@ const (
 	paramScope     = "scope"
 	routeBlob      = "/blobstorage"
 	routeStorage   = "/storage"
+
+	// paramSignatureVersion absent in the urls signed before the method was
+	paramSignatureVersion = "sigv"
+	signatureVersion      = "2"
 )
 
 // ErrorNotFound not found
-var ErrorNotFound = errors.New("not found")
+var ErrorNotFound = storage.ErrorNotFound
 
 // ErrorWrongGeneration the geration did not match
-var ErrorWrongGeneration = errors.New("wrong generation")
+var ErrorWrongGeneration = storage.ErrorWrongGeneration
 
 // App file system document storage
 type App struct {
-	cfg *config.Config
-	fs  *FileSystemStorage
+	cfg         *config.Config
+	users       storage.UserStorer
+	blobs       storage.BlobProvider
+	limits      userLimits
+	downloads   bandwidthLimiters
+	uploads     bandwidthLimiters
+	idempotency *idempotency.Store
+	// readAhead nil when disabled or the blobs aren't local
+	readAhead *readAhead
+	// metrics nil unless they are exported
+	metrics   *storageMetrics
+	resumable uploads
+	// sealer encrypts the chunks of the resumable uploads when encryption is enabled
+	sealer *FileSystemStorage
+	// events emits the changes of the roots the devices upload, nil if the users aren't local
+	events *FileSystemStorage
 }
 
 // NewApp StorageApp various storage routes
-func NewApp(cfg *config.Config, fs *FileSystemStorage) *App {
+func NewApp(cfg *config.Config, users storage.UserStorer, blobs storage.BlobProvider) *App {
 	staticWrapper := App{
-		fs:  fs,
-		cfg: cfg,
+		users:       users,
+		blobs:       blobs,
+		cfg:         cfg,
+		idempotency: idempotency.NewStore(cfg.IdempotencyWindow),
+		sealer:      &FileSystemStorage{Cfg: cfg},
+	}
+	if local, ok := blobs.(*FileSystemStorage); ok && cfg.BlobReadAhead > 0 {
+		staticWrapper.readAhead = newReadAhead(local, cfg.BlobReadAhead)
+	}
+	if local, ok := users.(*FileSystemStorage); ok {
+		staticWrapper.events = local
 	}
 	return &staticWrapper
 }
@@ -56,12 +88,12 @@ func NewApp(cfg *config.Config, fs *FileSystemStorage) *App {
 // RegisterRoutes blah
 func (app *App) RegisterRoutes(router *gin.Engine) {
 
-	router.GET(routeStorage+"/:"+tokenParam, app.downloadDocument)
-	router.PUT(routeStorage+"/:"+tokenParam, app.uploadDocument)
+	router.GET(routeStorage+"/:"+tokenParam, app.measure(opDocumentDownload, false), app.downloadDocument)
+	router.PUT(routeStorage+"/:"+tokenParam, app.measure(opDocumentUpload, true), app.uploadDocument)
 
 	//sync15
-	router.GET(routeBlob, app.downloadBlob)
-	router.PUT(routeBlob, app.uploadBlob)
+	router.GET(routeBlob, app.measure(opBlobDownload, false), app.downloadBlob)
+	router.PUT(routeBlob, app.measure(opBlobUpload, true), app.uploadBlob)
 }
 
 func (app *App) parseToken(token string) (*StorageClaim, error) {
@@ -76,6 +108,54 @@ func (app *App) parseToken(token string) (*StorageClaim, error) {
 	return claim, nil
 }
 
+// throttle limits the reader to the bandwidth the user is allowed
+func (app *App) throttle(c *gin.Context, uid string, r io.Reader, upload bool) io.Reader {
+	uploadLimit, downloadLimit := app.limits.get(uid, time.Now(), func() (int, int) {
+		user, err := app.users.GetUser(uid)
+		if err != nil {
+			return 0, 0
+		}
+		return user.UploadLimit, user.DownloadLimit
+	})
+	userLimit := downloadLimit
+	if upload {
+		userLimit = uploadLimit
+	}
+
+	limiters, defaultLimit := &app.downloads, app.cfg.DownloadLimit
+	if upload {
+		limiters, defaultLimit = &app.uploads, app.cfg.UploadLimit
+	}
+	limit := effectiveLimit(userLimit, defaultLimit)
+	if limit == 0 {
+		return r
+	}
+	throttled := &throttledReader{
+		r:       r,
+		limiter: limiters.get(uid, limit*1024),
+		ctx:     c.Request.Context(),
+	}
+	if s, ok := r.(io.Seeker); ok {
+		return &throttledReadSeeker{throttledReader: throttled, s: s}
+	}
+	return throttled
+}
+
+// sendData sends the reader, with a Range header only the requested part when it can seek
+// readers that can't, e.g. of object storage, are sent completely without a length
+func (app *App) sendData(c *gin.Context, uid string, r io.Reader) {
+	throttled := app.throttle(c, uid, r, false)
+	rs, ok := throttled.(io.ReadSeeker)
+	if !ok {
+		c.DataFromReader(http.StatusOK, -1, "application/octet-stream", throttled, nil)
+		return
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	// with the Content-Length, 206 and Content-Range for a Range (unless If-Range doesn't match the ETag)
+	// or 416 when the range is unsatisfiable
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, rs)
+}
+
 func (app *App) uploadDocument(c *gin.Context) {
 	strToken := c.Param(tokenParam)
 	log.Debug("[storage] uploading with token:", strToken)
@@ -91,8 +171,19 @@ func (app *App) uploadDocument(c *gin.Context) {
 	body := c.Request.Body
 	defer body.Close()
 
-	err = app.fs.StoreDocument(token.UserID, id, body)
+	done, ok := app.idempotency.Begin(c, token.UserID)
+	if !ok {
+		return
+	}
+	defer done()
+
+	err = app.blobs.StoreDocument(token.UserID, id, ioutil.NopCloser(app.throttle(c, token.UserID, body, true)))
 	if err != nil {
+		if errors.Is(err, storage.ErrorOverQuota) {
+			log.Warn("over quota: ", token.UserID)
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
 		log.Error(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
@@ -114,7 +205,7 @@ func (app *App) downloadDocument(c *gin.Context) {
 	//todo: storage provider
 	log.Info("Requestng Id: ", id)
 
-	reader, err := app.fs.GetDocument(token.UserID, id)
+	reader, err := app.blobs.GetDocument(token.UserID, id)
 
 	if err != nil {
 		log.Error(err)
@@ -122,7 +213,7 @@ func (app *App) downloadDocument(c *gin.Context) {
 		return
 	}
 	defer reader.Close()
-	c.DataFromReader(http.StatusOK, -1, "application/octet-stream", reader, nil)
+	app.sendData(c, token.UserID, reader)
 }
 
 func (app *App) downloadBlob(c *gin.Context) {
@@ -134,40 +225,88 @@ func (app *App) downloadBlob(c *gin.Context) {
 	signature := common.QueryS(paramSignature, c)
 	scope := common.QueryS(paramScope, c)
 
-	err := VerifyURLParams([]string{uid, blobID, exp, scope}, exp, signature, app.cfg.JWTSecretKey)
+	err := app.verifyBlobURL(c, uid, blobID, exp, scope, signature)
 	if err != nil {
 		log.Warn(err)
-		c.AbortWithStatus(http.StatusForbidden)
+		abortAccessDenied(c)
 		return
 	}
 
 	if scope != "read" {
-		c.AbortWithStatus(http.StatusForbidden)
+		abortAccessDenied(c)
+		return
 	}
 
 	if blobID == "" {
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithGCSError(c, http.StatusBadRequest, errorInvalidArgument)
+		return
 	}
 
 	log.Info("Requestng blob: ", blobID)
 
-	reader, generation, err := app.fs.LoadBlob(uid, blobID)
+	reader, generation, err := app.blobs.LoadBlob(uid, blobID)
 	if err != nil {
 		if err == ErrorNotFound {
-			c.AbortWithStatus(http.StatusNotFound)
+			abortWithGCSError(c, http.StatusNotFound, errorNoSuchKey)
 			return
 		}
 		log.Error(err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+		abortWithGCSError(c, http.StatusInternalServerError, errorInternal)
 		return
 	}
 	defer reader.Close()
 
-	if blobID == "root" {
+	if isMutableBlob(blobID) {
 		log.Debug("Sending gen: ", generation)
+		c.Header("Cache-Control", "no-cache")
+	} else if maxAge := app.cfg.BlobCacheMaxAge; maxAge > 0 {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int64(maxAge.Seconds())))
 	}
 	c.Header(generationHeader, strconv.FormatInt(generation, 10))
-	c.DataFromReader(http.StatusOK, -1, "application/octet-stream", reader, nil)
+	// for If-Range, the content of a blob never changes and the root's only with the generation
+	etag := blobID
+	if isMutableBlob(blobID) {
+		etag = strconv.FormatInt(generation, 10)
+	}
+	c.Header("ETag", strconv.Quote(etag))
+
+	body := io.Reader(reader)
+	var sent *hashingReader
+	if app.cfg.BlobHashCheck {
+		if isMutableBlob(blobID) {
+			// tiny, hashed every time
+			content, err := ioutil.ReadAll(reader)
+			if err != nil {
+				log.Error(err)
+				abortWithGCSError(c, http.StatusInternalServerError, errorInternal)
+				return
+			}
+			h := newBlobHash()
+			h.Write(content)
+			c.Header(googHashHeader, h.header())
+			body = bytes.NewReader(content)
+		} else if googHash := app.storedHash(uid, blobID); googHash != "" {
+			c.Header(googHashHeader, googHash)
+		} else if c.GetHeader("Range") == "" {
+			// stored before the hashes were kept, there is one for the next download
+			sent = newHashingReader(reader, nil)
+			body = sent
+		}
+	}
+	app.sendData(c, uid, body)
+	if sent != nil && sent.done {
+		app.storeHash(uid, blobID, sent.hash)
+	}
+
+	if app.readAhead != nil && !isMutableBlob(blobID) {
+		app.readAhead.warm(uid, blobID)
+	}
+}
+
+// isMutableBlob only the root changes, all other blobs are content addressed
+// and never change once written
+func isMutableBlob(blobID string) bool {
+	return blobID == rootFile
 }
 
 func (app *App) uploadBlob(c *gin.Context) {
@@ -179,24 +318,54 @@ func (app *App) uploadBlob(c *gin.Context) {
 	signature := common.QueryS(paramSignature, c)
 	scope := common.QueryS(paramScope, c)
 
-	err := VerifyURLParams([]string{uid, blobID, exp, scope}, exp, signature, app.cfg.JWTSecretKey)
+	err := app.verifyBlobURL(c, uid, blobID, exp, scope, signature)
 	if err != nil {
-		c.AbortWithStatus(http.StatusForbidden)
+		log.Warn(err)
+		abortAccessDenied(c)
+		return
 	}
 	log.Info(exp, signature)
 
 	if blobID == "" {
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithGCSError(c, http.StatusBadRequest, errorInvalidArgument)
+		return
 	}
 
 	if scope != "write" {
 		log.Warn("wrong scope: " + scope)
-		c.AbortWithStatus(http.StatusForbidden)
+		abortAccessDenied(c)
+		return
+	}
+
+	var expectedHash map[string]string
+	if app.cfg.BlobHashCheck {
+		expectedHash, err = parseGoogHash(c.Request.Header.Values(googHashHeader))
+		if err != nil {
+			log.Warn(err)
+			abortWithGCSError(c, http.StatusBadRequest, errorInvalidArgument)
+			return
+		}
 	}
 
 	body := c.Request.Body
 	defer body.Close()
 
+	if header := c.GetHeader(contentRangeHeader); header != "" {
+		app.uploadChunk(c, uid, blobID, header, expectedHash)
+		return
+	}
+
+	done, ok := app.idempotency.Begin(c, uid)
+	if !ok {
+		return
+	}
+	defer done()
+
+	app.storeUpload(c, uid, blobID, app.throttle(c, uid, body, true), expectedHash)
+}
+
+// storeUpload stores the uploaded blob and responds with its generation
+func (app *App) storeUpload(c *gin.Context, uid, blobID string, body io.Reader, expectedHash map[string]string) {
 	generation := int64(0)
 	gh := c.Request.Header.Get(generationMatchHeader)
 	if gh != "" {
@@ -208,49 +377,111 @@ func (app *App) uploadBlob(c *gin.Context) {
 		}
 	}
 
-	newgen, err := app.fs.StoreBlob(uid, blobID, body, generation)
+	upload := body
+	var received *hashingReader
+	if app.cfg.BlobHashCheck {
+		received = newHashingReader(body, expectedHash)
+		upload = received
+	}
+	var previousRoot string
+	notify := blobID == rootFile && app.events != nil && len(app.events.eventListeners) > 0
+	if notify {
+		previousRoot = app.previousRoot(uid)
+	}
+	newgen, err := app.blobs.StoreBlob(uid, blobID, upload, generation)
 
 	if err != nil {
 		if err == ErrorWrongGeneration {
-			c.AbortWithStatus(http.StatusPreconditionFailed)
+			abortWithGCSError(c, http.StatusPreconditionFailed, errorConditionNotMet)
+			return
+		}
+		if errors.Is(err, ErrorHashMismatch) {
+			log.Warn(blobID, ": ", err)
+			abortWithGCSError(c, http.StatusBadRequest, errorBadDigest)
+			return
+		}
+		if errors.Is(err, storage.ErrorOverQuota) {
+			log.Warn("over quota: ", uid)
+			abortWithGCSError(c, http.StatusRequestEntityTooLarge, errorQuotaExceeded)
 			return
 		}
 		log.Error(err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+		abortWithGCSError(c, http.StatusInternalServerError, errorInternal)
 		return
 	}
 
+	if received != nil && !isMutableBlob(blobID) {
+		app.storeHash(uid, blobID, received.hash)
+	}
+	if notify {
+		app.events.rootAdvanced(uid, previousRoot, newgen)
+	}
+
 	c.Header(generationHeader, strconv.FormatInt(newgen, 10))
 	c.JSON(http.StatusOK, gin.H{})
 }
 
-// SignURLParams signs url params
+// previousRoot the hash of the root before the upload, empty if there is none
+func (app *App) previousRoot(uid string) string {
+	r, _, err := app.blobs.LoadBlob(uid, rootFile)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	hash, err := ioutil.ReadAll(r)
+	if err != nil {
+		return ""
+	}
+	return string(hash)
+}
+
+// SignURLParams signs url params, each is length prefixed so different params never have the same signature
 func SignURLParams(parts []string, key []byte) (string, error) {
 	h := hmac.New(sha256.New, key)
 	for i, s := range parts {
 		if s == "" {
 			return "", fmt.Errorf("index %d is empty", i)
 		}
-		h.Write([]byte(s))
+		fmt.Fprintf(h, "%d:%s", len(s), s)
 	}
 	hs := h.Sum(nil)
 	s := hex.EncodeToString(hs)
 	return s, nil
 }
 
-// VerifyURLParams verify the signature and expiry
-func VerifyURLParams(parts []string, exp, signature string, key []byte) error {
+// signLegacyURLParams the params concatenated, how the urls were signed before
+func signLegacyURLParams(parts []string, key []byte) (string, error) {
+	h := hmac.New(sha256.New, key)
+	for i, s := range parts {
+		if s == "" {
+			return "", fmt.Errorf("index %d is empty", i)
+		}
+		h.Write([]byte(s))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyURLParams verify the signature and expiry, an expiry further than maxTTL is rejected, 0 allows any
+func VerifyURLParams(parts []string, exp, signature string, key []byte, maxTTL time.Duration) error {
 	expected, err := SignURLParams(parts, key)
 	if err != nil {
 		return err
 	}
-	expiration, err := strconv.Atoi(exp)
+	return checkSignature(expected, exp, signature, maxTTL)
+}
+
+func checkSignature(expected, exp, signature string, maxTTL time.Duration) error {
+	expiration, err := strconv.ParseInt(exp, 10, 64)
 	if err != nil {
 		return err
 	}
-	if expiration < int(time.Now().Unix()) {
+	now := time.Now()
+	if expiration < now.Unix() {
 		return errors.New("expired")
 	}
+	if maxTTL > 0 && expiration > now.Add(maxTTL).Unix() {
+		return fmt.Errorf("expires in more than %s", maxTTL)
+	}
 
 	if subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
 		return errors.New("wrong signature")
@@ -258,3 +489,27 @@ func VerifyURLParams(parts []string, exp, signature string, key []byte) error {
 
 	return nil
 }
+
+// blobURLMethod the method a blob url of the scope is signed for
+func blobURLMethod(scope string) string {
+	if scope == "write" {
+		return http.MethodPut
+	}
+	return http.MethodGet
+}
+
+// verifyBlobURL checks the signature of the blob url is for the method of the request,
+// the urls of the old format aren't bound to it and are only accepted unless the config is strict
+func (app *App) verifyBlobURL(c *gin.Context, uid, blobID, exp, scope, signature string) error {
+	if c.Query(paramSignatureVersion) == signatureVersion {
+		return VerifyURLParams([]string{c.Request.Method, uid, blobID, exp, scope}, exp, signature, app.cfg.JWTSecretKey, app.cfg.URLMaxTTL)
+	}
+	if app.cfg.URLSignatureStrict {
+		return errors.New("url signature of the old format")
+	}
+	expected, err := signLegacyURLParams([]string{uid, blobID, exp, scope}, app.cfg.JWTSecretKey)
+	if err != nil {
+		return err
+	}
+	return checkSignature(expected, exp, signature, app.cfg.URLMaxTTL)
+}
@@ -0,0 +1,205 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestBlobErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{DataDir: dir, JWTSecretKey: []byte("secret")}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	fsStorage := NewStorage(cfg)
+	NewApp(cfg, fsStorage, fsStorage).RegisterRoutes(router)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, routeBlob+"?uid=test&blobid=root&scope=write&exp=1&signature=bad", strings.NewReader("blah"))
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "<Code>AccessDenied</Code>") {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+	if _, err := os.Stat(NewStorage(cfg).getUserBlobPath("test")); !os.IsNotExist(err) {
+		t.Error("nothing should be stored")
+	}
+}
+
+func TestSignURLParams(t *testing.T) {
+	key := []byte("secret")
+	exp := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+
+	ab, _ := SignURLParams([]string{"ab", "c", exp}, key)
+	bc, _ := SignURLParams([]string{"a", "bc", exp}, key)
+	if ab == bc {
+		t.Error("different params have the same signature")
+	}
+	if err := VerifyURLParams([]string{"a", "bc", exp}, exp, ab, key, 0); err == nil {
+		t.Error("the signature of other params was accepted")
+	}
+	if err := VerifyURLParams([]string{"ab", "c", exp}, exp, ab, key, time.Hour); err != nil {
+		t.Error(err)
+	}
+	if err := VerifyURLParams([]string{"ab", "c", exp}, exp, ab, key, 10*time.Second); err == nil {
+		t.Error("an expiry over the max ttl was accepted")
+	}
+}
+
+func TestBlobURLMethod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{DataDir: dir, JWTSecretKey: []byte("secret")}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	fsStorage := NewStorage(cfg)
+	NewApp(cfg, fsStorage, fsStorage).RegisterRoutes(router)
+	if err = os.MkdirAll(fsStorage.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	status := func(method, target string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader("blah")))
+		return w.Code
+	}
+	exp := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	// a download url with the scope changed, signed for GET
+	signature, _ := SignURLParams([]string{http.MethodGet, "test", "blob", exp, "write"}, cfg.JWTSecretKey)
+	replayed := fmt.Sprintf("%s?uid=test&blobid=blob&exp=%s&scope=write&signature=%s&sigv=%s", routeBlob, exp, signature, signatureVersion)
+	if code := status(http.MethodPut, replayed); code != http.StatusForbidden {
+		t.Errorf("put with a get signature: %d", code)
+	}
+	if code := status(http.MethodPut, signedBlobURL(t, cfg, "test", "blob", "write")); code != http.StatusOK {
+		t.Errorf("put: %d", code)
+	}
+
+	legacy, _ := signLegacyURLParams([]string{"test", "blob", exp, "read"}, cfg.JWTSecretKey)
+	legacyURL := fmt.Sprintf("%s?uid=test&blobid=blob&exp=%s&scope=read&signature=%s", routeBlob, exp, legacy)
+	if code := status(http.MethodGet, legacyURL); code != http.StatusOK {
+		t.Errorf("old format: %d", code)
+	}
+	cfg.URLSignatureStrict = true
+	if code := status(http.MethodGet, legacyURL); code != http.StatusForbidden {
+		t.Errorf("old format when strict: %d", code)
+	}
+}
+
+// signedBlobURL a blob url valid for a minute
+func signedBlobURL(t *testing.T, cfg *config.Config, uid, blobID, scope string) string {
+	exp := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	signature, err := SignURLParams([]string{blobURLMethod(scope), uid, blobID, exp, scope}, cfg.JWTSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%s?uid=%s&blobid=%s&exp=%s&scope=%s&signature=%s&sigv=%s", routeBlob, uid, blobID, exp, scope, signature, signatureVersion)
+}
+
+func TestBlobRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{DataDir: dir, JWTSecretKey: []byte("secret")}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	fsStorage := NewStorage(cfg)
+	NewApp(cfg, fsStorage, fsStorage).RegisterRoutes(router)
+	if err = os.MkdirAll(fsStorage.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fsStorage.StoreBlob("test", "blob", strings.NewReader("0123456789"), -1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rangeHeader  string
+		code         int
+		body         string
+		contentRange string
+	}{
+		{"", http.StatusOK, "0123456789", ""},
+		{"bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"bytes=7-", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=-2", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"bytes=20-30", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, signedBlobURL(t, cfg, "test", "blob", "read"), nil)
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		router.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.rangeHeader, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusRequestedRangeNotSatisfiable && w.Body.String() != tt.body {
+			t.Errorf("%s: body %s, want %s", tt.rangeHeader, w.Body.String(), tt.body)
+		}
+		if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s: content range %s, want %s", tt.rangeHeader, got, tt.contentRange)
+		}
+		if w.Header().Get(generationHeader) == "" {
+			t.Errorf("%s: no generation header", tt.rangeHeader)
+		}
+	}
+}
+
+func TestBlobCacheControl(t *testing.T) {
+	for _, tt := range []struct {
+		maxAge  time.Duration
+		blobID  string
+		control string
+	}{
+		{0, "blob", ""},
+		{0, rootFile, "no-cache"},
+		{time.Hour, "blob", "public, max-age=3600, immutable"},
+		{time.Hour, rootFile, "no-cache"},
+	} {
+		cfg := &config.Config{DataDir: t.TempDir(), JWTSecretKey: []byte("secret"), BlobCacheMaxAge: tt.maxAge}
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		fsStorage := NewStorage(cfg)
+		NewApp(cfg, fsStorage, fsStorage).RegisterRoutes(router)
+		if err := os.MkdirAll(fsStorage.getUserBlobPath("test"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fsStorage.StoreBlob("test", tt.blobID, strings.NewReader("content"), 0); err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, signedBlobURL(t, cfg, "test", tt.blobID, "read"), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s max age %s: status %d", tt.blobID, tt.maxAge, w.Code)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.control {
+			t.Errorf("%s max age %s: cache control %q, want %q", tt.blobID, tt.maxAge, got, tt.control)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package fs
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// RecordAudit appends the entry to the audit log, one json object per line
+func (fs *FileSystemStorage) RecordAudit(entry *storage.AuditEntry) error {
+	if fs.Cfg.AuditLog == "" {
+		return nil
+	}
+	js, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	fs.auditLock.Lock()
+	defer fs.auditLock.Unlock()
+	f, err := os.OpenFile(fs.Cfg.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(js, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AuditLog reads the entries of the category, oldest first
+func (fs *FileSystemStorage) AuditLog(category string) ([]*storage.AuditEntry, error) {
+	entries := make([]*storage.AuditEntry, 0)
+	if fs.Cfg.AuditLog == "" {
+		return entries, nil
+	}
+
+	f, err := os.Open(fs.Cfg.AuditLog)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := &storage.AuditEntry{}
+		err = json.Unmarshal(scanner.Bytes(), entry)
+		if err != nil {
+			log.Warn("skipping broken audit entry: ", err)
+			continue
+		}
+		if category != "" && entry.Category != category {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
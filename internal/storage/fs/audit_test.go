@@ -0,0 +1,66 @@
+package fs
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+func TestAuditLog(t *testing.T) {
+	auditLog := path.Join(t.TempDir(), "audit.log")
+	fs := &FileSystemStorage{
+		Cfg: &config.Config{AuditLog: auditLog},
+	}
+
+	entries := []*storage.AuditEntry{
+		{Time: time.Now(), Actor: "admin", Category: storage.AuditUser, Action: "create", Target: "bob"},
+		{Time: time.Now(), Actor: "admin", Category: storage.AuditMaintenance, Action: "gc", Target: "bob", Params: map[string]string{"job": "1"}},
+		{Time: time.Now(), Actor: "other", Category: storage.AuditUser, Action: "delete", Target: "bob"},
+	}
+	for _, e := range entries {
+		if err := fs.RecordAudit(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a line which is not json, e.g. a partial write
+	f, err := os.OpenFile(auditLog, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{broken\n")
+	f.Close()
+
+	all, err := fs.AuditLog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected 3 entries got %d", len(all))
+	}
+
+	users, err := fs.AuditLog(storage.AuditUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Action != "create" || users[1].Action != "delete" {
+		t.Errorf("unexpected user entries %+v", users)
+	}
+
+	maintenance, err := fs.AuditLog(storage.AuditMaintenance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(maintenance) != 1 || maintenance[0].Params["job"] != "1" {
+		t.Errorf("unexpected maintenance entries %+v", maintenance)
+	}
+
+	fs.Cfg.AuditLog = ""
+	if err := fs.RecordAudit(entries[0]); err != nil {
+		t.Error("disabled audit log should not fail: ", err)
+	}
+}
@@ -0,0 +1,175 @@
+package fs
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// googHashHeader the crc32c and md5 of a blob, sent by the tablet and by google cloud storage
+	googHashHeader = "x-goog-hash"
+	// blobHashDir the hashes of the blobs, next to them in the sync folder
+	blobHashDir = ".hashes"
+)
+
+// ErrorHashMismatch the uploaded data doesn't match the hash the client sent
+var ErrorHashMismatch = errors.New("hash mismatch")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// blobHashStore providers that keep the x-goog-hash of the blobs they store
+type blobHashStore interface {
+	StoreBlobHash(uid, blobID, googHash string) error
+	// LoadBlobHash an empty hash when there is none
+	LoadBlobHash(uid, blobID string) (string, error)
+}
+
+// parseGoogHash the base64 hashes of the x-goog-hash header values by name, other hash types are ignored
+func parseGoogHash(values []string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			i := strings.Index(part, "=")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid %s '%s'", googHashHeader, part)
+			}
+			name, sum := part[:i], part[i+1:]
+			if name != "crc32c" && name != "md5" {
+				continue
+			}
+			if _, err := base64.StdEncoding.DecodeString(sum); err != nil {
+				return nil, fmt.Errorf("invalid %s %s: %v", googHashHeader, name, err)
+			}
+			hashes[name] = sum
+		}
+	}
+	return hashes, nil
+}
+
+// blobHash computes the crc32c and md5 of what is written to it
+type blobHash struct {
+	crc hash.Hash32
+	md5 hash.Hash
+}
+
+func newBlobHash() *blobHash {
+	return &blobHash{
+		crc: crc32.New(crc32cTable),
+		md5: md5.New(),
+	}
+}
+
+func (h *blobHash) Write(p []byte) (int, error) {
+	h.crc.Write(p)
+	return h.md5.Write(p)
+}
+
+func (h *blobHash) sums() map[string]string {
+	return map[string]string{
+		"crc32c": base64.StdEncoding.EncodeToString(h.crc.Sum(nil)),
+		"md5":    base64.StdEncoding.EncodeToString(h.md5.Sum(nil)),
+	}
+}
+
+// header the value of the x-goog-hash header
+func (h *blobHash) header() string {
+	sums := h.sums()
+	return "crc32c=" + sums["crc32c"] + ",md5=" + sums["md5"]
+}
+
+// hashingReader hashes what is read, at the end it fails with ErrorHashMismatch when
+// one of the expected hashes differs
+type hashingReader struct {
+	r        io.Reader
+	hash     *blobHash
+	expected map[string]string
+	// done everything was read
+	done bool
+}
+
+func newHashingReader(r io.Reader, expected map[string]string) *hashingReader {
+	return &hashingReader{
+		r:        r,
+		hash:     newBlobHash(),
+		expected: expected,
+	}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+	h.done = true
+	sums := h.hash.sums()
+	for name, sum := range h.expected {
+		if sums[name] != sum {
+			return n, fmt.Errorf("%w: %s is %s, the client sent %s", ErrorHashMismatch, name, sums[name], sum)
+		}
+	}
+	return n, err
+}
+
+func (fs *FileSystemStorage) blobHashPath(uid, blobID string) string {
+	return path.Join(fs.getUserBlobPath(uid), blobHashDir, common.Sanitize(blobID))
+}
+
+// StoreBlobHash keeps the hash of the blob, for sending it with the downloads
+func (fs *FileSystemStorage) StoreBlobHash(uid, blobID, googHash string) error {
+	hashPath := fs.blobHashPath(uid, blobID)
+	err := os.MkdirAll(path.Dir(hashPath), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(hashPath, []byte(googHash), 0600)
+}
+
+// LoadBlobHash the stored hash of the blob, empty if there is none
+func (fs *FileSystemStorage) LoadBlobHash(uid, blobID string) (string, error) {
+	content, err := ioutil.ReadFile(fs.blobHashPath(uid, blobID))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(content), err
+}
+
+// storedHash the hash kept with the blob, empty when the provider doesn't keep them or it has none
+func (app *App) storedHash(uid, blobID string) string {
+	store, ok := app.blobs.(blobHashStore)
+	if !ok {
+		return ""
+	}
+	googHash, err := store.LoadBlobHash(uid, blobID)
+	if err != nil {
+		log.Warn("can't read the hash of ", blobID, ": ", err)
+	}
+	return googHash
+}
+
+func (app *App) storeHash(uid, blobID string, h *blobHash) {
+	store, ok := app.blobs.(blobHashStore)
+	if !ok {
+		return
+	}
+	err := store.StoreBlobHash(uid, blobID, h.header())
+	if err != nil {
+		log.Warn("can't store the hash of ", blobID, ": ", err)
+	}
+}
@@ -0,0 +1,90 @@
+package fs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseGoogHash(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   map[string]string
+		err    bool
+	}{
+		{nil, map[string]string{}, false},
+		{[]string{"crc32c=yZRlqg==,md5=XrY7u+Ae7tCTyyK7j1rNww=="}, map[string]string{"crc32c": "yZRlqg==", "md5": "XrY7u+Ae7tCTyyK7j1rNww=="}, false},
+		{[]string{"crc32c=yZRlqg==", "md5=XrY7u+Ae7tCTyyK7j1rNww=="}, map[string]string{"crc32c": "yZRlqg==", "md5": "XrY7u+Ae7tCTyyK7j1rNww=="}, false},
+		{[]string{"sha1=abc=,crc32c=yZRlqg=="}, map[string]string{"crc32c": "yZRlqg=="}, false},
+		{[]string{"crc32c"}, nil, true},
+		{[]string{"md5=not base64"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseGoogHash(tt.values)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: error %v", tt.values, err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestBlobHashCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{DataDir: dir, JWTSecretKey: []byte("secret"), BlobHashCheck: true}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	fsStorage := NewStorage(cfg)
+	NewApp(cfg, fsStorage, fsStorage).RegisterRoutes(router)
+	if err = os.MkdirAll(fsStorage.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(blobID, content, googHash string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, signedBlobURL(t, cfg, "test", blobID, "write"), strings.NewReader(content))
+		req.Header.Set(googHashHeader, googHash)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	h := newBlobHash()
+	h.Write([]byte("blah"))
+	sums := h.sums()
+
+	if code := upload("wrong", "blah", "crc32c=AAAAAA=="); code != http.StatusBadRequest {
+		t.Errorf("wrong crc32c: %d", code)
+	}
+	if _, err = os.Stat(fsStorage.getUserBlobPath("test") + "/wrong"); !os.IsNotExist(err) {
+		t.Error("the blob with the wrong hash was stored")
+	}
+	if code := upload("invalid", "blah", "md5"); code != http.StatusBadRequest {
+		t.Errorf("invalid header: %d", code)
+	}
+	if code := upload("good", "blah", "crc32c="+sums["crc32c"]+",md5="+sums["md5"]); code != http.StatusOK {
+		t.Errorf("matching hash: %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, signedBlobURL(t, cfg, "test", "good", "read"), nil))
+	if w.Code != http.StatusOK || w.Body.String() != "blah" {
+		t.Fatalf("download: %d %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get(googHashHeader); got != h.header() {
+		t.Errorf("download hash %s, want %s", got, h.header())
+	}
+}
@@ -2,6 +2,8 @@ package fs
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io"
@@ -38,6 +40,7 @@ func (fs *FileSystemStorage) GetTree(uid string) (t *models.HashTree, err error)
 	if err != nil {
 		return nil, err
 	}
+	before := treeParents(tree)
 	changed, err := tree.Mirror(ls)
 	if err != nil {
 		return nil, err
@@ -47,6 +50,10 @@ func (fs *FileSystemStorage) GetTree(uid string) (t *models.HashTree, err error)
 		if err != nil {
 			return nil, err
 		}
+		// the data dir records them when the root is written, at the time in its history
+		if fs.blobs != nil {
+			fs.recordTreeTrash(uid, before, tree, time.Now().UTC())
+		}
 	}
 	return tree, nil
 }
@@ -81,7 +88,7 @@ func (fs *FileSystemStorage) Export(uid, docid string) (r io.ReadCloser, err err
 		err = exporter.RenderRmapi(archive, writer)
 		if err != nil {
 			log.Error(err)
-			writer.Close()
+			writer.CloseWithError(err)
 			return
 		}
 		writer.Close()
@@ -111,6 +118,33 @@ func (fs *FileSystemStorage) CreateBlobDocument(uid, filename, parent string, st
 		return nil, err
 	}
 
+	in := &storage.IngestDocument{
+		UserID:  uid,
+		ID:      docid,
+		Name:    docName,
+		Ext:     ext,
+		Parent:  parent,
+		Content: stream,
+		Siblings: func() (map[string]bool, error) {
+			return treeNames(tree, parent), nil
+		},
+	}
+	defer releaseContent(in)
+	err = fs.ingest(in)
+	if err != nil {
+		return nil, err
+	}
+	if in.Action == storage.ActionSkipped {
+		return &storage.Document{
+			Type:   models.DocumentType,
+			Parent: parent,
+			Name:   in.Name,
+			Action: in.Action,
+		}, nil
+	}
+	docName = in.Name
+	stream = in.Content
+
 	log.Info("Creating metadata... parent: ", parent)
 
 	metadata := models.MetadataFile{
@@ -122,7 +156,7 @@ func (fs *FileSystemStorage) CreateBlobDocument(uid, filename, parent string, st
 		Synced:           true,
 		MetadataModified: true,
 	}
-	metahash, size, err := createMetadataFile(metadata, blobPath)
+	metahash, size, err := fs.createMetadataFile(uid, metadata)
 	fi := models.NewFileHashEntry(metahash, docid+models.MetadataFileExt)
 	fi.Size = size
 	if err != nil {
@@ -136,12 +170,12 @@ func (fs *FileSystemStorage) CreateBlobDocument(uid, filename, parent string, st
 		return
 	}
 
-	content := createContent(ext)
+	content := createContent(ext, in.Tags)
 	contentHash, size, err := models.Hash(strings.NewReader(content))
 	if err != nil {
 		return
 	}
-	err = saveTo(strings.NewReader(content), contentHash, blobPath)
+	err = fs.saveBlob(uid, contentHash, strings.NewReader(content))
 	if err != nil {
 		return
 	}
@@ -163,8 +197,7 @@ func (fs *FileSystemStorage) CreateBlobDocument(uid, filename, parent string, st
 		return nil, err
 	}
 	tmpdoc.Close()
-	payloadFilename := path.Join(blobPath, payloadHash)
-	err = os.Rename(tmpdoc.Name(), payloadFilename)
+	err = fs.savePayload(uid, payloadHash, tmpdoc.Name())
 	if err != nil {
 		return nil, err
 	}
@@ -183,57 +216,185 @@ func (fs *FileSystemStorage) CreateBlobDocument(uid, filename, parent string, st
 	}
 
 	docIndexReader, err := hashDoc.IndexReader()
-	err = saveTo(docIndexReader, hashDoc.Hash, blobPath)
+	err = fs.saveBlob(uid, hashDoc.Hash, docIndexReader)
 	if err != nil {
 		return
 	}
 
-	rootIndexReader, err := tree.RootIndex()
-	err = saveTo(rootIndexReader, tree.Hash, blobPath)
+	err = fs.commitTree(uid, tree)
 	if err != nil {
 		return
 	}
+
+	doc = &storage.Document{
+		ID:         docid,
+		Type:       models.DocumentType,
+		Parent:     parent,
+		Name:       docName,
+		Version:    1,
+		Action:     in.Action,
+		BytesSaved: in.BytesSaved,
+		Warning:    in.Warning,
+	}
+	return
+}
+
+// createBlobFolder writes the blobs of a new folder and adds it to the tree
+// the root index is not updated
+func (fs *FileSystemStorage) createBlobFolder(uid, name, parent string, tree *models.HashTree) (*models.HashDoc, error) {
+	docid := uuid.New().String()
+
+	metadata := models.MetadataFile{
+		DocumentName:     name,
+		CollectionType:   models.CollectionType,
+		Parent:           parent,
+		Version:          1,
+		LastModified:     strconv.FormatInt(time.Now().Unix(), 10),
+		Synced:           true,
+		MetadataModified: true,
+	}
+	metahash, size, err := fs.createMetadataFile(uid, metadata)
+	if err != nil {
+		return nil, err
+	}
+	fi := models.NewFileHashEntry(metahash, docid+models.MetadataFileExt)
+	fi.Size = size
+
+	hashDoc := models.NewHashDocMeta(docid, metadata)
+	err = hashDoc.AddFile(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	content := "{}"
+	contentHash, size, err := models.Hash(strings.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	err = fs.saveBlob(uid, contentHash, strings.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	fi = models.NewFileHashEntry(contentHash, docid+models.ContentFileExt)
+	fi.Size = size
+	err = hashDoc.AddFile(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	docIndexReader, err := hashDoc.IndexReader()
+	if err != nil {
+		return nil, err
+	}
+	err = fs.saveBlob(uid, hashDoc.Hash, docIndexReader)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.Add(hashDoc)
+	if err != nil {
+		return nil, err
+	}
+	return hashDoc, nil
+}
+
+// commitTree writes the root index of the tree, bumps the generation and updates the cache
+func (fs *FileSystemStorage) commitTree(uid string, tree *models.HashTree) error {
+	rootIndexReader, err := tree.RootIndex()
+	if err != nil {
+		return err
+	}
+	err = fs.saveBlob(uid, tree.Hash, rootIndexReader)
+	if err != nil {
+		return err
+	}
 	blobStorage := &LocalBlobStorage{
 		fs:  fs,
 		uid: uid,
 	}
 
-	//todo:check gen + locking
+	//todo: locking
 	gen, err := blobStorage.WriteRootIndex(tree.Generation, tree.Hash)
 	if err != nil {
-		return
+		return err
 	}
 	log.Info("got gen ", gen)
 	tree.Generation = gen
-	err = fs.SaveTree(uid, tree)
+	return fs.SaveTree(uid, tree)
+}
 
-	if err != nil {
-		return
+// SetBlobProvider keeps the sync15 blobs written and read for the web ui in the provider instead of the data dir
+func (fs *FileSystemStorage) SetBlobProvider(blobs storage.BlobProvider) {
+	fs.blobs = blobs
+}
+
+// blobProvider where the sync15 tree is, the data dir unless another provider is set
+func (fs *FileSystemStorage) blobProvider() storage.BlobProvider {
+	if fs.blobs != nil {
+		return fs.blobs
+	}
+	return fs
+}
+
+// localBlobs fails with ErrorNotSupported when the blobs are kept by another provider,
+// for what needs the history and the files of the data dir
+func (fs *FileSystemStorage) localBlobs() error {
+	if fs.blobs != nil {
+		return storage.ErrorNotSupported
 	}
+	return nil
+}
 
-	doc = &storage.Document{
-		ID:     docid,
-		Type:   models.DocumentType,
-		Parent: "",
-		Name:   docName,
+// saveBlob writes a blob of the tree, the root index isn't updated
+func (fs *FileSystemStorage) saveBlob(uid, hash string, r io.Reader) error {
+	if fs.blobs == nil {
+		return fs.saveTo(uid, r, hash, fs.getUserBlobPath(uid))
 	}
-	return
+	_, err := fs.blobs.StoreBlob(uid, hash, r, -1)
+	return err
 }
 
-func saveTo(r io.Reader, hash, blobPath string) (err error) {
-	rootIndexFilePath := path.Join(blobPath, hash)
-	rootIndex, err := os.Create(rootIndexFilePath)
+// savePayload moves the hashed temp file to the blobs
+func (fs *FileSystemStorage) savePayload(uid, hash, tmpPath string) error {
+	// content starting like a stored format is written after rawMagic
+	if fs.blobs == nil && !fs.encrypting() && !fs.compressing() && !startsWithMagic(tmpPath) {
+		return fs.placeBlob(hash, hash, tmpPath, path.Join(fs.getUserBlobPath(uid), hash))
+	}
+	f, err := os.Open(tmpPath)
 	if err != nil {
-		return
+		return err
 	}
-	_, err = io.Copy(rootIndex, r)
+	defer f.Close()
+	return fs.saveBlob(uid, hash, f)
+}
+
+// saveTo writes the blob through a temp file, compressed and encrypted as enabled
+// it is shared with the other users when the hash is the checksum of the content
+func (fs *FileSystemStorage) saveTo(uid string, r io.Reader, hash, blobPath string) error {
+	tmp, err := ioutil.TempFile(blobPath, ".tmp")
 	if err != nil {
-		return
+		return err
 	}
-	return nil
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	out, err := fs.blobWriter(uid, tmp)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hasher), r)
+	if err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return fs.placeBlob(hash, hex.EncodeToString(hasher.Sum(nil)), tmp.Name(), path.Join(blobPath, hash))
 }
 
-func createMetadataFile(metadata models.MetadataFile, spath string) (filehash string, size int64, err error) {
+func (fs *FileSystemStorage) createMetadataFile(uid string, metadata models.MetadataFile) (filehash string, size int64, err error) {
 
 	jsn, err := json.Marshal(metadata)
 	if err != nil {
@@ -243,8 +404,7 @@ func createMetadataFile(metadata models.MetadataFile, spath string) (filehash st
 	if err != nil {
 		return
 	}
-	filePath := path.Join(spath, filehash)
-	err = ioutil.WriteFile(filePath, jsn, 0600)
+	err = fs.saveBlob(uid, filehash, bytes.NewReader(jsn))
 	if err != nil {
 		return
 	}
@@ -258,20 +418,25 @@ const rootFile = "root"
 // GetBlobURL return a url for a file to store
 func (fs *FileSystemStorage) GetBlobURL(uid, blobid, scope string) (docurl string, exp time.Time, err error) {
 	uploadRL := fs.Cfg.StorageURL
-	exp = time.Now().Add(time.Minute * config.ReadStorageExpirationInMinutes)
+	ttl := time.Minute * config.ReadStorageExpirationInMinutes
+	if maxTTL := fs.Cfg.URLMaxTTL; maxTTL > 0 && maxTTL < ttl {
+		ttl = maxTTL
+	}
+	exp = time.Now().Add(ttl)
 	strExp := strconv.FormatInt(exp.Unix(), 10)
 
-	signature, err := SignURLParams([]string{uid, blobid, strExp, scope}, fs.Cfg.JWTSecretKey)
+	signature, err := SignURLParams([]string{blobURLMethod(scope), uid, blobid, strExp, scope}, fs.Cfg.JWTSecretKey)
 	if err != nil {
 		return
 	}
 
 	params := url.Values{
-		paramUID:       {uid},
-		paramBlobID:    {blobid},
-		paramExp:       {strExp},
-		paramSignature: {signature},
-		paramScope:     {scope},
+		paramUID:              {uid},
+		paramBlobID:           {blobid},
+		paramExp:              {strExp},
+		paramSignature:        {signature},
+		paramScope:            {scope},
+		paramSignatureVersion: {signatureVersion},
 	}
 
 	blobURL := uploadRL + routeBlob + "?" + params.Encode()
@@ -304,72 +469,159 @@ func (fs *FileSystemStorage) LoadBlob(uid, blobid string) (io.ReadCloser, int64,
 		return nil, 0, ErrorNotFound
 	}
 
-	reader, err := os.Open(blobPath)
+	reader, _, err := fs.openStored(uid, blobPath)
 	return reader, generation, err
 }
 
+// RootGeneration the generation of the root, from the size of its history
+func (fs *FileSystemStorage) RootGeneration(uid string) (int64, error) {
+	return fs.currentGeneration(uid), nil
+}
+
 // StoreBlob stores a document
+// the stream is written to a temp file and hashed in one pass, then renamed into place
 func (fs *FileSystemStorage) StoreBlob(uid, id string, stream io.Reader, matchGen int64) (generation int64, err error) {
 	generation = 1
+	userBlobPath := fs.getUserBlobPath(uid)
+	if id == rootFile {
+		defer fs.usageChanged(uid)
+	} else {
+		// the root is tiny and devices must still be able to delete
+		stream, err = fs.limitToQuota(uid, stream)
+		if err != nil {
+			return
+		}
+	}
+
+	tmp, err := ioutil.TempFile(userBlobPath, ".tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	reader := stream
+	// the root stays plaintext, it is in the history anyway
+	out := io.WriteCloser(&contentWriter{w: nopWriteCloser{tmp}})
+	if id != rootFile {
+		out, err = fs.blobWriter(uid, tmp)
+		if err != nil {
+			return
+		}
+	}
+	hasher := sha256.New()
+	writers := []io.Writer{out, hasher}
+	// the root is tiny, keep it for the history
+	var rootHash bytes.Buffer
 	if id == rootFile {
-		historyPath := path.Join(fs.getUserBlobPath(uid), historyFile)
-		lock := fslock.New(historyPath)
+		writers = append(writers, &rootHash)
+	}
+	_, err = io.Copy(io.MultiWriter(writers...), stream)
+	if err != nil {
+		return
+	}
+	err = out.Close()
+	if err != nil {
+		return
+	}
+	written := fileSize(tmp.Name())
+	err = tmp.Close()
+	if err != nil {
+		return
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+
+	blobPath := path.Join(userBlobPath, common.Sanitize(id))
+	if id != rootFile {
+		// the gc removes the blobs in batches with the lock held, a blob it found unreachable
+		// is either touched before and kept, or collected before and written again
+		lock := fslock.New(path.Join(userBlobPath, historyFile))
 		err = lock.LockWithTimeout(time.Duration(time.Second * 5))
 		if err != nil {
 			log.Error("cannot obtain lock")
+			return
 		}
 		defer lock.Unlock()
 
-		currentGen := int64(0)
-		fi, err1 := os.Stat(historyPath)
-		if err1 == nil {
-			currentGen = generationFromFileSize(fi.Size())
+		// content addressed and already there
+		if checksum == id {
+			if _, err1 := os.Stat(blobPath); err1 == nil {
+				log.Debug("blob exists: ", id)
+				// referenced again, the gc grace period starts over
+				now := time.Now()
+				os.Chtimes(blobPath, now, now)
+				return
+			}
 		}
+		replaced := fileSize(blobPath)
+		err = fs.placeBlob(id, checksum, tmp.Name(), blobPath)
+		if err == nil {
+			fs.usageGrown(uid, written-replaced)
+		}
+		return
+	}
 
-		if currentGen != matchGen && matchGen > 0 {
-			log.Warnf("wrong gen, has %d but is %d", matchGen, currentGen)
-			return currentGen, ErrorWrongGeneration
+	// the documents moved to the trash are recorded once the lock is released
+	var previousRoot []byte
+	var storedAt time.Time
+	defer func() {
+		if err == nil {
+			fs.recordRootTrash(uid, string(previousRoot), rootHash.String(), storedAt)
 		}
+	}()
 
-		var buf bytes.Buffer
-		tee := io.TeeReader(stream, &buf)
+	historyPath := path.Join(userBlobPath, historyFile)
+	lock := fslock.New(historyPath)
+	err = lock.LockWithTimeout(time.Duration(time.Second * 5))
+	if err != nil {
+		log.Error("cannot obtain lock")
+		return
+	}
+	defer lock.Unlock()
 
-		var hist *os.File
-		hist, err = os.OpenFile(historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-		if err != nil {
-			return
-		}
-		defer hist.Close()
-		t := time.Now().UTC().Format(time.RFC3339) + " "
-		hist.WriteString(t)
-		_, err = io.Copy(hist, tee)
-		if err != nil {
-			return
-		}
-		hist.WriteString("\n")
+	currentGen := int64(0)
+	fi, err1 := os.Stat(historyPath)
+	if err1 == nil {
+		currentGen = generationFromFileSize(fi.Size())
+	}
 
-		reader = ioutil.NopCloser(&buf)
-		size, err1 := hist.Seek(0, os.SEEK_CUR)
+	if currentGen != matchGen && matchGen > 0 {
+		log.Warnf("wrong gen, has %d but is %d", matchGen, currentGen)
+		if fs.Cfg.RootConflictPolicy != config.RootConflictMerge {
+			return currentGen, ErrorWrongGeneration
+		}
+		merged, err1 := fs.mergeRootIndex(uid, matchGen, rootHash.String())
 		if err1 != nil {
-			err = err1
+			log.Warn("can't merge: ", err1)
+			return currentGen, ErrorWrongGeneration
+		}
+		rootHash.Reset()
+		rootHash.WriteString(merged)
+		err = ioutil.WriteFile(tmp.Name(), rootHash.Bytes(), 0600)
+		if err != nil {
 			return
 		}
-		generation = generationFromFileSize(size)
 	}
 
-	blobPath := path.Join(fs.getUserBlobPath(uid), common.Sanitize(id))
-	file, err := os.Create(blobPath)
+	var hist *os.File
+	hist, err = os.OpenFile(historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
 		return
 	}
-	defer file.Close()
-	_, err = io.Copy(file, reader)
+	defer hist.Close()
+	storedAt = time.Now().UTC().Truncate(time.Second)
+	_, err = hist.WriteString(storedAt.Format(time.RFC3339) + " " + rootHash.String() + "\n")
+	if err != nil {
+		return
+	}
+
+	size, err := hist.Seek(0, os.SEEK_CUR)
 	if err != nil {
 		return
 	}
+	generation = generationFromFileSize(size)
 
+	previousRoot, _ = ioutil.ReadFile(blobPath)
+	err = os.Rename(tmp.Name(), blobPath)
 	return
 }
 
@@ -0,0 +1,131 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+func TestStoreBlob(t *testing.T) {
+	testuser := "test"
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewStorage(&config.Config{DataDir: dir})
+	blobPath := fs.getUserBlobPath(testuser)
+	err = os.MkdirAll(blobPath, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sha256 of "blah"
+	const blobID = "8b7df143d91c716ecfa5fc1730022f6b421b05cedee8fd52b1fc65a96030ad52"
+	for i := 0; i < 2; i++ {
+		_, err = fs.StoreBlob(testuser, blobID, strings.NewReader("blah"), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	content, err := ioutil.ReadFile(path.Join(blobPath, blobID))
+	if err != nil || string(content) != "blah" {
+		t.Fatalf("blob not stored: %s %v", content, err)
+	}
+
+	if gen, err := fs.RootGeneration(testuser); err != nil || gen != 0 {
+		t.Fatalf("no root yet: gen %d %v", gen, err)
+	}
+	gen, err := fs.StoreBlob(testuser, rootFile, strings.NewReader(blobID), 0)
+	if err != nil || gen != 1 {
+		t.Fatalf("root: gen %d %v", gen, err)
+	}
+	if gen, err = fs.LimitToQuota(fs).(storage.RootGenerationReader).RootGeneration(testuser); err != nil || gen != 1 {
+		t.Fatalf("root generation %d %v", gen, err)
+	}
+	_, err = fs.StoreBlob(testuser, rootFile, strings.NewReader(blobID), 5)
+	if err != ErrorWrongGeneration {
+		t.Errorf("expected wrong generation, got %v", err)
+	}
+
+	files, err := ioutil.ReadDir(blobPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".tmp") {
+			t.Error("temp file left: ", f.Name())
+		}
+	}
+}
+
+func TestBlobProvider(t *testing.T) {
+	testuser := "test"
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewStorage(&config.Config{DataDir: path.Join(dir, "local")})
+	// another data dir stands in for the object storage
+	remote := NewStorage(&config.Config{DataDir: path.Join(dir, "remote")})
+	fs.SetBlobProvider(remote)
+	for _, s := range []*FileSystemStorage{fs, remote} {
+		if err = os.MkdirAll(s.getUserBlobPath(testuser), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doc, err := fs.CreateBlobDocument(testuser, "blob.pdf", "", strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path.Join(fs.getUserBlobPath(testuser), rootFile)); !os.IsNotExist(err) {
+		t.Error("the root was written to the data dir")
+	}
+	tree, err := remote.GetTree(testuser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tree.FindDoc(doc.ID); err != nil {
+		t.Errorf("the document isn't in the provider: %v", err)
+	}
+	if _, err = fs.Export(testuser, doc.ID); err != nil {
+		t.Errorf("can't export from the provider: %v", err)
+	}
+	native, err := fs.ExportNative(testuser, doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(native); err != nil {
+		t.Errorf("can't export the files from the provider: %v", err)
+	}
+	native.Close()
+
+	// the files are in the provider, the usage is counted from the indexes
+	usage, err := fs.Usage(testuser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.ByType[storage.UsagePDF] == 0 || usage.Total < usage.ByType[storage.UsagePDF] {
+		t.Errorf("usage %+v", usage)
+	}
+
+	// they need the history and the files of the data dir
+	if _, err = fs.Snapshots(testuser); err != storage.ErrorNotSupported {
+		t.Errorf("snapshots: %v", err)
+	}
+	if _, err = fs.FindOrphans(testuser); err != storage.ErrorNotSupported {
+		t.Errorf("orphans: %v", err)
+	}
+	if _, err = fs.GarbageCollect(testuser, true, nil); err != storage.ErrorNotSupported {
+		t.Errorf("gc: %v", err)
+	}
+}
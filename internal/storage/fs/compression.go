@@ -0,0 +1,83 @@
+package fs
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+)
+
+// Compressed blobs start with the magic followed by the deflate stream.
+// The compression is inside the encryption, the encrypted bytes don't compress
+const (
+	deflateMagic = "RMFCDFL1"
+	// inflateInMemory blobs up to this size are decompressed in memory, bigger ones to a temp file
+	inflateInMemory = 4 << 20
+)
+
+// compressing whether new sync15 blobs are compressed
+func (fs *FileSystemStorage) compressing() bool {
+	return fs.Cfg.BlobCompression == config.CompressionDeflate
+}
+
+// incompressible content which is compressed already: pdfs, zips (epubs), images and gzip
+// deflating it again saves nothing and every read would have to inflate it
+func incompressible(head []byte) bool {
+	for _, signature := range []string{"%PDF", "PK\x03\x04", "\x89PNG", "\xff\xd8\xff", "\x1f\x8b"} {
+		if bytes.HasPrefix(head, []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+// blobWriter compresses (when enabled) and encrypts what is written to w
+// Close flushes both, w itself isn't closed
+func (fs *FileSystemStorage) blobWriter(uid string, w io.Writer) (io.WriteCloser, error) {
+	sealed, err := fs.sealWriter(uid, w)
+	if err != nil {
+		return nil, err
+	}
+	return &contentWriter{w: sealed, compress: fs.compressing()}, nil
+}
+
+// memoryFile a decompressed blob
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// inflateStored the decompressed content of the compressed file, which is closed
+func inflateStored(f storedFile, size int64) (storedFile, int64, error) {
+	defer f.Close()
+	inflater := flate.NewReader(io.NewSectionReader(f, int64(len(deflateMagic)), size-int64(len(deflateMagic))))
+	defer inflater.Close()
+
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, inflater, inflateInMemory+1)
+	if err == io.EOF {
+		return memoryFile{bytes.NewReader(buf.Bytes())}, int64(buf.Len()), nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// not in the blob dir, the gc takes new files there for a sync in progress
+	tmp, err := ioutil.TempFile("", ".inflate")
+	if err != nil {
+		return nil, 0, err
+	}
+	spooled := &spooledFile{tmp}
+	inflated, err := io.Copy(tmp, io.MultiReader(&buf, inflater))
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spooled.Close()
+		return nil, 0, err
+	}
+	return spooled, inflated, nil
+}
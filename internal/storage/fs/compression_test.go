@@ -0,0 +1,143 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompressedBlobs(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir(), BlobCompression: config.CompressionDeflate})
+	blobPath := fs.getUserBlobPath("test")
+	if err := os.MkdirAll(blobPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// small ones are decompressed in memory, big ones to a temp file
+	for _, content := range []string{
+		strings.Repeat("stroke ", 100),
+		strings.Repeat("stroke ", inflateInMemory/4),
+	} {
+		id, size, err := models.Hash(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fs.StoreBlob("test", id, strings.NewReader(content), -1); err != nil {
+			t.Fatal(err)
+		}
+		if stored := fileSize(path.Join(blobPath, id)); stored >= size {
+			t.Errorf("not compressed: %d of %d", stored, size)
+		}
+
+		r, _, err := fs.LoadBlob("test", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || string(loaded) != content {
+			t.Errorf("loaded %d bytes of %d: %v", len(loaded), size, err)
+		}
+	}
+
+	// compressed inside the encryption
+	fs.Cfg.EncryptionKey = make([]byte, 32)
+	content := strings.Repeat("encrypted ", 100)
+	id, _, _ := models.Hash(strings.NewReader(content))
+	if _, err := fs.StoreBlob("test", id, strings.NewReader(content), -1); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := fs.readStored("test", path.Join(blobPath, id)); err != nil || string(loaded) != content {
+		t.Errorf("encrypted %q %v", loaded, err)
+	}
+
+	// the blobs written before stay readable
+	fs.Cfg.BlobCompression = ""
+	content = "plain"
+	id, _, _ = models.Hash(strings.NewReader(content))
+	if _, err := fs.StoreBlob("test", id, strings.NewReader(content), -1); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := fs.readStored("test", path.Join(blobPath, id)); err != nil || string(loaded) != content {
+		t.Errorf("plain %q %v", loaded, err)
+	}
+}
+
+func TestIncompressibleBlobs(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir(), BlobCompression: config.CompressionDeflate})
+	blobPath := fs.getUserBlobPath("test")
+	if err := os.MkdirAll(blobPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{
+		"%PDF-1.7" + strings.Repeat(" ", 1000),
+		"PK\x03\x04" + strings.Repeat(" ", 1000),
+	} {
+		id, size, _ := models.Hash(strings.NewReader(content))
+		if _, err := fs.StoreBlob("test", id, strings.NewReader(content), -1); err != nil {
+			t.Fatal(err)
+		}
+		if stored := fileSize(path.Join(blobPath, id)); stored != size {
+			t.Errorf("%q compressed: %d of %d", content[:4], stored, size)
+		}
+	}
+}
+
+func TestCompressedBlobRange(t *testing.T) {
+	cfg := &config.Config{DataDir: t.TempDir(), JWTSecretKey: []byte("secret"), BlobCompression: config.CompressionDeflate}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	fsStorage := NewStorage(cfg)
+	NewApp(cfg, fsStorage, fsStorage).RegisterRoutes(router)
+	blobPath := fsStorage.getUserBlobPath("test")
+	if err := os.MkdirAll(blobPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	// inflated to a temp file
+	var sb strings.Builder
+	for i := 0; sb.Len() <= inflateInMemory; i++ {
+		fmt.Fprintf(&sb, "%08d\n", i)
+	}
+	content := sb.String()
+	id, size, _ := models.Hash(strings.NewReader(content))
+	if _, err := fsStorage.StoreBlob("test", id, strings.NewReader(content), -1); err != nil {
+		t.Fatal(err)
+	}
+	if stored := fileSize(path.Join(blobPath, id)); stored >= size {
+		t.Fatalf("not compressed: %d of %d", stored, size)
+	}
+
+	tests := []struct {
+		rangeHeader  string
+		body         string
+		contentRange string
+	}{
+		{"bytes=18-26", content[18:27], fmt.Sprintf("bytes 18-26/%d", size)},
+		{fmt.Sprintf("bytes=%d-", size-9), content[size-9:], fmt.Sprintf("bytes %d-%d/%d", size-9, size-1, size)},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, signedBlobURL(t, cfg, "test", id, "read"), nil)
+		req.Header.Set("Range", tt.rangeHeader)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusPartialContent {
+			t.Errorf("%s: status %d", tt.rangeHeader, w.Code)
+			continue
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.rangeHeader, w.Body.String(), tt.body)
+		}
+		if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s: content range %s, want %s", tt.rangeHeader, got, tt.contentRange)
+		}
+	}
+}
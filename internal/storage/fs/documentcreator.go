@@ -19,12 +19,29 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func createContent(fileType string) string {
+// contentTag a document tag of the .content file
+type contentTag struct {
+	Name      string `json:"name"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// createContent the .content file of an uploaded document, with the tags the ingest processors added
+func createContent(fileType string, tags []string) string {
 	fileType = strings.TrimPrefix(fileType, ".")
+	tagsField := ""
+	if len(tags) > 0 {
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		contentTags := make([]contentTag, 0, len(tags))
+		for _, t := range tags {
+			contentTags = append(contentTags, contentTag{Name: t, Timestamp: now})
+		}
+		encoded, _ := json.Marshal(contentTags)
+		tagsField = fmt.Sprintf("\n\t\"tags\": %s,", encoded)
+	}
 	str :=
 		`
 {
-	"dummyDocument": false,
+	"dummyDocument": false,%s
 	"extraMetadata": {
 		"LastPen": "Finelinerv2",
 		"LastTool": "Finelinerv2",
@@ -53,7 +70,7 @@ func createContent(fileType string) string {
 	}
 }
 `
-	return fmt.Sprintf(str, fileType)
+	return fmt.Sprintf(str, tagsField, fileType)
 }
 
 func extractID(r io.Reader) (string, error) {
@@ -62,6 +79,7 @@ func extractID(r io.Reader) (string, error) {
 
 // CreateDocument creates a new document
 func (fs *FileSystemStorage) CreateDocument(uid, filename, parent string, stream io.Reader) (doc *storage.Document, err error) {
+	defer fs.usageChanged(uid)
 	ext := path.Ext(filename)
 	switch ext {
 	case models.PdfFileExt:
@@ -80,6 +98,34 @@ func (fs *FileSystemStorage) CreateDocument(uid, filename, parent string, stream
 	} else {
 		docid = uuid.New().String()
 	}
+
+	in := &storage.IngestDocument{
+		UserID:  uid,
+		ID:      docid,
+		Name:    strings.TrimSuffix(filename, ext),
+		Ext:     ext,
+		Parent:  parent,
+		Content: stream,
+		Siblings: func() (map[string]bool, error) {
+			return fs.metadataNames(uid, parent)
+		},
+	}
+	defer releaseContent(in)
+	err = fs.ingest(in)
+	if err != nil {
+		return
+	}
+	if in.Action == storage.ActionSkipped {
+		return &storage.Document{
+			Type:   models.DocumentType,
+			Parent: parent,
+			Name:   in.Name,
+			Action: in.Action,
+		}, nil
+	}
+	stream = in.Content
+	name := in.Name
+
 	//create zip from pdf
 	zipfile := fs.getPathFromUser(uid, docid+models.ZipFileExt)
 	file, err := os.Create(zipfile)
@@ -87,9 +133,14 @@ func (fs *FileSystemStorage) CreateDocument(uid, filename, parent string, stream
 		return
 	}
 	defer file.Close()
+	out, err := fs.storedWriter(uid, file)
+	if err != nil {
+		return
+	}
+	defer out.Close()
 
 	if !isZip {
-		w := zip.NewWriter(file)
+		w := zip.NewWriter(out)
 		defer w.Close()
 
 		documentPath := docid + ext
@@ -115,18 +166,17 @@ func (fs *FileSystemStorage) CreateDocument(uid, filename, parent string, stream
 			return
 		}
 
-		content := createContent(ext)
+		content := createContent(ext, in.Tags)
 		entry.Write([]byte(content))
 	} else {
 		logrus.Info("writing file")
-		_, err = io.Copy(file, stream)
+		_, err = io.Copy(out, stream)
 		if err != nil {
 			return
 		}
 	}
 
 	//create metadata
-	name := strings.TrimSuffix(filename, ext)
 	doc1 := createRawMedatadata(docid, name, parent)
 
 	jsn, err := json.Marshal(doc1)
@@ -135,10 +185,14 @@ func (fs *FileSystemStorage) CreateDocument(uid, filename, parent string, stream
 	}
 
 	doc = &storage.Document{
-		ID:      docid,
-		Type:    doc1.Type,
-		Name:    name,
-		Version: 1,
+		ID:         docid,
+		Type:       doc1.Type,
+		Parent:     parent,
+		Name:       name,
+		Version:    1,
+		Action:     in.Action,
+		BytesSaved: in.BytesSaved,
+		Warning:    in.Warning,
 	}
 	//save metadata
 	metafilePath := fs.getPathFromUser(uid, docid+models.MetadataFileExt)
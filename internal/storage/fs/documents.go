@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/ddvk/rmfakecloud/internal/common"
 	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/messages"
 	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/exporter"
 	"github.com/ddvk/rmfakecloud/internal/storage/models"
@@ -30,7 +32,17 @@ const (
 
 // FileSystemStorage store everything to disk
 type FileSystemStorage struct {
-	Cfg *config.Config
+	Cfg              *config.Config
+	ingestProcessors []storage.IngestProcessor
+	auditLock        sync.Mutex
+	usageLock        sync.Mutex
+	// usage cached per user, usageGeneration changes with every invalidation
+	usage           map[string]*storage.Usage
+	usageGeneration int64
+	trashLock       sync.Mutex
+	// blobs keeps the sync15 blobs when they aren't in the data dir
+	blobs          storage.BlobProvider
+	eventListeners []storage.EventListener
 }
 
 func sanitizeFileName(fileName string) string {
@@ -52,6 +64,9 @@ func (fs *FileSystemStorage) getPathFromUser(uid, path string) string {
 
 // ExportDocument Exports a document to the outputType
 func (fs *FileSystemStorage) ExportDocument(uid, id, outputType string, exportOption storage.ExportOption) (io.ReadCloser, error) {
+	if outputType == nativeFormat {
+		return fs.exportNativeDocument(uid, id)
+	}
 	if outputType != "pdf" {
 		return nil, errors.New("todo: only pdfs supported")
 	}
@@ -75,12 +90,12 @@ func (fs *FileSystemStorage) ExportDocument(uid, id, outputType string, exportOp
 
 	// exists and not older
 	if err == nil && !rawStat.ModTime().After(outStat.ModTime()) {
-		return os.Open(outputFilePath)
+		outputFile, _, err := fs.openStored(uid, outputFilePath)
+		return outputFile, err
 	}
 
-	size := rawStat.Size()
 	arch := &exporter.MyArchive{}
-	zipFile, err := os.Open(zipFilePath)
+	zipFile, size, err := fs.openStored(uid, zipFilePath)
 	if err != nil {
 		return nil, err
 	}
@@ -98,18 +113,27 @@ func (fs *FileSystemStorage) ExportDocument(uid, id, outputType string, exportOp
 	if err != nil {
 		return nil, err
 	}
-
-	err = exporter.RenderRmapi(arch, outputFile)
+	defer outputFile.Close()
+	out, err := fs.storedWriter(uid, outputFile)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = outputFile.Seek(0, 0)
+	err = exporter.RenderRmapi(arch, out)
+	if err != nil {
+		return nil, err
+	}
+	err = out.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = outputFile.Close()
 	if err != nil {
 		return nil, err
 	}
 
-	return outputFile, nil
+	rendered, _, err := fs.openStored(uid, outputFilePath)
+	return rendered, err
 
 }
 
@@ -117,18 +141,24 @@ func (fs *FileSystemStorage) ExportDocument(uid, id, outputType string, exportOp
 func (fs *FileSystemStorage) GetDocument(uid, id string) (io.ReadCloser, error) {
 	fullPath := fs.getPathFromUser(uid, id+models.ZipFileExt)
 	log.Debugln("Fullpath:", fullPath)
-	reader, err := os.Open(fullPath)
+	reader, _, err := fs.openStored(uid, fullPath)
 	return reader, err
 }
 
 // RemoveDocument removes document (moves it to trash)
 func (fs *FileSystemStorage) RemoveDocument(uid, id string) error {
+	defer fs.usageChanged(uid)
 
 	trashDir := fs.getPathFromUser(uid, DefaultTrashDir)
 	err := os.MkdirAll(trashDir, 0700)
 	if err != nil {
 		return err
 	}
+	// for the event, before the metadata is moved
+	var doc *messages.RawMetadata
+	if len(fs.eventListeners) > 0 {
+		doc, _ = fs.GetMetadata(uid, id)
+	}
 	//do not delete, move to trash
 	log.Info(trashDir)
 	meta := filepath.Base(id + models.MetadataFileExt)
@@ -141,22 +171,34 @@ func (fs *FileSystemStorage) RemoveDocument(uid, id string) error {
 	zipfile := filepath.Base(id + models.ZipFileExt)
 	fullPath = fs.getPathFromUser(uid, zipfile)
 	err = os.Rename(fullPath, path.Join(trashDir, zipfile))
-	if err != nil {
+	// folders might have no content
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	fs.removeOriginal(uid, id)
+	fs.recordTrash(uid, nil, map[string]string{id: ""}, time.Now().UTC())
+	if doc != nil {
+		fs.documentEvent(storage.EventDocumentDeleted, uid, doc)
+	}
 	return nil
 }
 
 // StoreDocument stores a document
+// written to a temp file first, the stored document stays when the upload fails
 func (fs *FileSystemStorage) StoreDocument(uid, id string, stream io.ReadCloser) error {
+	defer fs.usageChanged(uid)
 	fullPath := fs.getPathFromUser(uid, id+models.ZipFileExt)
-	file, err := os.Create(fullPath)
+	body, err := fs.limitToQuota(uid, stream)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	_, err = io.Copy(file, stream)
-	return err
+	_, err = fs.writeStored(uid, fullPath, body)
+	if err != nil {
+		return err
+	}
+	// replaced by the tablet
+	fs.removeOriginal(uid, id)
+	return nil
 }
 
 // GetStorageURL the storage url
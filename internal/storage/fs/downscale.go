@@ -0,0 +1,139 @@
+package fs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/exporter"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	originalsDir           = "originals"
+	downscaleProcessorName = "downscale"
+)
+
+// downscaleProcessor resamples oversized images of pdfs
+type downscaleProcessor struct {
+	fs *FileSystemStorage
+}
+
+func (p *downscaleProcessor) Name() string {
+	return downscaleProcessorName
+}
+
+func (p *downscaleProcessor) Process(doc *storage.IngestDocument) error {
+	if doc.Ext != models.PdfFileExt {
+		return nil
+	}
+	saved, err := p.fs.downscalePDF(doc)
+	if err != nil {
+		return err
+	}
+	doc.BytesSaved += saved
+	return nil
+}
+
+func (fs *FileSystemStorage) getOriginalPath(uid, docid string) string {
+	return path.Join(fs.getUserPath(uid), originalsDir, common.Sanitize(docid)+models.PdfFileExt)
+}
+
+// downscalePDF resamples the oversized images of an uploaded pdf, when enabled
+// the original is kept for exporting it later. The content of the document is replaced
+// with the downscaled pdf, returns how many bytes that saved
+func (fs *FileSystemStorage) downscalePDF(doc *storage.IngestDocument) (int64, error) {
+	if fs.Cfg.PDFImageMaxPPI <= 0 {
+		return 0, nil
+	}
+
+	original, originalSize, err := fs.spoolContent(doc)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(fs.getUserPath(doc.UserID), ".tmp")
+	if err != nil {
+		return 0, err
+	}
+	downscaled := &spooledFile{tmp}
+	err = exporter.DownscaleImages(original, downscaled, fs.Cfg.PDFImageMaxPPI, fs.Cfg.PDFImageQuality)
+	var downscaledSize int64
+	if err == nil {
+		downscaledSize, err = downscaled.Seek(0, io.SeekCurrent)
+	}
+	saved := originalSize - downscaledSize
+	if err != nil || saved <= 0 {
+		downscaled.Close()
+		if err != nil {
+			log.Warn("can't downscale, keeping the original: ", err)
+		}
+		// nothing to preserve, the original is stored as is
+		_, err = original.Seek(0, io.SeekStart)
+		return 0, err
+	}
+
+	originalPath := fs.getOriginalPath(doc.UserID, doc.ID)
+	err = os.MkdirAll(path.Dir(originalPath), 0700)
+	if err == nil {
+		_, err = original.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		_, err = fs.writeStored(doc.UserID, originalPath, original)
+	}
+	if err == nil {
+		_, err = downscaled.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		downscaled.Close()
+		return 0, err
+	}
+	log.Infof("downscaled images of %s from %d to %d bytes", doc.ID, originalSize, downscaledSize)
+	setContent(doc, downscaled)
+	return saved, nil
+}
+
+// removeOriginal the document was deleted or replaced, its original is of no use anymore
+func (fs *FileSystemStorage) removeOriginal(uid, docid string) {
+	err := os.Remove(fs.getOriginalPath(uid, docid))
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn("can't remove the original of ", docid, ": ", err)
+	}
+}
+
+// pruneOriginals removes the originals written before cutoff of the documents which are in neither tree
+// anymore, the tablets delete sync15 documents by uploading a root without them
+func (fs *FileSystemStorage) pruneOriginals(uid string, tree *models.HashTree, cutoff time.Time) {
+	files, err := ioutil.ReadDir(path.Join(fs.getUserPath(uid), originalsDir))
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if !f.ModTime().Before(cutoff) {
+			continue
+		}
+		docid := strings.TrimSuffix(f.Name(), models.PdfFileExt)
+		if _, err = tree.FindDoc(docid); err == nil {
+			continue
+		}
+		if _, err = os.Stat(fs.getPathFromUser(uid, docid+models.MetadataFileExt)); err == nil {
+			continue
+		}
+		log.Debug("[gc] removing the original of ", docid)
+		fs.removeOriginal(uid, docid)
+	}
+}
+
+// GetOriginal the uploaded pdf, before its images were downscaled
+func (fs *FileSystemStorage) GetOriginal(uid, docid string) (io.ReadCloser, error) {
+	f, _, err := fs.openStored(uid, fs.getOriginalPath(uid, docid))
+	if os.IsNotExist(err) {
+		return nil, ErrorNotFound
+	}
+	return f, err
+}
@@ -0,0 +1,129 @@
+package fs
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	"github.com/unidoc/unipdf/v3/creator"
+)
+
+// imagePdf a page with a noisy image of size pixels squeezed into an inch
+func imagePdf(t *testing.T, size int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	rnd := rand.New(rand.NewSource(1))
+	for x := 0; x < size; x++ {
+		for y := 0; y < size; y++ {
+			img.Set(x, y, color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255})
+		}
+	}
+	c := creator.New()
+	c.NewPage()
+	drawn, err := c.NewImageFromGoImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drawn.Scale(72/float64(size), 72/float64(size))
+	if err = c.Draw(drawn); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// storedPayload the pdf of the sync15 document
+func storedPayload(t *testing.T, fs *FileSystemStorage, uid, docid string) []byte {
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := tree.FindDoc(docid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range doc.Files {
+		if strings.HasSuffix(f.EntryName, models.PdfFileExt) {
+			content, err := fs.readBlob(uid, f.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return content
+		}
+	}
+	t.Fatal("no pdf in ", docid)
+	return nil
+}
+
+func TestDownscalePDF(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir(), PDFImageMaxPPI: 72, PDFImageQuality: 50})
+	if err := os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		content    []byte
+		downscaled bool
+	}{
+		{"downscaled", imagePdf(t, 600), true},
+		{"kept as is", testPdf(t, ""), false},
+	}
+	for _, tt := range tests {
+		doc, err := fs.CreateBlobDocument("test", tt.name+".pdf", "", bytes.NewReader(tt.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := storedPayload(t, fs, "test", doc.ID)
+		original, err := fs.GetOriginal("test", doc.ID)
+		if !tt.downscaled {
+			if doc.BytesSaved != 0 || !bytes.Equal(stored, tt.content) {
+				t.Errorf("%s: saved %d, stored %d of %d bytes", tt.name, doc.BytesSaved, len(stored), len(tt.content))
+			}
+			if err != ErrorNotFound {
+				t.Errorf("%s: original kept: %v", tt.name, err)
+			}
+			continue
+		}
+
+		if doc.BytesSaved <= 0 || int64(len(stored)) != int64(len(tt.content))-doc.BytesSaved {
+			t.Errorf("%s: saved %d, stored %d of %d bytes", tt.name, doc.BytesSaved, len(stored), len(tt.content))
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		content, _ := ioutil.ReadAll(original)
+		original.Close()
+		if !bytes.Equal(content, tt.content) {
+			t.Errorf("%s: original of %d bytes, uploaded %d", tt.name, len(content), len(tt.content))
+		}
+
+		// of no use once the document is gone
+		if _, err = fs.DeleteBlobDocument("test", doc.ID, storage.DeleteRecursive); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fs.GetOriginal("test", doc.ID); err != ErrorNotFound {
+			t.Errorf("%s: original left after the delete: %v", tt.name, err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(fs.getUserPath("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".tmp") {
+			t.Error("temp file left: ", f.Name())
+		}
+	}
+}
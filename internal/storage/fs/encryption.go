@@ -0,0 +1,480 @@
+package fs
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encrypted files start with the magic and the random nonce prefix, followed by the chunks.
+// Each chunk is sealed with the prefix, its index and whether it is the last one as the nonce,
+// so chunks can't be reordered, dropped or the file truncated without failing to open.
+// The chunks have a fixed size, any part of the file can be read without decrypting it all.
+const (
+	sealedMagic      = "RMFCENC1"
+	sealedPrefixSize = 7
+	sealedHeaderSize = len(sealedMagic) + sealedPrefixSize
+	sealedChunkSize  = 64 * 1024
+	sealedTagSize    = 16
+)
+
+// ErrorNoEncryptionKey an encrypted file but no key is configured
+var ErrorNoEncryptionKey = errors.New("the file is encrypted but no encryption key is set")
+
+// ErrorDecrypt the file can't be decrypted, the key is wrong or the file is damaged
+var ErrorDecrypt = errors.New("can't decrypt the file")
+
+// storedFile a document or blob as stored, decrypted if needed
+type storedFile interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+}
+
+// storedZip a zip of a sync10 document
+type storedZip struct {
+	*zip.Reader
+	io.Closer
+}
+
+// encrypting whether new documents and blobs are encrypted
+func (fs *FileSystemStorage) encrypting() bool {
+	return len(fs.Cfg.EncryptionKey) > 0
+}
+
+// userCipher the aead of the user, its key derived from the master key
+func (fs *FileSystemStorage) userCipher(uid string) (cipher.AEAD, error) {
+	if !fs.encrypting() {
+		return nil, ErrorNoEncryptionKey
+	}
+	key := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, fs.Cfg.EncryptionKey, nil, []byte("rmfakecloud user "+uid)), key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealWriter encrypts what is written to w when encryption is enabled
+// Close writes the last chunk, w itself isn't closed
+func (fs *FileSystemStorage) sealWriter(uid string, w io.Writer) (io.WriteCloser, error) {
+	if !fs.encrypting() {
+		return nopWriteCloser{w}, nil
+	}
+	aead, err := fs.userCipher(uid)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, sealedPrefixSize)
+	_, err = rand.Read(prefix)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(w, sealedMagic)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, sealedChunkSize),
+	}, nil
+}
+
+// openStored opens a document or blob, the encrypted ones are decrypted and the compressed ones decompressed
+// plaintext files are read as they are, the ones stored before encryption was enabled stay readable
+func (fs *FileSystemStorage) openStored(uid, filePath string) (storedFile, int64, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if st.IsDir() {
+		f.Close()
+		return nil, 0, ErrorNotFound
+	}
+	sf, size, err := fs.unseal(uid, f, st.Size())
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return openContent(sf, size)
+}
+
+// unseal the decrypted file when it is encrypted, otherwise the file itself
+func (fs *FileSystemStorage) unseal(uid string, f *os.File, size int64) (storedFile, int64, error) {
+	header := make([]byte, sealedHeaderSize)
+	n, _ := f.ReadAt(header, 0)
+	if n < sealedHeaderSize || string(header[:len(sealedMagic)]) != sealedMagic {
+		return f, size, nil
+	}
+	aead, err := fs.userCipher(uid)
+	if err != nil {
+		return nil, 0, err
+	}
+	sf, err := newSealedFile(f, aead, header[len(sealedMagic):], size-int64(sealedHeaderSize))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", filepath.Base(f.Name()), err)
+	}
+	return sf, sf.size, nil
+}
+
+// readStored the whole content of a document or blob
+func (fs *FileSystemStorage) readStored(uid, filePath string) ([]byte, error) {
+	f, _, err := fs.openStored(uid, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// openStoredZip opens the zip of a sync10 document
+func (fs *FileSystemStorage) openStoredZip(uid, zipPath string) (*storedZip, error) {
+	f, size, err := fs.openStored(uid, zipPath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(f, size)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &storedZip{Reader: r, Closer: f}, nil
+}
+
+// writeStored writes the file through a temp file in the same dir, encrypted when enabled
+func (fs *FileSystemStorage) writeStored(uid, filePath string, r io.Reader) (int64, error) {
+	return writeThrough(filePath, r, func(w io.Writer) (io.WriteCloser, error) {
+		return fs.storedWriter(uid, w)
+	})
+}
+
+// writeThrough writes the file through a temp file in the same dir with the writer wrap returns
+func writeThrough(filePath string, r io.Reader, wrap func(io.Writer) (io.WriteCloser, error)) (int64, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), ".tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	out, err := wrap(tmp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, r)
+	if err != nil {
+		return 0, err
+	}
+	err = out.Close()
+	if err != nil {
+		return 0, err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), filePath)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func sealedNonce(prefix []byte, index int64, last bool) []byte {
+	nonce := make([]byte, 0, sealedPrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = append(nonce, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(nonce[sealedPrefixSize:], uint32(index))
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// sealedWriter encrypts in chunks, a chunk is only sealed once it is known whether it is the last
+type sealedWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	buf    []byte
+	index  int64
+	closed bool
+}
+
+func (s *sealedWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write to a closed sealed writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(s.buf) == sealedChunkSize {
+			// more follows, not the last
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):sealedChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *sealedWriter) seal(last bool) error {
+	if s.index > int64(^uint32(0)) {
+		return errors.New("too large to encrypt")
+	}
+	chunk := s.aead.Seal(nil, sealedNonce(s.prefix, s.index, last), s.buf, nil)
+	s.index++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(chunk)
+	return err
+}
+
+// Close seals the last chunk, empty for an empty file
+func (s *sealedWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.seal(true)
+}
+
+// sealedFile decrypts an encrypted file, the chunk last read is kept
+type sealedFile struct {
+	f      *os.File
+	aead   cipher.AEAD
+	prefix []byte
+	chunks int64
+	// size of the plaintext
+	size int64
+	pos  int64
+
+	mu    sync.Mutex
+	index int64
+	chunk []byte
+}
+
+func newSealedFile(f *os.File, aead cipher.AEAD, prefix []byte, sealedSize int64) (*sealedFile, error) {
+	sealedChunk := int64(sealedChunkSize + sealedTagSize)
+	chunks := (sealedSize + sealedChunk - 1) / sealedChunk
+	if chunks == 0 || sealedSize-(chunks-1)*sealedChunk < sealedTagSize {
+		return nil, ErrorDecrypt
+	}
+	return &sealedFile{
+		f:      f,
+		aead:   aead,
+		prefix: append([]byte(nil), prefix...),
+		chunks: chunks,
+		size:   sealedSize - chunks*sealedTagSize,
+		index:  -1,
+	}, nil
+}
+
+// readChunk decrypts the chunk, the caller holds the lock
+func (s *sealedFile) readChunk(index int64) ([]byte, error) {
+	if index == s.index {
+		return s.chunk, nil
+	}
+	sealedChunk := int64(sealedChunkSize + sealedTagSize)
+	buf := make([]byte, sealedChunk)
+	n, err := s.f.ReadAt(buf, int64(sealedHeaderSize)+index*sealedChunk)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	last := index == s.chunks-1
+	chunk, err := s.aead.Open(buf[:0], sealedNonce(s.prefix, index, last), buf[:n], nil)
+	if err != nil {
+		return nil, ErrorDecrypt
+	}
+	s.index = index
+	s.chunk = chunk
+	return chunk, nil
+}
+
+// ReadAt reads the plaintext at the offset
+func (s *sealedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	read := 0
+	for len(p) > 0 {
+		if off >= s.size {
+			return read, io.EOF
+		}
+		chunk, err := s.readChunk(off / sealedChunkSize)
+		if err != nil {
+			return read, err
+		}
+		n := copy(p, chunk[off%sealedChunkSize:])
+		p = p[n:]
+		off += int64(n)
+		read += n
+	}
+	return read, nil
+}
+
+func (s *sealedFile) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	if remaining := s.size - s.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := s.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (s *sealedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *sealedFile) Close() error {
+	return s.f.Close()
+}
+
+// isSealed whether the file is encrypted
+func isSealed(filePath string) (bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	header := make([]byte, len(sealedMagic))
+	_, err = io.ReadFull(f, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(header, []byte(sealedMagic)), nil
+}
+
+// EncryptStats what the migration of a user encrypted
+type EncryptStats struct {
+	Encrypted int
+	Skipped   int
+}
+
+// EncryptExisting encrypts the plaintext documents and blobs of the user in place,
+// files already encrypted are skipped. The modification times are kept, the gc relies on them
+func (fs *FileSystemStorage) EncryptExisting(uid string) (*EncryptStats, error) {
+	if !fs.encrypting() {
+		return nil, ErrorNoEncryptionKey
+	}
+	stats := &EncryptStats{}
+	userPath := fs.getUserPath(uid)
+	dirs := []string{
+		userPath,
+		fs.getUserBlobPath(uid),
+		filepath.Join(userPath, originalsDir),
+		filepath.Join(userPath, CacheDir),
+	}
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.Mode().IsRegular() || !encryptedFile(dir == userPath, e.Name()) {
+				continue
+			}
+			filePath := filepath.Join(dir, e.Name())
+			sealed, err := isSealed(filePath)
+			if err != nil {
+				return nil, err
+			}
+			if sealed {
+				stats.Skipped++
+				continue
+			}
+			err = fs.encryptFile(uid, filePath, e)
+			if err != nil {
+				return nil, err
+			}
+			stats.Encrypted++
+		}
+	}
+	fs.usageChanged(uid)
+	return stats, nil
+}
+
+// encryptedFile whether the file is a document or blob, in the user's folder only the zips are
+// the root stays plaintext like its history, the dot files are state of the server
+func encryptedFile(userDir bool, name string) bool {
+	if userDir {
+		return filepath.Ext(name) == models.ZipFileExt
+	}
+	return name != rootFile && !strings.HasPrefix(name, ".")
+}
+
+func (fs *FileSystemStorage) encryptFile(uid, filePath string, fi os.FileInfo) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// the stored bytes as they are, they have their format already
+	_, err = writeThrough(filePath, f, func(w io.Writer) (io.WriteCloser, error) {
+		return fs.sealWriter(uid, w)
+	})
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(filePath, fi.ModTime(), fi.ModTime())
+}
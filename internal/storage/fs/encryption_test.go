@@ -0,0 +1,195 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+)
+
+func newEncryptedStorage(t *testing.T, key []byte) (*FileSystemStorage, func()) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewStorage(&config.Config{DataDir: dir, EncryptionKey: key})
+	return fs, func() { os.RemoveAll(dir) }
+}
+
+func TestSealedRoundTrip(t *testing.T) {
+	fs, cleanup := newEncryptedStorage(t, bytes.Repeat([]byte{1}, 32))
+	defer cleanup()
+	dir := fs.getUserPath("test")
+	os.MkdirAll(dir, 0700)
+
+	for _, size := range []int{0, 1, sealedChunkSize, sealedChunkSize + 1, 3*sealedChunkSize - 7} {
+		content := make([]byte, size)
+		for i := range content {
+			content[i] = byte(i * 7)
+		}
+		filePath := path.Join(dir, "file")
+		_, err := fs.writeStored("test", filePath, bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := ioutil.ReadFile(filePath)
+		if size > sealedChunkSize && bytes.Contains(raw, content[:64]) {
+			t.Errorf("%d: stored in plaintext", size)
+		}
+
+		f, n, err := fs.openStored("test", filePath)
+		if err != nil {
+			t.Fatal(size, err)
+		}
+		got, err := ioutil.ReadAll(f)
+		if err != nil || n != int64(size) || !bytes.Equal(got, content) {
+			t.Errorf("%d: got %d bytes, size %d, %v", size, len(got), n, err)
+		}
+		if size > sealedChunkSize+5 {
+			part := make([]byte, 10)
+			_, err = f.Seek(sealedChunkSize-5, io.SeekStart)
+			if err == nil {
+				_, err = io.ReadFull(f, part)
+			}
+			if err != nil || !bytes.Equal(part, content[sealedChunkSize-5:sealedChunkSize+5]) {
+				t.Errorf("%d: read across chunks %v", size, err)
+			}
+		}
+		f.Close()
+	}
+}
+
+func TestSealedTampered(t *testing.T) {
+	fs, cleanup := newEncryptedStorage(t, bytes.Repeat([]byte{1}, 32))
+	defer cleanup()
+	dir := fs.getUserPath("test")
+	os.MkdirAll(dir, 0700)
+	filePath := path.Join(dir, "file")
+	content := bytes.Repeat([]byte("notes"), sealedChunkSize/2)
+	_, err := fs.writeStored("test", filePath, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := ioutil.ReadFile(filePath)
+
+	// another user can't read it
+	if _, err = fs.readStored("other", filePath); err != ErrorDecrypt {
+		t.Errorf("other user: %v", err)
+	}
+
+	// the last chunk dropped
+	ioutil.WriteFile(filePath, raw[:sealedHeaderSize+sealedChunkSize+sealedTagSize], 0600)
+	if _, err = fs.readStored("test", filePath); err != ErrorDecrypt {
+		t.Errorf("truncated: %v", err)
+	}
+
+	flipped := append([]byte(nil), raw...)
+	flipped[len(flipped)-1] ^= 1
+	ioutil.WriteFile(filePath, flipped, 0600)
+	if _, err = fs.readStored("test", filePath); err != ErrorDecrypt {
+		t.Errorf("flipped: %v", err)
+	}
+
+	fs.Cfg.EncryptionKey = nil
+	ioutil.WriteFile(filePath, raw, 0600)
+	if _, err = fs.readStored("test", filePath); err != ErrorNoEncryptionKey {
+		t.Errorf("no key: %v", err)
+	}
+}
+
+func TestEncryptedBlobs(t *testing.T) {
+	fs, cleanup := newEncryptedStorage(t, bytes.Repeat([]byte{2}, 32))
+	defer cleanup()
+	testuser := "test"
+	blobPath := fs.getUserBlobPath(testuser)
+	os.MkdirAll(blobPath, 0700)
+
+	sum := sha256.Sum256([]byte("blah"))
+	blobID := hex.EncodeToString(sum[:])
+	_, err := fs.StoreBlob(testuser, blobID, strings.NewReader("blah"), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed, _ := isSealed(path.Join(blobPath, blobID)); !sealed {
+		t.Error("the blob should be encrypted")
+	}
+	r, _, err := fs.LoadBlob(testuser, blobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(content) != "blah" {
+		t.Errorf("got %q", content)
+	}
+
+	_, err = fs.StoreBlob(testuser, rootFile, strings.NewReader(blobID), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := ioutil.ReadFile(path.Join(blobPath, rootFile))
+	if string(root) != blobID {
+		t.Error("the root should stay plaintext")
+	}
+
+	err = fs.StoreDocument(testuser, "doc", ioutil.NopCloser(strings.NewReader("zip")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := fs.GetDocument(testuser, "doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ = ioutil.ReadAll(d)
+	d.Close()
+	if string(content) != "zip" {
+		t.Errorf("got %q", content)
+	}
+}
+
+func TestEncryptExisting(t *testing.T) {
+	fs, cleanup := newEncryptedStorage(t, nil)
+	defer cleanup()
+	testuser := "test"
+	blobPath := fs.getUserBlobPath(testuser)
+	os.MkdirAll(blobPath, 0700)
+
+	_, err := fs.StoreBlob(testuser, "blob", strings.NewReader("plaintext"), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.StoreDocument(testuser, "doc", ioutil.NopCloser(strings.NewReader("zip")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.Stat(path.Join(blobPath, "blob"))
+
+	if _, err = fs.EncryptExisting(testuser); err != ErrorNoEncryptionKey {
+		t.Errorf("no key: %v", err)
+	}
+	fs.Cfg.EncryptionKey = bytes.Repeat([]byte{3}, 32)
+	stats, err := fs.EncryptExisting(testuser)
+	if err != nil || stats.Encrypted != 2 {
+		t.Fatalf("%+v %v", stats, err)
+	}
+	stats, _ = fs.EncryptExisting(testuser)
+	if stats.Encrypted != 0 || stats.Skipped != 2 {
+		t.Errorf("encrypted again: %+v", stats)
+	}
+
+	after, _ := os.Stat(path.Join(blobPath, "blob"))
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Error("the modification time changed")
+	}
+	content, err := fs.readStored(testuser, path.Join(blobPath, "blob"))
+	if err != nil || string(content) != "plaintext" {
+		t.Errorf("got %q %v", content, err)
+	}
+}
@@ -0,0 +1,123 @@
+package fs
+
+import (
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// RegisterEventListener gets the events of the documents of all users
+// not thread safe, register before serving
+func (fs *FileSystemStorage) RegisterEventListener(l storage.EventListener) {
+	fs.eventListeners = append(fs.eventListeners, l)
+}
+
+func (fs *FileSystemStorage) emit(e *storage.Event) {
+	for _, l := range fs.eventListeners {
+		l.Event(e)
+	}
+}
+
+// documentEvent a sync10 document was stored or removed
+func (fs *FileSystemStorage) documentEvent(eventType, uid string, doc *messages.RawMetadata) {
+	if len(fs.eventListeners) == 0 {
+		return
+	}
+	fs.emit(&storage.Event{
+		Type:         eventType,
+		UserID:       uid,
+		Time:         time.Now().UTC(),
+		DocumentID:   doc.ID,
+		Name:         doc.VissibleName,
+		DocumentType: doc.Type,
+		Parent:       doc.Parent,
+		Version:      doc.Version,
+	})
+}
+
+// rootDocuments the documents of the root index, by id
+func rootDocuments(ls *LocalBlobStorage, rootHash string) (map[string]*models.HashEntry, error) {
+	entries := make(map[string]*models.HashEntry)
+	if rootHash == "" {
+		return entries, nil
+	}
+	r, err := ls.GetReader(rootHash)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	index, err := models.ParseIndex(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range index {
+		entries[e.EntryName] = e
+	}
+	return entries, nil
+}
+
+// rootEvent the document event with the metadata of the document index
+func rootEvent(ls *LocalBlobStorage, eventType string, entry *models.HashEntry, generation int64) *storage.Event {
+	doc := &models.HashDoc{}
+	if err := doc.Mirror(entry, ls); err != nil {
+		log.Warn("can't read the metadata of ", entry.EntryName, ": ", err)
+	}
+	return &storage.Event{
+		Type:         eventType,
+		UserID:       ls.uid,
+		Time:         time.Now().UTC(),
+		DocumentID:   entry.EntryName,
+		Name:         doc.DocumentName,
+		DocumentType: doc.CollectionType,
+		Parent:       doc.Parent,
+		Version:      doc.Version,
+		Generation:   generation,
+	}
+}
+
+// rootAdvanced emits the documents which changed since the previous root and the new generation
+func (fs *FileSystemStorage) rootAdvanced(uid, previous string, generation int64) {
+	if len(fs.eventListeners) == 0 {
+		return
+	}
+	ls := &LocalBlobStorage{
+		fs:  fs,
+		uid: uid,
+	}
+	current, _, err := ls.GetRootIndex()
+	if err != nil {
+		log.Warn("events: can't read the root of ", uid, ": ", err)
+		return
+	}
+	if current != previous {
+		before, err := rootDocuments(ls, previous)
+		if err != nil {
+			log.Warn("events: can't read the previous root of ", uid, ": ", err)
+			before = make(map[string]*models.HashEntry)
+		}
+		after, err := rootDocuments(ls, current)
+		if err != nil {
+			log.Warn("events: can't read the root of ", uid, ": ", err)
+			return
+		}
+		for id, entry := range after {
+			if old, ok := before[id]; !ok || old.Hash != entry.Hash {
+				fs.emit(rootEvent(ls, storage.EventDocumentUploaded, entry, generation))
+			}
+		}
+		for id, entry := range before {
+			if _, ok := after[id]; !ok {
+				fs.emit(rootEvent(ls, storage.EventDocumentDeleted, entry, generation))
+			}
+		}
+	}
+	fs.emit(&storage.Event{
+		Type:       storage.EventRootGeneration,
+		UserID:     uid,
+		Time:       time.Now().UTC(),
+		Generation: generation,
+	})
+}
@@ -0,0 +1,84 @@
+package fs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+type recordedEvents []*storage.Event
+
+func (r *recordedEvents) Event(e *storage.Event) {
+	*r = append(*r, e)
+}
+
+func (r *recordedEvents) take() string {
+	events := make([]string, 0, len(*r))
+	for _, e := range *r {
+		events = append(events, e.Type+":"+e.DocumentID+":"+e.Name)
+	}
+	*r = nil
+	return strings.Join(events, " ")
+}
+
+func TestBlobEvents(t *testing.T) {
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	err := os.MkdirAll(fs.getUserBlobPath(testuser), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := &recordedEvents{}
+	fs.RegisterEventListener(events)
+
+	doc, err := fs.CreateBlobDocument(testuser, "notes.pdf", "", strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*events)[1].Generation != 1 {
+		t.Errorf("generation %d", (*events)[1].Generation)
+	}
+	want := storage.EventDocumentUploaded + ":" + doc.ID + ":notes " + storage.EventRootGeneration + "::"
+	if got := events.take(); got != want {
+		t.Errorf("created: %s", got)
+	}
+
+	_, err = fs.DeleteBlobDocument(testuser, doc.ID, storage.DeleteReparent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = storage.EventDocumentDeleted + ":" + doc.ID + ":notes " + storage.EventRootGeneration + "::"
+	if got := events.take(); got != want {
+		t.Errorf("deleted: %s", got)
+	}
+}
+
+func TestDocumentEvents(t *testing.T) {
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	err := os.MkdirAll(fs.getUserPath(testuser), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := &recordedEvents{}
+	fs.RegisterEventListener(events)
+
+	err = fs.UpdateMetadata(testuser, &messages.RawMetadata{ID: "doc", VissibleName: "notes", Version: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := events.take(); got != storage.EventDocumentUploaded+":doc:notes" {
+		t.Errorf("uploaded: %s", got)
+	}
+	err = fs.RemoveDocument(testuser, "doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := events.take(); got != storage.EventDocumentDeleted+":doc:notes" {
+		t.Errorf("removed: %s", got)
+	}
+}
@@ -0,0 +1,162 @@
+package fs
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+const trashParent = "trash"
+
+// planDeletion works out which documents move and which are deleted
+// parents maps every document id to its parent
+func planDeletion(parents map[string]string, docid, mode string) (*storage.Deletion, error) {
+	if mode != storage.DeleteReparent && mode != storage.DeleteRecursive {
+		return nil, fmt.Errorf("%w: %s", storage.ErrorInvalidDeleteMode, mode)
+	}
+	if _, ok := parents[docid]; !ok {
+		return nil, ErrorNotFound
+	}
+
+	children := make(map[string][]string)
+	for id, parent := range parents {
+		if id != parent {
+			children[parent] = append(children[parent], id)
+		}
+	}
+	for _, c := range children {
+		sort.Strings(c)
+	}
+
+	deletion := &storage.Deletion{
+		ID:      docid,
+		Moved:   []string{},
+		Deleted: []string{docid},
+	}
+
+	if mode == storage.DeleteRecursive {
+		seen := map[string]bool{docid: true}
+		queue := []string{docid}
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			for _, child := range children[id] {
+				if seen[child] {
+					continue
+				}
+				seen[child] = true
+				deletion.Deleted = append(deletion.Deleted, child)
+				queue = append(queue, child)
+			}
+		}
+		return deletion, nil
+	}
+
+	// the new parent must not be the folder itself, one of its children
+	// or a folder that is not there, otherwise the children end up in the root
+	parent := parents[docid]
+	seen := map[string]bool{docid: true}
+	for p := parent; p != "" && p != trashParent; p = parents[p] {
+		if _, ok := parents[p]; !ok || seen[p] {
+			log.Warn("ancestors of ", docid, " are not valid, moving the children to the root")
+			parent = ""
+			break
+		}
+		seen[p] = true
+	}
+	deletion.Parent = parent
+	deletion.Moved = append(deletion.Moved, children[docid]...)
+	return deletion, nil
+}
+
+// DeleteBlobDocument removes the document from the tree, the children of a folder
+// are moved to its parent or deleted as well, depending on the mode
+func (fs *FileSystemStorage) DeleteBlobDocument(uid, docid, mode string) (*storage.Deletion, error) {
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[string]string, len(tree.Docs))
+	for _, d := range tree.Docs {
+		parents[d.EntryName] = d.Parent
+	}
+	deletion, err := planDeletion(parents, docid, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range deletion.Moved {
+		doc, err := tree.FindDoc(id)
+		if err != nil {
+			return nil, err
+		}
+		doc.Parent = deletion.Parent
+		err = fs.saveBlobDocument(uid, doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range deletion.Deleted {
+		err = tree.Remove(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = tree.Rehash()
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.commitTree(uid, tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range deletion.Deleted {
+		fs.removeOriginal(uid, id)
+	}
+	log.Infof("deleted %s, moved: %d, deleted: %d", docid, len(deletion.Moved), len(deletion.Deleted))
+	return deletion, nil
+}
+
+// DeleteDocument removes the document, the children of a folder
+// are moved to its parent or deleted as well, depending on the mode
+func (fs *FileSystemStorage) DeleteDocument(uid, docid, mode string) (*storage.Deletion, error) {
+	documents, err := fs.GetAllMetadata(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*messages.RawMetadata, len(documents))
+	parents := make(map[string]string, len(documents))
+	for _, d := range documents {
+		byID[d.ID] = d
+		parents[d.ID] = d.Parent
+	}
+	deletion, err := planDeletion(parents, docid, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range deletion.Moved {
+		doc := byID[id]
+		doc.Parent = deletion.Parent
+		doc.Version++
+		doc.ModifiedClient = time.Now().UTC().Format(time.RFC3339Nano)
+		err = fs.UpdateMetadata(uid, doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range deletion.Deleted {
+		err = fs.RemoveDocument(uid, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return deletion, nil
+}
@@ -0,0 +1,68 @@
+package fs
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+func TestPlanDeletion(t *testing.T) {
+	parents := map[string]string{
+		"top":    "",
+		"folder": "top",
+		"doc1":   "folder",
+		"doc2":   "folder",
+		"sub":    "folder",
+		"doc3":   "sub",
+		"loop1":  "loop2",
+		"loop2":  "loop1",
+		"child":  "loop1",
+		"lost":   "missing",
+		"orphan": "lost",
+	}
+
+	tests := []struct {
+		name    string
+		docid   string
+		mode    string
+		parent  string
+		moved   []string
+		deleted []string
+	}{
+		{"reparent", "folder", storage.DeleteReparent, "top", []string{"doc1", "doc2", "sub"}, []string{"folder"}},
+		{"reparent to root", "top", storage.DeleteReparent, "", []string{"folder"}, []string{"top"}},
+		{"document", "doc1", storage.DeleteReparent, "folder", []string{}, []string{"doc1"}},
+		{"cycle", "loop1", storage.DeleteReparent, "", []string{"child", "loop2"}, []string{"loop1"}},
+		{"missing grandparent", "lost", storage.DeleteReparent, "", []string{"orphan"}, []string{"lost"}},
+		{"recursive", "folder", storage.DeleteRecursive, "", []string{}, []string{"doc1", "doc2", "doc3", "folder", "sub"}},
+		{"recursive cycle", "loop1", storage.DeleteRecursive, "", []string{}, []string{"child", "loop1", "loop2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deletion, err := planDeletion(parents, tt.docid, tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(deletion.Deleted)
+			if deletion.Parent != tt.parent {
+				t.Errorf("parent: got %q want %q", deletion.Parent, tt.parent)
+			}
+			if !reflect.DeepEqual(deletion.Moved, tt.moved) {
+				t.Errorf("moved: got %v want %v", deletion.Moved, tt.moved)
+			}
+			if !reflect.DeepEqual(deletion.Deleted, tt.deleted) {
+				t.Errorf("deleted: got %v want %v", deletion.Deleted, tt.deleted)
+			}
+		})
+	}
+
+	if _, err := planDeletion(parents, "nothere", storage.DeleteReparent); err != ErrorNotFound {
+		t.Error("expected not found, got ", err)
+	}
+	if _, err := planDeletion(parents, "folder", "bogus"); !errors.Is(err, storage.ErrorInvalidDeleteMode) {
+		t.Error("expected an error for an unknown mode")
+	}
+}
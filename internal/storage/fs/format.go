@@ -0,0 +1,201 @@
+package fs
+
+import (
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The stored documents and blobs say how they are stored with their first bytes: sealedMagic is
+// encrypted, inside it (or without encryption) deflateMagic is compressed and rawMagic is the
+// content as it is. Content which starts with one of the magics itself is always written after
+// rawMagic, so a magic at the start of a file is never part of the content.
+// storeFormatFile records that the whole data dir is stored like this, the files of older
+// versions are upgraded once when the storage is created
+const (
+	rawMagic        = "RMFCRAW1"
+	magicSize       = len(rawMagic)
+	storeFormatFile = ".format"
+	storeFormat     = "2"
+)
+
+// hasMagic whether the content starts like a stored file with a format
+func hasMagic(head []byte) bool {
+	if len(head) < magicSize {
+		return false
+	}
+	switch string(head[:magicSize]) {
+	case rawMagic, sealedMagic, deflateMagic:
+		return true
+	}
+	return false
+}
+
+// contentWriter writes the content to the (sealed) file, compressed when enabled, after rawMagic
+// when it starts with a magic. Which one is decided once the first bytes are written
+type contentWriter struct {
+	w        io.WriteCloser
+	compress bool
+	head     []byte
+	out      io.Writer
+	deflater *flate.Writer
+}
+
+func (c *contentWriter) Write(p []byte) (int, error) {
+	if c.out != nil {
+		return c.out.Write(p)
+	}
+	c.head = append(c.head, p...)
+	if len(c.head) < magicSize {
+		return len(p), nil
+	}
+	if err := c.start(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// start writes the format and the first bytes
+func (c *contentWriter) start() error {
+	c.out = c.w
+	switch {
+	case c.compress && !incompressible(c.head):
+		if _, err := io.WriteString(c.w, deflateMagic); err != nil {
+			return err
+		}
+		deflater, err := flate.NewWriter(c.w, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		c.deflater = deflater
+		c.out = deflater
+	case hasMagic(c.head):
+		if _, err := io.WriteString(c.w, rawMagic); err != nil {
+			return err
+		}
+	}
+	_, err := c.out.Write(c.head)
+	c.head = nil
+	return err
+}
+
+// Close flushes the content and closes the sealed writer
+func (c *contentWriter) Close() error {
+	if c.out == nil {
+		if err := c.start(); err != nil {
+			return err
+		}
+	}
+	if c.deflater != nil {
+		if err := c.deflater.Close(); err != nil {
+			return err
+		}
+	}
+	return c.w.Close()
+}
+
+// storedWriter encrypts what is written to w when enabled, Close doesn't close w
+func (fs *FileSystemStorage) storedWriter(uid string, w io.Writer) (io.WriteCloser, error) {
+	sealed, err := fs.sealWriter(uid, w)
+	if err != nil {
+		return nil, err
+	}
+	return &contentWriter{w: sealed}, nil
+}
+
+// sectionFile the content of a file after its format
+type sectionFile struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// openContent the content of the decrypted file, decompressed or after rawMagic
+func openContent(f storedFile, size int64) (storedFile, int64, error) {
+	head := make([]byte, magicSize)
+	n, _ := f.ReadAt(head, 0)
+	if n < magicSize {
+		return f, size, nil
+	}
+	switch string(head) {
+	case rawMagic:
+		contentSize := size - int64(magicSize)
+		return &sectionFile{io.NewSectionReader(f, int64(magicSize), contentSize), f}, contentSize, nil
+	case deflateMagic:
+		return inflateStored(f, size)
+	}
+	return f, size, nil
+}
+
+// upgradeFormat puts the files of older versions, which stored the content as it is, after rawMagic
+// when they start with a magic, then records the format of the data dir
+func (fs *FileSystemStorage) upgradeFormat() error {
+	marker := filepath.Join(fs.Cfg.DataDir, storeFormatFile)
+	if content, err := ioutil.ReadFile(marker); err == nil && strings.TrimSpace(string(content)) == storeFormat {
+		return nil
+	}
+	upgraded := 0
+	err := filepath.Walk(fs.getUserPath(""), func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), ".") || !startsWithMagic(filePath) {
+			return nil
+		}
+		log.Info("upgrading ", filePath)
+		upgraded++
+		return escapeFile(filePath, fi)
+	})
+	if err != nil {
+		return err
+	}
+	if upgraded > 0 {
+		// the shared copies weren't upgraded, no user has them anymore
+		if _, err = fs.pruneSharedBlobs(); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(marker, []byte(storeFormat+"\n"), 0600)
+}
+
+// escapeFile writes the file again after rawMagic, keeping its time for the gc
+func escapeFile(filePath string, fi os.FileInfo) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	_, err = io.Copy(tmp, io.MultiReader(strings.NewReader(rawMagic), f))
+	if err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filePath); err != nil {
+		return err
+	}
+	return os.Chtimes(filePath, fi.ModTime(), fi.ModTime())
+}
+
+// startsWithMagic whether the file has to be written after rawMagic
+func startsWithMagic(filePath string) bool {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, magicSize)
+	n, _ := io.ReadFull(f, head)
+	return hasMagic(head[:n])
+}
@@ -0,0 +1,104 @@
+package fs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+func TestContentStartingWithMagic(t *testing.T) {
+	contents := []string{"", "RMFC", rawMagic, sealedMagic, deflateMagic + "not deflate", sealedMagic + strings.Repeat("x", 100)}
+	for _, setup := range []struct {
+		name        string
+		key         []byte
+		compression string
+	}{
+		{name: "plain"},
+		{name: "encrypted", key: make([]byte, 32)},
+		{name: "compressed", compression: config.CompressionDeflate},
+		{name: "both", key: make([]byte, 32), compression: config.CompressionDeflate},
+	} {
+		t.Run(setup.name, func(t *testing.T) {
+			fs := NewStorage(&config.Config{DataDir: t.TempDir(), EncryptionKey: setup.key, BlobCompression: setup.compression})
+			blobPath := fs.getUserBlobPath("test")
+			if err := os.MkdirAll(blobPath, 0700); err != nil {
+				t.Fatal(err)
+			}
+			for _, content := range contents {
+				id, _, _ := models.Hash(strings.NewReader(content))
+				if _, err := fs.StoreBlob("test", id, strings.NewReader(content), -1); err != nil {
+					t.Fatal(err)
+				}
+				if loaded, err := fs.readStored("test", path.Join(blobPath, id)); err != nil || string(loaded) != content {
+					t.Errorf("blob %q loaded as %q %v", content, loaded, err)
+				}
+
+				docPath := fs.getPathFromUser("test", "doc"+models.ZipFileExt)
+				if _, err := fs.writeStored("test", docPath, strings.NewReader(content)); err != nil {
+					t.Fatal(err)
+				}
+				if loaded, err := fs.readStored("test", docPath); err != nil || string(loaded) != content {
+					t.Errorf("document %q loaded as %q %v", content, loaded, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUpgradeFormat(t *testing.T) {
+	dataDir := t.TempDir()
+	cfg := &config.Config{DataDir: dataDir, EncryptionKey: make([]byte, 32)}
+	fs := &FileSystemStorage{Cfg: cfg}
+	blobPath := fs.getUserBlobPath("test")
+	if err := os.MkdirAll(blobPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// written by an older version: the content as it is
+	legacy := func(name string, stored []byte) string {
+		filePath := filepath.Join(blobPath, name)
+		if err := ioutil.WriteFile(filePath, stored, 0600); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-time.Hour).Truncate(time.Second)
+		if err := os.Chtimes(filePath, old, old); err != nil {
+			t.Fatal(err)
+		}
+		return filePath
+	}
+	files := map[string]string{
+		legacy("plain", []byte("plain")):                                                "plain",
+		legacy("looksSealed", []byte(sealedMagic+"user text")):                          sealedMagic + "user text",
+		legacy("looksSealedLong", []byte(sealedMagic+strings.Repeat("user text", 100))): sealedMagic + strings.Repeat("user text", 100),
+		legacy("looksDeflated", []byte(deflateMagic+"user text")):                       deflateMagic + "user text",
+		legacy("looksRaw", []byte(rawMagic+"user text")):                                rawMagic + "user text",
+	}
+
+	fs = NewStorage(cfg)
+	for filePath, content := range files {
+		if loaded, err := fs.readStored("test", filePath); err != nil || string(loaded) != content {
+			t.Errorf("%s loaded as %q %v", filepath.Base(filePath), loaded, err)
+		}
+		if fi, err := os.Stat(filePath); err != nil || time.Since(fi.ModTime()) < time.Hour {
+			t.Errorf("%s time not kept %v", filepath.Base(filePath), err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, storeFormatFile)); err != nil {
+		t.Error("format not recorded", err)
+	}
+
+	// once only
+	stored, _ := ioutil.ReadFile(filepath.Join(blobPath, "looksRaw"))
+	NewStorage(cfg)
+	if again, _ := ioutil.ReadFile(filepath.Join(blobPath, "looksRaw")); !bytes.Equal(stored, again) {
+		t.Error("upgraded twice")
+	}
+}
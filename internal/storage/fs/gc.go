@@ -0,0 +1,268 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	"github.com/juju/fslock"
+	log "github.com/sirupsen/logrus"
+)
+
+// gcSyncIdle users with a blob written more recently are syncing and not collected
+const gcSyncIdle = 10 * time.Minute
+
+// gcArchiveDir the collected blobs in archive mode, in the user's folder
+const gcArchiveDir = ".gc-archive"
+
+// gcBatchSize the blobs removed with the lock held at once, uploads wait for at most one batch
+const gcBatchSize = 100
+
+// gcScanned is called between the scan and taking the lock to remove the blobs (for tests)
+var gcScanned func(uid string)
+
+// markTree marks the root index, its document indexes and their files as reachable
+func (fs *FileSystemStorage) markTree(uid, rootHash string, reachable map[string]bool) error {
+	blobPath := fs.getUserBlobPath(uid)
+	reachable[rootHash] = true
+	docs, ok := fs.readIndex(uid, path.Join(blobPath, common.Sanitize(rootHash)))
+	if !ok {
+		return fmt.Errorf("can't read the root index %s", rootHash)
+	}
+	for _, d := range docs {
+		reachable[d.Hash] = true
+		files, ok := fs.readIndex(uid, path.Join(blobPath, common.Sanitize(d.Hash)))
+		if !ok {
+			return fmt.Errorf("can't read the index of %s", d.EntryName)
+		}
+		for _, f := range files {
+			reachable[f.Hash] = true
+		}
+	}
+	return nil
+}
+
+// reachableBlobs the blobs referenced by the current root index and the root history
+// read from the blobs rather than the cached tree, any unreadable index of the current root aborts.
+// The trees of the roots written after retainSince are kept too, a device still on one of them can sync,
+// as are the trees of the last RootHistory generations for restoring them.
+// With the recover policy the orphans are kept as well until they are recovered
+func (fs *FileSystemStorage) reachableBlobs(uid string, retainSince time.Time) (map[string]bool, error) {
+	blobPath := fs.getUserBlobPath(uid)
+	ls := &LocalBlobStorage{
+		fs:  fs,
+		uid: uid,
+	}
+	rootHash, _, err := ls.GetRootIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := make(map[string]bool)
+	if rootHash != "" {
+		if err = fs.markTree(uid, rootHash, reachable); err != nil {
+			return nil, err
+		}
+	}
+
+	// keep the root indexes, they are the modification log
+	history, err := ioutil.ReadFile(path.Join(blobPath, historyFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(history)), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		hash := fields[1]
+		reachable[hash] = true
+		written, err := time.Parse(time.RFC3339, fields[0])
+		recent := err == nil && !written.Before(retainSince)
+		// the last generations can be restored
+		snapshot := fs.keepsSnapshot(int64(i+1), int64(len(lines)))
+		if hash == rootHash || !(recent || snapshot) {
+			continue
+		}
+		// recent roots are only partially there when their blobs were collected before
+		if err = fs.markTree(uid, hash, reachable); err != nil {
+			log.Warn("[gc] ", uid, ": ", err)
+		}
+	}
+
+	if fs.Cfg.OrphanPolicy == config.OrphanRecover {
+		// every root is in the history, the tree adds nothing
+		orphans, err := fs.findOrphans(uid, &models.HashTree{})
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range orphans {
+			reachable[o.Hash] = true
+			for _, f := range o.doc.Files {
+				reachable[f.Hash] = true
+			}
+		}
+	}
+	return reachable, nil
+}
+
+// currentGeneration the generation of the root, from the size of its history
+func (fs *FileSystemStorage) currentGeneration(uid string) int64 {
+	return generationFromFileSize(fileSize(path.Join(fs.getUserBlobPath(uid), historyFile)))
+}
+
+// gcGracePeriod blobs younger than this are never collected, they could belong to a sync in progress
+func (fs *FileSystemStorage) gcGracePeriod() time.Duration {
+	if fs.Cfg.GCGracePeriod > 0 {
+		return fs.Cfg.GCGracePeriod
+	}
+	return config.DefaultGCGracePeriod
+}
+
+// GarbageCollect removes the user's blobs which are not reachable from the root index, nor from a root
+// written within the grace period, and older than it. In archive mode they are moved to gcArchiveDir instead.
+// With dryRun they are only counted and listed. Users who synced in the last gcSyncIdle are skipped with ErrorSyncInProgress
+func (fs *FileSystemStorage) GarbageCollect(uid string, dryRun bool, progress func(storage.GCStats)) (stats storage.GCStats, err error) {
+	stats.DryRun = dryRun
+	if err = fs.localBlobs(); err != nil {
+		return stats, err
+	}
+	now := time.Now()
+	cutoff := now.Add(-fs.gcGracePeriod())
+	generation := fs.currentGeneration(uid)
+	reachable, err := fs.reachableBlobs(uid, cutoff)
+	if err != nil {
+		return
+	}
+
+	blobPath := fs.getUserBlobPath(uid)
+	files, err := ioutil.ReadDir(blobPath)
+	if err != nil {
+		return
+	}
+
+	candidates := make([]os.FileInfo, 0)
+	for _, f := range files {
+		if now.Sub(f.ModTime()) < gcSyncIdle {
+			log.Info("[gc] ", uid, " is syncing, skipped")
+			return stats, storage.ErrorSyncInProgress
+		}
+		name := f.Name()
+		if f.IsDir() || name == rootFile || strings.HasPrefix(name, ".") {
+			continue
+		}
+		stats.Scanned++
+		if !reachable[name] && f.ModTime().Before(cutoff) {
+			candidates = append(candidates, f)
+		}
+	}
+
+	if dryRun {
+		for _, f := range candidates {
+			stats.Reclaimed++
+			stats.BytesFreed += f.Size()
+			stats.Blobs = append(stats.Blobs, f.Name())
+		}
+		log.Infof("[gc] %s: dry run, scanned %d, %d blobs, %d bytes can be removed", uid, stats.Scanned, stats.Reclaimed, stats.BytesFreed)
+		if progress != nil {
+			progress(stats)
+		}
+		return
+	}
+
+	if tree, err1 := fs.GetTree(uid); err1 == nil {
+		fs.pruneOriginals(uid, tree, cutoff)
+	}
+	if gcScanned != nil {
+		gcScanned(uid)
+	}
+
+	archive := ""
+	if fs.Cfg.GCMode == config.GCModeArchive {
+		archive = path.Join(fs.getUserPath(uid), gcArchiveDir, now.UTC().Format("20060102T150405"))
+	}
+	defer fs.usageChanged(uid)
+	// the lock is released between the batches, the devices can upload meanwhile
+	for start := 0; start < len(candidates); start += gcBatchSize {
+		end := start + gcBatchSize
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+		var synced bool
+		synced, err = fs.collectBatch(uid, generation, candidates[start:end], cutoff, archive, &stats)
+		if err != nil {
+			return
+		}
+		if synced && start == 0 {
+			log.Info("[gc] ", uid, " synced during the scan, skipped")
+			return stats, storage.ErrorSyncInProgress
+		}
+		if synced {
+			log.Info("[gc] ", uid, " synced, the rest is left for the next run")
+			break
+		}
+		if progress != nil && end < len(candidates) {
+			progress(stats)
+		}
+	}
+	if stats.Archive != "" {
+		log.Infof("[gc] %s: scanned %d, archived %d blobs, %d bytes to %s", uid, stats.Scanned, stats.Reclaimed, stats.BytesFreed, archive)
+	} else {
+		log.Infof("[gc] %s: scanned %d, removed %d blobs, %d bytes", uid, stats.Scanned, stats.Reclaimed, stats.BytesFreed)
+	}
+	if progress != nil {
+		progress(stats)
+	}
+	return
+}
+
+// collectBatch removes the batch of candidates with the lock held, nothing when a root was written since the scan
+func (fs *FileSystemStorage) collectBatch(uid string, generation int64, batch []os.FileInfo, cutoff time.Time, archive string, stats *storage.GCStats) (synced bool, err error) {
+	blobPath := fs.getUserBlobPath(uid)
+	// no root can be written while the blobs are removed, and none was since they were found unreachable
+	lock := fslock.New(path.Join(blobPath, historyFile))
+	err = lock.LockWithTimeout(time.Duration(time.Second * 5))
+	if err != nil {
+		return false, fmt.Errorf("[gc] cannot obtain lock: %w", err)
+	}
+	defer lock.Unlock()
+	if fs.currentGeneration(uid) != generation {
+		return true, nil
+	}
+
+	if archive != "" {
+		if err = os.MkdirAll(archive, 0700); err != nil {
+			return
+		}
+		stats.Archive = archive
+	}
+	for _, f := range batch {
+		name := f.Name()
+		// referenced again since the scan, StoreBlob touches them with the lock held
+		if fi, err1 := os.Stat(path.Join(blobPath, name)); err1 != nil || !fi.ModTime().Before(cutoff) {
+			log.Debug("[gc] referenced again, kept ", name)
+			continue
+		}
+		if archive != "" {
+			err = os.Rename(path.Join(blobPath, name), path.Join(archive, name))
+		} else {
+			err = fs.removeBlob(uid, name)
+		}
+		if err != nil {
+			return
+		}
+		os.Remove(fs.blobHashPath(uid, name))
+		log.Debug("[gc] collected ", name)
+		stats.Reclaimed++
+		stats.BytesFreed += f.Size()
+	}
+	return
+}
@@ -0,0 +1,243 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	testuser := "test"
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewStorage(&config.Config{DataDir: dir, GCGracePeriod: 24 * time.Hour})
+	blobPath := fs.getUserBlobPath(testuser)
+	err = os.MkdirAll(blobPath, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, content := range map[string]string{"index": "3\n", "old": "unreachable", "young": "new"} {
+		if _, err = fs.StoreBlob(testuser, id, strings.NewReader(content), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = fs.StoreBlob(testuser, rootFile, strings.NewReader("index"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = fs.GarbageCollect(testuser, false, nil); err != storage.ErrorSyncInProgress {
+		t.Fatalf("just synced, got %v", err)
+	}
+
+	age := func(name string, d time.Duration) {
+		then := time.Now().Add(-d)
+		if err := os.Chtimes(path.Join(blobPath, name), then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"index", "old", rootFile, historyFile} {
+		age(name, 48*time.Hour)
+	}
+	age("young", time.Hour)
+
+	stats, err := fs.GarbageCollect(testuser, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Scanned != 3 || stats.Reclaimed != 1 || len(stats.Blobs) != 1 || stats.Blobs[0] != "old" {
+		t.Fatalf("dry run: %+v", stats)
+	}
+	if _, err = os.Stat(path.Join(blobPath, "old")); err != nil {
+		t.Fatal("the dry run removed the blob")
+	}
+
+	stats, err = fs.GarbageCollect(testuser, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Reclaimed != 1 || stats.BytesFreed != int64(len("unreachable")) {
+		t.Errorf("gc: %+v", stats)
+	}
+	for name, kept := range map[string]bool{"old": false, "young": true, "index": true} {
+		if _, err = os.Stat(path.Join(blobPath, name)); (err == nil) != kept {
+			t.Errorf("%s kept %v: %v", name, kept, err)
+		}
+	}
+}
+
+func TestGarbageCollectReferencedAgain(t *testing.T) {
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: t.TempDir(), GCGracePeriod: 24 * time.Hour})
+	blobPath := fs.getUserBlobPath(testuser)
+	if err := os.MkdirAll(blobPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	// sha256 of "blah"
+	const blobID = "8b7df143d91c716ecfa5fc1730022f6b421b05cedee8fd52b1fc65a96030ad52"
+	if _, err := fs.StoreBlob(testuser, blobID, strings.NewReader("blah"), -1); err != nil {
+		t.Fatal(err)
+	}
+	then := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{blobID, historyFile} {
+		if err := os.Chtimes(path.Join(blobPath, name), then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a device uploads the blob again while the gc runs, before its new root
+	gcScanned = func(uid string) {
+		if _, err := fs.StoreBlob(uid, blobID, strings.NewReader("blah"), -1); err != nil {
+			t.Error(err)
+		}
+	}
+	defer func() { gcScanned = nil }()
+	stats, err := fs.GarbageCollect(testuser, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Reclaimed != 0 {
+		t.Errorf("gc: %+v", stats)
+	}
+	if _, err = os.Stat(path.Join(blobPath, blobID)); err != nil {
+		t.Error("the blob referenced again was collected")
+	}
+}
+
+func TestGarbageCollectBatches(t *testing.T) {
+	testuser := "test"
+	// the generation counts the history lines of 64 char hashes
+	index := strings.Repeat("a", 64)
+	fs := NewStorage(&config.Config{DataDir: t.TempDir(), GCGracePeriod: 24 * time.Hour})
+	blobPath := fs.getUserBlobPath(testuser)
+	if err := os.MkdirAll(blobPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= gcBatchSize; i++ {
+		if _, err := fs.StoreBlob(testuser, fmt.Sprintf("blob%03d", i), strings.NewReader("unreachable"), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fs.StoreBlob(testuser, rootFile, strings.NewReader(index), 0); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(blobPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	then := time.Now().Add(-48 * time.Hour)
+	for _, f := range files {
+		if err = os.Chtimes(path.Join(blobPath, f.Name()), then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = fs.StoreBlob(testuser, index, strings.NewReader("3\n"), -1); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(path.Join(blobPath, index), then, then); err != nil {
+		t.Fatal(err)
+	}
+
+	// a device syncs between the batches, it doesn't wait for the gc to finish
+	stats, err := fs.GarbageCollect(testuser, false, func(storage.GCStats) {
+		if _, err := fs.StoreBlob(testuser, rootFile, strings.NewReader(index), 0); err != nil {
+			t.Error("the root waited for the gc: ", err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rest is left for the next run, the new root could reference it
+	if stats.Reclaimed != gcBatchSize {
+		t.Errorf("gc: %+v", stats)
+	}
+}
+
+func TestGarbageCollectRetention(t *testing.T) {
+	testuser := "test"
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewStorage(&config.Config{DataDir: dir, GCGracePeriod: 24 * time.Hour, GCMode: config.GCModeArchive})
+	blobPath := fs.getUserBlobPath(testuser)
+	if err = os.MkdirAll(blobPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	blobs := map[string]string{
+		"root1": "3\nd1:80000000:doc1:1:10\n",
+		"d1":    "3\nf1:0:doc1.content:0:10\n",
+		"f1":    "0123456789",
+		"root2": "3\n",
+	}
+	for id, content := range blobs {
+		if _, err = fs.StoreBlob(testuser, id, strings.NewReader(content), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, root := range []string{"root1", "root2"} {
+		if _, err = fs.StoreBlob(testuser, rootFile, strings.NewReader(root), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	age := func(d time.Duration) {
+		then := time.Now().Add(-d)
+		for _, name := range []string{"root1", "d1", "f1", "root2", rootFile, historyFile} {
+			if err := os.Chtimes(path.Join(blobPath, name), then, then); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	age(48 * time.Hour)
+
+	// root1 was written within the grace period, a device can still be on it
+	stats, err := fs.GarbageCollect(testuser, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Reclaimed != 0 {
+		t.Fatalf("collected the tree of a recent root: %+v", stats)
+	}
+
+	history, err := ioutil.ReadFile(path.Join(blobPath, historyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	lines := strings.Split(strings.TrimSpace(string(history)), "\n")
+	for i, line := range lines {
+		lines[i] = old + line[strings.Index(line, " "):]
+	}
+	if err = ioutil.WriteFile(path.Join(blobPath, historyFile), []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	age(48 * time.Hour)
+
+	stats, err = fs.GarbageCollect(testuser, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Reclaimed != 2 || stats.Archive == "" {
+		t.Fatalf("gc: %+v", stats)
+	}
+	for name, kept := range map[string]bool{"root1": true, "root2": true, "d1": false, "f1": false} {
+		if _, err = os.Stat(path.Join(blobPath, name)); (err == nil) != kept {
+			t.Errorf("%s kept %v: %v", name, kept, err)
+		}
+	}
+	if content, err := ioutil.ReadFile(path.Join(stats.Archive, "f1")); err != nil || string(content) != blobs["f1"] {
+		t.Errorf("archived %s %v", content, err)
+	}
+}
@@ -0,0 +1,70 @@
+package fs
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// gcsError the xml error body of google cloud storage, which serves the signed blob urls
+// of the official cloud. Some clients parse it and keep retrying without it
+type gcsError struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+	Details string   `xml:"Details,omitempty"`
+}
+
+var (
+	errorAccessDenied = gcsError{
+		Code:    "AccessDenied",
+		Message: "Access denied.",
+	}
+	errorNoSuchKey = gcsError{
+		Code:    "NoSuchKey",
+		Message: "The specified key does not exist.",
+	}
+	errorConditionNotMet = gcsError{
+		Code:    "ConditionNotMet",
+		Message: "At least one of the pre-conditions you specified did not hold.",
+		Details: "Precondition Failed",
+	}
+	errorInvalidArgument = gcsError{
+		Code:    "InvalidArgument",
+		Message: "Invalid argument.",
+	}
+	errorQuotaExceeded = gcsError{
+		Code:    "EntityTooLarge",
+		Message: "Your proposed upload exceeds the storage quota.",
+	}
+	errorBadDigest = gcsError{
+		Code:    "BadDigest",
+		Message: "The hash of the uploaded data doesn't match the x-goog-hash header.",
+	}
+	errorUploadInProgress = gcsError{
+		Code:    "Conflict",
+		Message: "Another chunk of this upload is being received.",
+	}
+	errorInternal = gcsError{
+		Code:    "InternalError",
+		Message: "We encountered an internal error. Please try again.",
+	}
+)
+
+// abortWithGCSError aborts the request with the error body the storage would send
+func abortWithGCSError(c *gin.Context, status int, e gcsError) {
+	body, err := xml.Marshal(e)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(status)
+		return
+	}
+	c.Abort()
+	c.Data(status, "application/xml; charset=UTF-8", append([]byte(xml.Header), body...))
+}
+
+func abortAccessDenied(c *gin.Context) {
+	abortWithGCSError(c, http.StatusForbidden, errorAccessDenied)
+}
@@ -0,0 +1,17 @@
+package fs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+)
+
+// CheckHealth checks the data dir is writable
+func (fs *FileSystemStorage) CheckHealth(ctx context.Context) error {
+	f, err := ioutil.TempFile(fs.getUserPath(""), ".health")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,96 @@
+package fs
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// RegisterIngestProcessor adds a processor to the upload pipeline
+// unless configured otherwise, processors run in the order they were registered
+func (fs *FileSystemStorage) RegisterIngestProcessor(p storage.IngestProcessor) {
+	fs.ingestProcessors = append(fs.ingestProcessors, p)
+}
+
+// enabledProcessors the registered processors in the configured order
+func (fs *FileSystemStorage) enabledProcessors() []storage.IngestProcessor {
+	if fs.Cfg.IngestProcessors == nil {
+		return fs.ingestProcessors
+	}
+
+	registered := make(map[string]storage.IngestProcessor)
+	for _, p := range fs.ingestProcessors {
+		registered[p.Name()] = p
+	}
+	result := make([]storage.IngestProcessor, 0, len(fs.Cfg.IngestProcessors))
+	for _, name := range fs.Cfg.IngestProcessors {
+		p, ok := registered[name]
+		if !ok {
+			log.Warn("unknown ingest processor: ", name)
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// ingest runs the uploaded document through the processors
+func (fs *FileSystemStorage) ingest(doc *storage.IngestDocument) error {
+	if doc.Action == "" {
+		doc.Action = storage.ActionCreated
+	}
+	for _, p := range fs.enabledProcessors() {
+		err := p.Process(doc)
+		if err != nil {
+			log.Warnf("ingest processor %s: %v", p.Name(), err)
+			return err
+		}
+		if doc.Action == storage.ActionSkipped {
+			log.Infof("ingest processor %s skipped %s", p.Name(), doc.Name)
+			return nil
+		}
+	}
+	return nil
+}
+
+// spoolContent the content of the document in a temp file, for the processors which read it
+// more than once. The temp file becomes the content, it is removed once the document is stored
+func (fs *FileSystemStorage) spoolContent(doc *storage.IngestDocument) (*spooledFile, int64, error) {
+	if spooled, ok := doc.Content.(*spooledFile); ok {
+		size, err := spooled.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = spooled.Seek(0, io.SeekStart)
+		}
+		return spooled, size, err
+	}
+	tmp, err := ioutil.TempFile(fs.getUserPath(doc.UserID), ".tmp")
+	if err != nil {
+		return nil, 0, err
+	}
+	spooled := &spooledFile{tmp}
+	size, err := io.Copy(tmp, doc.Content)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spooled.Close()
+		return nil, 0, err
+	}
+	doc.Content = spooled
+	return spooled, size, nil
+}
+
+// setContent replaces the content of the document, the temp file it was spooled to is removed
+func setContent(doc *storage.IngestDocument, content io.Reader) {
+	releaseContent(doc)
+	doc.Content = content
+}
+
+// releaseContent removes the temp file the content was spooled to
+func releaseContent(doc *storage.IngestDocument) {
+	if spooled, ok := doc.Content.(*spooledFile); ok {
+		spooled.Close()
+	}
+}
@@ -0,0 +1,67 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+type fakeProcessor struct {
+	name   string
+	action string
+	err    error
+	calls  *[]string
+}
+
+func (p *fakeProcessor) Name() string {
+	return p.name
+}
+
+func (p *fakeProcessor) Process(doc *storage.IngestDocument) error {
+	*p.calls = append(*p.calls, p.name)
+	if p.action != "" {
+		doc.Action = p.action
+	}
+	return p.err
+}
+
+func TestIngest(t *testing.T) {
+	rejected := fmt.Errorf("%w: no", storage.ErrorRejected)
+	tests := []struct {
+		name       string
+		order      []string
+		wantCalls  string
+		wantAction string
+		wantErr    error
+	}{
+		{"registration order", nil, "a,skip", storage.ActionSkipped, nil},
+		{"configured order", []string{"reject", "a"}, "reject", "", rejected},
+		{"disabled", []string{"a", "unknown"}, "a", storage.ActionCreated, nil},
+		{"none", []string{}, "", storage.ActionCreated, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			fs := &FileSystemStorage{Cfg: &config.Config{IngestProcessors: tt.order}}
+			fs.RegisterIngestProcessor(&fakeProcessor{name: "a", calls: &calls})
+			fs.RegisterIngestProcessor(&fakeProcessor{name: "skip", action: storage.ActionSkipped, calls: &calls})
+			fs.RegisterIngestProcessor(&fakeProcessor{name: "reject", err: rejected, calls: &calls})
+
+			doc := &storage.IngestDocument{Name: "doc"}
+			err := fs.ingest(doc)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ingest() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && doc.Action != tt.wantAction {
+				t.Errorf("action = %s, want %s", doc.Action, tt.wantAction)
+			}
+			if got := strings.Join(calls, ","); got != tt.wantCalls {
+				t.Errorf("calls = %s, want %s", got, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// hardLinks whether the blobs can be shared by hard links, their count is read from the inode
+const hardLinks = true
+
+// linkCount the number of hard links of the file
+func linkCount(filePath string) (uint64, bool) {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return 0, false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Nlink), true
+}
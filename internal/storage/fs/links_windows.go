@@ -0,0 +1,11 @@
+//go:build windows
+
+package fs
+
+// hardLinks whether the blobs can be shared by hard links, the count isn't available here
+const hardLinks = false
+
+// linkCount the number of hard links of the file
+func linkCount(filePath string) (uint64, bool) {
+	return 0, false
+}
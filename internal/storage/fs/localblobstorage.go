@@ -6,7 +6,7 @@ import (
 	"strings"
 )
 
-// LocalBlobStorage local file system storage
+// LocalBlobStorage the blobs of the user, in the file system or the configured provider
 type LocalBlobStorage struct {
 	fs  *FileSystemStorage
 	uid string
@@ -14,7 +14,7 @@ type LocalBlobStorage struct {
 
 // GetRootIndex the hash of the root index
 func (p *LocalBlobStorage) GetRootIndex() (string, int64, error) {
-	r, gen, err := p.fs.LoadBlob(p.uid, rootFile)
+	r, gen, err := p.fs.blobProvider().LoadBlob(p.uid, rootFile)
 	if err == ErrorNotFound {
 		return "", 0, nil
 	}
@@ -32,20 +32,27 @@ func (p *LocalBlobStorage) GetRootIndex() (string, int64, error) {
 
 // WriteRootIndex writes the root index
 func (p *LocalBlobStorage) WriteRootIndex(generation int64, roothash string) (int64, error) {
+	var previous string
+	if len(p.fs.eventListeners) > 0 {
+		previous, _, _ = p.GetRootIndex()
+	}
 	r := strings.NewReader(roothash)
-	newGen, err := p.fs.StoreBlob(p.uid, rootFile, r, generation)
+	newGen, err := p.fs.blobProvider().StoreBlob(p.uid, rootFile, r, generation)
+	if err == nil {
+		p.fs.rootAdvanced(p.uid, previous, newGen)
+	}
 	return int64(newGen), err
 }
 
 // GetReader reader for a given hash
 func (p *LocalBlobStorage) GetReader(hash string) (io.ReadCloser, error) {
-	r, _, err := p.fs.LoadBlob(p.uid, hash)
+	r, _, err := p.fs.blobProvider().LoadBlob(p.uid, hash)
 	return r, err
 }
 
 // Write stores the reader in the hash
 func (p *LocalBlobStorage) Write(hash string, r io.Reader) error {
-	_, err := p.fs.StoreBlob(p.uid, hash, r, -1)
+	_, err := p.fs.blobProvider().StoreBlob(p.uid, hash, r, -1)
 
 	return err
 }
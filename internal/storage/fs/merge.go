@@ -0,0 +1,140 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// mergeRoot merges the changes of the client (theirs) into the current root (ours)
+// base is the root the client started from. Each document can be changed
+// by one side only, when both changed it differently it is a conflict
+func mergeRoot(base, ours, theirs []*models.HashEntry) ([]*models.HashEntry, error) {
+	index := func(entries []*models.HashEntry) map[string]*models.HashEntry {
+		m := make(map[string]*models.HashEntry, len(entries))
+		for _, e := range entries {
+			m[e.EntryName] = e
+		}
+		return m
+	}
+	baseDocs := index(base)
+	ourDocs := index(ours)
+	theirDocs := index(theirs)
+
+	ids := make(map[string]bool)
+	for _, docs := range []map[string]*models.HashEntry{baseDocs, ourDocs, theirDocs} {
+		for id := range docs {
+			ids[id] = true
+		}
+	}
+
+	hashOf := func(e *models.HashEntry) string {
+		if e == nil {
+			return ""
+		}
+		return e.Hash
+	}
+
+	merged := make([]*models.HashEntry, 0, len(ids))
+	for id := range ids {
+		b, o, t := baseDocs[id], ourDocs[id], theirDocs[id]
+		var keep *models.HashEntry
+		switch {
+		case hashOf(o) == hashOf(t):
+			keep = o
+		case hashOf(o) == hashOf(b):
+			keep = t
+		case hashOf(t) == hashOf(b):
+			keep = o
+		default:
+			return nil, fmt.Errorf("%w: %s changed on both sides", ErrorWrongGeneration, id)
+		}
+		if keep != nil {
+			merged = append(merged, keep)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].EntryName < merged[j].EntryName })
+	return merged, nil
+}
+
+// rootIndexEntries the entries of a root index, none for an empty hash
+func (fs *FileSystemStorage) rootIndexEntries(uid, hash string) ([]*models.HashEntry, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	entries, ok := fs.readIndex(uid, path.Join(fs.getUserBlobPath(uid), common.Sanitize(hash)))
+	if !ok {
+		return nil, fmt.Errorf("can't read root index %s", hash)
+	}
+	return entries, nil
+}
+
+// historyRoot the root hash of the generation
+func (fs *FileSystemStorage) historyRoot(uid string, generation int64) (string, error) {
+	history, err := ioutil.ReadFile(path.Join(fs.getUserBlobPath(uid), historyFile))
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSpace(string(history)), "\n")
+	if generation < 1 || generation > int64(len(lines)) {
+		return "", fmt.Errorf("generation %d not in the history", generation)
+	}
+	fields := strings.Fields(lines[generation-1])
+	if len(fields) != 2 {
+		return "", fmt.Errorf("can't parse history line %d", generation)
+	}
+	return fields[1], nil
+}
+
+// mergeRootIndex stores the merge of the client's root index into the current root and returns its hash
+// the caller has to hold the history lock
+func (fs *FileSystemStorage) mergeRootIndex(uid string, baseGeneration int64, theirHash string) (string, error) {
+	blobPath := fs.getUserBlobPath(uid)
+	baseHash, err := fs.historyRoot(uid, baseGeneration)
+	if err != nil {
+		return "", err
+	}
+	current, err := ioutil.ReadFile(path.Join(blobPath, rootFile))
+	if err != nil {
+		return "", err
+	}
+
+	base, err := fs.rootIndexEntries(uid, baseHash)
+	if err != nil {
+		return "", err
+	}
+	ours, err := fs.rootIndexEntries(uid, strings.TrimSpace(string(current)))
+	if err != nil {
+		return "", err
+	}
+	theirs, err := fs.rootIndexEntries(uid, strings.TrimSpace(theirHash))
+	if err != nil {
+		return "", err
+	}
+	merged, err := mergeRoot(base, ours, theirs)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := models.HashEntries(merged)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString("3\n")
+	for _, e := range merged {
+		fmt.Fprintf(&sb, "%s:%s:%s:%d:%d\n", e.Hash, e.Type, e.EntryName, e.Subfiles, e.Size)
+	}
+	err = fs.saveTo(uid, strings.NewReader(sb.String()), hash, blobPath)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("merged root of generation %d with the current root: %s", baseGeneration, hash)
+	return hash, nil
+}
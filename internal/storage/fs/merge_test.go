@@ -0,0 +1,155 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+// entries from "id:hash" pairs
+func rootEntries(docs ...string) []*models.HashEntry {
+	entries := make([]*models.HashEntry, 0, len(docs))
+	for _, d := range docs {
+		parts := strings.Split(d, ":")
+		entries = append(entries, &models.HashEntry{
+			EntryName: parts[0],
+			Hash:      parts[1],
+			Type:      "80000000",
+			Subfiles:  2,
+		})
+	}
+	return entries
+}
+
+func entriesString(entries []*models.HashEntry) string {
+	docs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		docs = append(docs, e.EntryName+":"+e.Hash)
+	}
+	return strings.Join(docs, " ")
+}
+
+func TestMergeRoot(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     []string
+		ours     []string
+		theirs   []string
+		want     string
+		conflict bool
+	}{
+		{"both added", []string{"a:1"}, []string{"a:1", "b:1"}, []string{"a:1", "c:1"}, "a:1 b:1 c:1", false},
+		{"same change", []string{"a:1"}, []string{"a:2"}, []string{"a:2"}, "a:2", false},
+		{"different docs changed", []string{"a:1", "b:1"}, []string{"a:2", "b:1"}, []string{"a:1", "b:2"}, "a:2 b:2", false},
+		{"they deleted", []string{"a:1", "b:1"}, []string{"a:1", "b:1", "c:1"}, []string{"a:1"}, "a:1 c:1", false},
+		{"both changed", []string{"a:1"}, []string{"a:2"}, []string{"a:3"}, "", true},
+		{"changed and deleted", []string{"a:1"}, []string{"a:2"}, []string{}, "", true},
+		{"both added the same id", []string{}, []string{"a:1"}, []string{"a:2"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeRoot(rootEntries(tt.base...), rootEntries(tt.ours...), rootEntries(tt.theirs...))
+			if tt.conflict {
+				if !errors.Is(err, ErrorWrongGeneration) {
+					t.Errorf("expected a conflict, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entriesString(merged); got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreBlobMerge(t *testing.T) {
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: t.TempDir(), RootConflictPolicy: config.RootConflictMerge})
+	blobPath := fs.getUserBlobPath(testuser)
+	err := os.MkdirAll(blobPath, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a hash for each version of a document
+	docHash := func(d string) string {
+		h := sha256.Sum256([]byte(d))
+		return hex.EncodeToString(h[:])
+	}
+	writeRoot := func(docs ...string) string {
+		for i, d := range docs {
+			docs[i] = strings.Split(d, ":")[0] + ":" + docHash(d)
+		}
+		entries := rootEntries(docs...)
+		hash, err := models.HashEntries(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sb strings.Builder
+		sb.WriteString("3\n")
+		for _, e := range entries {
+			fmt.Fprintf(&sb, "%s:%s:%s:%d:%d\n", e.Hash, e.Type, e.EntryName, e.Subfiles, e.Size)
+		}
+		err = ioutil.WriteFile(path.Join(blobPath, hash), []byte(sb.String()), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	base := writeRoot("a:1")
+	ours := writeRoot("a:1", "b:1")
+	theirs := writeRoot("a:1", "c:1")
+	changed := writeRoot("a:2", "b:1", "c:1")
+	conflicting := writeRoot("a:3", "b:1", "c:1")
+
+	for gen, root := range []string{base, ours} {
+		_, err = fs.StoreBlob(testuser, rootFile, strings.NewReader(root), int64(gen))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// based on generation 1, but the current one is 2
+	gen, err := fs.StoreBlob(testuser, rootFile, strings.NewReader(theirs), 1)
+	if err != nil || gen != 3 {
+		t.Fatalf("merge: gen %d %v", gen, err)
+	}
+	root, err := ioutil.ReadFile(path.Join(blobPath, rootFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := fs.rootIndexEntries(testuser, string(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entriesString(merged); got != "a:"+docHash("a:1")+" b:"+docHash("b:1")+" c:"+docHash("c:1") {
+		t.Errorf("unexpected merged root: %s", got)
+	}
+	history, err := fs.historyRoot(testuser, 3)
+	if err != nil || history != string(root) {
+		t.Errorf("history does not have the merged root: %s %v", history, err)
+	}
+
+	// both change a
+	_, err = fs.StoreBlob(testuser, rootFile, strings.NewReader(changed), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err = fs.StoreBlob(testuser, rootFile, strings.NewReader(conflicting), 3)
+	if err != ErrorWrongGeneration || gen != 4 {
+		t.Errorf("expected wrong generation, got %d %v", gen, err)
+	}
+}
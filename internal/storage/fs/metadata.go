@@ -6,8 +6,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/models"
 	log "github.com/sirupsen/logrus"
 )
@@ -62,13 +64,23 @@ func (fs *FileSystemStorage) GetMetadata(uid, id string) (*messages.RawMetadata,
 
 // UpdateMetadata updates the metadata of a document
 func (fs *FileSystemStorage) UpdateMetadata(uid string, r *messages.RawMetadata) error {
+	defer fs.usageChanged(uid)
 	filepath := fs.getPathFromUser(uid, r.ID+models.MetadataFileExt)
+	before := make(map[string]string)
+	if old, err := fs.GetMetadata(uid, r.ID); err == nil {
+		before[r.ID] = old.Parent
+	}
 
 	js, err := json.Marshal(r)
 	if err != nil {
 		return err
 	}
 	err = ioutil.WriteFile(filepath, js, 0600)
-	return err
+	if err != nil {
+		return err
+	}
+	fs.recordTrash(uid, before, map[string]string{r.ID: r.Parent}, time.Now().UTC())
+	fs.documentEvent(storage.EventDocumentUploaded, uid, r)
+	return nil
 
 }
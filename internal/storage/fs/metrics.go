@@ -0,0 +1,143 @@
+package fs
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	opBlobUpload       = "blob_upload"
+	opBlobDownload     = "blob_download"
+	opDocumentUpload   = "document_upload"
+	opDocumentDownload = "document_download"
+
+	// conflictWindow the conflicts gauge counts the ones in this window
+	conflictWindow = time.Minute
+)
+
+// storageMetrics of the storage handlers
+type storageMetrics struct {
+	requests  *metrics.CounterVec
+	bytes     *metrics.HistogramVec
+	duration  *metrics.HistogramVec
+	conflicts *metrics.CounterVec
+	errors    *metrics.CounterVec
+	// backend where the blobs and documents are, the label of the errors
+	backend string
+
+	lock sync.Mutex
+	// recent when the generation conflicts of the last window happened
+	recent []time.Time
+}
+
+// ExportMetrics registers the metrics of the storage handlers, they aren't collected otherwise
+func (app *App) ExportMetrics(registry *metrics.Registry) {
+	m := &storageMetrics{
+		requests: registry.NewCounterVec("rmfakecloud_storage_requests_total",
+			"Blob and document uploads and downloads by result.", "op", "result"),
+		bytes: registry.NewHistogramVec("rmfakecloud_storage_transferred_bytes",
+			"Bytes transferred per upload or download.", metrics.ExponentialBuckets(1024, 4, 10), "op"),
+		duration: registry.NewHistogramVec("rmfakecloud_storage_request_duration_seconds",
+			"Latency of the storage handlers.", metrics.ExponentialBuckets(0.005, 2, 12), "op"),
+		conflicts: registry.NewCounterVec("rmfakecloud_storage_generation_conflicts_total",
+			"Uploads rejected with 412 because the root generation didn't match.", "op"),
+		errors: registry.NewCounterVec("rmfakecloud_storage_errors_total",
+			"Uploads and downloads which failed in the storage backend.", "backend", "op"),
+		backend: app.cfg.StorageProvider,
+	}
+	if m.backend == "" {
+		m.backend = config.StorageProviderFS
+	}
+	registry.NewGaugeFunc("rmfakecloud_storage_generation_conflicts",
+		"Root uploads rejected for a wrong generation in the last minute.", m.recentConflicts)
+	app.metrics = m
+}
+
+// result the label of the response status
+func result(status int) string {
+	switch {
+	case status < http.StatusBadRequest:
+		return "ok"
+	case status == http.StatusNotFound:
+		return "notfound"
+	case status == http.StatusPreconditionFailed:
+		return "wrong_generation"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "forbidden"
+	}
+	return "error"
+}
+
+// countingReader counts the bytes read
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// measure records the result, size and latency of the handlers after it
+func (app *App) measure(op string, upload bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m := app.metrics
+		if m == nil {
+			return
+		}
+		start := time.Now()
+		var received *countingReader
+		if upload {
+			received = &countingReader{ReadCloser: c.Request.Body}
+			c.Request.Body = received
+		}
+		c.Next()
+
+		status := c.Writer.Status()
+		m.requests.Inc(op, result(status))
+		m.duration.Observe(time.Since(start).Seconds(), op)
+		if upload {
+			m.bytes.Observe(float64(received.n), op)
+		} else if sent := c.Writer.Size(); sent > 0 {
+			m.bytes.Observe(float64(sent), op)
+		}
+		switch {
+		case status == http.StatusPreconditionFailed:
+			m.conflicts.Inc(op)
+			m.conflict(time.Now())
+		case status >= http.StatusInternalServerError:
+			m.errors.Inc(m.backend, op)
+		}
+	}
+}
+
+func (m *storageMetrics) conflict(now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.recent = append(m.pruneConflicts(now), now)
+}
+
+// pruneConflicts drops the conflicts older than the window, with the lock held
+func (m *storageMetrics) pruneConflicts(now time.Time) []time.Time {
+	cutoff := now.Add(-conflictWindow)
+	i := 0
+	for i < len(m.recent) && !m.recent[i].After(cutoff) {
+		i++
+	}
+	m.recent = m.recent[i:]
+	return m.recent
+}
+
+func (m *storageMetrics) recentConflicts() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return float64(len(m.pruneConflicts(time.Now())))
+}
@@ -0,0 +1,64 @@
+package fs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+func TestStorageMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{DataDir: dir, JWTSecretKey: []byte("secret")}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	fsStorage := NewStorage(cfg)
+	app := NewApp(cfg, fsStorage, fsStorage)
+	registry := metrics.NewRegistry()
+	app.ExportMetrics(registry)
+	app.RegisterRoutes(router)
+	if err = os.MkdirAll(fsStorage.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(req *http.Request) {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send(httptest.NewRequest(http.MethodPut, signedBlobURL(t, cfg, "test", "blob", "write"), strings.NewReader("0123456789")))
+	send(httptest.NewRequest(http.MethodGet, signedBlobURL(t, cfg, "test", "blob", "read"), nil))
+	send(httptest.NewRequest(http.MethodGet, signedBlobURL(t, cfg, "test", "missing", "read"), nil))
+	send(httptest.NewRequest(http.MethodGet, signedBlobURL(t, cfg, "test", "blob", "write"), nil))
+	stale := httptest.NewRequest(http.MethodPut, signedBlobURL(t, cfg, "test", rootFile, "write"), strings.NewReader("blob"))
+	stale.Header.Set(generationMatchHeader, "5")
+	send(stale)
+
+	var out bytes.Buffer
+	registry.Write(&out)
+	for _, line := range []string{
+		`rmfakecloud_storage_requests_total{op="blob_upload",result="ok"} 1`,
+		`rmfakecloud_storage_requests_total{op="blob_download",result="ok"} 1`,
+		`rmfakecloud_storage_requests_total{op="blob_download",result="notfound"} 1`,
+		`rmfakecloud_storage_requests_total{op="blob_download",result="forbidden"} 1`,
+		`rmfakecloud_storage_requests_total{op="blob_upload",result="wrong_generation"} 1`,
+		`rmfakecloud_storage_transferred_bytes_sum{op="blob_upload"} 14`,
+		`rmfakecloud_storage_request_duration_seconds_count{op="blob_download"} 3`,
+		"rmfakecloud_storage_generation_conflicts 1",
+		`rmfakecloud_storage_generation_conflicts_total{op="blob_upload"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %s in\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package fs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// placedDoc where a document is, for checking a move
+type placedDoc struct {
+	name     string
+	parent   string
+	isFolder bool
+}
+
+// checkMove the parent is a folder outside the document, and with the reject policy the name is free there
+func checkMove(docs map[string]placedDoc, docid, name, parent, policy string) error {
+	if _, ok := docs[docid]; !ok {
+		return ErrorNotFound
+	}
+	if parent != "" {
+		folder, ok := docs[parent]
+		if !ok || !folder.isFolder {
+			return fmt.Errorf("%w: no folder %s", storage.ErrorInvalidMove, parent)
+		}
+		seen := make(map[string]bool)
+		for p := parent; p != "" && !seen[p]; p = docs[p].parent {
+			if p == docid {
+				return fmt.Errorf("%w: %s would be inside itself", storage.ErrorInvalidMove, name)
+			}
+			seen[p] = true
+		}
+	}
+	if policy != config.NameCollisionReject {
+		return nil
+	}
+	for id, d := range docs {
+		if id != docid && d.parent == parent && d.name == name {
+			return fmt.Errorf("%w: %s", storage.ErrorNameTaken, name)
+		}
+	}
+	return nil
+}
+
+// MoveDocument renames a sync10 document or moves it to another folder, an empty parent is the root
+func (fs *FileSystemStorage) MoveDocument(uid, docid, name, parent string) (*storage.Document, error) {
+	metadata, err := fs.GetAllMetadata(uid)
+	if err != nil {
+		return nil, err
+	}
+	docs := make(map[string]placedDoc, len(metadata))
+	for _, m := range metadata {
+		docs[m.ID] = placedDoc{
+			name:     m.VissibleName,
+			parent:   m.Parent,
+			isFolder: m.Type == models.CollectionType,
+		}
+	}
+	err = checkMove(docs, docid, name, parent, fs.Cfg.NameCollisionPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := fs.GetMetadata(uid, docid)
+	if err != nil {
+		return nil, err
+	}
+	meta.VissibleName = name
+	meta.Parent = parent
+	meta.Version++
+	meta.ModifiedClient = time.Now().UTC().Format(time.RFC3339Nano)
+	err = fs.UpdateMetadata(uid, meta)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("moved %s to '%s' as %s", docid, parent, name)
+	return &storage.Document{
+		ID:      docid,
+		Type:    meta.Type,
+		Name:    name,
+		Parent:  parent,
+		Version: meta.Version,
+	}, nil
+}
+
+// MoveBlobDocument renames a sync15 document or moves it to another folder, as a new version of it
+func (fs *FileSystemStorage) MoveBlobDocument(uid, docid, name, parent string) (*storage.Document, error) {
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	docs := make(map[string]placedDoc, len(tree.Docs))
+	for _, d := range tree.Docs {
+		if d.Deleted {
+			continue
+		}
+		docs[d.EntryName] = placedDoc{
+			name:     d.DocumentName,
+			parent:   d.Parent,
+			isFolder: d.CollectionType == models.CollectionType,
+		}
+	}
+	err = checkMove(docs, docid, name, parent, fs.Cfg.NameCollisionPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := tree.FindDoc(docid)
+	if err != nil {
+		return nil, ErrorNotFound
+	}
+	doc.DocumentName = name
+	doc.Parent = parent
+	err = fs.saveBlobDocument(uid, doc)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.Rehash()
+	if err != nil {
+		return nil, err
+	}
+	err = fs.commitTree(uid, tree)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("moved %s to '%s' as %s", docid, parent, name)
+	return &storage.Document{
+		ID:      docid,
+		Type:    doc.CollectionType,
+		Name:    name,
+		Parent:  parent,
+		Version: doc.Version,
+	}, nil
+}
@@ -0,0 +1,125 @@
+package fs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+func TestCheckMove(t *testing.T) {
+	docs := map[string]placedDoc{
+		"top":    {name: "top", isFolder: true},
+		"folder": {name: "folder", parent: "top", isFolder: true},
+		"sub":    {name: "sub", parent: "folder", isFolder: true},
+		"notes":  {name: "notes", parent: "folder"},
+		"other":  {name: "other", parent: "top"},
+	}
+
+	tests := []struct {
+		name    string
+		docid   string
+		newName string
+		parent  string
+		policy  string
+		wantErr error
+	}{
+		{"rename", "notes", "renamed", "folder", config.NameCollisionReject, nil},
+		{"same name", "notes", "notes", "folder", config.NameCollisionReject, nil},
+		{"to root", "folder", "folder", "", config.NameCollisionReject, nil},
+		{"taken", "other", "notes", "folder", config.NameCollisionReject, storage.ErrorNameTaken},
+		{"taken allowed", "other", "notes", "folder", config.NameCollisionAllow, nil},
+		{"into a document", "other", "other", "notes", config.NameCollisionAllow, storage.ErrorInvalidMove},
+		{"missing folder", "other", "other", "missing", config.NameCollisionAllow, storage.ErrorInvalidMove},
+		{"into itself", "folder", "folder", "folder", config.NameCollisionAllow, storage.ErrorInvalidMove},
+		{"into a subfolder", "top", "top", "sub", config.NameCollisionAllow, storage.ErrorInvalidMove},
+		{"missing document", "missing", "missing", "", config.NameCollisionAllow, ErrorNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMove(docs, tt.docid, tt.newName, tt.parent, tt.policy)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Errorf("checkMove() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMoveDocument(t *testing.T) {
+	dir, err := ioutil.TempDir("", "move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testuser := "test"
+	fs := NewStorage(&config.Config{
+		DataDir:             dir,
+		NameCollisionPolicy: config.NameCollisionReject,
+		DefaultFolders:      []string{"Inbox"},
+	})
+	err = os.MkdirAll(fs.getUserBlobPath(testuser), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.createDefaultFolders(testuser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sync10
+	metadata, _ := fs.GetAllMetadata(testuser)
+	inbox := metadata[0].ID
+	first, err := fs.CreateDocument(testuser, "first.pdf", "", strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.CreateDocument(testuser, "second.pdf", inbox, strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.CreateDocument(testuser, "second.pdf", inbox, strings.NewReader("pdf"))
+	if !errors.Is(err, storage.ErrorNameTaken) {
+		t.Errorf("expected the upload to be rejected, got %v", err)
+	}
+	_, err = fs.MoveDocument(testuser, first.ID, "second", inbox)
+	if !errors.Is(err, storage.ErrorNameTaken) {
+		t.Errorf("expected the move to be rejected, got %v", err)
+	}
+	moved, err := fs.MoveDocument(testuser, first.ID, "renamed", inbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := fs.GetMetadata(testuser, first.ID)
+	if meta.Parent != inbox || meta.VissibleName != "renamed" || meta.Version != moved.Version || moved.Version < 2 {
+		t.Errorf("not moved: %+v", meta)
+	}
+
+	// sync15
+	tree, _ := fs.GetTree(testuser)
+	inbox = tree.Docs[0].EntryName
+	doc, err := fs.CreateBlobDocument(testuser, "blob.pdf", "", strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.MoveBlobDocument(testuser, doc.ID, "Inbox", "")
+	if !errors.Is(err, storage.ErrorNameTaken) {
+		t.Errorf("expected the move to be rejected, got %v", err)
+	}
+	_, err = fs.MoveBlobDocument(testuser, doc.ID, "blob", inbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, _ = fs.GetTree(testuser)
+	d, err := tree.FindDoc(doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Parent != inbox || d.DocumentName != "blob" {
+		t.Errorf("not moved: %+v", d.MetadataFile)
+	}
+}
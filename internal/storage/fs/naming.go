@@ -0,0 +1,102 @@
+package fs
+
+import (
+	"fmt"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+const namingProcessorName = "naming"
+
+// namingProcessor applies the name collision policy
+type namingProcessor struct {
+	policy string
+}
+
+func (p *namingProcessor) Name() string {
+	return namingProcessorName
+}
+
+func (p *namingProcessor) Process(doc *storage.IngestDocument) error {
+	if doc.Siblings == nil {
+		return nil
+	}
+	taken, err := doc.Siblings()
+	if err != nil {
+		return err
+	}
+	if p.policy == config.NameCollisionReject && taken[doc.Name] {
+		return fmt.Errorf("%w: %s", storage.ErrorNameTaken, doc.Name)
+	}
+	doc.Name, doc.Action = resolveName(p.policy, doc.Name, taken)
+	return nil
+}
+
+// resolveName applies the name collision policy to a new document
+// taken are the names already in the target folder
+func resolveName(policy, name string, taken map[string]bool) (string, string) {
+	if !taken[name] {
+		return name, storage.ActionCreated
+	}
+
+	switch policy {
+	case config.NameCollisionSuffix:
+		for i := 2; ; i++ {
+			candidate := fmt.Sprintf("%s (%d)", name, i)
+			if !taken[candidate] {
+				return candidate, storage.ActionRenamed
+			}
+		}
+	case config.NameCollisionSkip:
+		return name, storage.ActionSkipped
+	}
+	return name, storage.ActionDuplicate
+}
+
+// treeNames the names of the documents in a folder of the tree
+func treeNames(tree *models.HashTree, parent string) map[string]bool {
+	names := make(map[string]bool)
+	for _, d := range tree.Docs {
+		if d.Parent == parent && !d.Deleted {
+			names[d.DocumentName] = true
+		}
+	}
+	return names
+}
+
+// metadataNames the names of the documents in a folder, sync10 has no deleted flag:
+// the documents in the trash are the deleted ones and don't take a name
+func (fs *FileSystemStorage) metadataNames(uid, parent string) (map[string]bool, error) {
+	names := make(map[string]bool)
+	docs, err := fs.GetAllMetadata(uid)
+	if err != nil {
+		return nil, err
+	}
+	parents := make(map[string]string, len(docs))
+	for _, d := range docs {
+		parents[d.ID] = d.Parent
+	}
+	if inTrash(parents, parent) {
+		return names, nil
+	}
+	for _, d := range docs {
+		if d.Parent == parent {
+			names[d.VissibleName] = true
+		}
+	}
+	return names, nil
+}
+
+// inTrash whether the folder is the trash or one of its subfolders
+func inTrash(parents map[string]string, folder string) bool {
+	seen := make(map[string]bool)
+	for p := folder; p != "" && !seen[p]; p = parents[p] {
+		if p == trashParent {
+			return true
+		}
+		seen[p] = true
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package fs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+func TestResolveName(t *testing.T) {
+	taken := map[string]bool{
+		"notes":     true,
+		"notes (2)": true,
+	}
+	tests := []struct {
+		policy     string
+		name       string
+		wantName   string
+		wantAction string
+	}{
+		{config.NameCollisionSuffix, "other", "other", storage.ActionCreated},
+		{config.NameCollisionSuffix, "notes", "notes (3)", storage.ActionRenamed},
+		{config.NameCollisionSkip, "notes", "notes", storage.ActionSkipped},
+		{config.NameCollisionAllow, "notes", "notes", storage.ActionDuplicate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+"/"+tt.name, func(t *testing.T) {
+			name, action := resolveName(tt.policy, tt.name, taken)
+			if name != tt.wantName || action != tt.wantAction {
+				t.Errorf("resolveName() = %s, %s, want %s, %s", name, action, tt.wantName, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestMetadataNames(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	if err := os.MkdirAll(fs.getUserPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []*messages.RawMetadata{
+		{ID: "folder", VissibleName: "folder", Type: models.CollectionType},
+		{ID: "deletedFolder", VissibleName: "old", Type: models.CollectionType, Parent: trashParent},
+		{ID: "notes", VissibleName: "notes", Type: models.DocumentType},
+		{ID: "inFolder", VissibleName: "notes", Type: models.DocumentType, Parent: "folder"},
+		{ID: "deleted", VissibleName: "deleted", Type: models.DocumentType, Parent: trashParent},
+		{ID: "inDeletedFolder", VissibleName: "notes", Type: models.DocumentType, Parent: "deletedFolder"},
+	} {
+		if err := fs.UpdateMetadata("test", doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for parent, want := range map[string]map[string]bool{
+		"":              {"folder": true, "notes": true},
+		"folder":        {"notes": true},
+		trashParent:     {},
+		"deletedFolder": {},
+	} {
+		names, err := fs.metadataNames("test", parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%q: %v, want %v", parent, names, want)
+		}
+	}
+}
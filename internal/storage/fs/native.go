@@ -0,0 +1,134 @@
+package fs
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// nativeFormat a tar with the document files as they are stored on the tablet
+// (xochitl's layout): docid.metadata, docid.content, docid.pdf, docid/page.rm ...
+const nativeFormat = "native"
+
+// nativeName guards against entries escaping the archive
+func nativeName(name string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.New("invalid entry name: " + name)
+	}
+	return clean, nil
+}
+
+func writeNativeEntry(w *tar.Writer, name string, size int64, r io.Reader) error {
+	name, err := nativeName(name)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// streamNative writes the archive in the background
+func streamNative(write func(w *tar.Writer) error) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		err := write(tw)
+		if err == nil {
+			err = tw.Close()
+		}
+		if err != nil {
+			log.Error(err)
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader
+}
+
+// ExportNative the untouched files of a sync15 document as a tar
+func (fs *FileSystemStorage) ExportNative(uid, docid string) (io.ReadCloser, error) {
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := tree.FindDoc(docid)
+	if err != nil {
+		return nil, err
+	}
+
+	return streamNative(func(w *tar.Writer) error {
+		for _, f := range doc.Files {
+			blob, size, err := fs.openBlob(uid, f.Hash)
+			if err != nil {
+				return err
+			}
+			err = writeNativeEntry(w, f.EntryName, size, blob)
+			blob.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}), nil
+}
+
+// exportNativeDocument the files of a sync10 document as a tar, from its zip and the metadata
+func (fs *FileSystemStorage) exportNativeDocument(uid, docid string) (io.ReadCloser, error) {
+	sanitizedID := common.Sanitize(docid)
+	archive, err := fs.openStoredZip(uid, fs.getPathFromUser(uid, sanitizedID+models.ZipFileExt))
+	if err != nil {
+		return nil, err
+	}
+	metadataPath := fs.getPathFromUser(uid, sanitizedID+models.MetadataFileExt)
+
+	return streamNative(func(w *tar.Writer) error {
+		defer archive.Close()
+
+		metadata, err := os.Open(metadataPath)
+		if err != nil {
+			return err
+		}
+		defer metadata.Close()
+		st, err := metadata.Stat()
+		if err != nil {
+			return err
+		}
+		err = writeNativeEntry(w, sanitizedID+models.MetadataFileExt, st.Size(), metadata)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range archive.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = writeNativeEntry(w, f.Name, int64(f.UncompressedSize64), r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,97 @@
+package fs
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+func TestExportNativeDocument(t *testing.T) {
+	testuser := "test"
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewStorage(&config.Config{DataDir: dir})
+	err = os.MkdirAll(fs.getUserPath(testuser), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := fs.CreateDocument(testuser, "blah.pdf", "", strings.NewReader("dummy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := fs.ExportDocument(testuser, doc.ID, nativeFormat, storage.ExportWithAnnotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	files := readNative(t, r)
+	for _, ext := range []string{models.MetadataFileExt, models.ContentFileExt, models.PdfFileExt} {
+		if _, ok := files[doc.ID+ext]; !ok {
+			t.Errorf("missing %s in %v", doc.ID+ext, files)
+		}
+	}
+}
+
+func TestExportNativeBlobDocument(t *testing.T) {
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	if err := os.MkdirAll(fs.getUserBlobPath(testuser), 0700); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := fs.CreateBlobDocument(testuser, "blah.pdf", "", strings.NewReader("dummy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := fs.ExportNative(testuser, doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	files := readNative(t, r)
+	for _, ext := range []string{models.MetadataFileExt, models.ContentFileExt, models.PdfFileExt} {
+		if _, ok := files[doc.ID+ext]; !ok {
+			t.Errorf("missing %s in %v", doc.ID+ext, files)
+		}
+	}
+	if pdf := files[doc.ID+models.PdfFileExt]; pdf != "dummy" {
+		t.Errorf("the pdf is %q", pdf)
+	}
+
+	if _, err = fs.ExportNative(testuser, "missing"); err == nil {
+		t.Error("exported a missing document")
+	}
+}
+
+// readNative the entries of the tar
+func readNative(t *testing.T, r io.Reader) map[string]string {
+	files := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[h.Name] = string(content)
+	}
+}
@@ -0,0 +1,328 @@
+package fs
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	recoveredFolderName = "Recovered"
+
+	orphanIgnored   = "ignored"
+	orphanPending   = "pending"
+	orphanRecovered = "recovered"
+	orphanDeleted   = "deleted"
+)
+
+type orphanDoc struct {
+	storage.Orphan
+	doc *models.HashDoc
+}
+
+// readIndex parses the blob if it is an index file
+func (fs *FileSystemStorage) readIndex(uid, blobPath string) ([]*models.HashEntry, bool) {
+	f, _, err := fs.openStored(uid, blobPath)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header, err := r.Peek(2)
+	if err != nil || string(header) != "3\n" {
+		return nil, false
+	}
+	entries, err := models.ParseIndex(r)
+	if err != nil {
+		return nil, false
+	}
+	return entries, true
+}
+
+// historicDocs the ids of all documents any root generation ever referenced
+func (fs *FileSystemStorage) historicDocs(uid string) (map[string]bool, error) {
+	blobPath := fs.getUserBlobPath(uid)
+	docs := make(map[string]bool)
+
+	history, err := ioutil.ReadFile(path.Join(blobPath, historyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return docs, nil
+		}
+		return nil, err
+	}
+	roots := make(map[string]bool)
+	for _, line := range strings.Split(string(history), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || roots[fields[1]] {
+			continue
+		}
+		roots[fields[1]] = true
+		entries, ok := fs.readIndex(uid, path.Join(blobPath, fields[1]))
+		if !ok {
+			log.Warn("can't read root index: ", fields[1])
+			continue
+		}
+		for _, e := range entries {
+			docs[e.EntryName] = true
+		}
+	}
+	return docs, nil
+}
+
+// findOrphans finds the documents that were uploaded but never made it in a root index
+// which happens when a sync is interrupted
+func (fs *FileSystemStorage) findOrphans(uid string, tree *models.HashTree) ([]*orphanDoc, error) {
+	known, err := fs.historicDocs(uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range tree.Docs {
+		known[d.EntryName] = true
+	}
+
+	blobPath := fs.getUserBlobPath(uid)
+	files, err := ioutil.ReadDir(blobPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ls := &LocalBlobStorage{
+		fs:  fs,
+		uid: uid,
+	}
+	orphans := make(map[string]*orphanDoc)
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || name == rootFile || strings.HasPrefix(name, ".") {
+			continue
+		}
+		entries, ok := fs.readIndex(uid, path.Join(blobPath, name))
+		if !ok {
+			continue
+		}
+
+		var metadata *models.HashEntry
+		var size int64
+		for _, e := range entries {
+			size += e.Size
+			if strings.HasSuffix(e.EntryName, models.MetadataFileExt) {
+				metadata = e
+			}
+		}
+		// the root index or not a document
+		if metadata == nil {
+			continue
+		}
+		docid := strings.TrimSuffix(metadata.EntryName, models.MetadataFileExt)
+		if known[docid] {
+			continue
+		}
+		// keep the latest version only
+		if existing, ok := orphans[docid]; ok && existing.Modified.After(f.ModTime()) {
+			continue
+		}
+
+		doc := &models.HashDoc{
+			HashEntry: models.HashEntry{
+				Hash:      name,
+				EntryName: docid,
+			},
+			Files: entries,
+		}
+		err = doc.ReadMetadata(metadata, ls)
+		if err != nil {
+			log.Warn("can't read orphan metadata ", docid, err)
+		}
+
+		orphans[docid] = &orphanDoc{
+			Orphan: storage.Orphan{
+				ID:       docid,
+				Hash:     name,
+				Name:     doc.DocumentName,
+				Type:     doc.CollectionType,
+				Modified: f.ModTime(),
+				Size:     size,
+			},
+			doc: doc,
+		}
+	}
+
+	result := make([]*orphanDoc, 0, len(orphans))
+	for _, o := range orphans {
+		result = append(result, o)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Modified.Before(result[j].Modified) })
+	return result, nil
+}
+
+// FindOrphans lists the documents which are stored, but not referenced by any root index
+func (fs *FileSystemStorage) FindOrphans(uid string) ([]*storage.Orphan, error) {
+	if err := fs.localBlobs(); err != nil {
+		return nil, err
+	}
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	orphans, err := fs.findOrphans(uid, tree)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*storage.Orphan, 0, len(orphans))
+	for _, o := range orphans {
+		result = append(result, &o.Orphan)
+	}
+	return result, nil
+}
+
+// ResolveOrphans applies the configured orphan policy to the orphans older than the grace period
+func (fs *FileSystemStorage) ResolveOrphans(uid string) ([]*storage.Orphan, error) {
+	if err := fs.localBlobs(); err != nil {
+		return nil, err
+	}
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	orphans, err := fs.findOrphans(uid, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := fs.Cfg.OrphanPolicy
+	cutoff := time.Now().Add(-fs.Cfg.OrphanGracePeriod)
+	var recoveryFolder *models.HashDoc
+	result := make([]*storage.Orphan, 0, len(orphans))
+
+	for _, o := range orphans {
+		result = append(result, &o.Orphan)
+		switch {
+		case policy == config.OrphanIgnore:
+			o.Action = orphanIgnored
+			continue
+		case o.Modified.After(cutoff):
+			o.Action = orphanPending
+			continue
+		}
+
+		switch policy {
+		case config.OrphanRecover:
+			if recoveryFolder == nil {
+				recoveryFolder, err = fs.recoveryFolder(uid, tree)
+				if err != nil {
+					return nil, err
+				}
+			}
+			err = fs.recoverOrphan(uid, o.doc, recoveryFolder.EntryName, tree)
+			if err != nil {
+				return nil, err
+			}
+			log.Info("recovered orphan: ", o.ID, " ", o.Name)
+			o.Action = orphanRecovered
+
+		case config.OrphanDelete:
+			err = fs.deleteOrphan(uid, o.doc, tree)
+			if err != nil {
+				return nil, err
+			}
+			log.Info("deleted orphan: ", o.ID, " ", o.Name)
+			o.Action = orphanDeleted
+		}
+	}
+
+	if recoveryFolder != nil {
+		err = fs.commitTree(uid, tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// recoveryFolder finds or creates the folder in the root where orphans are put
+func (fs *FileSystemStorage) recoveryFolder(uid string, tree *models.HashTree) (*models.HashDoc, error) {
+	for _, d := range tree.Docs {
+		if d.CollectionType == models.CollectionType &&
+			d.DocumentName == recoveredFolderName &&
+			d.Parent == "" {
+			return d, nil
+		}
+	}
+	return fs.createBlobFolder(uid, recoveredFolderName, "", tree)
+}
+
+// recoverOrphan moves the orphan to the folder and links it into the tree
+func (fs *FileSystemStorage) recoverOrphan(uid string, doc *models.HashDoc, folderID string, tree *models.HashTree) error {
+	doc.Parent = folderID
+	doc.Deleted = false
+	err := fs.saveBlobDocument(uid, doc)
+	if err != nil {
+		return err
+	}
+	return tree.Add(doc)
+}
+
+// saveBlobDocument stores the changed metadata as a new version of the document
+func (fs *FileSystemStorage) saveBlobDocument(uid string, doc *models.HashDoc) error {
+	return fs.saveBlobDocumentFields(uid, doc, nil)
+}
+
+// saveBlobDocumentFields like saveBlobDocument, the metadata is written over the fields of its json
+func (fs *FileSystemStorage) saveBlobDocumentFields(uid string, doc *models.HashDoc, fields map[string]json.RawMessage) error {
+	doc.Version++
+	doc.LastModified = strconv.FormatInt(time.Now().Unix(), 10)
+	doc.MetadataModified = true
+
+	metahash, reader, err := doc.MetadataReaderWith(fields)
+	if err != nil {
+		return err
+	}
+	err = fs.saveBlob(uid, metahash, reader)
+	if err != nil {
+		return err
+	}
+	err = doc.Rehash()
+	if err != nil {
+		return err
+	}
+	docIndexReader, err := doc.IndexReader()
+	if err != nil {
+		return err
+	}
+	return fs.saveBlob(uid, doc.Hash, docIndexReader)
+}
+
+// deleteOrphan removes the orphan index and the files no other document references
+func (fs *FileSystemStorage) deleteOrphan(uid string, doc *models.HashDoc, tree *models.HashTree) error {
+	defer fs.usageChanged(uid)
+	referenced := make(map[string]bool)
+	for _, d := range tree.Docs {
+		for _, f := range d.Files {
+			referenced[f.Hash] = true
+		}
+	}
+
+	for _, f := range doc.Files {
+		if referenced[f.Hash] {
+			continue
+		}
+		err := fs.removeBlob(uid, f.Hash)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return fs.removeBlob(uid, doc.Hash)
+}
@@ -0,0 +1,135 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+// orphanStorage a user with a committed folder and a folder whose blobs were uploaded
+// but never made it in a root index, written age ago
+func orphanStorage(t *testing.T, cfg *config.Config, age time.Duration) (*FileSystemStorage, *models.HashDoc) {
+	fs := NewStorage(cfg)
+	if err := os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := fs.GetTree("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.createBlobFolder("test", "kept", "", tree); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.commitTree("test", tree); err != nil {
+		t.Fatal(err)
+	}
+	orphan, err := fs.createBlobFolder("test", "orphan", "", &models.HashTree{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	then := time.Now().Add(-age)
+	if err = os.Chtimes(path.Join(fs.getUserBlobPath("test"), orphan.Hash), then, then); err != nil {
+		t.Fatal(err)
+	}
+	return fs, orphan
+}
+
+func TestResolveOrphans(t *testing.T) {
+	grace := 24 * time.Hour
+	tests := []struct {
+		name   string
+		policy string
+		age    time.Duration
+		action string
+		inTree bool
+		stored bool
+	}{
+		{"ignore", config.OrphanIgnore, 2 * grace, orphanIgnored, false, true},
+		{"recover", config.OrphanRecover, 2 * grace, orphanRecovered, true, true},
+		{"delete", config.OrphanDelete, 2 * grace, orphanDeleted, false, false},
+		{"within the grace period", config.OrphanDelete, grace - time.Minute, orphanPending, false, true},
+		{"past the grace period", config.OrphanDelete, grace + time.Minute, orphanDeleted, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, orphan := orphanStorage(t, &config.Config{
+				DataDir:           t.TempDir(),
+				OrphanPolicy:      tt.policy,
+				OrphanGracePeriod: grace,
+			}, tt.age)
+
+			resolved, err := fs.ResolveOrphans("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resolved) != 1 || resolved[0].ID != orphan.EntryName || resolved[0].Action != tt.action {
+				t.Fatalf("resolved %+v, want %s", resolved, tt.action)
+			}
+
+			tree, err := fs.GetTree("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			inTree := false
+			for _, d := range tree.Docs {
+				if d.EntryName == orphan.EntryName {
+					inTree = true
+					if d.Parent == "" {
+						t.Error("recovered into the root, not the folder")
+					}
+				}
+			}
+			if inTree != tt.inTree {
+				t.Errorf("in the tree %t, want %t", inTree, tt.inTree)
+			}
+			_, err = os.Stat(path.Join(fs.getUserBlobPath("test"), orphan.Hash))
+			if stored := err == nil; stored != tt.stored {
+				t.Errorf("index stored %t, want %t", stored, tt.stored)
+			}
+		})
+	}
+}
+
+func TestGarbageCollectOrphans(t *testing.T) {
+	for policy, kept := range map[string]bool{config.OrphanRecover: true, config.OrphanIgnore: false} {
+		fs, orphan := orphanStorage(t, &config.Config{
+			DataDir:           t.TempDir(),
+			OrphanPolicy:      policy,
+			OrphanGracePeriod: 7 * 24 * time.Hour,
+			GCGracePeriod:     24 * time.Hour,
+		}, 0)
+		blobPath := fs.getUserBlobPath("test")
+		files, err := ioutil.ReadDir(blobPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// collectable, but not resolved yet
+		then := time.Now().Add(-48 * time.Hour)
+		for _, f := range files {
+			if err = os.Chtimes(path.Join(blobPath, f.Name()), then, then); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err = fs.GarbageCollect("test", false, nil); err != nil {
+			t.Fatal(err)
+		}
+		hashes := []string{orphan.Hash}
+		for _, f := range orphan.Files {
+			if f.EntryName == orphan.EntryName+models.MetadataFileExt {
+				hashes = append(hashes, f.Hash)
+			}
+		}
+		for _, hash := range hashes {
+			_, err = os.Stat(path.Join(blobPath, hash))
+			if (err == nil) != kept {
+				t.Errorf("%s: %s kept %t, want %t", policy, hash, err == nil, kept)
+			}
+		}
+	}
+}
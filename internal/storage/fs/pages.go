@@ -0,0 +1,381 @@
+package fs
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const notebookFileType = "notebook"
+
+// notebookContent the parts of the .content file the page order is in
+// unknown fields are kept as they are
+type notebookContent struct {
+	fields map[string]json.RawMessage
+	// pages the old format, a list of page ids
+	pages []string
+	// cPages the newer format, pages with their position (idx)
+	cPages map[string]json.RawMessage
+	// cPagesEntries the pages of cPages, without the deleted ones
+	cPagesEntries []map[string]json.RawMessage
+	cPagesDeleted []map[string]json.RawMessage
+}
+
+func parseContent(content []byte) (*notebookContent, error) {
+	c := &notebookContent{}
+	err := json.Unmarshal(content, &c.fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileType string
+	if raw, ok := c.fields["fileType"]; ok {
+		err = json.Unmarshal(raw, &fileType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fileType != "" && fileType != notebookFileType {
+		return nil, fmt.Errorf("%w: only the pages of notebooks can be reordered, not %s", storage.ErrorInvalidPages, fileType)
+	}
+
+	if raw, ok := c.fields["pages"]; ok {
+		err = json.Unmarshal(raw, &c.pages)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if raw, ok := c.fields["cPages"]; ok {
+		err = json.Unmarshal(raw, &c.cPages)
+		if err != nil {
+			return nil, err
+		}
+		var entries []map[string]json.RawMessage
+		if raw, ok := c.cPages["pages"]; ok {
+			err = json.Unmarshal(raw, &entries)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, e := range entries {
+			if _, deleted := e["deleted"]; deleted {
+				c.cPagesDeleted = append(c.cPagesDeleted, e)
+			} else {
+				c.cPagesEntries = append(c.cPagesEntries, e)
+			}
+		}
+	}
+	return c, nil
+}
+
+func cPageID(entry map[string]json.RawMessage) string {
+	var id string
+	json.Unmarshal(entry["id"], &id)
+	return id
+}
+
+// Pages the page ids in order
+func (c *notebookContent) Pages() []string {
+	if c.cPages == nil {
+		return c.pages
+	}
+	ids := make([]string, 0, len(c.cPagesEntries))
+	for _, e := range c.cPagesEntries {
+		ids = append(ids, cPageID(e))
+	}
+	return ids
+}
+
+// checkPermutation the order has exactly the existing pages
+func checkPermutation(pages, order []string) error {
+	if len(order) != len(pages) {
+		return fmt.Errorf("%w: the document has %d pages, got %d", storage.ErrorInvalidPages, len(pages), len(order))
+	}
+	existing := make(map[string]bool, len(pages))
+	for _, p := range pages {
+		existing[p] = true
+	}
+	seen := make(map[string]bool, len(order))
+	for _, p := range order {
+		if !existing[p] {
+			return fmt.Errorf("%w: unknown page %s", storage.ErrorInvalidPages, p)
+		}
+		if seen[p] {
+			return fmt.Errorf("%w: page %s is there twice", storage.ErrorInvalidPages, p)
+		}
+		seen[p] = true
+	}
+	return nil
+}
+
+// Reorder puts the pages in the order, in cPages the positions are handed out again in the new order
+func (c *notebookContent) Reorder(order []string) error {
+	err := checkPermutation(c.Pages(), order)
+	if err != nil {
+		return err
+	}
+
+	if c.pages != nil {
+		c.pages = order
+		c.fields["pages"], err = json.Marshal(c.pages)
+		if err != nil {
+			return err
+		}
+	}
+	if c.cPages != nil {
+		byID := make(map[string]map[string]json.RawMessage, len(c.cPagesEntries))
+		positions := make([]json.RawMessage, 0, len(c.cPagesEntries))
+		for _, e := range c.cPagesEntries {
+			byID[cPageID(e)] = e
+			positions = append(positions, e["idx"])
+		}
+		entries := make([]map[string]json.RawMessage, 0, len(c.cPagesEntries)+len(c.cPagesDeleted))
+		for i, id := range order {
+			e := byID[id]
+			if positions[i] != nil {
+				e["idx"] = positions[i]
+			}
+			entries = append(entries, e)
+		}
+		c.cPagesEntries = entries
+		c.cPages["pages"], err = json.Marshal(append(entries, c.cPagesDeleted...))
+		if err != nil {
+			return err
+		}
+		c.fields["cPages"], err = json.Marshal(c.cPages)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bytes the content file
+func (c *notebookContent) Bytes() ([]byte, error) {
+	return json.Marshal(c.fields)
+}
+
+// blobContent the .content file of a sync15 document
+func (fs *FileSystemStorage) blobContent(uid string, doc *models.HashDoc) (*models.HashEntry, []byte, error) {
+	for _, f := range doc.Files {
+		if path.Ext(f.EntryName) != models.ContentFileExt {
+			continue
+		}
+		content, err := fs.readBlob(uid, f.Hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, content, nil
+	}
+	return nil, nil, fmt.Errorf("%w: %s has no content file", storage.ErrorInvalidPages, doc.EntryName)
+}
+
+// GetBlobPages the page ids of a sync15 notebook, in order
+func (fs *FileSystemStorage) GetBlobPages(uid, docid string) ([]string, error) {
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := tree.FindDoc(docid)
+	if err != nil {
+		return nil, ErrorNotFound
+	}
+	_, content, err := fs.blobContent(uid, doc)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return c.Pages(), nil
+}
+
+// ReorderBlobPages changes the page order of a sync15 notebook, as a new version of it
+func (fs *FileSystemStorage) ReorderBlobPages(uid, docid string, order []string) error {
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return err
+	}
+	doc, err := tree.FindDoc(docid)
+	if err != nil {
+		return ErrorNotFound
+	}
+	entry, content, err := fs.blobContent(uid, doc)
+	if err != nil {
+		return err
+	}
+	c, err := parseContent(content)
+	if err != nil {
+		return err
+	}
+	err = c.Reorder(order)
+	if err != nil {
+		return err
+	}
+	content, err = c.Bytes()
+	if err != nil {
+		return err
+	}
+
+	hash, size, err := models.Hash(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	err = fs.saveBlob(uid, hash, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	entry.Size = size
+	err = fs.saveBlobDocument(uid, doc)
+	if err != nil {
+		return err
+	}
+	err = tree.Rehash()
+	if err != nil {
+		return err
+	}
+	err = fs.commitTree(uid, tree)
+	if err != nil {
+		return err
+	}
+	log.Infof("reordered the %d pages of %s", len(order), docid)
+	return nil
+}
+
+// zipContent the .content file of a sync10 document
+func zipContent(r *zip.Reader) (*zip.File, []byte, error) {
+	for _, f := range r.File {
+		if filepath.Ext(f.Name) != models.ContentFileExt || strings.Contains(f.Name, "/") {
+			continue
+		}
+		fr, err := f.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer fr.Close()
+		content, err := ioutil.ReadAll(fr)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, content, nil
+	}
+	return nil, nil, fmt.Errorf("%w: no content file", storage.ErrorInvalidPages)
+}
+
+// GetPages the page ids of a sync10 notebook, in order
+func (fs *FileSystemStorage) GetPages(uid, docid string) ([]string, error) {
+	r, err := fs.openStoredZip(uid, fs.getPathFromUser(uid, docid+models.ZipFileExt))
+	if os.IsNotExist(err) {
+		return nil, ErrorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	_, content, err := zipContent(r.Reader)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return c.Pages(), nil
+}
+
+// ReorderPages changes the page order of a sync10 notebook, the zip is rewritten
+// and the version bumped so the tablets download it again
+func (fs *FileSystemStorage) ReorderPages(uid, docid string, order []string) error {
+	meta, err := fs.GetMetadata(uid, docid)
+	if err != nil {
+		return ErrorNotFound
+	}
+	zipPath := fs.getPathFromUser(uid, docid+models.ZipFileExt)
+	r, err := fs.openStoredZip(uid, zipPath)
+	if os.IsNotExist(err) {
+		return ErrorNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	contentFile, content, err := zipContent(r.Reader)
+	if err != nil {
+		return err
+	}
+	c, err := parseContent(content)
+	if err != nil {
+		return err
+	}
+	err = c.Reorder(order)
+	if err != nil {
+		return err
+	}
+	content, err = c.Bytes()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(fs.getUserPath(uid), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	w := zip.NewWriter(tmp)
+	for _, f := range r.File {
+		if f != contentFile {
+			err = w.Copy(f)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fw, err := w.CreateHeader(&zip.FileHeader{
+			Name:     f.Name,
+			Method:   f.Method,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(content)
+		if err != nil {
+			return err
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), zipPath)
+	if err != nil {
+		return err
+	}
+
+	meta.Version++
+	meta.ModifiedClient = time.Now().UTC().Format(time.RFC3339Nano)
+	err = fs.UpdateMetadata(uid, meta)
+	if err != nil {
+		return err
+	}
+	log.Infof("reordered the %d pages of %s", len(order), docid)
+	return nil
+}
@@ -0,0 +1,137 @@
+package fs
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+const (
+	oldContent = `{"fileType":"notebook","pageCount":3,"pages":["a","b","c"]}`
+	newContent = `{"fileType":"notebook","cPages":{"lastOpened":{"value":"a"},"pages":[
+		{"id":"a","idx":{"timestamp":"1:2","value":"ba"}},
+		{"id":"gone","idx":{"timestamp":"1:2","value":"bb"},"deleted":{"timestamp":"1:3","value":1}},
+		{"id":"b","idx":{"timestamp":"1:2","value":"bc"}},
+		{"id":"c","idx":{"timestamp":"1:2","value":"bd"}}]}}`
+)
+
+func TestReorderContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		order   []string
+		err     bool
+	}{
+		{"pages", oldContent, []string{"c", "a", "b"}, false},
+		{"cPages", newContent, []string{"c", "a", "b"}, false},
+		{"missing page", oldContent, []string{"c", "a"}, true},
+		{"added page", oldContent, []string{"c", "a", "b", "d"}, true},
+		{"unknown page", oldContent, []string{"c", "a", "d"}, true},
+		{"duplicate page", newContent, []string{"c", "a", "a"}, true},
+		{"deleted page", newContent, []string{"c", "a", "gone"}, true},
+		{"pdf", `{"fileType":"pdf","pages":["a"]}`, []string{"a"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseContent([]byte(tt.content))
+			if err == nil {
+				err = c.Reorder(tt.order)
+			}
+			if tt.err {
+				if !errors.Is(err, storage.ErrorInvalidPages) {
+					t.Errorf("expected an invalid pages error, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := c.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			reordered, err := parseContent(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pages := reordered.Pages(); !reflect.DeepEqual(pages, tt.order) {
+				t.Errorf("got %v, want %v", pages, tt.order)
+			}
+			if len(reordered.cPagesDeleted) != len(c.cPagesDeleted) {
+				t.Error("the deleted pages are gone")
+			}
+			// the positions stay in order
+			for i, e := range reordered.cPagesEntries {
+				idx := struct{ Value string }{}
+				json.Unmarshal(e["idx"], &idx)
+				if want := []string{"ba", "bc", "bd"}[i]; idx.Value != want {
+					t.Errorf("page %d has position %s, want %s", i, idx.Value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReorderPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: dir})
+	err = os.MkdirAll(fs.getUserPath(testuser), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docid := "doc"
+	f, err := os.Create(fs.getPathFromUser(testuser, docid+models.ZipFileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		docid + models.ContentFileExt: oldContent,
+		docid + "/a.rm":               "page a",
+	} {
+		fw, _ := w.Create(name)
+		fw.Write([]byte(content))
+	}
+	w.Close()
+	f.Close()
+	err = fs.UpdateMetadata(testuser, &messages.RawMetadata{ID: docid, Version: 1, Type: models.DocumentType})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := []string{"b", "c", "a"}
+	err = fs.ReorderPages(testuser, docid, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := fs.GetPages(testuser, docid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pages, order) {
+		t.Errorf("got %v, want %v", pages, order)
+	}
+	meta, err := fs.GetMetadata(testuser, docid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Version != 2 {
+		t.Errorf("the version wasn't bumped: %d", meta.Version)
+	}
+}
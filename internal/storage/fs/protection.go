@@ -0,0 +1,66 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/exporter"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	protectionProcessorName = "protection"
+	protectedWarning        = "password protected or drm, the tablet can't open it"
+	// protectedTag the tag of the flagged documents
+	protectedTag = "unreadable"
+)
+
+// protectionProcessor finds password protected pdfs and epubs with drm
+type protectionProcessor struct {
+	fs     *FileSystemStorage
+	policy string
+}
+
+func (p *protectionProcessor) Name() string {
+	return protectionProcessorName
+}
+
+func (p *protectionProcessor) Process(doc *storage.IngestDocument) error {
+	if doc.Ext != models.PdfFileExt && doc.Ext != models.EpubFileExt {
+		return nil
+	}
+	// scanned from a temp file, which is then stored
+	content, size, err := p.fs.spoolContent(doc)
+	if err != nil {
+		return err
+	}
+
+	var protected bool
+	if doc.Ext == models.PdfFileExt {
+		protected, err = exporter.PDFProtected(content)
+	} else {
+		protected, err = exporter.EPUBProtected(content, size)
+	}
+	if _, seekErr := content.Seek(0, io.SeekStart); seekErr != nil {
+		return seekErr
+	}
+	if err != nil {
+		// not for this processor to decide
+		log.Warn("can't check the protection of ", doc.Name, ": ", err)
+		return nil
+	}
+	if !protected {
+		return nil
+	}
+
+	if p.policy == config.ProtectedFlag {
+		log.Warn(doc.Name, ": ", protectedWarning)
+		doc.Warning = protectedWarning
+		doc.Tags = append(doc.Tags, protectedTag)
+		return nil
+	}
+	return fmt.Errorf("%w: %s", storage.ErrorRejected, protectedWarning)
+}
@@ -0,0 +1,137 @@
+package fs
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/unidoc/unipdf/v3/creator"
+	pdf "github.com/unidoc/unipdf/v3/model"
+)
+
+func testEpub(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testPdf(t *testing.T, userPassword string) []byte {
+	c := creator.New()
+	c.NewPage()
+	var plain bytes.Buffer
+	if err := c.Write(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if userPassword == "" {
+		return plain.Bytes()
+	}
+
+	reader, err := pdf.NewPdfReader(bytes.NewReader(plain.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := reader.ToWriter(&pdf.ReaderToWriterOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Encrypt([]byte(userPassword), []byte("owner"), nil); err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	if err = writer.Write(&encrypted); err != nil {
+		t.Fatal(err)
+	}
+	return encrypted.Bytes()
+}
+
+const encryptionXML = `<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">
+<enc:EncryptedData><enc:EncryptionMethod Algorithm="%s"/></enc:EncryptedData>
+</encryption>`
+
+func TestProtectionProcessor(t *testing.T) {
+	container := "META-INF/container.xml"
+	tests := []struct {
+		name      string
+		ext       string
+		content   []byte
+		protected bool
+	}{
+		{"pdf", ".pdf", testPdf(t, ""), false},
+		{"pdf with password", ".pdf", testPdf(t, "secret"), true},
+		{"epub", ".epub", testEpub(t, map[string]string{container: ""}), false},
+		{"epub with obfuscated fonts", ".epub", testEpub(t, map[string]string{
+			"META-INF/encryption.xml": fmt.Sprintf(encryptionXML, "http://www.idpf.org/2008/embedding"),
+		}), false},
+		{"epub with encrypted content", ".epub", testEpub(t, map[string]string{
+			"META-INF/encryption.xml": fmt.Sprintf(encryptionXML, "http://www.w3.org/2001/04/xmlenc#aes128-cbc"),
+		}), true},
+		{"epub with rights", ".epub", testEpub(t, map[string]string{"META-INF/rights.xml": "<rights/>"}), true},
+	}
+
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	if err := os.MkdirAll(fs.getUserPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reject := &protectionProcessor{fs: fs, policy: config.ProtectedReject}
+			doc := &storage.IngestDocument{UserID: "test", Name: tt.name, Ext: tt.ext, Content: bytes.NewReader(tt.content)}
+			err := reject.Process(doc)
+			if rejected := errors.Is(err, storage.ErrorRejected); rejected != tt.protected {
+				t.Errorf("rejected: %t, %v", rejected, err)
+			}
+			releaseContent(doc)
+
+			flag := &protectionProcessor{fs: fs, policy: config.ProtectedFlag}
+			doc = &storage.IngestDocument{UserID: "test", Name: tt.name, Ext: tt.ext, Content: bytes.NewReader(tt.content)}
+			if err = flag.Process(doc); err != nil {
+				t.Fatal(err)
+			}
+			defer releaseContent(doc)
+			if flagged := doc.Warning != "" && len(doc.Tags) == 1; flagged != tt.protected {
+				t.Errorf("flagged: %t", flagged)
+			}
+			stored, _ := ioutil.ReadAll(doc.Content)
+			if !bytes.Equal(stored, tt.content) {
+				t.Error("the content changed")
+			}
+		})
+	}
+}
+
+func TestFlaggedIsTagged(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir(), ProtectedUploadPolicy: config.ProtectedFlag})
+	if err := os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := fs.CreateBlobDocument("test", "locked.pdf", "", bytes.NewReader(testPdf(t, "secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Warning == "" {
+		t.Error("no warning")
+	}
+	tags, err := fs.BlobDocumentTags("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags[doc.ID]) != 1 || tags[doc.ID][0] != protectedTag {
+		t.Errorf("tags %v", tags[doc.ID])
+	}
+}
@@ -0,0 +1,103 @@
+package fs
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// folderParent the path of the parent folder, empty for the root
+func folderParent(folderPath string) string {
+	i := strings.LastIndex(folderPath, "/")
+	if i < 0 {
+		return ""
+	}
+	return folderPath[:i]
+}
+
+// folderName the last part of the path
+func folderName(folderPath string) string {
+	return folderPath[strings.LastIndex(folderPath, "/")+1:]
+}
+
+// createFolders creates the folders of the paths, parents first, each folder once
+// create makes a folder and returns its id
+func createFolders(paths []string, create func(name, parent string) (string, error)) (map[string]string, error) {
+	ids := make(map[string]string)
+	var createPath func(folderPath string) (string, error)
+	createPath = func(folderPath string) (string, error) {
+		if folderPath == "" {
+			return "", nil
+		}
+		if id, ok := ids[folderPath]; ok {
+			return id, nil
+		}
+		parent, err := createPath(folderParent(folderPath))
+		if err != nil {
+			return "", err
+		}
+		id, err := create(folderName(folderPath), parent)
+		if err != nil {
+			return "", err
+		}
+		ids[folderPath] = id
+		return id, nil
+	}
+	for _, p := range paths {
+		if _, err := createPath(p); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// createDefaultFolders creates the configured folders of a new user, for both sync versions
+func (fs *FileSystemStorage) createDefaultFolders(uid string) error {
+	folders := fs.Cfg.DefaultFolders
+	if len(folders) == 0 {
+		return nil
+	}
+	defer fs.usageChanged(uid)
+
+	// sync10
+	_, err := createFolders(folders, func(name, parent string) (string, error) {
+		id := uuid.New().String()
+		return id, fs.UpdateMetadata(uid, &messages.RawMetadata{
+			ID:             id,
+			VissibleName:   name,
+			Version:        1,
+			ModifiedClient: time.Now().UTC().Format(time.RFC3339Nano),
+			Type:           models.CollectionType,
+			Parent:         parent,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// sync15
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return err
+	}
+	ids, err := createFolders(folders, func(name, parent string) (string, error) {
+		doc, err := fs.createBlobFolder(uid, name, parent, tree)
+		if err != nil {
+			return "", err
+		}
+		return doc.EntryName, nil
+	})
+	if err != nil {
+		return err
+	}
+	err = fs.commitTree(uid, tree)
+	if err != nil {
+		return err
+	}
+	log.Infof("created %d default folders for %s", len(ids), uid)
+	return nil
+}
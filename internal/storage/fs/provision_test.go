@@ -0,0 +1,80 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+func TestDefaultFolders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewStorage(&config.Config{
+		DataDir:        dir,
+		DefaultFolders: []string{"Inbox", "Projects/Work", "Projects"},
+	})
+	err = fs.RegisterUser(&model.User{ID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sync10
+	metadata, err := fs.GetAllMetadata("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]string)
+	parents := make(map[string]string)
+	for _, m := range metadata {
+		if m.Type != models.CollectionType {
+			t.Errorf("%s is not a folder", m.VissibleName)
+		}
+		byName[m.VissibleName] = m.ID
+		parents[m.VissibleName] = m.Parent
+	}
+	if len(metadata) != 3 {
+		t.Fatalf("expected 3 folders, got %d", len(metadata))
+	}
+	if parents["Work"] != byName["Projects"] || parents["Projects"] != "" || parents["Inbox"] != "" {
+		t.Errorf("wrong parents %v", parents)
+	}
+
+	// sync15
+	tree, err := fs.GetTree("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName = make(map[string]string)
+	parents = make(map[string]string)
+	for _, doc := range tree.Docs {
+		if doc.MetadataFile.CollectionType != models.CollectionType {
+			t.Errorf("%s is not a folder", doc.MetadataFile.DocumentName)
+		}
+		byName[doc.MetadataFile.DocumentName] = doc.EntryName
+		parents[doc.MetadataFile.DocumentName] = doc.MetadataFile.Parent
+	}
+	if len(tree.Docs) != 3 {
+		t.Fatalf("expected 3 folders, got %d", len(tree.Docs))
+	}
+	if parents["Work"] != byName["Projects"] || parents["Inbox"] != "" {
+		t.Errorf("wrong parents %v", parents)
+	}
+
+	// existing users don't get them again
+	err = fs.UpdateUser(&model.User{ID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, _ = fs.GetAllMetadata("test")
+	if len(metadata) != 3 {
+		t.Errorf("expected 3 folders after the update, got %d", len(metadata))
+	}
+}
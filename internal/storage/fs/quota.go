@@ -0,0 +1,141 @@
+package fs
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+// effectiveQuota the user's quota over the server default, 0 is unlimited
+func effectiveQuota(userQuota, defaultQuota int64) int64 {
+	switch {
+	case userQuota < 0:
+		return 0
+	case userQuota > 0:
+		return userQuota
+	}
+	return defaultQuota
+}
+
+// Quota of the user in bytes, 0 is unlimited
+func (fs *FileSystemStorage) Quota(uid string) (int64, error) {
+	user, err := fs.GetUser(uid)
+	if err != nil {
+		return 0, err
+	}
+	return effectiveQuota(user.Quota, fs.Cfg.Quota), nil
+}
+
+// quotaLeft how many more bytes the user can store, ok is false when unlimited
+func (fs *FileSystemStorage) quotaLeft(uid string) (left int64, ok bool, err error) {
+	var userQuota int64
+	if user, err := fs.GetUser(uid); err == nil {
+		userQuota = user.Quota
+	}
+	quota := effectiveQuota(userQuota, fs.Cfg.Quota)
+	if quota == 0 {
+		return 0, false, nil
+	}
+	usage, err := fs.Usage(uid)
+	if err != nil {
+		return 0, false, err
+	}
+	left = quota - usage.Total
+	if left < 0 {
+		left = 0
+	}
+	return left, true, nil
+}
+
+// quotaReader fails with ErrorOverQuota once more than left bytes are read
+type quotaReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.left -= int64(n)
+	if q.left < 0 {
+		return n, storage.ErrorOverQuota
+	}
+	return n, err
+}
+
+// limitToQuota the stream fails when it would take the user over the quota
+func (fs *FileSystemStorage) limitToQuota(uid string, stream io.Reader) (io.Reader, error) {
+	left, ok, err := fs.quotaLeft(uid)
+	if err != nil || !ok {
+		return stream, err
+	}
+	return &quotaReader{r: stream, left: left}, nil
+}
+
+// usageGrown the user stored size more bytes which aren't in a document yet,
+// the cached usage is updated instead of walking all the files again
+func (fs *FileSystemStorage) usageGrown(uid string, size int64) {
+	fs.usageLock.Lock()
+	defer fs.usageLock.Unlock()
+	fs.usageGeneration++
+	cached, ok := fs.usage[uid]
+	if !ok {
+		return
+	}
+	grown := *cached
+	grown.Total += size
+	grown.Other += size
+	fs.usage[uid] = &grown
+}
+
+// quotaProvider stores the blobs and documents in another provider, within the quota of the user
+type quotaProvider struct {
+	storage.BlobProvider
+	fs *FileSystemStorage
+}
+
+// LimitToQuota the uploads to the provider fail with ErrorOverQuota once the user's quota is used,
+// the usage is what the provider holds in the documents of the user
+func (fs *FileSystemStorage) LimitToQuota(blobs storage.BlobProvider) storage.BlobProvider {
+	return &quotaProvider{BlobProvider: blobs, fs: fs}
+}
+
+func (q *quotaProvider) quotaLeft(uid string) (int64, bool, error) {
+	return q.fs.quotaLeft(uid)
+}
+
+// StoreBlob the root is tiny and devices must still be able to delete
+func (q *quotaProvider) StoreBlob(uid, blobID string, stream io.Reader, matchGeneration int64) (int64, error) {
+	if blobID == rootFile {
+		defer q.fs.usageChanged(uid)
+		return q.BlobProvider.StoreBlob(uid, blobID, stream, matchGeneration)
+	}
+	limited, err := q.fs.limitToQuota(uid, stream)
+	if err != nil {
+		return 0, err
+	}
+	counted := &countingReader{ReadCloser: ioutil.NopCloser(limited)}
+	generation, err := q.BlobProvider.StoreBlob(uid, blobID, counted, matchGeneration)
+	if err == nil {
+		q.fs.usageGrown(uid, counted.n)
+	}
+	return generation, err
+}
+
+// RootGeneration of the provider, if it can tell it without loading the root
+func (q *quotaProvider) RootGeneration(uid string) (int64, error) {
+	if r, ok := q.BlobProvider.(storage.RootGenerationReader); ok {
+		return r.RootGeneration(uid)
+	}
+	return 0, storage.ErrorNotSupported
+}
+
+// StoreDocument the sync10 document, within the quota
+func (q *quotaProvider) StoreDocument(uid, docid string, stream io.ReadCloser) error {
+	defer q.fs.usageChanged(uid)
+	limited, err := q.fs.limitToQuota(uid, stream)
+	if err != nil {
+		return err
+	}
+	return q.BlobProvider.StoreDocument(uid, docid, ioutil.NopCloser(limited))
+}
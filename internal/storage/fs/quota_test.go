@@ -0,0 +1,142 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+func TestEffectiveQuota(t *testing.T) {
+	tests := []struct {
+		user, def, want int64
+	}{
+		{0, 0, 0},
+		{0, 100, 100},
+		{50, 100, 50},
+		{-1, 100, 0},
+	}
+	for _, tt := range tests {
+		if got := effectiveQuota(tt.user, tt.def); got != tt.want {
+			t.Errorf("user %d default %d: got %d, want %d", tt.user, tt.def, got, tt.want)
+		}
+	}
+}
+
+func TestStoreOverQuota(t *testing.T) {
+	testuser := "test"
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewStorage(&config.Config{DataDir: dir, Quota: 10})
+	blobPath := fs.getUserBlobPath(testuser)
+	err = os.MkdirAll(blobPath, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.StoreBlob(testuser, "small", strings.NewReader("123456"), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, err := fs.Usage(testuser)
+	if err != nil || usage.Total != 6 {
+		t.Fatalf("usage %v %v", usage, err)
+	}
+
+	_, err = fs.StoreBlob(testuser, "big", strings.NewReader("123456"), -1)
+	if err != storage.ErrorOverQuota {
+		t.Fatalf("expected over quota, got %v", err)
+	}
+	if _, err = os.Stat(path.Join(blobPath, "big")); !os.IsNotExist(err) {
+		t.Error("the blob over the quota was stored")
+	}
+
+	_, err = fs.StoreBlob(testuser, "tiny", strings.NewReader("1"), -1)
+	if err != nil {
+		t.Error(err)
+	}
+	// the cached usage grew without walking the files again
+	usage, err = fs.Usage(testuser)
+	if err != nil || usage.Total != 7 {
+		t.Errorf("usage %v %v", usage, err)
+	}
+	err = fs.StoreDocument(testuser, "doc", ioutil.NopCloser(strings.NewReader("123456")))
+	if err != storage.ErrorOverQuota {
+		t.Errorf("expected over quota for the document, got %v", err)
+	}
+
+	// the root is always accepted
+	fs.Cfg.Quota = 1
+	_, err = fs.StoreBlob(testuser, rootFile, strings.NewReader("small"), 0)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewStorage(&config.Config{DataDir: dir, Quota: 100})
+	user, err := model.NewUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if quota, err := fs.Quota("test"); err != nil || quota != 100 {
+		t.Errorf("default quota %d %v", quota, err)
+	}
+	user.Quota = -1
+	if err = fs.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if quota, err := fs.Quota("test"); err != nil || quota != 0 {
+		t.Errorf("unlimited quota %d %v", quota, err)
+	}
+	if _, err = fs.Quota("nobody"); err == nil {
+		t.Error("quota of a missing user")
+	}
+}
+
+func TestProviderOverQuota(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewStorage(&config.Config{DataDir: path.Join(dir, "local"), Quota: 10})
+	// another data dir stands in for the object storage
+	remote := NewStorage(&config.Config{DataDir: path.Join(dir, "remote")})
+	fs.SetBlobProvider(remote)
+	for _, p := range []string{fs.getUserPath("test"), remote.getUserBlobPath("test")} {
+		if err := os.MkdirAll(p, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	limited := fs.LimitToQuota(remote)
+
+	if _, err := limited.StoreBlob("test", "small", strings.NewReader("123456"), -1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limited.StoreBlob("test", "big", strings.NewReader("123456"), -1); err != storage.ErrorOverQuota {
+		t.Errorf("expected over quota, got %v", err)
+	}
+	err := limited.StoreDocument("test", "doc", ioutil.NopCloser(strings.NewReader("12345678901")))
+	if err != storage.ErrorOverQuota {
+		t.Errorf("expected over quota for the document, got %v", err)
+	}
+	// the root is always accepted
+	if _, err = limited.StoreBlob("test", rootFile, strings.NewReader("small"), 0); err != nil {
+		t.Error(err)
+	}
+}
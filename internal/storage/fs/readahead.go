@@ -0,0 +1,97 @@
+package fs
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// readAheadWorkers how many indexes are warmed at the same time, downloads while they are busy don't read ahead
+const readAheadWorkers = 2
+
+// readAhead warms the blobs of a document into the os cache when its index is downloaded
+// the tablet fetches the files of a document right after its index, other blobs don't trigger anything
+type readAhead struct {
+	fs    *FileSystemStorage
+	max   int
+	slots chan struct{}
+}
+
+func newReadAhead(fs *FileSystemStorage, max int) *readAhead {
+	return &readAhead{
+		fs:    fs,
+		max:   max,
+		slots: make(chan struct{}, readAheadWorkers),
+	}
+}
+
+// readAheadBlobs the hashes of the first max files of a document index
+// nothing for other blobs and for the root index, whose documents are fetched only when they changed
+func readAheadBlobs(r io.Reader, max int) []string {
+	br := bufio.NewReader(r)
+	// skip the blobs that aren't an index without reading them
+	if start, err := br.Peek(2); err != nil || string(start) != "3\n" {
+		return nil
+	}
+	entries, err := models.ParseIndex(br)
+	if err != nil {
+		return nil
+	}
+	hashes := make([]string, 0, max)
+	for _, e := range entries {
+		if e.Subfiles > 0 {
+			return nil
+		}
+		if len(hashes) < max {
+			hashes = append(hashes, e.Hash)
+		}
+	}
+	return hashes
+}
+
+// warm reads the files of the downloaded index in the background
+func (ra *readAhead) warm(uid, blobID string) {
+	blobPath := path.Join(ra.fs.getUserBlobPath(uid), common.Sanitize(blobID))
+	select {
+	case ra.slots <- struct{}{}:
+	default:
+		return
+	}
+	go func() {
+		defer func() { <-ra.slots }()
+		f, _, err := ra.fs.openStored(uid, blobPath)
+		if err != nil {
+			return
+		}
+		hashes := readAheadBlobs(f, ra.max)
+		f.Close()
+		if len(hashes) == 0 {
+			return
+		}
+		start := time.Now()
+		var total int64
+		dir := path.Dir(blobPath)
+		for _, h := range hashes {
+			total += warmFile(path.Join(dir, common.Sanitize(h)))
+		}
+		log.Debugf("read ahead %d blobs (%d KB) of %s in %s", len(hashes), total>>10, path.Base(blobPath), time.Since(start))
+	}()
+}
+
+// warmFile reads the file so that the next read comes from the cache
+func warmFile(filePath string) int64 {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	n, _ := io.Copy(ioutil.Discard, f)
+	return n
+}
@@ -0,0 +1,40 @@
+package fs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadAheadBlobs(t *testing.T) {
+	docIndex := "3\n" +
+		"h1:0:doc.content:0:10\n" +
+		"h2:0:doc.metadata:0:20\n" +
+		"h3:0:doc/p1.rm:0:30\n"
+	rootIndex := "3\n" +
+		"d1:80000000:doc1:3:60\n" +
+		"d2:80000000:doc2:2:40\n"
+
+	tests := []struct {
+		name  string
+		index string
+		max   int
+		want  []string
+	}{
+		{"doc index", docIndex, 5, []string{"h1", "h2", "h3"}},
+		{"limited", docIndex, 2, []string{"h1", "h2"}},
+		{"root index", rootIndex, 5, nil},
+		{"not an index", "%PDF-1.4\n", 5, nil},
+		{"broken index", "3\nnot:an:entry\n", 5, nil},
+		{"empty", "", 5, nil},
+	}
+	for _, tt := range tests {
+		got := readAheadBlobs(strings.NewReader(tt.index), tt.max)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// untitledName the name of documents whose metadata has none
+const untitledName = "Untitled"
+
+// metadataFields the fields of a metadata json, the problems found while reading them are collected
+type metadataFields struct {
+	fields   map[string]json.RawMessage
+	problems []string
+}
+
+func parseMetadataFields(content []byte) *metadataFields {
+	m := &metadataFields{}
+	err := json.Unmarshal(content, &m.fields)
+	if err != nil || m.fields == nil {
+		m.problems = append(m.problems, "not a json object")
+		m.fields = make(map[string]json.RawMessage)
+	}
+	return m
+}
+
+func (m *metadataFields) problem(format string, args ...interface{}) {
+	m.problems = append(m.problems, fmt.Sprintf(format, args...))
+}
+
+// str a string field, numbers are converted
+func (m *metadataFields) str(name string, required bool, def string) string {
+	raw, ok := m.fields[name]
+	if !ok || string(raw) == "null" {
+		if required {
+			m.problem("missing %s", name)
+		}
+		return def
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var n json.Number
+	if json.Unmarshal(raw, &n) == nil {
+		m.problem("%s is a number", name)
+		return n.String()
+	}
+	m.problem("%s is not a string", name)
+	return def
+}
+
+// integer an int field, numeric strings are converted
+func (m *metadataFields) integer(name string, required bool, def int) int {
+	raw, ok := m.fields[name]
+	if !ok || string(raw) == "null" {
+		if required {
+			m.problem("missing %s", name)
+		}
+		return def
+	}
+	var n int
+	if json.Unmarshal(raw, &n) == nil {
+		return n
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if n, err := strconv.Atoi(s); err == nil {
+			m.problem("%s is a string", name)
+			return n
+		}
+	}
+	m.problem("%s is not a number", name)
+	return def
+}
+
+// boolean an optional bool field, "true" and "false" are converted
+func (m *metadataFields) boolean(name string) bool {
+	raw, ok := m.fields[name]
+	if !ok || string(raw) == "null" {
+		return false
+	}
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return b
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			m.problem("%s is a string", name)
+			return b
+		}
+	}
+	m.problem("%s is not a bool", name)
+	return false
+}
+
+// name a non empty visible name
+func (m *metadataFields) name(field string) string {
+	name := m.str(field, true, untitledName)
+	if strings.TrimSpace(name) == "" {
+		m.problem("empty %s", field)
+		return untitledName
+	}
+	return name
+}
+
+// docType DocumentType or CollectionType, guessed when it is neither
+func (m *metadataFields) docType(field, guess string) string {
+	t := m.str(field, true, guess)
+	if t != models.DocumentType && t != models.CollectionType {
+		m.problem("unknown %s %s", field, t)
+		return guess
+	}
+	return t
+}
+
+// guessType folders only have metadata and content
+func guessType(files []*models.HashEntry) string {
+	for _, f := range files {
+		switch path.Ext(f.EntryName) {
+		case models.MetadataFileExt, models.ContentFileExt:
+		default:
+			return models.DocumentType
+		}
+	}
+	return models.CollectionType
+}
+
+// checkBlobMetadata validates a sync15 metadata blob, the metadata has the defaults filled in
+// and the fields are the parsed json, to be patched with it. No problems means it is fine as it is
+func checkBlobMetadata(content []byte, files []*models.HashEntry) (models.MetadataFile, map[string]json.RawMessage, []string) {
+	m := parseMetadataFields(content)
+	meta := models.MetadataFile{
+		DocumentName:     m.name("visibleName"),
+		CollectionType:   m.docType("type", guessType(files)),
+		Parent:           m.str("parent", true, ""),
+		LastModified:     m.str("lastModified", true, strconv.FormatInt(time.Now().Unix(), 10)),
+		LastOpened:       m.str("lastOpened", false, ""),
+		Version:          m.integer("version", false, 0),
+		Pinned:           m.boolean("pinned"),
+		Synced:           m.boolean("synced"),
+		Modified:         m.boolean("modified"),
+		Deleted:          m.boolean("deleted"),
+		MetadataModified: m.boolean("metadatamodified"),
+	}
+	if _, err := strconv.ParseInt(meta.LastModified, 10, 64); err != nil {
+		m.problem("lastModified '%s' is not a timestamp", meta.LastModified)
+		meta.LastModified = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	return meta, m.fields, m.problems
+}
+
+// cachedMetadata the metadata of the cached tree when the blob is gone, with the defaults filled in
+func cachedMetadata(doc *models.HashDoc) models.MetadataFile {
+	js, _ := json.Marshal(doc.MetadataFile)
+	meta, _, _ := checkBlobMetadata(js, doc.Files)
+	return meta
+}
+
+// checkMetadata validates a sync10 metadata file
+func checkMetadata(content []byte, docid string, hasZip bool) (*messages.RawMetadata, []string) {
+	m := parseMetadataFields(content)
+	guess := models.CollectionType
+	if hasZip {
+		guess = models.DocumentType
+	}
+	meta := &messages.RawMetadata{
+		ID:             m.str("ID", true, docid),
+		Version:        m.integer("Version", true, 0),
+		ModifiedClient: m.str("ModifiedClient", true, time.Now().UTC().Format(time.RFC3339Nano)),
+		Type:           m.docType("Type", guess),
+		VissibleName:   m.name("VissibleName"),
+		CurrentPage:    m.integer("CurrentPage", false, 0),
+		Bookmarked:     m.boolean("Bookmarked"),
+		Parent:         m.str("Parent", true, ""),
+	}
+	if meta.ID != docid {
+		m.problem("ID %s doesn't match the file", meta.ID)
+		meta.ID = docid
+	}
+	if _, err := time.Parse(time.RFC3339Nano, meta.ModifiedClient); err != nil {
+		m.problem("ModifiedClient '%s' is not a time", meta.ModifiedClient)
+		meta.ModifiedClient = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	return meta, m.problems
+}
+
+// RepairBlobMetadata checks the metadata of the sync15 documents and rewrites the broken ones
+// as a new version, with dryRun only the problems are reported
+func (fs *FileSystemStorage) RepairBlobMetadata(uid string, dryRun bool) ([]*storage.MetadataRepair, error) {
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	repairs := make([]*storage.MetadataRepair, 0)
+	for _, doc := range tree.Docs {
+		var metadataEntry *models.HashEntry
+		for _, f := range doc.Files {
+			if path.Ext(f.EntryName) == models.MetadataFileExt {
+				metadataEntry = f
+			}
+		}
+		var meta models.MetadataFile
+		// the parsed json, the fields newer firmwares write are kept
+		var fields map[string]json.RawMessage
+		var problems []string
+		if metadataEntry == nil {
+			meta = cachedMetadata(doc)
+			problems = []string{"no metadata file"}
+		} else {
+			content, err := fs.readBlob(uid, metadataEntry.Hash)
+			switch {
+			case err == ErrorNotFound:
+				meta = cachedMetadata(doc)
+				problems = []string{"the metadata blob is missing"}
+			case err != nil:
+				return nil, err
+			default:
+				meta, fields, problems = checkBlobMetadata(content, doc.Files)
+			}
+		}
+		if len(problems) == 0 {
+			continue
+		}
+
+		repair := &storage.MetadataRepair{
+			ID:       doc.EntryName,
+			Name:     meta.DocumentName,
+			Problems: problems,
+		}
+		repairs = append(repairs, repair)
+		if dryRun {
+			log.Info("broken metadata: ", doc.EntryName, " ", strings.Join(problems, ", "))
+			continue
+		}
+
+		if metadataEntry == nil {
+			doc.Files = append(doc.Files, models.NewFileHashEntry("", doc.EntryName+models.MetadataFileExt))
+		}
+		doc.MetadataFile = meta
+		err = fs.saveBlobDocumentFields(uid, doc, fields)
+		if err != nil {
+			return nil, err
+		}
+		repair.Repaired = true
+		log.Info("repaired metadata: ", doc.EntryName, " ", strings.Join(problems, ", "))
+	}
+
+	if dryRun || len(repairs) == 0 {
+		return repairs, nil
+	}
+	err = tree.Rehash()
+	if err != nil {
+		return nil, err
+	}
+	err = fs.commitTree(uid, tree)
+	if err != nil {
+		return nil, err
+	}
+	return repairs, nil
+}
+
+// RepairMetadata checks the sync10 metadata files and rewrites the broken ones with the version bumped
+// with dryRun only the problems are reported
+func (fs *FileSystemStorage) RepairMetadata(uid string, dryRun bool) ([]*storage.MetadataRepair, error) {
+	files, err := ioutil.ReadDir(fs.getUserPath(uid))
+	if err != nil {
+		return nil, err
+	}
+
+	repairs := make([]*storage.MetadataRepair, 0)
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != models.MetadataFileExt {
+			continue
+		}
+		docid := strings.TrimSuffix(f.Name(), models.MetadataFileExt)
+		content, err := ioutil.ReadFile(fs.getPathFromUser(uid, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		hasZip := fileSize(fs.getPathFromUser(uid, docid+models.ZipFileExt)) > 0
+		meta, problems := checkMetadata(content, docid, hasZip)
+		if len(problems) == 0 {
+			continue
+		}
+
+		repair := &storage.MetadataRepair{
+			ID:       docid,
+			Name:     meta.VissibleName,
+			Problems: problems,
+		}
+		repairs = append(repairs, repair)
+		if dryRun {
+			log.Info("broken metadata: ", docid, " ", strings.Join(problems, ", "))
+			continue
+		}
+
+		meta.Version++
+		err = fs.UpdateMetadata(uid, meta)
+		if err != nil {
+			return nil, err
+		}
+		repair.Repaired = true
+		log.Info("repaired metadata: ", docid, " ", strings.Join(problems, ", "))
+	}
+	return repairs, nil
+}
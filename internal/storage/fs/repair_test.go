@@ -0,0 +1,147 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+func TestCheckBlobMetadata(t *testing.T) {
+	pdf := []*models.HashEntry{{EntryName: "doc.metadata"}, {EntryName: "doc.pdf"}}
+	tests := []struct {
+		name     string
+		content  string
+		files    []*models.HashEntry
+		problems int
+		check    func(m models.MetadataFile) bool
+	}{
+		{"valid", `{"visibleName":"a","type":"DocumentType","parent":"","lastModified":"1641092645123","version":2}`, pdf, 0,
+			func(m models.MetadataFile) bool { return m.Version == 2 }},
+		{"newer firmware", `{"visibleName":"a","type":"CollectionType","parent":"p","lastModified":"1","createdTime":"1","pinned":false}`, pdf, 0,
+			func(m models.MetadataFile) bool { return m.Parent == "p" }},
+		{"number timestamp", `{"visibleName":"a","type":"DocumentType","parent":"","lastModified":1641092645123}`, pdf, 1,
+			func(m models.MetadataFile) bool { return m.LastModified == "1641092645123" }},
+		{"string version", `{"visibleName":"a","type":"DocumentType","parent":"","lastModified":"1","version":"3"}`, pdf, 1,
+			func(m models.MetadataFile) bool { return m.Version == 3 }},
+		{"missing name and type", `{"parent":"","lastModified":"1"}`, pdf, 2,
+			func(m models.MetadataFile) bool {
+				return m.DocumentName == untitledName && m.CollectionType == models.DocumentType
+			}},
+		{"folder guessed", `{"visibleName":"f","parent":"","lastModified":"1"}`, []*models.HashEntry{{EntryName: "f.metadata"}, {EntryName: "f.content"}}, 1,
+			func(m models.MetadataFile) bool { return m.CollectionType == models.CollectionType }},
+		{"not json", `{visibleName`, pdf, 5,
+			func(m models.MetadataFile) bool { return m.DocumentName == untitledName }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, _, problems := checkBlobMetadata([]byte(tt.content), tt.files)
+			if len(problems) != tt.problems {
+				t.Errorf("expected %d problems, got %v", tt.problems, problems)
+			}
+			if !tt.check(meta) {
+				t.Errorf("wrong metadata %+v", meta)
+			}
+		})
+	}
+}
+
+func TestRepairMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: dir})
+	err = os.MkdirAll(fs.getUserBlobPath(testuser), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sync10
+	err = ioutil.WriteFile(fs.getPathFromUser(testuser, "broken"+models.MetadataFileExt), []byte(`{"ID":"broken","Version":"1","VissibleName":"","Type":"CollectionType","Parent":"","ModifiedClient":"2022-01-02T03:04:05Z"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repairs, err := fs.RepairMetadata(testuser, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repairs) != 1 || repairs[0].Repaired || len(repairs[0].Problems) != 2 {
+		t.Fatalf("expected 1 broken document, got %+v", repairs)
+	}
+	repairs, err = fs.RepairMetadata(testuser, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repairs) != 1 || !repairs[0].Repaired {
+		t.Fatalf("expected 1 repaired document, got %+v", repairs)
+	}
+	meta, err := fs.GetMetadata(testuser, "broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Version != 2 || meta.VissibleName != untitledName {
+		t.Errorf("not repaired %+v", meta)
+	}
+	repairs, _ = fs.RepairMetadata(testuser, false)
+	if len(repairs) != 0 {
+		t.Errorf("expected nothing to repair, got %+v", repairs)
+	}
+
+	// sync15
+	doc, err := fs.CreateBlobDocument(testuser, "blob.pdf", "", strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, _ := fs.GetTree(testuser)
+	hashDoc, _ := tree.FindDoc(doc.ID)
+	for _, f := range hashDoc.Files {
+		if path.Ext(f.EntryName) == models.MetadataFileExt {
+			// a firmware quirk
+			err = ioutil.WriteFile(path.Join(fs.getUserBlobPath(testuser), f.Hash), []byte(`{"visibleName":"blob","type":"DocumentType","parent":null,"lastModified":12,"createdTime":"1641092645123"}`), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	repairs, err = fs.RepairBlobMetadata(testuser, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repairs) != 1 || repairs[0].Repaired || repairs[0].ID != doc.ID {
+		t.Fatalf("expected 1 broken document, got %+v", repairs)
+	}
+	generation := tree.Generation
+	repairs, err = fs.RepairBlobMetadata(testuser, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repairs) != 1 || !repairs[0].Repaired {
+		t.Fatalf("expected 1 repaired document, got %+v", repairs)
+	}
+	tree, _ = fs.GetTree(testuser)
+	hashDoc, _ = tree.FindDoc(doc.ID)
+	if tree.Generation == generation || hashDoc.Version != 1 || hashDoc.DocumentName != "blob" {
+		t.Errorf("not repaired %+v", hashDoc.MetadataFile)
+	}
+	for _, f := range hashDoc.Files {
+		if path.Ext(f.EntryName) != models.MetadataFileExt {
+			continue
+		}
+		content, err := fs.readBlob(testuser, f.Hash)
+		if err != nil || !strings.Contains(string(content), `"createdTime":"1641092645123"`) || !strings.Contains(string(content), `"parent":""`) {
+			t.Errorf("the unknown fields were dropped: %s %v", content, err)
+		}
+	}
+	repairs, _ = fs.RepairBlobMetadata(testuser, false)
+	if len(repairs) != 0 {
+		t.Errorf("expected nothing to repair, got %+v", repairs)
+	}
+}
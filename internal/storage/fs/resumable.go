@@ -0,0 +1,402 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	contentRangeHeader = "Content-Range"
+	// partialUploadDir the chunks received so far of the resumable uploads, in the user's folder
+	partialUploadDir = ".uploads"
+	// partialUploadTTL an upload which isn't resumed for this long starts over
+	partialUploadTTL = 24 * time.Hour
+	// sweepInterval how often the abandoned uploads are removed
+	sweepInterval = time.Hour
+	// sealedChunkExt the chunks received while encryption is enabled
+	sealedChunkExt = ".sealed"
+	// statusResumeIncomplete gcs' answer to every chunk but the last
+	statusResumeIncomplete = 308
+)
+
+// contentRange of an upload chunk, first is -1 when the client asks what was received (bytes */total)
+// and total -1 until the client knows the size (bytes 0-99/*)
+type contentRange struct {
+	first, last, total int64
+}
+
+func parseContentRange(header string) (r contentRange, err error) {
+	invalid := fmt.Errorf("invalid %s '%s'", contentRangeHeader, header)
+	spec := strings.TrimPrefix(header, "bytes ")
+	i := strings.Index(spec, "/")
+	if spec == header || i < 0 {
+		return r, invalid
+	}
+	byteRange, total := spec[:i], spec[i+1:]
+
+	r.total = -1
+	if total != "*" {
+		r.total, err = strconv.ParseInt(total, 10, 64)
+		if err != nil || r.total < 0 {
+			return r, invalid
+		}
+	}
+	if byteRange == "*" {
+		r.first, r.last = -1, -1
+		return r, nil
+	}
+	j := strings.Index(byteRange, "-")
+	if j < 0 {
+		return r, invalid
+	}
+	r.first, err = strconv.ParseInt(byteRange[:j], 10, 64)
+	if err != nil {
+		return r, invalid
+	}
+	r.last, err = strconv.ParseInt(byteRange[j+1:], 10, 64)
+	if err != nil || r.first < 0 || r.last < r.first || (r.total >= 0 && r.last >= r.total) {
+		return r, invalid
+	}
+	return r, nil
+}
+
+// uploads the resumable uploads receiving a chunk
+type uploads struct {
+	lock   sync.Mutex
+	active map[string]bool
+}
+
+// begin false when a chunk of the upload is already being received
+func (u *uploads) begin(key string) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.active == nil {
+		u.active = make(map[string]bool)
+	}
+	if u.active[key] {
+		return false
+	}
+	u.active[key] = true
+	return true
+}
+
+func (u *uploads) end(key string) {
+	u.lock.Lock()
+	delete(u.active, key)
+	u.lock.Unlock()
+}
+
+// quotaLimited providers with a storage quota
+type quotaLimited interface {
+	quotaLeft(uid string) (left int64, ok bool, err error)
+}
+
+// partialUploadPath the dir with the chunks received so far, each in a file named after its first byte
+func (app *App) partialUploadPath(uid, blobID string) string {
+	return filepath.Join(app.cfg.DataDir, userDir, sanitizeFileName(uid), partialUploadDir, common.Sanitize(blobID))
+}
+
+// partialChunk a chunk of a resumable upload as received
+type partialChunk struct {
+	path   string
+	sealed bool
+}
+
+// chunkName the file of the chunk starting at first, sorted like the chunks
+func chunkName(first int64, sealed bool) string {
+	name := fmt.Sprintf("%020d", first)
+	if sealed {
+		name += sealedChunkExt
+	}
+	return name
+}
+
+// partialChunks the chunks received so far and their size, stale or damaged uploads are removed
+func (app *App) partialChunks(uid, partialPath string) ([]partialChunk, int64) {
+	fi, err := os.Stat(partialPath)
+	if err != nil {
+		return nil, 0
+	}
+	if time.Since(fi.ModTime()) > partialUploadTTL {
+		os.RemoveAll(partialPath)
+		return nil, 0
+	}
+	files, err := ioutil.ReadDir(partialPath)
+	if err != nil {
+		return nil, 0
+	}
+	chunks := make([]partialChunk, 0, len(files))
+	received := int64(0)
+	for _, f := range files {
+		name := f.Name()
+		chunk := partialChunk{
+			path:   filepath.Join(partialPath, name),
+			sealed: strings.HasSuffix(name, sealedChunkExt),
+		}
+		first, err := strconv.ParseInt(strings.TrimSuffix(name, sealedChunkExt), 10, 64)
+		var size int64
+		if err == nil && first == received {
+			size, err = app.chunkSize(uid, chunk)
+		} else if err == nil {
+			err = fmt.Errorf("chunk %s doesn't start at %d", name, received)
+		}
+		if err != nil {
+			log.Warn("restarting the upload, ", err)
+			os.RemoveAll(partialPath)
+			return nil, 0
+		}
+		chunks = append(chunks, chunk)
+		received += size
+	}
+	return chunks, received
+}
+
+// chunkSize the size of the chunk as received, before it was encrypted
+func (app *App) chunkSize(uid string, chunk partialChunk) (int64, error) {
+	f, err := app.openChunk(uid, chunk)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.Seek(0, io.SeekEnd)
+}
+
+func (app *App) openChunk(uid string, chunk partialChunk) (storedFile, error) {
+	f, err := os.Open(chunk.path)
+	if err != nil {
+		return nil, err
+	}
+	if !chunk.sealed {
+		return f, nil
+	}
+	st, err := f.Stat()
+	if err == nil {
+		var sf storedFile
+		sf, _, err = app.sealer.unseal(uid, f, st.Size())
+		if err == nil {
+			return sf, nil
+		}
+	}
+	f.Close()
+	return nil, err
+}
+
+// openPartial the received chunks as one reader
+func (app *App) openPartial(uid string, chunks []partialChunk) (io.ReadCloser, error) {
+	complete := &chunksReader{}
+	readers := make([]io.Reader, 0, len(chunks))
+	for _, chunk := range chunks {
+		f, err := app.openChunk(uid, chunk)
+		if err != nil {
+			complete.Close()
+			return nil, err
+		}
+		complete.files = append(complete.files, f)
+		readers = append(readers, f)
+	}
+	complete.Reader = io.MultiReader(readers...)
+	return complete, nil
+}
+
+// chunksReader reads the chunks one after the other
+type chunksReader struct {
+	io.Reader
+	files []storedFile
+}
+
+func (r *chunksReader) Close() error {
+	for _, f := range r.files {
+		f.Close()
+	}
+	return nil
+}
+
+// resumeIncomplete tells the client what was received so far, it sends the rest from there
+func resumeIncomplete(c *gin.Context, received int64) {
+	if received > 0 {
+		c.Header("Range", fmt.Sprintf("bytes=0-%d", received-1))
+	}
+	c.Status(statusResumeIncomplete)
+}
+
+// uploadChunk appends a chunk of a resumable upload, like the uploads to gcs with a Content-Range.
+// A chunk which doesn't start where the received part ends, or a status query, gets a 308 with
+// the Range received, the blob is stored once all of it is there
+func (app *App) uploadChunk(c *gin.Context, uid, blobID, header string, expectedHash map[string]string) {
+	cr, err := parseContentRange(header)
+	if err != nil {
+		log.Warn(err)
+		abortWithGCSError(c, http.StatusBadRequest, errorInvalidArgument)
+		return
+	}
+
+	key := uid + "/" + blobID
+	if !app.resumable.begin(key) {
+		abortWithGCSError(c, http.StatusConflict, errorUploadInProgress)
+		return
+	}
+	defer app.resumable.end(key)
+
+	partialPath := app.partialUploadPath(uid, blobID)
+	chunks, received := app.partialChunks(uid, partialPath)
+	if cr.first == 0 && received > 0 {
+		log.Debug("restarting the upload of ", blobID)
+		os.RemoveAll(partialPath)
+		chunks, received = nil, 0
+	}
+	if cr.first >= 0 && cr.first != received {
+		resumeIncomplete(c, received)
+		return
+	}
+
+	if cr.first >= 0 {
+		length := cr.last - cr.first + 1
+		if c.Request.ContentLength >= 0 && c.Request.ContentLength != length {
+			log.Warnf("chunk of %s: %d bytes for %s", blobID, c.Request.ContentLength, header)
+			abortWithGCSError(c, http.StatusBadRequest, errorInvalidArgument)
+			return
+		}
+		// one more byte than the range tells a longer body
+		chunk := io.LimitReader(c.Request.Body, length+1)
+		if limited, ok := app.blobs.(quotaLimited); ok {
+			left, hasQuota, err := limited.quotaLeft(uid)
+			if err != nil {
+				log.Error(err)
+				abortWithGCSError(c, http.StatusInternalServerError, errorInternal)
+				return
+			}
+			if hasQuota {
+				if cr.total > left {
+					os.RemoveAll(partialPath)
+					log.Warn("over quota: ", uid)
+					abortWithGCSError(c, http.StatusRequestEntityTooLarge, errorQuotaExceeded)
+					return
+				}
+				chunk = &quotaReader{r: chunk, left: left - received}
+			}
+		}
+
+		saved, n, err := app.appendChunk(uid, partialPath, cr.first, app.throttle(c, uid, chunk, true))
+		if errors.Is(err, storage.ErrorOverQuota) {
+			os.RemoveAll(partialPath)
+			log.Warn("over quota: ", uid)
+			abortWithGCSError(c, http.StatusRequestEntityTooLarge, errorQuotaExceeded)
+			return
+		}
+		if n > length || (err == nil && n < length) {
+			os.Remove(saved.path)
+			log.Warnf("chunk of %s: %d bytes for %s", blobID, n, header)
+			abortWithGCSError(c, http.StatusBadRequest, errorInvalidArgument)
+			return
+		}
+		if err != nil {
+			// the connection dropped, the client resumes from what was received
+			log.Warn("chunk of ", blobID, " interrupted: ", err)
+		}
+		if n > 0 {
+			chunks = append(chunks, saved)
+			received += n
+		} else if saved.path != "" {
+			os.Remove(saved.path)
+		}
+	}
+
+	if cr.total < 0 || received < cr.total {
+		resumeIncomplete(c, received)
+		return
+	}
+	defer os.RemoveAll(partialPath)
+	if received > cr.total {
+		abortWithGCSError(c, http.StatusBadRequest, errorInvalidArgument)
+		return
+	}
+
+	complete, err := app.openPartial(uid, chunks)
+	if err != nil {
+		log.Error(err)
+		abortWithGCSError(c, http.StatusInternalServerError, errorInternal)
+		return
+	}
+	defer complete.Close()
+	app.storeUpload(c, uid, blobID, complete, expectedHash)
+}
+
+// appendChunk saves the chunk starting at first, encrypted when enabled
+func (app *App) appendChunk(uid, partialPath string, first int64, chunk io.Reader) (partialChunk, int64, error) {
+	saved := partialChunk{}
+	err := os.MkdirAll(partialPath, 0700)
+	if err != nil {
+		return saved, 0, err
+	}
+	sealed := app.sealer.encrypting()
+	f, err := os.OpenFile(filepath.Join(partialPath, chunkName(first, sealed)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return saved, 0, err
+	}
+	saved = partialChunk{path: f.Name(), sealed: sealed}
+	w, err := app.sealer.sealWriter(uid, f)
+	if err != nil {
+		f.Close()
+		return saved, 0, err
+	}
+	n, err := io.Copy(w, chunk)
+	// what was received is kept when the connection dropped
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return saved, n, err
+}
+
+// SweepUploads removes the partial uploads not resumed for partialUploadTTL, every sweepInterval until stop is closed
+func (app *App) SweepUploads(stop <-chan struct{}) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			app.sweepUploads(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sweepUploads removes the partial uploads of every user not resumed since partialUploadTTL before now
+func (app *App) sweepUploads(now time.Time) {
+	users, err := ioutil.ReadDir(filepath.Join(app.cfg.DataDir, userDir))
+	if err != nil {
+		log.Warn("sweeping the uploads: ", err)
+		return
+	}
+	for _, u := range users {
+		uploadsPath := filepath.Join(app.cfg.DataDir, userDir, u.Name(), partialUploadDir)
+		partials, err := ioutil.ReadDir(uploadsPath)
+		if err != nil {
+			continue
+		}
+		for _, p := range partials {
+			if now.Sub(p.ModTime()) <= partialUploadTTL {
+				continue
+			}
+			log.Info("removing the abandoned upload ", p.Name(), " of ", u.Name())
+			os.RemoveAll(filepath.Join(uploadsPath, p.Name()))
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package fs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   contentRange
+		err    bool
+	}{
+		{"bytes 0-99/*", contentRange{0, 99, -1}, false},
+		{"bytes 100-199/200", contentRange{100, 199, 200}, false},
+		{"bytes */200", contentRange{-1, -1, 200}, false},
+		{"bytes */*", contentRange{-1, -1, -1}, false},
+		{"0-99/*", contentRange{}, true},
+		{"bytes 10-5/*", contentRange{}, true},
+		{"bytes 0-200/200", contentRange{}, true},
+		{"bytes 0-99", contentRange{}, true},
+		{"bytes a-b/*", contentRange{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseContentRange(tt.header)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.header, err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{DataDir: dir, JWTSecretKey: []byte("secret")}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	fsStorage := NewStorage(cfg)
+	app := NewApp(cfg, fsStorage, fsStorage)
+	app.RegisterRoutes(router)
+	if err = os.MkdirAll(fsStorage.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(contentRange, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, signedBlobURL(t, cfg, "test", "blob", "write"), strings.NewReader(body))
+		req.Header.Set(contentRangeHeader, contentRange)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	steps := []struct {
+		contentRange, body string
+		code               int
+		received           string
+	}{
+		{"bytes 0-3/*", "0123", statusResumeIncomplete, "bytes=0-3"},
+		// the status after an interruption
+		{"bytes */10", "", statusResumeIncomplete, "bytes=0-3"},
+		// not where the received part ends
+		{"bytes 6-9/10", "6789", statusResumeIncomplete, "bytes=0-3"},
+		// not as long as the range
+		{"bytes 4-9/10", "4567", http.StatusBadRequest, ""},
+		{"bytes 4-9/10", "456789", http.StatusOK, ""},
+	}
+	for _, s := range steps {
+		w := put(s.contentRange, s.body)
+		if w.Code != s.code || w.Header().Get("Range") != s.received {
+			t.Fatalf("%s: %d %s, want %d %s", s.contentRange, w.Code, w.Header().Get("Range"), s.code, s.received)
+		}
+	}
+
+	content, err := ioutil.ReadFile(path.Join(fsStorage.getUserBlobPath("test"), "blob"))
+	if err != nil || string(content) != "0123456789" {
+		t.Errorf("stored %s %v", content, err)
+	}
+	if _, err = os.Stat(app.partialUploadPath("test", "blob")); !os.IsNotExist(err) {
+		t.Error("the partial upload was kept")
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, signedBlobURL(t, cfg, "test", "blob", "read"), nil)
+	req.Header.Set("Range", "bytes=4-")
+	req.Header.Set("If-Range", `"other"`)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Length") != "10" {
+		t.Errorf("If-Range of another blob: %d, length %s", w.Code, w.Header().Get("Content-Length"))
+	}
+	w = httptest.NewRecorder()
+	req.Header.Set("If-Range", `"blob"`)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "456789" {
+		t.Errorf("If-Range: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestEncryptedResumableUpload(t *testing.T) {
+	cfg := &config.Config{DataDir: t.TempDir(), JWTSecretKey: []byte("secret"), EncryptionKey: make([]byte, 32)}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	fsStorage := NewStorage(cfg)
+	app := NewApp(cfg, fsStorage, fsStorage)
+	app.RegisterRoutes(router)
+	if err := os.MkdirAll(fsStorage.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(contentRange, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, signedBlobURL(t, cfg, "test", "blob", "write"), strings.NewReader(body))
+		req.Header.Set(contentRangeHeader, contentRange)
+		// sent chunked, the length is only known from the body
+		req.ContentLength = -1
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := put("bytes 0-3/10", "0123"); w.Code != statusResumeIncomplete {
+		t.Fatalf("first chunk: %d", w.Code)
+	}
+	chunks, err := ioutil.ReadDir(app.partialUploadPath("test", "blob"))
+	if err != nil || len(chunks) != 1 {
+		t.Fatalf("chunks %v %v", chunks, err)
+	}
+	saved, err := ioutil.ReadFile(path.Join(app.partialUploadPath("test", "blob"), chunks[0].Name()))
+	if err != nil || strings.Contains(string(saved), "0123") {
+		t.Errorf("the chunk is not encrypted: %q %v", saved, err)
+	}
+
+	if w := put("bytes 4-9/10", "456789X"); w.Code != http.StatusBadRequest {
+		t.Errorf("longer than the range: %d", w.Code)
+	}
+	if w := put("bytes */10", ""); w.Header().Get("Range") != "bytes=0-3" {
+		t.Errorf("after the longer chunk: %s", w.Header().Get("Range"))
+	}
+	if w := put("bytes 4-9/10", "456789"); w.Code != http.StatusOK {
+		t.Fatalf("last chunk: %d", w.Code)
+	}
+	r, _, err := fsStorage.LoadBlob("test", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	content, err := ioutil.ReadAll(r)
+	if err != nil || string(content) != "0123456789" {
+		t.Errorf("stored %s %v", content, err)
+	}
+}
+
+func TestSweepUploads(t *testing.T) {
+	cfg := &config.Config{DataDir: t.TempDir()}
+	app := NewApp(cfg, nil, nil)
+	now := time.Now()
+	for blobID, age := range map[string]time.Duration{
+		"abandoned": partialUploadTTL + time.Hour,
+		"resumed":   time.Hour,
+	} {
+		partialPath := app.partialUploadPath("test", blobID)
+		if _, _, err := app.appendChunk("test", partialPath, 0, strings.NewReader("0123")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(partialPath, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app.sweepUploads(now)
+	if _, err := os.Stat(app.partialUploadPath("test", "abandoned")); !os.IsNotExist(err) {
+		t.Error("the abandoned upload was kept")
+	}
+	if _, err := os.Stat(app.partialUploadPath("test", "resumed")); err != nil {
+		t.Error("the resumed upload was removed: ", err)
+	}
+}
@@ -0,0 +1,203 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// sharedBlobDir the sync15 blobs of all the users, named by the sha256 of their content.
+// The blobs of the users are hard links to these, a blob identical for several documents or
+// users is stored once. The links are the references: when only the shared one is left it is removed
+const sharedBlobDir = "blobs"
+
+// ErrorNoSharing the blobs can't be shared, they are encrypted or the os has no hard links
+var ErrorNoSharing = errors.New("the blobs can't be shared")
+
+// SharingStats what sharing the blobs of a user saved
+type SharingStats struct {
+	Shared     int
+	Skipped    int
+	BytesSaved int64
+}
+
+// sharingBlobs whether identical blobs are stored once, the encrypted ones differ for each user
+func (fs *FileSystemStorage) sharingBlobs() bool {
+	return hardLinks && !fs.encrypting()
+}
+
+func (fs *FileSystemStorage) sharedBlobPath(hash string) string {
+	return filepath.Join(fs.Cfg.DataDir, sharedBlobDir, hash[:2], hash)
+}
+
+// isContentHash whether the blob id is a sha256, the blobs of the root index aren't
+func isContentHash(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// placeBlob moves the written temp file to the blob. When the id is the checksum of the content
+// it is linked to the shared blob, which is created if this is the first copy
+func (fs *FileSystemStorage) placeBlob(id, checksum, tmpPath, blobPath string) error {
+	if !fs.sharingBlobs() || id != checksum || !isContentHash(id) {
+		return os.Rename(tmpPath, blobPath)
+	}
+	shared := fs.sharedBlobPath(id)
+	err := os.Link(tmpPath, shared)
+	if os.IsNotExist(err) {
+		if err = os.MkdirAll(path.Dir(shared), 0700); err == nil {
+			err = os.Link(tmpPath, shared)
+		}
+	}
+	if os.IsExist(err) {
+		// stored before, by this or another user
+		linkPath := tmpPath + ".link"
+		defer os.Remove(linkPath)
+		if err = os.Link(shared, linkPath); err == nil {
+			if err = os.Rename(linkPath, blobPath); err == nil {
+				return nil
+			}
+		}
+	}
+	if err != nil {
+		log.Debug("can't share the blob ", id, ": ", err)
+	}
+	return os.Rename(tmpPath, blobPath)
+}
+
+// removeBlob removes the blob of the user, and the shared one when no other user has it
+func (fs *FileSystemStorage) removeBlob(uid, id string) error {
+	err := os.Remove(path.Join(fs.getUserBlobPath(uid), common.Sanitize(id)))
+	if err != nil {
+		return err
+	}
+	fs.releaseBlob(id)
+	return nil
+}
+
+// releaseBlob removes the shared blob once it is the only link left
+func (fs *FileSystemStorage) releaseBlob(id string) {
+	if !isContentHash(id) {
+		return
+	}
+	shared := fs.sharedBlobPath(id)
+	if links, ok := linkCount(shared); ok && links == 1 {
+		os.Remove(shared)
+	}
+}
+
+// pruneSharedBlobs removes the shared blobs no user has anymore, e.g. after a user was removed
+func (fs *FileSystemStorage) pruneSharedBlobs() (removed int, err error) {
+	root := filepath.Join(fs.Cfg.DataDir, sharedBlobDir)
+	shards, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		blobs, err := ioutil.ReadDir(filepath.Join(root, shard.Name()))
+		if err != nil {
+			return removed, err
+		}
+		for _, b := range blobs {
+			shared := filepath.Join(root, shard.Name(), b.Name())
+			if links, ok := linkCount(shared); ok && links == 1 && os.Remove(shared) == nil {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+// ShareExisting links the blobs the user stored before to the shared ones, the identical blobs
+// of the users are then stored once. The blobs whose content doesn't match their id are skipped
+func (fs *FileSystemStorage) ShareExisting(uid string) (*SharingStats, error) {
+	if !fs.sharingBlobs() {
+		return nil, ErrorNoSharing
+	}
+	stats := &SharingStats{}
+	blobPath := fs.getUserBlobPath(uid)
+	entries, err := ioutil.ReadDir(blobPath)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.Mode().IsRegular() || !isContentHash(e.Name()) {
+			continue
+		}
+		filePath := filepath.Join(blobPath, e.Name())
+		if links, ok := linkCount(filePath); !ok || links > 1 {
+			continue
+		}
+		checksum, err := fs.storedChecksum(uid, filePath)
+		if err != nil {
+			return nil, err
+		}
+		if checksum != e.Name() {
+			stats.Skipped++
+			continue
+		}
+
+		shared := fs.sharedBlobPath(e.Name())
+		if err = os.MkdirAll(path.Dir(shared), 0700); err != nil {
+			return nil, err
+		}
+		err = os.Link(filePath, shared)
+		if err == nil {
+			stats.Shared++
+			continue
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		// keep the newer time, the gc relies on it
+		if sharedInfo, err := os.Stat(shared); err == nil && e.ModTime().After(sharedInfo.ModTime()) {
+			os.Chtimes(shared, e.ModTime(), e.ModTime())
+		}
+		linkPath := filepath.Join(blobPath, "."+e.Name()+".link")
+		if err = os.Link(shared, linkPath); err != nil {
+			return nil, err
+		}
+		if err = os.Rename(linkPath, filePath); err != nil {
+			os.Remove(linkPath)
+			return nil, err
+		}
+		stats.Shared++
+		stats.BytesSaved += e.Size()
+	}
+	fs.usageChanged(uid)
+	return stats, nil
+}
+
+// storedChecksum the sha256 of the content of the blob
+func (fs *FileSystemStorage) storedChecksum(uid, filePath string) (string, error) {
+	f, _, err := fs.openStored(uid, filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,100 @@
+//go:build !windows
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+)
+
+// sha256 of "blah"
+const blahID = "8b7df143d91c716ecfa5fc1730022f6b421b05cedee8fd52b1fc65a96030ad52"
+
+func TestSharedBlobs(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	for _, uid := range []string{"a", "b"} {
+		if err := os.MkdirAll(fs.getUserBlobPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.StoreBlob(uid, blahID, strings.NewReader("blah"), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shared, err := os.Stat(fs.sharedBlobPath(blahID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range []string{"a", "b"} {
+		fi, err := os.Stat(path.Join(fs.getUserBlobPath(uid), blahID))
+		if err != nil || !os.SameFile(fi, shared) {
+			t.Errorf("%s: not shared %v", uid, err)
+		}
+	}
+
+	// the content doesn't match the id
+	const wrongID = "0000000000000000000000000000000000000000000000000000000000000000"
+	if _, err = fs.StoreBlob("a", wrongID, strings.NewReader("blah"), -1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(fs.sharedBlobPath(wrongID)); !os.IsNotExist(err) {
+		t.Errorf("shared with the wrong id: %v", err)
+	}
+
+	if err = fs.removeBlob("a", blahID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(fs.sharedBlobPath(blahID)); err != nil {
+		t.Errorf("removed while b has it: %v", err)
+	}
+	if err = fs.removeBlob("b", blahID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(fs.sharedBlobPath(blahID)); !os.IsNotExist(err) {
+		t.Errorf("not removed with the last user: %v", err)
+	}
+}
+
+func TestShareExisting(t *testing.T) {
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	for _, uid := range []string{"a", "b"} {
+		blobPath := fs.getUserBlobPath(uid)
+		if err := os.MkdirAll(blobPath, 0700); err != nil {
+			t.Fatal(err)
+		}
+		// stored before the blobs were shared
+		if err := ioutil.WriteFile(path.Join(blobPath, blahID), []byte("blah"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first one becomes the shared blob
+	for i, uid := range []string{"a", "b"} {
+		stats, err := fs.ShareExisting(uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Shared != 1 || stats.BytesSaved != int64(i*4) {
+			t.Errorf("%s: %+v", uid, stats)
+		}
+	}
+	a, _ := os.Stat(path.Join(fs.getUserBlobPath("a"), blahID))
+	b, _ := os.Stat(path.Join(fs.getUserBlobPath("b"), blahID))
+	if !os.SameFile(a, b) {
+		t.Error("not linked")
+	}
+
+	// the removed user's references are gone
+	if err := fs.RemoveUser("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveUser("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fs.sharedBlobPath(blahID)); !os.IsNotExist(err) {
+		t.Errorf("not pruned: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// rootHistory the roots written, by generation - 1
+func (fs *FileSystemStorage) rootHistory(uid string) ([]*storage.Snapshot, error) {
+	if err := fs.localBlobs(); err != nil {
+		return nil, err
+	}
+	history, err := ioutil.ReadFile(path.Join(fs.getUserBlobPath(uid), historyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(history)), "\n")
+	roots := make([]*storage.Snapshot, 0, len(lines))
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		written, _ := time.Parse(time.RFC3339, fields[0])
+		roots = append(roots, &storage.Snapshot{
+			Generation: int64(i + 1),
+			Hash:       fields[1],
+			Time:       written,
+		})
+	}
+	return roots, nil
+}
+
+// checkSnapshot counts the documents of the root and whether all their blobs are still there
+func (fs *FileSystemStorage) checkSnapshot(uid string, s *storage.Snapshot) {
+	blobPath := fs.getUserBlobPath(uid)
+	docs, ok := fs.readIndex(uid, path.Join(blobPath, common.Sanitize(s.Hash)))
+	if !ok {
+		return
+	}
+	s.Documents = len(docs)
+	for _, d := range docs {
+		files, ok := fs.readIndex(uid, path.Join(blobPath, common.Sanitize(d.Hash)))
+		if !ok {
+			return
+		}
+		for _, f := range files {
+			if _, err := os.Stat(path.Join(blobPath, common.Sanitize(f.Hash))); err != nil {
+				return
+			}
+		}
+	}
+	s.Complete = true
+}
+
+// currentSnapshot the generation of the last root, 0 if there is none
+func currentSnapshot(roots []*storage.Snapshot) int64 {
+	if len(roots) == 0 {
+		return 0
+	}
+	return roots[len(roots)-1].Generation
+}
+
+// keepsSnapshot whether the generation is one of the last RootHistory
+func (fs *FileSystemStorage) keepsSnapshot(generation, current int64) bool {
+	return current-generation < int64(fs.Cfg.RootHistory)
+}
+
+// Snapshots the last RootHistory generations of the root, the newest first
+func (fs *FileSystemStorage) Snapshots(uid string) ([]*storage.Snapshot, error) {
+	roots, err := fs.rootHistory(uid)
+	if err != nil {
+		return nil, err
+	}
+	current := currentSnapshot(roots)
+	snapshots := make([]*storage.Snapshot, 0)
+	for i := len(roots) - 1; i >= 0 && fs.keepsSnapshot(roots[i].Generation, current); i-- {
+		s := roots[i]
+		s.Current = s.Generation == current
+		fs.checkSnapshot(uid, s)
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, nil
+}
+
+// RestoreSnapshot writes the root of the generation as a new generation, the devices sync back to it.
+// Only the kept generations whose blobs are all there can be restored, a root written
+// by a device in the meantime fails with ErrorWrongGeneration
+func (fs *FileSystemStorage) RestoreSnapshot(uid string, generation int64) (*storage.Snapshot, error) {
+	roots, err := fs.rootHistory(uid)
+	if err != nil {
+		return nil, err
+	}
+	current := currentSnapshot(roots)
+	var snapshot *storage.Snapshot
+	for _, s := range roots {
+		if s.Generation == generation && fs.keepsSnapshot(generation, current) {
+			snapshot = s
+		}
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("%w: generation %d is not kept", storage.ErrorSnapshotUnavailable, generation)
+	}
+	fs.checkSnapshot(uid, snapshot)
+	if !snapshot.Complete {
+		return nil, fmt.Errorf("%w: blobs of generation %d are missing", storage.ErrorSnapshotUnavailable, generation)
+	}
+
+	ls := &LocalBlobStorage{
+		fs:  fs,
+		uid: uid,
+	}
+	newGen, err := ls.WriteRootIndex(current, snapshot.Hash)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("restored the root of generation %d for %s as generation %d", generation, uid, newGen)
+	// updates the cached tree
+	_, err = fs.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Snapshot{
+		Generation: newGen,
+		Hash:       snapshot.Hash,
+		Time:       time.Now().UTC(),
+		Documents:  snapshot.Documents,
+		Complete:   true,
+		Current:    true,
+	}, nil
+}
@@ -0,0 +1,78 @@
+package fs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+func TestRestoreSnapshot(t *testing.T) {
+	testuser := "test"
+	dir, err := ioutil.TempDir("", "rmfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewStorage(&config.Config{DataDir: dir, RootHistory: 2})
+	err = os.MkdirAll(fs.getUserBlobPath(testuser), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := fs.CreateBlobDocument(testuser, "first.pdf", "", strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.CreateBlobDocument(testuser, "second.pdf", "", strings.NewReader("another pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := fs.Snapshots(testuser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Generation != 2 || !snapshots[0].Current || snapshots[1].Current {
+		t.Fatalf("snapshots: %+v", snapshots)
+	}
+	if snapshots[1].Documents != 1 || !snapshots[1].Complete {
+		t.Errorf("generation 1: %+v", snapshots[1])
+	}
+
+	restored, err := fs.RestoreSnapshot(testuser, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Generation != 3 || restored.Documents != 1 {
+		t.Errorf("restored: %+v", restored)
+	}
+	tree, err := fs.GetTree(testuser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Generation != 3 || len(tree.Docs) != 1 || tree.Docs[0].EntryName != first.ID {
+		t.Errorf("tree of generation %d: %d docs", tree.Generation, len(tree.Docs))
+	}
+
+	// only the last 2 are kept
+	_, err = fs.RestoreSnapshot(testuser, 1)
+	if !errors.Is(err, storage.ErrorSnapshotUnavailable) {
+		t.Errorf("not kept: %v", err)
+	}
+
+	os.Remove(path.Join(fs.getUserBlobPath(testuser), tree.Docs[0].Files[0].Hash))
+	snapshots, _ = fs.Snapshots(testuser)
+	if snapshots[0].Complete {
+		t.Error("a blob is missing")
+	}
+	_, err = fs.RestoreSnapshot(testuser, 2)
+	if !errors.Is(err, storage.ErrorSnapshotUnavailable) {
+		t.Errorf("incomplete: %v", err)
+	}
+}
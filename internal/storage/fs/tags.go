@@ -0,0 +1,87 @@
+package fs
+
+import (
+	"encoding/json"
+
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// contentTags the document tags in the .content file, the page tags are left out
+func contentTags(content []byte) ([]string, error) {
+	var c struct {
+		Tags []struct {
+			Name string `json:"name"`
+		} `json:"tags"`
+	}
+	err := json.Unmarshal(content, &c)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(c.Tags))
+	for _, t := range c.Tags {
+		tags = append(tags, t.Name)
+	}
+	return tags, nil
+}
+
+// BlobDocumentTags the tags of the sync15 documents, by id
+func (fs *FileSystemStorage) BlobDocumentTags(uid string) (map[string][]string, error) {
+	tree, err := fs.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string][]string)
+	for _, doc := range tree.Docs {
+		if doc.CollectionType == models.CollectionType {
+			continue
+		}
+		_, content, err := fs.blobContent(uid, doc)
+		if err != nil {
+			log.Warn("can't read the tags of ", doc.EntryName, ": ", err)
+			continue
+		}
+		docTags, err := contentTags(content)
+		if err != nil {
+			log.Warn("can't read the tags of ", doc.EntryName, ": ", err)
+			continue
+		}
+		tags[doc.EntryName] = docTags
+	}
+	return tags, nil
+}
+
+// zipTags the tags in the content file of a sync10 zip
+func (fs *FileSystemStorage) zipTags(uid, zipPath string) ([]string, error) {
+	r, err := fs.openStoredZip(uid, zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	_, content, err := zipContent(r.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return contentTags(content)
+}
+
+// DocumentTags the tags of the sync10 documents, by id
+func (fs *FileSystemStorage) DocumentTags(uid string) (map[string][]string, error) {
+	metadata, err := fs.GetAllMetadata(uid)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string][]string)
+	for _, m := range metadata {
+		if m.Type == models.CollectionType {
+			continue
+		}
+		docTags, err := fs.zipTags(uid, fs.getPathFromUser(uid, m.ID+models.ZipFileExt))
+		if err != nil {
+			log.Warn("can't read the tags of ", m.ID, ": ", err)
+			continue
+		}
+		tags[m.ID] = docTags
+	}
+	return tags, nil
+}
@@ -0,0 +1,26 @@
+package fs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContentTags(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{`{}`, []string{}},
+		{`{"tags": [{"name": "work", "timestamp": 1}, {"name": "later", "timestamp": 2}]}`, []string{"work", "later"}},
+		{`{"tags": [], "pageTags": [{"name": "page", "pageId": "p1"}]}`, []string{}},
+	}
+	for _, tt := range tests {
+		got, err := contentTags([]byte(tt.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limitsTTL the limits of a user are read from the profile again after this long
+	limitsTTL = time.Minute
+	// limiterIdle the limiters of the users who haven't transferred anything for this long are dropped
+	limiterIdle = 10 * time.Minute
+)
+
+// userLimits the bandwidth limits of the users, cached for limitsTTL
+type userLimits struct {
+	lock   sync.Mutex
+	cached map[string]cachedLimits
+	swept  time.Time
+}
+
+type cachedLimits struct {
+	upload, download int
+	read             time.Time
+}
+
+// get the upload and download limits of the user in KB/s, from read when they aren't cached
+func (l *userLimits) get(uid string, now time.Time, read func() (upload, download int)) (int, int) {
+	l.lock.Lock()
+	cached, ok := l.cached[uid]
+	l.lock.Unlock()
+	if ok && now.Sub(cached.read) < limitsTTL {
+		return cached.upload, cached.download
+	}
+
+	upload, download := read()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.cached == nil {
+		l.cached = make(map[string]cachedLimits)
+	}
+	if now.Sub(l.swept) > limitsTTL {
+		for id, c := range l.cached {
+			if now.Sub(c.read) >= limitsTTL {
+				delete(l.cached, id)
+			}
+		}
+		l.swept = now
+	}
+	l.cached[uid] = cachedLimits{upload: upload, download: download, read: now}
+	return upload, download
+}
+
+// bandwidthLimiters one limiter per user, shared by all of its requests
+type bandwidthLimiters struct {
+	lock     sync.Mutex
+	limiters map[string]*userLimiter
+	swept    time.Time
+}
+
+// userLimiter the limiter of a user, used is when it last throttled a read in unix nanoseconds
+type userLimiter struct {
+	*rate.Limiter
+	used int64
+}
+
+// get the limiter of the user, adjusted to the current limit
+func (b *bandwidthLimiters) get(uid string, bytesPerSecond int) *userLimiter {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	if b.limiters == nil {
+		b.limiters = make(map[string]*userLimiter)
+	}
+	if now.Sub(b.swept) > limiterIdle {
+		b.sweep(now)
+	}
+	limiter, ok := b.limiters[uid]
+	if !ok {
+		limiter = &userLimiter{Limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), bytesPerSecond)}
+		b.limiters[uid] = limiter
+	} else if limiter.Burst() != bytesPerSecond {
+		limiter.SetLimit(rate.Limit(bytesPerSecond))
+		limiter.SetBurst(bytesPerSecond)
+	}
+	atomic.StoreInt64(&limiter.used, now.UnixNano())
+	return limiter
+}
+
+// sweep drops the limiters idle since limiterIdle, the lock is held
+func (b *bandwidthLimiters) sweep(now time.Time) {
+	for uid, limiter := range b.limiters {
+		if now.Sub(time.Unix(0, atomic.LoadInt64(&limiter.used))) > limiterIdle {
+			delete(b.limiters, uid)
+		}
+	}
+	b.swept = now
+}
+
+// throttledReader waits for the limiter after each read
+type throttledReader struct {
+	r       io.Reader
+	limiter *userLimiter
+	ctx     context.Context
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if burst := t.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&t.limiter.used, time.Now().UnixNano())
+		// the request ended, or its deadline comes before the bytes are allowed: they aren't sent
+		if werr := t.limiter.WaitN(t.ctx, n); werr != nil {
+			return 0, werr
+		}
+	}
+	return n, err
+}
+
+// throttledReadSeeker a throttled file, for serving ranges of it
+type throttledReadSeeker struct {
+	*throttledReader
+	s io.Seeker
+}
+
+func (t *throttledReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return t.s.Seek(offset, whence)
+}
+
+// effectiveLimit the limit in KB/s of the user, falling back to the server default
+// 0 means unlimited
+func effectiveLimit(userLimit, defaultLimit int) int {
+	switch {
+	case userLimit < 0:
+		return 0
+	case userLimit > 0:
+		return userLimit
+	}
+	return defaultLimit
+}
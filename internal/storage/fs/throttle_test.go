@@ -0,0 +1,177 @@
+package fs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+func TestEffectiveLimit(t *testing.T) {
+	tests := []struct {
+		user, server, want int
+	}{
+		{0, 0, 0},
+		{0, 100, 100},
+		{50, 100, 50},
+		{200, 100, 200},
+		{-1, 100, 0},
+		{50, 0, 50},
+	}
+	for _, tt := range tests {
+		if got := effectiveLimit(tt.user, tt.server); got != tt.want {
+			t.Errorf("effectiveLimit(%d, %d) = %d, want %d", tt.user, tt.server, got, tt.want)
+		}
+	}
+}
+
+func TestThrottledDownload(t *testing.T) {
+	// the first second is the burst, the second one is waited for
+	const limit = 32
+	content := strings.Repeat("x", 2*limit*1024)
+	cfg := &config.Config{DataDir: t.TempDir(), JWTSecretKey: []byte("secret"), DownloadLimit: limit}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	fsStorage := NewStorage(cfg)
+	NewApp(cfg, fsStorage, fsStorage).RegisterRoutes(router)
+	for _, uid := range []string{"limited", "unlimited", "gone"} {
+		u, err := model.NewUser(uid, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uid == "unlimited" {
+			u.DownloadLimit = -1
+		}
+		if err = fsStorage.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.MkdirAll(fsStorage.getUserBlobPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fsStorage.StoreBlob(uid, "blob", strings.NewReader(content), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	download := func(ctx context.Context, uid string) (*httptest.ResponseRecorder, time.Duration) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, signedBlobURL(t, cfg, uid, "blob", "read"), nil).WithContext(ctx)
+		start := time.Now()
+		router.ServeHTTP(w, req)
+		return w, time.Since(start)
+	}
+
+	// slowed down, not rejected
+	w, took := download(context.Background(), "limited")
+	if w.Code != http.StatusOK || w.Body.Len() != len(content) {
+		t.Errorf("limited: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if took < 800*time.Millisecond {
+		t.Errorf("limited: took %s", took)
+	}
+
+	w, took = download(context.Background(), "unlimited")
+	if w.Code != http.StatusOK || w.Body.Len() != len(content) {
+		t.Errorf("unlimited: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if took > 500*time.Millisecond {
+		t.Errorf("unlimited: took %s", took)
+	}
+
+	// the wait ends with the request
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	w, took = download(ctx, "gone")
+	if w.Body.Len() >= len(content) {
+		t.Errorf("gone: sent %d bytes", w.Body.Len())
+	}
+	if took > 800*time.Millisecond {
+		t.Errorf("gone: took %s", took)
+	}
+}
+
+func TestThrottledUpload(t *testing.T) {
+	const limit = 32
+	content := strings.Repeat("x", 2*limit*1024)
+	cfg := &config.Config{DataDir: t.TempDir(), JWTSecretKey: []byte("secret"), UploadLimit: limit}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	fsStorage := NewStorage(cfg)
+	NewApp(cfg, fsStorage, fsStorage).RegisterRoutes(router)
+	if err := os.MkdirAll(fsStorage.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, signedBlobURL(t, cfg, "test", "blob", "write"), strings.NewReader(content))
+	start := time.Now()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("status %d", w.Code)
+	}
+	if took := time.Since(start); took < 800*time.Millisecond {
+		t.Errorf("took %s", took)
+	}
+	if stored := fileSize(path.Join(fsStorage.getUserBlobPath("test"), "blob")); stored != int64(len(content)) {
+		t.Errorf("stored %d bytes", stored)
+	}
+}
+
+func TestBandwidthLimitersShared(t *testing.T) {
+	var limiters bandwidthLimiters
+	first := limiters.get("test", 1024)
+	if second := limiters.get("test", 1024); second != first {
+		t.Error("the requests of a user have their own limiter")
+	}
+	if other := limiters.get("other", 1024); other == first {
+		t.Error("the users share a limiter")
+	}
+	// the limit changed in the profile
+	if changed := limiters.get("test", 2048); changed != first || changed.Burst() != 2048 {
+		t.Errorf("limit not adjusted: burst %d", changed.Burst())
+	}
+}
+
+func TestBandwidthLimitersIdle(t *testing.T) {
+	var limiters bandwidthLimiters
+	idle := limiters.get("idle", 1024)
+	active := limiters.get("active", 1024)
+	then := time.Now().Add(-2 * limiterIdle)
+	idle.used = then.UnixNano()
+	limiters.swept = then
+
+	if limiters.get("active", 1024) != active {
+		t.Error("the active limiter was dropped")
+	}
+	if _, ok := limiters.limiters["idle"]; ok {
+		t.Error("the idle limiter was kept")
+	}
+}
+
+func TestUserLimitsCached(t *testing.T) {
+	var limits userLimits
+	reads := 0
+	read := func() (int, int) {
+		reads++
+		return reads, 0
+	}
+	now := time.Now()
+	if upload, _ := limits.get("test", now, read); upload != 1 {
+		t.Errorf("upload limit %d", upload)
+	}
+	if upload, _ := limits.get("test", now.Add(time.Second), read); upload != 1 || reads != 1 {
+		t.Errorf("not cached: upload limit %d, %d reads", upload, reads)
+	}
+	// the profile changed
+	if upload, _ := limits.get("test", now.Add(limitsTTL), read); upload != 2 {
+		t.Errorf("not read again: upload limit %d", upload)
+	}
+}
@@ -0,0 +1,162 @@
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// trashName where the documents in the trash are recorded, the tablet doesn't keep the original parent
+const trashName = ".trash.json"
+
+func (fs *FileSystemStorage) loadTrash(uid string) (map[string]*storage.TrashedDocument, error) {
+	trashed := make(map[string]*storage.TrashedDocument)
+	js, err := ioutil.ReadFile(fs.getPathFromUser(uid, trashName))
+	if os.IsNotExist(err) {
+		return trashed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(js, &trashed)
+	if err != nil {
+		return nil, err
+	}
+	return trashed, nil
+}
+
+// TrashedDocuments the recorded documents in the trash, by id
+// documents trashed before they were recorded are missing
+func (fs *FileSystemStorage) TrashedDocuments(uid string) (map[string]*storage.TrashedDocument, error) {
+	fs.trashLock.Lock()
+	defer fs.trashLock.Unlock()
+	return fs.loadTrash(uid)
+}
+
+// recordTrash compares the parents of the documents before and after a change at the time, an empty parent after
+// means removed. Documents moved to the trash are recorded with where they were, the ones which left it are forgotten
+func (fs *FileSystemStorage) recordTrash(uid string, before, after map[string]string, at time.Time) {
+	fs.trashLock.Lock()
+	defer fs.trashLock.Unlock()
+	trashed, err := fs.loadTrash(uid)
+	if err != nil {
+		log.Warn("can't read the trash of ", uid, ": ", err)
+		return
+	}
+
+	changed := false
+	for id, parent := range after {
+		_, recorded := trashed[id]
+		if parent != trashParent {
+			if recorded {
+				delete(trashed, id)
+				changed = true
+			}
+			continue
+		}
+		original, known := before[id]
+		if recorded || !known || original == trashParent {
+			continue
+		}
+		trashed[id] = &storage.TrashedDocument{
+			OriginalParent: original,
+			DeletedAt:      at,
+		}
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	js, err := json.Marshal(trashed)
+	if err == nil {
+		err = ioutil.WriteFile(fs.getPathFromUser(uid, trashName), js, 0600)
+	}
+	if err != nil {
+		log.Warn("can't record the trash of ", uid, ": ", err)
+	}
+}
+
+// treeParents the parent of each document in the tree
+func treeParents(tree *models.HashTree) map[string]string {
+	parents := make(map[string]string, len(tree.Docs))
+	for _, d := range tree.Docs {
+		parents[d.EntryName] = d.MetadataFile.Parent
+	}
+	return parents
+}
+
+// recordTreeTrash records the trash moves between two versions of the tree
+func (fs *FileSystemStorage) recordTreeTrash(uid string, before map[string]string, tree *models.HashTree, at time.Time) {
+	after := treeParents(tree)
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			after[id] = ""
+		}
+	}
+	fs.recordTrash(uid, before, after, at)
+}
+
+// recordRootTrash records the trash moves of a new root written at the time, only the changed documents are read
+func (fs *FileSystemStorage) recordRootTrash(uid, previous, current string, at time.Time) {
+	if previous == current {
+		return
+	}
+	ls := &LocalBlobStorage{
+		fs:  fs,
+		uid: uid,
+	}
+	before, err := rootDocuments(ls, previous)
+	if err != nil {
+		log.Warn("trash: can't read the previous root of ", uid, ": ", err)
+		before = make(map[string]*models.HashEntry)
+	}
+	after, err := rootDocuments(ls, current)
+	if err != nil {
+		log.Warn("trash: can't read the root of ", uid, ": ", err)
+		return
+	}
+
+	beforeParents := make(map[string]string)
+	afterParents := make(map[string]string)
+	for id, entry := range after {
+		old, ok := before[id]
+		if ok && old.Hash == entry.Hash {
+			continue
+		}
+		parent, err := documentParent(ls, entry)
+		if err != nil {
+			log.Warn("trash: ", err)
+			continue
+		}
+		afterParents[id] = parent
+		if ok {
+			if parent, err = documentParent(ls, old); err == nil {
+				beforeParents[id] = parent
+			}
+		}
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			afterParents[id] = ""
+		}
+	}
+	if len(afterParents) > 0 {
+		fs.recordTrash(uid, beforeParents, afterParents, at)
+	}
+}
+
+// documentParent the parent in the metadata of the document index
+func documentParent(ls *LocalBlobStorage, entry *models.HashEntry) (string, error) {
+	doc := &models.HashDoc{}
+	if err := doc.Mirror(entry, ls); err != nil {
+		return "", fmt.Errorf("can't read the metadata of %s: %w", entry.EntryName, err)
+	}
+	return doc.Parent, nil
+}
@@ -0,0 +1,170 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+func TestRecordTrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: dir})
+	err = os.MkdirAll(fs.getUserPath(testuser), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := &messages.RawMetadata{ID: "doc", VissibleName: "doc", Type: models.DocumentType, Parent: "folder"}
+	err = fs.UpdateMetadata(testuser, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc.Parent = trashParent
+	err = fs.UpdateMetadata(testuser, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashed, err := fs.TrashedDocuments(testuser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := trashed["doc"]
+	if !ok || r.OriginalParent != "folder" || r.DeletedAt.IsZero() {
+		t.Fatalf("expected doc trashed from folder, got %+v", trashed)
+	}
+
+	// updates in the trash keep the record
+	doc.Version++
+	fs.UpdateMetadata(testuser, doc)
+	trashed, _ = fs.TrashedDocuments(testuser)
+	if trashed["doc"] == nil || trashed["doc"].OriginalParent != "folder" {
+		t.Errorf("the record should be kept, got %+v", trashed)
+	}
+
+	// restored
+	doc.Parent = "folder"
+	fs.UpdateMetadata(testuser, doc)
+	trashed, _ = fs.TrashedDocuments(testuser)
+	if len(trashed) != 0 {
+		t.Errorf("expected no trashed documents, got %+v", trashed)
+	}
+
+	// trashed and removed
+	doc.Parent = trashParent
+	fs.UpdateMetadata(testuser, doc)
+	err = fs.RemoveDocument(testuser, "doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashed, _ = fs.TrashedDocuments(testuser)
+	if len(trashed) != 0 {
+		t.Errorf("expected no trashed documents after the removal, got %+v", trashed)
+	}
+}
+
+func TestRecordTreeTrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: dir})
+	err = os.MkdirAll(fs.getUserPath(testuser), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := &models.HashTree{}
+	tree.Docs = []*models.HashDoc{
+		{HashEntry: models.HashEntry{EntryName: "a"}, MetadataFile: models.MetadataFile{Parent: "folder"}},
+		{HashEntry: models.HashEntry{EntryName: "b"}, MetadataFile: models.MetadataFile{Parent: ""}},
+	}
+	before := treeParents(tree)
+	tree.Docs[0].MetadataFile.Parent = trashParent
+	tree.Docs[1].MetadataFile.Parent = trashParent
+	deletedAt := time.Now().Add(-time.Hour).UTC()
+	fs.recordTreeTrash(testuser, before, tree, deletedAt)
+
+	trashed, _ := fs.TrashedDocuments(testuser)
+	if len(trashed) != 2 || trashed["a"].OriginalParent != "folder" || trashed["b"].OriginalParent != "" {
+		t.Fatalf("expected a and b trashed, got %+v", trashed)
+	}
+	if !trashed["a"].DeletedAt.Equal(deletedAt) {
+		t.Errorf("deleted at %s, want %s", trashed["a"].DeletedAt, deletedAt)
+	}
+
+	// the trash was emptied
+	before = treeParents(tree)
+	tree.Docs = nil
+	fs.recordTreeTrash(testuser, before, tree, time.Now())
+	trashed, _ = fs.TrashedDocuments(testuser)
+	if len(trashed) != 0 {
+		t.Errorf("expected an empty trash, got %+v", trashed)
+	}
+}
+
+func TestRecordRootTrash(t *testing.T) {
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: t.TempDir()})
+	if err := os.MkdirAll(fs.getUserBlobPath(testuser), 0700); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := fs.CreateBlobDocument(testuser, "doc.pdf", "folder", strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tablet moves it to the trash
+	tree, err := fs.GetTree(testuser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashDoc, err := tree.FindDoc(doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashDoc.Parent = trashParent
+	if err = fs.saveBlobDocument(testuser, hashDoc); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Rehash(); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.commitTree(testuser, tree); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := ioutil.ReadFile(path.Join(fs.getUserBlobPath(testuser), historyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(history)), "\n")
+	written, err := time.Parse(time.RFC3339, strings.Fields(lines[len(lines)-1])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashed, err := fs.TrashedDocuments(testuser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := trashed[doc.ID]
+	if !ok || r.OriginalParent != "folder" || !r.DeletedAt.Equal(written) {
+		t.Errorf("expected %s trashed from the folder at %s, got %+v", doc.ID, written, r)
+	}
+}
@@ -0,0 +1,177 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// payloadType the document type by the extension of its payload
+func payloadType(names []string) string {
+	for _, name := range names {
+		switch strings.ToLower(filepath.Ext(name)) {
+		case models.PdfFileExt:
+			return storage.UsagePDF
+		case models.EpubFileExt:
+			return storage.UsageEPUB
+		}
+	}
+	return storage.UsageNotebook
+}
+
+// zipType the type of a sync10 document, by the files in its zip
+func (fs *FileSystemStorage) zipType(uid, zipPath string) string {
+	r, err := fs.openStoredZip(uid, zipPath)
+	if err != nil {
+		log.Warn("usage: can't open ", zipPath, ": ", err)
+		return storage.UsageNotebook
+	}
+	defer r.Close()
+	names := make([]string, 0, len(r.File))
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	return payloadType(names)
+}
+
+// fileSize 0 if the file doesn't exist
+func fileSize(filePath string) int64 {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// documentSizer a provider which tells the size of a sync10 document without downloading it
+type documentSizer interface {
+	DocumentSize(uid, docid string) (int64, error)
+}
+
+// documentSize the size of the sync10 document in the provider, 0 if it can't tell
+func (fs *FileSystemStorage) documentSize(uid, docid string) int64 {
+	sizer, ok := fs.blobs.(documentSizer)
+	if !ok {
+		return 0
+	}
+	size, err := sizer.DocumentSize(uid, docid)
+	if err != nil && err != ErrorNotFound {
+		log.Warn("usage: can't get the size of ", docid, ": ", err)
+	}
+	return size
+}
+
+// computeUsage walks the user's folder, the current documents of both sync versions are broken down by type
+func (fs *FileSystemStorage) computeUsage(uid string) (*storage.Usage, error) {
+	usage := &storage.Usage{
+		ByType:   make(map[string]int64),
+		Computed: time.Now().UTC(),
+	}
+	err := filepath.Walk(fs.getUserPath(uid), func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			usage.Total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// sync10
+	metadata, err := fs.GetAllMetadata(uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range metadata {
+		size := fileSize(fs.getPathFromUser(uid, m.ID+models.MetadataFileExt))
+		docType := storage.UsageFolder
+		if m.Type != models.CollectionType {
+			if fs.blobs != nil {
+				// not in the data dir, not broken down by type
+				usage.Total += fs.documentSize(uid, m.ID)
+				continue
+			}
+			zipPath := fs.getPathFromUser(uid, m.ID+models.ZipFileExt)
+			size += fileSize(zipPath) + fileSize(fs.getOriginalPath(uid, m.ID))
+			docType = fs.zipType(uid, zipPath)
+		}
+		usage.ByType[docType] += size
+	}
+
+	// sync15, the files of the documents are counted from their indexes when they aren't in the data dir
+	if _, err = os.Stat(fs.getUserBlobPath(uid)); err == nil || fs.blobs != nil {
+		tree, err := fs.GetTree(uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range tree.Docs {
+			var size int64
+			names := make([]string, 0, len(doc.Files))
+			for _, f := range doc.Files {
+				size += f.Size
+				names = append(names, f.EntryName)
+			}
+			docType := storage.UsageFolder
+			if doc.MetadataFile.CollectionType != models.CollectionType {
+				docType = payloadType(names)
+			}
+			if fs.blobs != nil {
+				usage.Total += size
+			}
+			usage.ByType[docType] += size
+		}
+	}
+
+	var documents int64
+	for _, size := range usage.ByType {
+		documents += size
+	}
+	usage.Other = usage.Total - documents
+	if usage.Other < 0 {
+		usage.Other = 0
+	}
+	return usage, nil
+}
+
+// Usage the storage used by the user, walks the user's folder when the cache is invalid
+func (fs *FileSystemStorage) Usage(uid string) (*storage.Usage, error) {
+	fs.usageLock.Lock()
+	cached, ok := fs.usage[uid]
+	generation := fs.usageGeneration
+	fs.usageLock.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	usage, err := fs.computeUsage(uid)
+	if err != nil {
+		return nil, err
+	}
+	fs.usageLock.Lock()
+	defer fs.usageLock.Unlock()
+	// something changed during the walk, don't cache it
+	if generation != fs.usageGeneration {
+		return usage, nil
+	}
+	if fs.usage == nil {
+		fs.usage = make(map[string]*storage.Usage)
+	}
+	fs.usage[uid] = usage
+	return usage, nil
+}
+
+// usageChanged invalidates the cached usage of the user
+func (fs *FileSystemStorage) usageChanged(uid string) {
+	fs.usageLock.Lock()
+	delete(fs.usage, uid)
+	fs.usageGeneration++
+	fs.usageLock.Unlock()
+}
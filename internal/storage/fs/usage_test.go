@@ -0,0 +1,64 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+func TestUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testuser := "test"
+	fs := NewStorage(&config.Config{DataDir: dir})
+	err = os.MkdirAll(fs.getUserBlobPath(testuser), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.CreateDocument(testuser, "sync10.pdf", "", strings.NewReader("pdf content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.CreateBlobDocument(testuser, "sync15.epub", "", strings.NewReader("epub content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := fs.Usage(testuser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.ByType[storage.UsagePDF] == 0 || usage.ByType[storage.UsageEPUB] == 0 {
+		t.Errorf("missing types: %v", usage.ByType)
+	}
+	if usage.ByType[storage.UsageNotebook] != 0 {
+		t.Errorf("no notebooks expected: %v", usage.ByType)
+	}
+	documents := usage.ByType[storage.UsagePDF] + usage.ByType[storage.UsageEPUB]
+	if usage.Total != documents+usage.Other {
+		t.Errorf("total %d, documents %d, other %d", usage.Total, documents, usage.Other)
+	}
+
+	cached, _ := fs.Usage(testuser)
+	if cached != usage {
+		t.Error("the usage should be cached")
+	}
+
+	_, err = fs.CreateBlobDocument(testuser, "another.pdf", "", strings.NewReader("pdf content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, _ := fs.Usage(testuser)
+	if changed.ByType[storage.UsagePDF] <= usage.ByType[storage.UsagePDF] {
+		t.Errorf("the cache wasn't invalidated: %v", changed.ByType)
+	}
+}
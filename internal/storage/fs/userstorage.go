@@ -21,12 +21,19 @@ func NewStorage(cfg *config.Config) *FileSystemStorage {
 	fs := &FileSystemStorage{
 		Cfg: cfg,
 	}
+	fs.RegisterIngestProcessor(&namingProcessor{policy: cfg.NameCollisionPolicy})
+	fs.RegisterIngestProcessor(&protectionProcessor{fs: fs, policy: cfg.ProtectedUploadPolicy})
+	fs.RegisterIngestProcessor(&downscaleProcessor{fs: fs})
 
 	usersPath := fs.getUserPath("")
 	err := os.MkdirAll(usersPath, 0700)
 	if err != nil {
 		log.Fatal("cannot create the user path " + usersPath)
 	}
+	err = fs.upgradeFormat()
+	if err != nil {
+		log.Fatal("cannot upgrade the stored files: ", err)
+	}
 
 	return fs
 }
@@ -115,7 +122,7 @@ func (fs *FileSystemStorage) RegisterUser(u *model.User) (err error) {
 		return err
 	}
 
-	return
+	return fs.createDefaultFolders(u.ID)
 }
 
 // UpdateUser updates the user
@@ -132,14 +139,19 @@ func (fs *FileSystemStorage) UpdateUser(u *model.User) (err error) {
 	}
 
 	profilePath := fs.getPathFromUser(u.ID, profileName)
+	_, err = os.Stat(profilePath)
+	isNew := os.IsNotExist(err)
 	// Overwrite the profile
 	js, err := u.Serialize()
 	if err != nil {
 		return
 	}
 	err = ioutil.WriteFile(profilePath, js, 0600)
+	if err != nil || !isNew {
+		return
+	}
 
-	return
+	return fs.createDefaultFolders(u.ID)
 }
 
 // RemoveUser remove the user and their data
@@ -151,9 +163,12 @@ func (fs *FileSystemStorage) RemoveUser(uid string) (err error) {
 
 	userSyncPath := fs.getUserPath(uid)
 	err = os.RemoveAll(userSyncPath)
+	fs.usageChanged(uid)
 	if err != nil {
 		return
 	}
-
+	if _, err1 := fs.pruneSharedBlobs(); err1 != nil {
+		log.Warn("can't prune the shared blobs: ", err1)
+	}
 	return
 }
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrorRejected an ingest processor refused the upload
+var ErrorRejected = errors.New("upload rejected")
+
+// IngestDocument an uploaded document, before it is stored
+type IngestDocument struct {
+	UserID  string
+	ID      string
+	Name    string
+	Ext     string
+	Parent  string
+	Content io.Reader
+	// Siblings the names already taken in the parent folder
+	Siblings func() (map[string]bool, error)
+
+	// Action what happened with the document, ActionSkipped stops the upload
+	Action     string
+	BytesSaved int64
+	// Warning the document was stored, but might not open on the tablet
+	Warning string
+	// Tags added to the document, they flag it on the tablet and in the web ui
+	Tags []string
+}
+
+// IngestProcessor a step of the upload pipeline, it can change the content
+// or the metadata of the document, or reject it by returning an error wrapping ErrorRejected
+type IngestProcessor interface {
+	Name() string
+	Process(doc *IngestDocument) error
+}
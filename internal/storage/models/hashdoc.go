@@ -57,10 +57,26 @@ func (d *HashDoc) Rehash() error {
 }
 
 func (d *HashDoc) MetadataReader() (hash string, reader io.Reader, err error) {
+	return d.MetadataReaderWith(nil)
+}
+
+// MetadataReaderWith like MetadataReader, the metadata is written over the fields of the json,
+// the fields it doesn't know are kept
+func (d *HashDoc) MetadataReaderWith(fields map[string]json.RawMessage) (hash string, reader io.Reader, err error) {
 	jsn, err := json.Marshal(d.MetadataFile)
 	if err != nil {
 		return
 	}
+	if fields != nil {
+		err = json.Unmarshal(jsn, &fields)
+		if err != nil {
+			return
+		}
+		jsn, err = json.Marshal(fields)
+		if err != nil {
+			return
+		}
+	}
 	sha := sha256.New()
 	sha.Write(jsn)
 	hash = hex.EncodeToString(sha.Sum(nil))
@@ -70,6 +86,7 @@ func (d *HashDoc) MetadataReader() (hash string, reader io.Reader, err error) {
 	for _, f := range d.Files {
 		if strings.HasSuffix(f.EntryName, MetadataFileExt) {
 			f.Hash = hash
+			f.Size = int64(len(jsn))
 			found = true
 			break
 		}
@@ -170,7 +187,7 @@ func (d *HashDoc) Mirror(e *HashEntry, r RemoteStorage) error {
 		return err
 	}
 	defer entryIndex.Close()
-	entries, err := parseIndex(entryIndex)
+	entries, err := ParseIndex(entryIndex)
 	if err != nil {
 		return err
 	}
@@ -130,7 +130,8 @@ func parseEntry(line string) (*HashEntry, error) {
 	return &entry, nil
 }
 
-func parseIndex(f io.Reader) ([]*HashEntry, error) {
+// ParseIndex parses an index blob into its entries
+func ParseIndex(f io.Reader) ([]*HashEntry, error) {
 	var entries []*HashEntry
 	scanner := bufio.NewScanner(f)
 	scanner.Scan()
@@ -251,7 +252,7 @@ func (t *HashTree) Mirror(r RemoteStorage) (changed bool, err error) {
 	}
 	defer rdr.Close()
 
-	entries, err := parseIndex(rdr)
+	entries, err := ParseIndex(rdr)
 	if err != nil {
 		return
 	}
@@ -310,7 +311,7 @@ func BuildTree(provider RemoteStorage) (*HashTree, error) {
 	}
 
 	defer rootIndex.Close()
-	entries, _ := parseIndex(rootIndex)
+	entries, _ := ParseIndex(rootIndex)
 
 	for _, e := range entries {
 		f, _ := provider.GetReader(e.Hash)
@@ -320,7 +321,7 @@ func BuildTree(provider RemoteStorage) (*HashTree, error) {
 		doc.HashEntry = *e
 		tree.Docs = append(tree.Docs, doc)
 
-		items, _ := parseIndex(f)
+		items, _ := ParseIndex(f)
 		doc.Files = items
 		for _, i := range items {
 			doc.ReadMetadata(i, provider)
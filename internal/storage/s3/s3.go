@@ -0,0 +1,277 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// rootBlob the only blob that changes, it has a generation
+	rootBlob = "root"
+	// generationHeader the generation of the root, kept in the object metadata
+	generationHeader = "X-Amz-Meta-Generation"
+	requestTimeout   = 5 * time.Minute
+	syncDir          = "sync"
+)
+
+// Storage keeps the blobs and documents as objects in a bucket, with the same layout as the data dir
+// users/<uid>/sync/<blob> and users/<uid>/<document>.zip
+type Storage struct {
+	endpoint *url.URL
+	bucket   string
+	creds    credentials
+	client   *http.Client
+}
+
+// NewStorage the storage for the bucket in the config
+func NewStorage(cfg *config.S3Config) (*Storage, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("s3 endpoint %s is not an http url", cfg.Endpoint)
+	}
+	return &Storage{
+		endpoint: endpoint,
+		bucket:   cfg.Bucket,
+		creds: credentials{
+			region:    cfg.Region,
+			accessKey: cfg.AccessKey,
+			secretKey: cfg.SecretKey,
+		},
+		client: &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+func userPrefix(uid string) string {
+	return path.Join("users", path.Base(path.Clean("/"+uid)))
+}
+
+func blobKey(uid, blobID string) string {
+	return path.Join(userPrefix(uid), syncDir, common.Sanitize(blobID))
+}
+
+func documentKey(uid, docid string) string {
+	return path.Join(userPrefix(uid), path.Base(docid)+models.ZipFileExt)
+}
+
+// objectURL path style, works with aws and minio
+func (s *Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	p := "/" + s.bucket
+	if key != "" {
+		p += "/" + key
+	}
+	u.Path = s.endpoint.Path + p
+	u.RawPath = s.endpoint.EscapedPath() + uriEncode(p, true)
+	return &u
+}
+
+func (s *Storage) do(ctx context.Context, method, key string, body io.ReadSeeker, size int64, payloadHash string, headers http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range headers {
+		req.Header[k] = values
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(body)
+		req.ContentLength = size
+	}
+	s.creds.sign(req, payloadHash, time.Now())
+	return s.client.Do(req)
+}
+
+// statusError the error of a failed request
+func statusError(method, key string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return storage.ErrorNotFound
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("s3 %s %s: %s %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+func (s *Storage) get(key string) (io.ReadCloser, http.Header, error) {
+	resp, err := s.do(context.Background(), http.MethodGet, key, nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, nil, statusError(http.MethodGet, key, resp)
+	}
+	return resp.Body, resp.Header, nil
+}
+
+// object the generation, etag and size of an object
+type object struct {
+	generation int64
+	etag       string
+	size       int64
+}
+
+// head the object, an empty etag when there is none
+func (s *Storage) head(key string) (*object, error) {
+	resp, err := s.do(context.Background(), http.MethodHead, key, nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return &object{}, nil
+	default:
+		return nil, statusError(http.MethodHead, key, resp)
+	}
+	generation, _ := strconv.ParseInt(resp.Header.Get(generationHeader), 10, 64)
+	return &object{
+		generation: generation,
+		etag:       resp.Header.Get("ETag"),
+		size:       resp.ContentLength,
+	}, nil
+}
+
+// put uploads the stream, it is spooled to a temp file first since s3 needs the length
+func (s *Storage) put(key string, stream io.Reader, headers http.Header) error {
+	tmp, err := ioutil.TempFile("", "rmfakecloud-s3")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), stream)
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := s.do(context.Background(), http.MethodPut, key, tmp, size, hex.EncodeToString(hasher.Sum(nil)), headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return storage.ErrorWrongGeneration
+	}
+	return statusError(http.MethodPut, key, resp)
+}
+
+// LoadBlob the blob, the generation is only set for the root
+func (s *Storage) LoadBlob(uid, blobID string) (io.ReadCloser, int64, error) {
+	key := blobKey(uid, blobID)
+	log.Debugln("s3 object:", key)
+	reader, headers, err := s.get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	generation := int64(0)
+	if blobID == rootBlob {
+		generation, _ = strconv.ParseInt(headers.Get(generationHeader), 10, 64)
+	}
+	return reader, generation, nil
+}
+
+// RootGeneration the generation in the metadata of the root, without downloading it
+func (s *Storage) RootGeneration(uid string) (int64, error) {
+	root, err := s.head(blobKey(uid, rootBlob))
+	if err != nil {
+		return 0, err
+	}
+	return root.generation, nil
+}
+
+// StoreBlob stores the blob, the root only when matchGen is its current generation (or not set)
+// the root is written with If-Match on the etag it had, a concurrent write also fails with ErrorWrongGeneration
+func (s *Storage) StoreBlob(uid, blobID string, stream io.Reader, matchGen int64) (int64, error) {
+	key := blobKey(uid, blobID)
+	if blobID != rootBlob {
+		return 1, s.put(key, stream, nil)
+	}
+
+	current, err := s.head(key)
+	if err != nil {
+		return 0, err
+	}
+	currentGen, etag := current.generation, current.etag
+	if currentGen != matchGen && matchGen > 0 {
+		log.Warnf("wrong gen, has %d but is %d", matchGen, currentGen)
+		return currentGen, storage.ErrorWrongGeneration
+	}
+	generation := currentGen + 1
+	headers := http.Header{}
+	headers.Set(generationHeader, strconv.FormatInt(generation, 10))
+	if etag != "" {
+		headers.Set("If-Match", etag)
+	} else {
+		headers.Set("If-None-Match", "*")
+	}
+	err = s.put(key, stream, headers)
+	if err == storage.ErrorWrongGeneration {
+		log.Warn("the root changed while it was written")
+		return currentGen, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return generation, nil
+}
+
+// GetDocument the sync10 document
+func (s *Storage) GetDocument(uid, docid string) (io.ReadCloser, error) {
+	reader, _, err := s.get(documentKey(uid, docid))
+	return reader, err
+}
+
+// DocumentSize the size of the sync10 document, 0 if there is none
+func (s *Storage) DocumentSize(uid, docid string) (int64, error) {
+	doc, err := s.head(documentKey(uid, docid))
+	if err != nil {
+		return 0, err
+	}
+	return doc.size, nil
+}
+
+// StoreDocument stores the sync10 document
+func (s *Storage) StoreDocument(uid, docid string, stream io.ReadCloser) error {
+	return s.put(documentKey(uid, docid), stream, nil)
+}
+
+// CheckHealth the bucket is reachable with the credentials
+func (s *Storage) CheckHealth(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("s3 bucket %s: %s", s.bucket, resp.Status)
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"io"
 	"time"
 
@@ -36,6 +38,29 @@ type BlobStorage interface {
 	CreateBlobDocument(uid, name, parent string, stream io.Reader) (doc *Document, err error)
 }
 
+// BlobProvider where the storage routes keep the blobs and documents the devices up- and download
+type BlobProvider interface {
+	LoadBlob(uid, blobID string) (io.ReadCloser, int64, error)
+	StoreBlob(uid, blobID string, s io.Reader, matchGeneration int64) (int64, error)
+	GetDocument(uid, docid string) (io.ReadCloser, error)
+	StoreDocument(uid, docid string, s io.ReadCloser) error
+}
+
+// RootGenerationReader tells the generation of the root without loading it
+type RootGenerationReader interface {
+	// RootGeneration 0 when there is no root yet
+	RootGeneration(uid string) (int64, error)
+}
+
+// ErrorNotFound no blob or document with the id
+var ErrorNotFound = errors.New("not found")
+
+// ErrorWrongGeneration the generation did not match
+var ErrorWrongGeneration = errors.New("wrong generation")
+
+// ErrorOverQuota storing it would take the user over the quota
+var ErrorOverQuota = errors.New("over quota")
+
 // MetadataStorer manages document metadata
 type MetadataStorer interface {
 	UpdateMetadata(uid string, r *messages.RawMetadata) error
@@ -52,6 +77,45 @@ type UserStorer interface {
 	RemoveUser(uid string) error
 }
 
+const (
+	// AuditUser creating, changing and removing users
+	AuditUser = "user"
+	// AuditMaintenance garbage collection and other housekeeping
+	AuditMaintenance = "maintenance"
+	// AuditDocuments restoring, importing and repairing the documents of an account
+	AuditDocuments = "documents"
+)
+
+// AuditEntry an administrative action, who did what and when
+type AuditEntry struct {
+	Time     time.Time         `json:"time"`
+	Actor    string            `json:"actor"`
+	Category string            `json:"category"`
+	Action   string            `json:"action"`
+	Target   string            `json:"target,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	// Status http status of the request
+	Status int `json:"status,omitempty"`
+}
+
+// AuditStorer keeps the audit trail
+type AuditStorer interface {
+	RecordAudit(entry *AuditEntry) error
+	// AuditLog the entries of the category, all of them when empty
+	AuditLog(category string) ([]*AuditEntry, error)
+}
+
+const (
+	// ActionCreated the document was created with the requested name
+	ActionCreated = "created"
+	// ActionDuplicate created, but another document in the folder has the same name
+	ActionDuplicate = "duplicate"
+	// ActionRenamed created with a suffix to make the name unique
+	ActionRenamed = "renamed"
+	// ActionSkipped not created, the name was taken
+	ActionSkipped = "skipped"
+)
+
 // Document represents a document in storage
 type Document struct {
 	ID      string
@@ -59,4 +123,193 @@ type Document struct {
 	Parent  string
 	Name    string
 	Version int
+	// Action what the name collision policy did
+	Action string
+	// BytesSaved by downscaling the images
+	BytesSaved int64
+	// Warning about a problem with the content
+	Warning string
+}
+
+const (
+	// DeleteReparent moves the children of a deleted folder to its parent
+	DeleteReparent = "reparent"
+	// DeleteRecursive deletes the folder with everything in it
+	DeleteRecursive = "recursive"
+)
+
+// ErrorInvalidDeleteMode the mode is neither DeleteReparent nor DeleteRecursive
+var ErrorInvalidDeleteMode = errors.New("invalid delete mode")
+
+// Deletion the result of deleting a document or a folder
+type Deletion struct {
+	ID string
+	// Parent where the children were moved to
+	Parent string
+	// Moved the children which were reparented
+	Moved []string
+	// Deleted the document and the descendants which were deleted
+	Deleted []string
+}
+
+// ErrorInvalidPages the new page order isn't a permutation of the pages, or the document isn't a notebook
+var ErrorInvalidPages = errors.New("invalid page order")
+
+// ErrorNameTaken a document with the same name is in the folder
+var ErrorNameTaken = errors.New("name already taken")
+
+// ErrorInvalidMove the target folder doesn't exist or is inside the moved folder
+var ErrorInvalidMove = errors.New("invalid move")
+
+// Orphan a sync15 document whose blobs exist, but no root ever referenced it
+type Orphan struct {
+	ID       string
+	Hash     string
+	Name     string
+	Type     string
+	Modified time.Time
+	Size     int64
+	// Action what the orphan policy did with it
+	Action string
+}
+
+// MetadataRepair a document whose metadata didn't match the schema
+type MetadataRepair struct {
+	ID   string
+	Name string
+	// Problems what was wrong, the fields that were missing or had the wrong type
+	Problems []string
+	// Repaired false in a dry run
+	Repaired bool
+}
+
+// GCStats progress of a blob garbage collection
+// in a dry run nothing is removed, Reclaimed and BytesFreed are what would be
+type GCStats struct {
+	Scanned    int   `json:"scanned"`
+	Reclaimed  int   `json:"reclaimed"`
+	BytesFreed int64 `json:"bytesFreed"`
+	DryRun     bool  `json:"dryRun,omitempty"`
+	// Blobs the unreachable blobs, only listed in a dry run
+	Blobs []string `json:"blobs,omitempty"`
+	// Archive where the blobs were moved to, in archive mode
+	Archive string `json:"archive,omitempty"`
+}
+
+// ErrorSyncInProgress the user is syncing, try again later
+var ErrorSyncInProgress = errors.New("sync in progress")
+
+// Snapshot a past generation of the sync15 root
+type Snapshot struct {
+	Generation int64
+	// Hash of the root index
+	Hash string
+	Time time.Time
+	// Documents in the root, 0 if it can't be read
+	Documents int
+	// Complete all the blobs are there and it can be restored
+	Complete bool
+	// Current the generation the devices sync
+	Current bool
+}
+
+// ErrorSnapshotUnavailable the generation isn't kept or some of its blobs were collected
+var ErrorSnapshotUnavailable = errors.New("snapshot unavailable")
+
+// ErrorNotSupported the storage provider can't do it, the blobs aren't in the data dir
+var ErrorNotSupported = errors.New("not supported by the storage provider")
+
+// AccountImport what an imported account archive restored
+type AccountImport struct {
+	// Documents the ids of the documents and folders
+	Documents []string
+	Blobs     int
+	// Generation the new generation of the sync15 root, 0 for sync10
+	Generation int64
+}
+
+// ErrorInvalidArchive the account archive is damaged or of the other sync version
+var ErrorInvalidArchive = errors.New("invalid account archive")
+
+// HealthChecker a backend that can check it is reachable and usable
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+const (
+	// UsagePDF pdf documents
+	UsagePDF = "pdf"
+	// UsageEPUB epub documents
+	UsageEPUB = "epub"
+	// UsageNotebook notebooks
+	UsageNotebook = "notebook"
+	// UsageFolder the metadata of folders
+	UsageFolder = "folder"
+)
+
+// Usage the storage used by a user
+type Usage struct {
+	// Total bytes on disk
+	Total int64 `json:"total"`
+	// ByType bytes of the current documents, by document type
+	ByType map[string]int64 `json:"byType"`
+	// Other everything else, e.g. the trash, the history and older generations
+	Other    int64     `json:"other"`
+	Computed time.Time `json:"computed"`
+}
+
+// Add adds the usage of another user
+func (u *Usage) Add(other *Usage) {
+	if u.ByType == nil {
+		u.ByType = make(map[string]int64)
+	}
+	u.Total += other.Total
+	u.Other += other.Other
+	for t, size := range other.ByType {
+		u.ByType[t] += size
+	}
+}
+
+// UsageReporter computes the storage usage
+type UsageReporter interface {
+	// Usage of the user, cached until the user's documents change
+	Usage(uid string) (*Usage, error)
+	// Quota of the user in bytes, the server default unless the user has one, 0 is unlimited
+	Quota(uid string) (int64, error)
+}
+
+// TrashedDocument a document the tablet moved to the trash
+type TrashedDocument struct {
+	// OriginalParent the folder it was in, empty for the root
+	OriginalParent string    `json:"originalParent"`
+	DeletedAt      time.Time `json:"deletedAt"`
+}
+
+const (
+	// EventDocumentUploaded a document was added or changed
+	EventDocumentUploaded = "document.uploaded"
+	// EventDocumentDeleted a document was removed
+	EventDocumentDeleted = "document.deleted"
+	// EventRootGeneration the sync15 root advanced to a new generation
+	EventRootGeneration = "root.generation"
+)
+
+// Event a change of the documents of a user
+type Event struct {
+	Type   string
+	UserID string
+	Time   time.Time
+	// DocumentID and the metadata of the document, only for the document events
+	DocumentID   string
+	Name         string
+	DocumentType string
+	Parent       string
+	Version      int
+	// Generation of the sync15 root, 0 for sync10
+	Generation int64
+}
+
+// EventListener gets the events after the change is stored, it must not block
+type EventListener interface {
+	Event(e *Event)
 }
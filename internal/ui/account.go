@@ -0,0 +1,65 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// accountFormat the export of the whole account, as a tar which can be imported again
+const accountFormat = "account"
+
+// exportAccount streams a tar with the documents, blobs and metadata of the user
+func (app *ReactAppWrapper) exportAccount(c *gin.Context, uid string) {
+	backend := getBackend(c)
+	log.Info(uiLogger, "exporting the account of ", uid)
+
+	name := fmt.Sprintf("%s-%s.tar", zipName(uid, "account"), time.Now().UTC().Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	c.Header("Content-Type", "application/x-tar")
+	c.Status(http.StatusOK)
+
+	// written as it goes, an error can't change the status anymore
+	// the connection is aborted instead, the client doesn't keep a truncated tar
+	err := backend.ExportAccount(uid, c.Writer)
+	if err != nil {
+		log.Error(uiLogger, "export of the account of ", uid, " failed: ", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// importAccount restores an account export, the body is the tar
+func (app *ReactAppWrapper) importAccount(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+	auditTarget(c, uid)
+	backend := getBackend(c)
+	defer c.Request.Body.Close()
+
+	log.Info(uiLogger, "importing an account for ", uid)
+	result, err := backend.ImportAccount(uid, c.Request.Body)
+	if err != nil {
+		log.Error(err)
+		switch {
+		case errors.Is(err, storage.ErrorInvalidArchive):
+			badReq(c, err.Error())
+		case errors.Is(err, storage.ErrorOverQuota):
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrorWrongGeneration):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a device synced in the meantime, try again"})
+		default:
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, viewmodel.AccountImport{
+		Documents:  len(result.Documents),
+		Blobs:      result.Blobs,
+		Generation: result.Generation,
+	})
+}
@@ -0,0 +1,106 @@
+package ui
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// adminUser loads the user of the url, aborts when there is none
+func (app *ReactAppWrapper) adminUser(c *gin.Context) *model.User {
+	uid := c.Param(useridParam)
+	user, err := app.userStorer.GetUser(uid)
+	if err != nil || user == nil {
+		log.Warn(uiLogger, "no such user: ", uid, " ", err)
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Invalid user"})
+		return nil
+	}
+	return user
+}
+
+// setUserDisabled disables or enables the account, a disabled one can't log in or get new tokens
+func (app *ReactAppWrapper) setUserDisabled(disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := app.adminUser(c)
+		if user == nil {
+			return
+		}
+		if disabled && user.ID == c.GetString(userIDContextKey) {
+			badReq(c, "can't disable the current user")
+			return
+		}
+		user.Disabled = disabled
+		auditParam(c, "disabled", strconv.FormatBool(disabled))
+		if err := app.userStorer.UpdateUser(user); err != nil {
+			log.Error(uiLogger, "can't update ", user.ID, ": ", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		log.Info(uiLogger, "user ", user.ID, " disabled: ", disabled)
+		c.JSON(http.StatusOK, userViewModel(user))
+	}
+}
+
+// resetUserPassword sets a new password, generates one when none is given
+func (app *ReactAppWrapper) resetUserPassword(c *gin.Context) {
+	var req viewmodel.PasswordReset
+	// an empty body generates the password
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		badReq(c, err.Error())
+		return
+	}
+	user := app.adminUser(c)
+	if user == nil {
+		return
+	}
+
+	var result viewmodel.PasswordResetResult
+	password := req.NewPassword
+	if password == "" {
+		var err error
+		password, err = model.GenPassword()
+		if err != nil {
+			log.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		result.Password = password
+		auditParam(c, "password", "generated")
+	} else {
+		auditParam(c, "password", "changed")
+	}
+	if err := user.SetPassword(password); err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err := app.userStorer.UpdateUser(user); err != nil {
+		log.Error(uiLogger, "can't update ", user.ID, ": ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// revokeUserTokens rejects the device tokens issued until now, the devices have to be paired again
+func (app *ReactAppWrapper) revokeUserTokens(c *gin.Context) {
+	user := app.adminUser(c)
+	if user == nil {
+		return
+	}
+	user.TokensRevokedAt = time.Now().UTC()
+	auditParam(c, "revokedAt", user.TokensRevokedAt.Format(time.RFC3339))
+	if err := app.userStorer.UpdateUser(user); err != nil {
+		log.Error(uiLogger, "can't update ", user.ID, ": ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Info(uiLogger, "revoked the device tokens of ", user.ID)
+	c.JSON(http.StatusOK, userViewModel(user))
+}
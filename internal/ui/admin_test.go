@@ -0,0 +1,105 @@
+package ui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/fs"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{DataDir: t.TempDir()}
+	cfg.AuditLog = cfg.DataDir + "/audit.log"
+	storer := fs.NewStorage(cfg)
+	app := &ReactAppWrapper{cfg: cfg, userStorer: storer, auditStorer: storer}
+	for _, id := range []string{"admin", "user"} {
+		u, err := model.NewUser(id, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = storer.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := gin.New()
+	users := router.Group("/users", func(c *gin.Context) {
+		c.Set(userIDContextKey, "admin")
+	})
+	users.POST(":userid/disable", app.audited(storage.AuditUser, "disable", app.setUserDisabled(true)))
+	users.POST(":userid/password", app.audited(storage.AuditUser, "password", app.resetUserPassword))
+	users.POST(":userid/revoke", app.audited(storage.AuditUser, "revoke", app.revokeUserTokens))
+
+	post := func(url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("/users/admin/disable", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("disabled the current user: %d", w.Code)
+	}
+	if w := post("/users/missing/disable", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing user: %d", w.Code)
+	}
+	if w := post("/users/user/disable", ""); w.Code != http.StatusOK {
+		t.Errorf("disable: %d", w.Code)
+	}
+
+	w := post("/users/user/password", "")
+	var result viewmodel.PasswordResetResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusOK || result.Password == "" {
+		t.Fatalf("generated password: %d %s", w.Code, w.Body)
+	}
+	if w = post("/users/user/password", `{"newpassword":"secret"}`); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("set password: %d %s", w.Code, w.Body)
+	}
+
+	before := time.Now().Add(-time.Second).Unix()
+	if w = post("/users/user/revoke", ""); w.Code != http.StatusOK {
+		t.Errorf("revoke: %d", w.Code)
+	}
+
+	user, err := storer.GetUser("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Disabled {
+		t.Error("not disabled")
+	}
+	if ok, _ := user.CheckPassword("secret"); !ok {
+		t.Error("password not changed")
+	}
+	if !user.TokenRevoked(before) || !user.TokenRevoked(0) || user.TokenRevoked(time.Now().Add(time.Second).Unix()) {
+		t.Errorf("tokens revoked at %v", user.TokensRevokedAt)
+	}
+
+	entries, err := storer.AuditLog(storage.AuditUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 6 {
+		t.Fatalf("audit entries %+v", entries)
+	}
+	for _, e := range entries {
+		if e.Actor != "admin" {
+			t.Errorf("actor %+v", e)
+		}
+		for _, v := range e.Params {
+			if v == "secret" || v == result.Password {
+				t.Errorf("the password is in the audit log %+v", e)
+			}
+		}
+	}
+}
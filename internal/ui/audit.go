@@ -0,0 +1,81 @@
+package ui
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	auditTargetKey = "auditTarget"
+	auditParamsKey = "auditParams"
+	categoryQuery  = "category"
+)
+
+// audited records who ran the admin handler, on whom and with which result
+func (app *ReactAppWrapper) audited(category, action string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handler(c)
+
+		entry := &storage.AuditEntry{
+			Time:     time.Now().UTC(),
+			Actor:    c.GetString(userIDContextKey),
+			Category: category,
+			Action:   action,
+			Target:   c.Param(useridParam),
+			Status:   c.Writer.Status(),
+		}
+		if target := c.GetString(auditTargetKey); target != "" {
+			entry.Target = target
+		}
+		if params, ok := c.Get(auditParamsKey); ok {
+			entry.Params = params.(map[string]string)
+		}
+		err := app.auditStorer.RecordAudit(entry)
+		if err != nil {
+			log.Error(uiLogger, "can't record the audit entry: ", err)
+		}
+	}
+}
+
+// auditTarget sets the target when it is not in the url
+func auditTarget(c *gin.Context, target string) {
+	c.Set(auditTargetKey, target)
+}
+
+// auditParam adds a parameter to the audit entry, never pass secrets
+func auditParam(c *gin.Context, key, value string) {
+	params, ok := c.Get(auditParamsKey)
+	if !ok {
+		params = make(map[string]string)
+		c.Set(auditParamsKey, params)
+	}
+	params.(map[string]string)[key] = value
+}
+
+func (app *ReactAppWrapper) listAudit(c *gin.Context) {
+	entries, err := app.auditStorer.AuditLog(c.Query(categoryQuery))
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]viewmodel.AuditEntry, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, viewmodel.AuditEntry{
+			Time:     e.Time,
+			Actor:    e.Actor,
+			Category: e.Category,
+			Action:   e.Action,
+			Target:   e.Target,
+			Params:   e.Params,
+			Status:   e.Status,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -2,8 +2,11 @@ package ui
 
 import (
 	"io"
+	"os"
+	"strconv"
 
 	"github.com/ddvk/rmfakecloud/internal/app/hub"
+	"github.com/ddvk/rmfakecloud/internal/messages"
 	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/models"
 	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
@@ -21,7 +24,7 @@ func (d *backend10) Sync(uid string) {
 
 func (d *backend10) CreateDocument(uid, filename, parent string, stream io.Reader) (doc *storage.Document, err error) {
 	doc, err = d.documentHandler.CreateDocument(uid, filename, parent, stream)
-	if err != nil {
+	if err != nil || doc.Action == storage.ActionSkipped {
 		return
 	}
 
@@ -43,8 +46,207 @@ func (d *backend10) GetDocumentTree(uid string) (tree *viewmodel.DocumentTree, e
 		return nil, err
 	}
 
-	return viewmodel.DocTreeFromRawMetadata(documents), nil
+	trashed, err := d.documentHandler.TrashedDocuments(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	tree = viewmodel.DocTreeFromRawMetadata(documents)
+	tree.SetTrashed(trashed)
+	return tree, nil
+}
+
+func (d *backend10) GetFolderTree(uid string) (tree *viewmodel.FolderNode, err error) {
+	documents, err := d.documentHandler.GetAllMetadata(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return viewmodel.FolderTreeFromRawMetadata(documents), nil
+}
+
+func (d *backend10) RootGeneration(uid string) (int64, error) {
+	return 0, nil
+}
+
+// DocumentGeneration the version of the document
+func (d *backend10) DocumentGeneration(uid, docid string) (string, error) {
+	metadata, err := d.documentHandler.GetMetadata(uid, docid)
+	if os.IsNotExist(err) {
+		return "", storage.ErrorNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(metadata.Version), nil
 }
+
 func (d *backend10) Export(uid, doc, exporttype string, opt storage.ExportOption) (stream io.ReadCloser, err error) {
 	return d.documentHandler.ExportDocument(uid, doc, exporttype, opt)
 }
+
+// FindOrphans there are no orphans without blobs
+func (d *backend10) FindOrphans(uid string) ([]*storage.Orphan, error) {
+	return []*storage.Orphan{}, nil
+}
+
+// ResolveOrphans nothing to resolve
+func (d *backend10) ResolveOrphans(uid string) ([]*storage.Orphan, error) {
+	return []*storage.Orphan{}, nil
+}
+
+// DeleteDocument deletes the document and notifies the devices about every document that changed
+func (d *backend10) DeleteDocument(uid, docid, mode string) (*storage.Deletion, error) {
+	documents, err := d.documentHandler.GetAllMetadata(uid)
+	if err != nil {
+		return nil, err
+	}
+	deletion, err := d.documentHandler.DeleteDocument(uid, docid, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	notify := func(doc *messages.RawMetadata, eventType string) {
+		ntf := hub.DocumentNotification{
+			ID:      doc.ID,
+			Type:    doc.Type,
+			Version: doc.Version,
+			Parent:  doc.Parent,
+			Name:    doc.VissibleName,
+		}
+		d.h.Notify(uid, "web", ntf, eventType)
+	}
+	for _, id := range deletion.Moved {
+		doc, err := d.documentHandler.GetMetadata(uid, id)
+		if err != nil {
+			log.Warn(uiLogger, "can't notify about the moved document ", id, err)
+			continue
+		}
+		notify(doc, hub.DocAddedEvent)
+	}
+	deleted := make(map[string]bool, len(deletion.Deleted))
+	for _, id := range deletion.Deleted {
+		deleted[id] = true
+	}
+	for _, doc := range documents {
+		if deleted[doc.ID] {
+			notify(doc, hub.DocDeletedEvent)
+		}
+	}
+	return deletion, nil
+}
+
+// GetPages the page ids of a notebook
+func (d *backend10) GetPages(uid, docid string) ([]string, error) {
+	return d.documentHandler.GetPages(uid, docid)
+}
+
+// ReorderPages changes the page order and notifies the devices about the new version
+func (d *backend10) ReorderPages(uid, docid string, pages []string) error {
+	err := d.documentHandler.ReorderPages(uid, docid, pages)
+	if err != nil {
+		return err
+	}
+	doc, err := d.documentHandler.GetMetadata(uid, docid)
+	if err != nil {
+		log.Warn(uiLogger, "can't notify about the reordered document ", docid, err)
+		return nil
+	}
+	ntf := hub.DocumentNotification{
+		ID:      doc.ID,
+		Type:    doc.Type,
+		Version: doc.Version,
+		Parent:  doc.Parent,
+		Name:    doc.VissibleName,
+	}
+	d.h.Notify(uid, "web", ntf, hub.DocAddedEvent)
+	return nil
+}
+
+// MoveDocument moves or renames the document and notifies the devices about the new version
+func (d *backend10) MoveDocument(uid, docid, name, parent string) (*storage.Document, error) {
+	doc, err := d.documentHandler.MoveDocument(uid, docid, name, parent)
+	if err != nil {
+		return nil, err
+	}
+	ntf := hub.DocumentNotification{
+		ID:      doc.ID,
+		Type:    doc.Type,
+		Version: doc.Version,
+		Parent:  doc.Parent,
+		Name:    doc.Name,
+	}
+	d.h.Notify(uid, "web", ntf, hub.DocAddedEvent)
+	return doc, nil
+}
+
+// DocumentTags the tags of the documents
+func (d *backend10) DocumentTags(uid string) (map[string][]string, error) {
+	return d.documentHandler.DocumentTags(uid)
+}
+
+// RepairMetadata repairs the metadata and notifies the devices about the repaired documents
+func (d *backend10) RepairMetadata(uid string, dryRun bool) ([]*storage.MetadataRepair, error) {
+	repairs, err := d.documentHandler.RepairMetadata(uid, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range repairs {
+		if !r.Repaired {
+			continue
+		}
+		doc, err := d.documentHandler.GetMetadata(uid, r.ID)
+		if err != nil {
+			log.Warn(uiLogger, "can't notify about the repaired document ", r.ID, err)
+			continue
+		}
+		ntf := hub.DocumentNotification{
+			ID:      doc.ID,
+			Type:    doc.Type,
+			Version: doc.Version,
+			Parent:  doc.Parent,
+			Name:    doc.VissibleName,
+		}
+		d.h.Notify(uid, "web", ntf, hub.DocAddedEvent)
+	}
+	return repairs, nil
+}
+
+// Snapshots there are no generations without blobs
+func (d *backend10) Snapshots(uid string) ([]*storage.Snapshot, error) {
+	return []*storage.Snapshot{}, nil
+}
+
+// RestoreSnapshot nothing to restore
+func (d *backend10) RestoreSnapshot(uid string, generation int64) (*storage.Snapshot, error) {
+	return nil, storage.ErrorSnapshotUnavailable
+}
+
+// ExportAccount the documents and their metadata
+func (d *backend10) ExportAccount(uid string, w io.Writer) error {
+	return d.documentHandler.ExportAccount(uid, w)
+}
+
+// ImportAccount restores the documents and notifies the devices about each of them
+func (d *backend10) ImportAccount(uid string, r io.Reader) (*storage.AccountImport, error) {
+	result, err := d.documentHandler.ImportAccount(uid, r)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range result.Documents {
+		doc, err := d.documentHandler.GetMetadata(uid, id)
+		if err != nil {
+			log.Warn(uiLogger, "can't notify about the imported document ", id, err)
+			continue
+		}
+		ntf := hub.DocumentNotification{
+			ID:      doc.ID,
+			Type:    doc.Type,
+			Version: doc.Version,
+			Parent:  doc.Parent,
+			Name:    doc.VissibleName,
+		}
+		d.h.Notify(uid, "web", ntf, hub.DocAddedEvent)
+	}
+	return result, nil
+}
@@ -21,9 +21,50 @@ func (b *backend15) GetDocumentTree(uid string) (tree *viewmodel.DocumentTree, e
 		return nil, err
 	}
 
-	return viewmodel.DocTreeFromHashTree(hashTree), nil
+	trashed, err := b.blobHandler.TrashedDocuments(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	tree = viewmodel.DocTreeFromHashTree(hashTree)
+	tree.SetTrashed(trashed)
+	return tree, nil
+}
+
+func (b *backend15) GetFolderTree(uid string) (tree *viewmodel.FolderNode, err error) {
+	hashTree, err := b.blobHandler.GetTree(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return viewmodel.FolderTreeFromHashTree(hashTree), nil
+}
+
+// DocumentGeneration the hash of the document index
+func (b *backend15) DocumentGeneration(uid, docid string) (string, error) {
+	hashTree, err := b.blobHandler.GetTree(uid)
+	if err != nil {
+		return "", err
+	}
+	doc, err := hashTree.FindDoc(docid)
+	if err != nil {
+		return "", storage.ErrorNotFound
+	}
+	return doc.Hash, nil
 }
+
+func (b *backend15) RootGeneration(uid string) (int64, error) {
+	hashTree, err := b.blobHandler.GetTree(uid)
+	if err != nil {
+		return 0, err
+	}
+	return hashTree.Generation, nil
+}
+
 func (b *backend15) Export(uid, docid, exporttype string, opt storage.ExportOption) (r io.ReadCloser, err error) {
+	if exporttype == nativeFormat {
+		return b.blobHandler.ExportNative(uid, docid)
+	}
 	r, err = b.blobHandler.Export(uid, docid)
 	return
 }
@@ -37,3 +78,97 @@ func (b *backend15) Sync(uid string) {
 	logrus.Info("notifying")
 	b.h.NotifySync(uid, uuid.NewString())
 }
+
+func (b *backend15) FindOrphans(uid string) ([]*storage.Orphan, error) {
+	return b.blobHandler.FindOrphans(uid)
+}
+
+func (b *backend15) ResolveOrphans(uid string) ([]*storage.Orphan, error) {
+	orphans, err := b.blobHandler.ResolveOrphans(uid)
+	if err != nil {
+		return nil, err
+	}
+	b.Sync(uid)
+	return orphans, nil
+}
+
+func (b *backend15) DeleteDocument(uid, docid, mode string) (*storage.Deletion, error) {
+	deletion, err := b.blobHandler.DeleteBlobDocument(uid, docid, mode)
+	if err != nil {
+		return nil, err
+	}
+	b.Sync(uid)
+	return deletion, nil
+}
+
+// GetPages the page ids of a notebook
+func (b *backend15) GetPages(uid, docid string) ([]string, error) {
+	return b.blobHandler.GetBlobPages(uid, docid)
+}
+
+// ReorderPages changes the page order and notifies the devices
+func (b *backend15) ReorderPages(uid, docid string, pages []string) error {
+	err := b.blobHandler.ReorderBlobPages(uid, docid, pages)
+	if err != nil {
+		return err
+	}
+	b.Sync(uid)
+	return nil
+}
+
+// MoveDocument moves or renames the document and notifies the devices
+func (b *backend15) MoveDocument(uid, docid, name, parent string) (*storage.Document, error) {
+	doc, err := b.blobHandler.MoveBlobDocument(uid, docid, name, parent)
+	if err != nil {
+		return nil, err
+	}
+	b.Sync(uid)
+	return doc, nil
+}
+
+// DocumentTags the tags of the documents
+func (b *backend15) DocumentTags(uid string) (map[string][]string, error) {
+	return b.blobHandler.BlobDocumentTags(uid)
+}
+
+// RepairMetadata repairs the metadata and notifies the devices if anything was repaired
+func (b *backend15) RepairMetadata(uid string, dryRun bool) ([]*storage.MetadataRepair, error) {
+	repairs, err := b.blobHandler.RepairBlobMetadata(uid, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun && len(repairs) > 0 {
+		b.Sync(uid)
+	}
+	return repairs, nil
+}
+
+// Snapshots the kept generations of the root
+func (b *backend15) Snapshots(uid string) ([]*storage.Snapshot, error) {
+	return b.blobHandler.Snapshots(uid)
+}
+
+// RestoreSnapshot restores the generation and notifies the devices
+func (b *backend15) RestoreSnapshot(uid string, generation int64) (*storage.Snapshot, error) {
+	snapshot, err := b.blobHandler.RestoreSnapshot(uid, generation)
+	if err != nil {
+		return nil, err
+	}
+	b.Sync(uid)
+	return snapshot, nil
+}
+
+// ExportAccount the root and all the blobs it references
+func (b *backend15) ExportAccount(uid string, w io.Writer) error {
+	return b.blobHandler.ExportBlobAccount(uid, w)
+}
+
+// ImportAccount restores the blobs as a new generation and notifies the devices
+func (b *backend15) ImportAccount(uid string, r io.Reader) (*storage.AccountImport, error) {
+	result, err := b.blobHandler.ImportBlobAccount(uid, r)
+	if err != nil {
+		return nil, err
+	}
+	b.Sync(uid)
+	return result, nil
+}
@@ -0,0 +1,124 @@
+package ui
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// exportCache keeps the most recently used exports in memory
+// the generation is part of the key, so changed documents are never served stale
+type exportCache struct {
+	lock    sync.Mutex
+	maxSize int64
+	size    int64
+	items   map[string]*list.Element
+	order   *list.List
+}
+
+type cachedExport struct {
+	key  string
+	data []byte
+}
+
+func newExportCache(maxSize int64) *exportCache {
+	return &exportCache{
+		maxSize: maxSize,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// exportKey identifies an export of a specific document generation
+func exportKey(uid, docid, generation, format string, options ...interface{}) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%v", uid, docid, generation, format, options)
+}
+
+// etag of the export
+func etag(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+func (c *exportCache) enabled() bool {
+	return c.maxSize > 0
+}
+
+// Get returns the cached export and marks it as recently used
+func (c *exportCache) Get(key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*cachedExport).data, true
+	}
+	return nil, false
+}
+
+// Put adds the export, evicting the least recently used ones when the cache is full
+func (c *exportCache) Put(key string, data []byte) {
+	size := int64(len(data))
+	if size > c.maxSize {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.order.Back())
+	}
+	c.items[key] = c.order.PushFront(&cachedExport{key: key, data: data})
+	c.size += size
+}
+
+func (c *exportCache) remove(e *list.Element) {
+	item := c.order.Remove(e).(*cachedExport)
+	delete(c.items, item.key)
+	c.size -= int64(len(item.data))
+}
+
+// cachingReader copies what is read, as long as it fits in the cache
+// and stores it once the export was read completely
+type cachingReader struct {
+	io.Reader
+	cache *exportCache
+	key   string
+	buf   bytes.Buffer
+	done  bool
+}
+
+func (c *exportCache) reader(key string, r io.Reader) io.Reader {
+	return &cachingReader{
+		Reader: r,
+		cache:  c,
+		key:    key,
+	}
+}
+
+func (r *cachingReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if r.done {
+		return
+	}
+	if n > 0 {
+		if int64(r.buf.Len()+n) > r.cache.maxSize {
+			// too big, don't bother
+			r.done = true
+			r.buf = bytes.Buffer{}
+			return
+		}
+		r.buf.Write(p[:n])
+	}
+	if err == io.EOF {
+		r.done = true
+		r.cache.Put(r.key, r.buf.Bytes())
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package ui
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestExportCacheEviction(t *testing.T) {
+	cache := newExportCache(10)
+
+	cache.Put("a", []byte("12345"))
+	cache.Put("b", []byte("12345"))
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("a should be cached")
+	}
+	// b is now the least recently used
+	cache.Put("c", []byte("123"))
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("a should be cached")
+	}
+	cache.Put("d", []byte("12345678901"))
+	if _, ok := cache.Get("d"); ok {
+		t.Error("d is bigger than the cache")
+	}
+}
+
+func TestCachingReader(t *testing.T) {
+	cache := newExportCache(10)
+
+	r := cache.reader("small", strings.NewReader("data"))
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	if data, ok := cache.Get("small"); !ok || string(data) != "data" {
+		t.Error("not cached after reading")
+	}
+
+	r = cache.reader("big", strings.NewReader("more than ten bytes"))
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	if _, ok := cache.Get("big"); ok {
+		t.Error("should not be cached")
+	}
+}
@@ -0,0 +1,200 @@
+package ui
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	folderQuery = "folder"
+	tagQuery    = "tag"
+)
+
+// exportExtensions the file extension of each export format
+var exportExtensions = map[string]string{
+	"pdf":        ".pdf",
+	nativeFormat: ".tar",
+}
+
+// exportEntry a document and its path in the zip
+type exportEntry struct {
+	id   string
+	path string
+}
+
+// zipName a file name without separators
+func zipName(name, id string) string {
+	name = strings.TrimSpace(strings.NewReplacer("/", "_", "\\", "_").Replace(name))
+	if name == "" || name == "." || name == ".." {
+		return id
+	}
+	return name
+}
+
+// findFolder the folder with the id, in the tree
+func findFolder(node *viewmodel.FolderNode, id string) *viewmodel.FolderNode {
+	if node.ID == id {
+		return node
+	}
+	for _, f := range node.Folders {
+		if found := findFolder(f, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// exportEntries the documents in the folder and its subfolders, with their paths relative to it
+// when tagged is set only the documents it accepts
+func exportEntries(node *viewmodel.FolderNode, ext string, tagged func(id string) bool) []exportEntry {
+	entries := make([]exportEntry, 0)
+	used := make(map[string]bool)
+	unique := func(dir, name, ext string) string {
+		p := path.Join(dir, name+ext)
+		for i := 2; used[p]; i++ {
+			p = path.Join(dir, fmt.Sprintf("%s (%d)%s", name, i, ext))
+		}
+		used[p] = true
+		return p
+	}
+
+	var walk func(node *viewmodel.FolderNode, dir string)
+	walk = func(node *viewmodel.FolderNode, dir string) {
+		for _, d := range node.Documents {
+			if tagged != nil && !tagged(d.ID) {
+				continue
+			}
+			entries = append(entries, exportEntry{
+				id:   d.ID,
+				path: unique(dir, zipName(d.Name, d.ID), ext),
+			})
+		}
+		for _, f := range node.Folders {
+			walk(f, unique(dir, zipName(f.Name, f.ID), ""))
+		}
+	}
+	walk(node, "")
+	return entries
+}
+
+// exportDocuments streams a zip with the documents of a folder, or with a tag, in their folders
+// format=account is a tar of the whole account instead
+func (app *ReactAppWrapper) exportDocuments(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+	folderID := c.Query(folderQuery)
+	tag := c.Query(tagQuery)
+	format := c.DefaultQuery("format", "pdf")
+	if format == accountFormat {
+		app.exportAccount(c, uid)
+		return
+	}
+	ext, ok := exportExtensions[format]
+	if !ok {
+		badReq(c, "invalid format: "+format)
+		return
+	}
+	backend := getBackend(c)
+
+	tree, err := backend.GetFolderTree(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	node := tree
+	archiveName := "documents"
+	if folderID != "" {
+		node = findFolder(tree, folderID)
+		if node == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no folder " + folderID})
+			return
+		}
+		archiveName = zipName(node.Name, node.ID)
+	}
+
+	var tagged func(string) bool
+	if tag != "" {
+		tags, err := backend.DocumentTags(uid)
+		if err != nil {
+			log.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		tagged = func(id string) bool {
+			for _, t := range tags[id] {
+				if t == tag {
+					return true
+				}
+			}
+			return false
+		}
+		if folderID == "" {
+			archiveName = zipName(tag, "tag")
+		}
+	}
+
+	entries := exportEntries(node, ext, tagged)
+	log.Info(uiLogger, "exporting ", len(entries), " documents of ", uid)
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archiveName+".zip"))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+
+	// written as it goes, an error can't change the status anymore
+	// the connection is aborted instead, the client doesn't keep a truncated zip
+	w := zip.NewWriter(c.Writer)
+	for _, e := range entries {
+		err = exportEntryTo(w, backend, uid, e, format)
+		if err != nil {
+			log.Error(uiLogger, "export of ", e.id, " failed: ", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		log.Error(err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// exportEntryTo exports the document to a temp file first, a failed export doesn't leave
+// half an entry in the zip
+func exportEntryTo(w *zip.Writer, backend backend, uid string, e exportEntry, format string) error {
+	var exportOption storage.ExportOption
+	reader, err := backend.Export(uid, e.id, format, exportOption)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tmp, err := ioutil.TempFile("", "rmfakecloud-export")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err = io.Copy(tmp, reader); err != nil {
+		return err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	fw, err := w.Create(e.path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, tmp)
+	return err
+}
@@ -0,0 +1,59 @@
+package ui
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+)
+
+func TestExportEntries(t *testing.T) {
+	docs := []*messages.RawMetadata{
+		{ID: "projects", VissibleName: "Projects", Type: models.CollectionType},
+		{ID: "work", VissibleName: "Work/Old", Type: models.CollectionType, Parent: "projects"},
+		{ID: "plan", VissibleName: "plan", Type: models.DocumentType, Parent: "projects"},
+		{ID: "plan2", VissibleName: "plan", Type: models.DocumentType, Parent: "projects"},
+		{ID: "notes", VissibleName: "notes", Type: models.DocumentType, Parent: "work"},
+		{ID: "other", VissibleName: "other", Type: models.DocumentType},
+		{ID: "deleted", VissibleName: "deleted", Type: models.DocumentType, Parent: "trash"},
+	}
+	tree := viewmodel.FolderTreeFromRawMetadata(docs)
+
+	paths := func(entries []exportEntry) map[string]string {
+		result := make(map[string]string)
+		for _, e := range entries {
+			result[e.id] = e.path
+		}
+		return result
+	}
+
+	folder := findFolder(tree, "projects")
+	if folder == nil {
+		t.Fatal("projects not found")
+	}
+	got := paths(exportEntries(folder, ".pdf", nil))
+	want := map[string]string{
+		"plan":  "plan.pdf",
+		"plan2": "plan (2).pdf",
+		"notes": "Work_Old/notes.pdf",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("folder: got %v, want %v", got, want)
+	}
+
+	tagged := map[string]bool{"notes": true, "other": true}
+	got = paths(exportEntries(tree, ".pdf", func(id string) bool { return tagged[id] }))
+	want = map[string]string{
+		"notes": "Projects/Work_Old/notes.pdf",
+		"other": "other.pdf",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tag: got %v, want %v", got, want)
+	}
+
+	if findFolder(tree, "missing") != nil {
+		t.Error("missing folder found")
+	}
+}
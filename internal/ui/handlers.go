@@ -1,12 +1,18 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/jobs"
 	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
@@ -21,9 +27,49 @@ const (
 	docIDParam          = "docid"
 	uiLogger            = "[ui] "
 	useridParam         = "userid"
+	jobidParam          = "jobid"
+	originalFormat      = "original"
+	nativeFormat        = "native"
 	cookieName          = ".Authrmfakecloud"
+	accountExpired      = "account expired"
+	accountDisabled     = "account disabled"
+	includeTrashedQuery = "includeTrashed"
+	onlyTrashedQuery    = "onlyTrashed"
+	dryRunQuery         = "dryRun"
+	generationParam     = "generation"
 )
 
+const (
+	featureRegistration = "registration"
+	featureEmail        = "email"
+	featureHWR          = "hwr"
+	featureOIDC         = "oidc"
+)
+
+func (app *ReactAppWrapper) appConfig(c *gin.Context) {
+	branding := app.cfg.Branding
+	features := []string{}
+	if app.cfg.RegistrationOpen {
+		features = append(features, featureRegistration)
+	}
+	if app.cfg.SMTPConfig != nil {
+		features = append(features, featureEmail)
+	}
+	if app.cfg.HWRApplicationKey != "" && app.cfg.HWRHmac != "" {
+		features = append(features, featureHWR)
+	}
+	if app.oidc != nil {
+		features = append(features, featureOIDC)
+	}
+
+	c.JSON(http.StatusOK, viewmodel.AppConfig{
+		InstanceName: branding.InstanceName,
+		LogoURL:      branding.LogoURL,
+		ThemeColor:   branding.ThemeColor,
+		Features:     features,
+	})
+}
+
 func (app *ReactAppWrapper) register(c *gin.Context) {
 
 	if !app.cfg.RegistrationOpen {
@@ -61,6 +107,7 @@ func (app *ReactAppWrapper) register(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	user.ExpiresAt = app.newAccountExpiry()
 
 	err = app.userStorer.RegisterUser(user)
 	if err != nil {
@@ -115,7 +162,28 @@ func (app *ReactAppWrapper) login(c *gin.Context) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
+	if user.Expired() {
+		log.Warn(uiLogger, "account expired: ", user.ID, ", login failed ip: ", c.ClientIP())
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": accountExpired})
+		return
+	}
+	if user.Disabled {
+		log.Warn(uiLogger, "account disabled: ", user.ID, ", login failed ip: ", c.ClientIP())
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": accountDisabled})
+		return
+	}
 
+	tokenString, err := app.newSession(c, user)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.String(http.StatusOK, tokenString)
+}
+
+// newSession signs the token of the web ui for the user and sets it as the cookie
+func (app *ReactAppWrapper) newSession(c *gin.Context, user *model.User) (string, error) {
 	scopes := ""
 	if user.Sync15 {
 		scopes = isSync15Key
@@ -129,6 +197,7 @@ func (app *ReactAppWrapper) login(c *gin.Context) {
 		Scopes:    scopes,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expires,
+			IssuedAt:  time.Now().Unix(),
 			Issuer:    "rmFake WEB",
 			Audience:  WebUsage,
 		},
@@ -140,16 +209,12 @@ func (app *ReactAppWrapper) login(c *gin.Context) {
 	}
 
 	tokenString, err := common.SignClaims(claims, app.cfg.JWTSecretKey)
-
 	if err != nil {
-		log.Error(err)
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
+		return "", err
 	}
 	log.Debug("cookie expires after: ", expiresAfter)
 	c.SetCookie(cookieName, tokenString, int(expiresAfter.Seconds()), "/", "", app.cfg.HTTPSCookie, true)
-
-	c.String(http.StatusOK, tokenString)
+	return tokenString, nil
 }
 
 func (app *ReactAppWrapper) changePassword(c *gin.Context) {
@@ -229,13 +294,56 @@ func getBackend(c *gin.Context) backend {
 	return blah.(backend)
 
 }
+
+// trashFilter the documents without the trash, unless includeTrashed or onlyTrashed is set
+func trashFilter(c *gin.Context) (string, error) {
+	include, err := strconv.ParseBool(c.DefaultQuery(includeTrashedQuery, "false"))
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %s", includeTrashedQuery, c.Query(includeTrashedQuery))
+	}
+	only, err := strconv.ParseBool(c.DefaultQuery(onlyTrashedQuery, "false"))
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %s", onlyTrashedQuery, c.Query(onlyTrashedQuery))
+	}
+	switch {
+	case include && only:
+		return "", fmt.Errorf("%s and %s can't be combined", includeTrashedQuery, onlyTrashedQuery)
+	case include:
+		return viewmodel.TrashInclude, nil
+	case only:
+		return viewmodel.TrashOnly, nil
+	}
+	return viewmodel.TrashExclude, nil
+}
+
 func (app *ReactAppWrapper) listDocuments(c *gin.Context) {
 	uid := c.GetString(userIDContextKey)
 
+	filter, err := trashFilter(c)
+	if err != nil {
+		badReq(c, err.Error())
+		return
+	}
+
 	var tree *viewmodel.DocumentTree
 
 	backend := getBackend(c)
-	tree, err := backend.GetDocumentTree(uid)
+	tree, err = backend.GetDocumentTree(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	tree.FilterTrash(filter)
+	c.JSON(http.StatusOK, tree)
+}
+
+// folderTree the nested folders with their documents
+func (app *ReactAppWrapper) folderTree(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+
+	backend := getBackend(c)
+	tree, err := backend.GetFolderTree(uid)
 	if err != nil {
 		log.Error(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -243,22 +351,81 @@ func (app *ReactAppWrapper) listDocuments(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, tree)
 }
+
+// abortDocumentError 404 when the document doesn't exist, 500 when it can't be read
+func abortDocumentError(c *gin.Context, err error) {
+	if errors.Is(err, storage.ErrorNotFound) {
+		log.Warn(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	log.Error(err)
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
 func (app *ReactAppWrapper) getDocument(c *gin.Context) {
 	uid := c.GetString(userIDContextKey)
 	docid := common.ParamS(docIDParam, c)
+	format := c.DefaultQuery("format", "pdf")
+	var exportOption storage.ExportOption
 	log.Info("exporting ", docid)
 	backend := getBackend(c)
-	reader, err := backend.Export(uid, docid, "pdf", 0)
+
+	if format == originalFormat {
+		app.getOriginal(c, uid, docid)
+		return
+	}
+
+	generation, err := backend.DocumentGeneration(uid, docid)
+	if err != nil {
+		abortDocumentError(c, err)
+		return
+	}
+	key := exportKey(uid, docid, generation, format, exportOption)
+	tag := etag(key)
+	c.Header("ETag", tag)
+	if c.GetHeader("If-None-Match") == tag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	cache := app.exportCache
+	if cache.enabled() {
+		if data, ok := cache.Get(key); ok {
+			log.Debug(uiLogger, "export from cache ", docid)
+			c.Data(http.StatusOK, "application/octet-stream", data)
+			return
+		}
+	}
+
+	reader, err := backend.Export(uid, docid, format, exportOption)
 	if err != nil {
 		log.Error(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	defer reader.Close()
+
+	var r io.Reader = reader
+	if cache.enabled() {
+		r = cache.reader(key, reader)
+	}
+	c.DataFromReader(http.StatusOK, -1, "application/octet-stream", r, nil)
+}
 
+// getOriginal the uploaded pdf, when its images were downscaled
+func (app *ReactAppWrapper) getOriginal(c *gin.Context, uid, docid string) {
+	reader, err := app.documentHandler.GetOriginal(uid, docid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 	defer reader.Close()
-	c.DataFromReader(http.StatusOK, -1, "application/octet-stream", reader, nil)
+	c.DataFromReader(http.StatusOK, -1, "application/pdf", reader, nil)
 }
 
+// updateDocument moves or renames a document
 func (app *ReactAppWrapper) updateDocument(c *gin.Context) {
 	upd := viewmodel.UpdateDoc{}
 	if err := c.ShouldBindJSON(&upd); err != nil {
@@ -266,16 +433,64 @@ func (app *ReactAppWrapper) updateDocument(c *gin.Context) {
 		badReq(c, err.Error())
 		return
 	}
-	// uid := c.GetString(userID)
-	// docid := c.Param("docid")
+	uid := c.GetString(userIDContextKey)
 
-	c.Status(http.StatusOK)
+	log.Info(uiLogger, "moving: ", upd.DocumentID, " to: '", upd.ParentID, "' as: ", upd.Name)
+	backend := getBackend(c)
+	doc, err := backend.MoveDocument(uid, upd.DocumentID, upd.Name, upd.ParentID)
+	if err != nil {
+		log.Error(err)
+		switch {
+		case errors.Is(err, storage.ErrorNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrorNameTaken):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrorInvalidMove):
+			badReq(c, err.Error())
+		default:
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, viewmodel.Document{
+		ID:           doc.ID,
+		Name:         doc.Name,
+		DocumentType: doc.Type,
+	})
 }
+
+// deleteDocument deletes a document, the children of a folder are moved to its parent
+// or with mode=recursive deleted as well
 func (app *ReactAppWrapper) deleteDocument(c *gin.Context) {
-	// uid := c.GetString(userID)
-	// docid := c.Param("docid")
+	uid := c.GetString(userIDContextKey)
+	docid := c.Param("docid")
+	mode := c.DefaultQuery("mode", storage.DeleteReparent)
+	if mode != storage.DeleteReparent && mode != storage.DeleteRecursive {
+		badReq(c, "invalid mode: "+mode)
+		return
+	}
 
-	c.Status(http.StatusOK)
+	log.Info(uiLogger, "deleting: ", docid, " mode: ", mode)
+	backend := getBackend(c)
+	deletion, err := backend.DeleteDocument(uid, docid, mode)
+	if err != nil {
+		log.Error(err)
+		switch {
+		case errors.Is(err, storage.ErrorNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrorInvalidDeleteMode):
+			badReq(c, err.Error())
+		default:
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, viewmodel.DeleteResult{
+		ID:      deletion.ID,
+		Parent:  deletion.Parent,
+		Moved:   deletion.Moved,
+		Deleted: deletion.Deleted,
+	})
 }
 func (app *ReactAppWrapper) createDocument(c *gin.Context) {
 	uid := c.GetString(userIDContextKey)
@@ -296,6 +511,7 @@ func (app *ReactAppWrapper) createDocument(c *gin.Context) {
 	}
 	log.Info("Parent: " + parentID)
 
+	results := make([]viewmodel.UploadResult, 0, len(form.File["file"]))
 	for _, file := range form.File["file"] {
 		f, err := file.Open()
 		if err != nil {
@@ -308,15 +524,236 @@ func (app *ReactAppWrapper) createDocument(c *gin.Context) {
 		//do the stuff
 		log.Info(uiLogger, fmt.Sprintf("Uploading %s , size: %d", file.Filename, file.Size))
 
-		_, err = backend.CreateDocument(uid, file.Filename, parentID, f)
+		doc, err := backend.CreateDocument(uid, file.Filename, parentID, f)
 		if err != nil {
 			log.Error(err)
+			if errors.Is(err, storage.ErrorRejected) {
+				badReq(c, file.Filename+": "+err.Error())
+				return
+			}
+			if errors.Is(err, storage.ErrorNameTaken) {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": file.Filename + ": " + err.Error()})
+				return
+			}
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
+		results = append(results, viewmodel.UploadResult{
+			FileName:   file.Filename,
+			ID:         doc.ID,
+			Name:       doc.Name,
+			Action:     doc.Action,
+			BytesSaved: doc.BytesSaved,
+			Warning:    doc.Warning,
+		})
 	}
 	backend.Sync(uid)
-	c.Status(http.StatusOK)
+	c.JSON(http.StatusOK, results)
+}
+
+// syncStatus lists the devices and the root generation they last synced
+func (app *ReactAppWrapper) syncStatus(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+
+	backend := getBackend(c)
+	generation, err := backend.RootGeneration(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	status := viewmodel.SyncStatus{
+		Generation: generation,
+		Devices:    make([]viewmodel.DeviceSync, 0),
+	}
+	for _, d := range app.h.DeviceGenerations(uid) {
+		status.Devices = append(status.Devices, viewmodel.DeviceSync{
+			DeviceID:   d.DeviceID,
+			Generation: d.Generation,
+			LastSeen:   d.LastSeen,
+			Lagging:    d.Generation < generation,
+		})
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func snapshotViewModel(s *storage.Snapshot) viewmodel.Snapshot {
+	return viewmodel.Snapshot{
+		Generation: s.Generation,
+		Hash:       s.Hash,
+		Time:       s.Time,
+		Documents:  s.Documents,
+		Complete:   s.Complete,
+		Current:    s.Current,
+	}
+}
+
+// listSnapshots the past generations of the root which can be restored
+func (app *ReactAppWrapper) listSnapshots(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+	backend := getBackend(c)
+
+	snapshots, err := backend.Snapshots(uid)
+	if err != nil {
+		log.Error(err)
+		if errors.Is(err, storage.ErrorNotSupported) {
+			c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	result := make([]viewmodel.Snapshot, 0, len(snapshots))
+	for _, s := range snapshots {
+		result = append(result, snapshotViewModel(s))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// restoreSnapshot rolls the account back to the generation, as a new generation
+func (app *ReactAppWrapper) restoreSnapshot(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+	auditTarget(c, uid)
+	auditParam(c, "generation", c.Param(generationParam))
+	generation, err := strconv.ParseInt(c.Param(generationParam), 10, 64)
+	if err != nil {
+		badReq(c, "invalid generation: "+c.Param(generationParam))
+		return
+	}
+	backend := getBackend(c)
+
+	log.Info(uiLogger, "restoring generation ", generation, " of ", uid)
+	snapshot, err := backend.RestoreSnapshot(uid, generation)
+	if err != nil {
+		log.Error(err)
+		switch {
+		case errors.Is(err, storage.ErrorSnapshotUnavailable):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrorWrongGeneration):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a device synced in the meantime, try again"})
+		case errors.Is(err, storage.ErrorNotSupported):
+			c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		default:
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, snapshotViewModel(snapshot))
+}
+
+func orphansViewModel(orphans []*storage.Orphan) []viewmodel.Orphan {
+	result := make([]viewmodel.Orphan, 0, len(orphans))
+	for _, o := range orphans {
+		result = append(result, viewmodel.Orphan{
+			ID:       o.ID,
+			Name:     o.Name,
+			Type:     o.Type,
+			Modified: o.Modified,
+			Size:     o.Size,
+			Action:   o.Action,
+		})
+	}
+	return result
+}
+
+func (app *ReactAppWrapper) listOrphans(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+	backend := getBackend(c)
+
+	orphans, err := backend.FindOrphans(uid)
+	if err != nil {
+		log.Error(err)
+		if errors.Is(err, storage.ErrorNotSupported) {
+			c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, orphansViewModel(orphans))
+}
+
+func (app *ReactAppWrapper) resolveOrphans(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+	auditTarget(c, uid)
+	auditParam(c, "policy", app.cfg.OrphanPolicy)
+	backend := getBackend(c)
+
+	log.Info(uiLogger, "resolving orphans, policy: ", app.cfg.OrphanPolicy)
+	orphans, err := backend.ResolveOrphans(uid)
+	if err != nil {
+		log.Error(err)
+		if errors.Is(err, storage.ErrorNotSupported) {
+			c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, orphansViewModel(orphans))
+}
+
+// repairMetadata checks the metadata of the documents and repairs it, with dryRun=true only reports it
+func (app *ReactAppWrapper) repairMetadata(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+	auditTarget(c, uid)
+	dryRun, err := strconv.ParseBool(c.DefaultQuery(dryRunQuery, "false"))
+	if err != nil {
+		badReq(c, "invalid "+dryRunQuery+": "+c.Query(dryRunQuery))
+		return
+	}
+	auditParam(c, dryRunQuery, strconv.FormatBool(dryRun))
+	backend := getBackend(c)
+
+	log.Info(uiLogger, "repairing metadata of ", uid, " dry run: ", dryRun)
+	repairs, err := backend.RepairMetadata(uid, dryRun)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	result := make([]viewmodel.MetadataRepair, 0, len(repairs))
+	for _, r := range repairs {
+		result = append(result, viewmodel.MetadataRepair{
+			ID:       r.ID,
+			Name:     r.Name,
+			Problems: r.Problems,
+			Repaired: r.Repaired,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// newAccountExpiry when an account created now expires, zero if they don't
+func (app *ReactAppWrapper) newAccountExpiry() time.Time {
+	if app.cfg.AccountExpiry == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(app.cfg.AccountExpiry).UTC()
+}
+
+func expiresAt(u *model.User) *time.Time {
+	if u.ExpiresAt.IsZero() {
+		return nil
+	}
+	return &u.ExpiresAt
+}
+
+func userViewModel(u *model.User) viewmodel.User {
+	usr := viewmodel.User{
+		ID:        u.ID,
+		Email:     u.Email,
+		Name:      u.Name,
+		CreatedAt: u.CreatedAt,
+		ExpiresAt: expiresAt(u),
+		Expired:   u.Expired(),
+		Disabled:  u.Disabled,
+	}
+	if !u.TokensRevokedAt.IsZero() {
+		usr.TokensRevokedAt = &u.TokensRevokedAt
+	}
+	return usr
 }
 
 func (app *ReactAppWrapper) getAppUsers(c *gin.Context) {
@@ -331,13 +768,7 @@ func (app *ReactAppWrapper) getAppUsers(c *gin.Context) {
 
 	uilist := make([]viewmodel.User, 0)
 	for _, u := range users {
-		usr := viewmodel.User{
-			ID:        u.ID,
-			Email:     u.Email,
-			Name:      u.Name,
-			CreatedAt: u.CreatedAt,
-		}
-		uilist = append(uilist, usr)
+		uilist = append(uilist, userViewModel(u))
 	}
 	c.JSON(http.StatusOK, uilist)
 }
@@ -364,12 +795,7 @@ func (app *ReactAppWrapper) getUser(c *gin.Context) {
 		return
 	}
 
-	vmUser := &viewmodel.User{
-		ID:        user.ID,
-		Email:     user.Email,
-		Name:      user.Name,
-		CreatedAt: user.CreatedAt,
-	}
+	vmUser := userViewModel(user)
 	for _, i := range user.Integrations {
 		vmUser.Integrations = append(vmUser.Integrations, i.Name)
 	}
@@ -384,6 +810,7 @@ func (app *ReactAppWrapper) updateUser(c *gin.Context) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
+	auditTarget(c, req.ID)
 	user, err := app.userStorer.GetUser(req.ID)
 	if err != nil {
 		log.Error(err)
@@ -397,10 +824,21 @@ func (app *ReactAppWrapper) updateUser(c *gin.Context) {
 	}
 	if req.NewPassword != "" {
 		user.SetPassword(req.NewPassword)
+		auditParam(c, "password", "changed")
 	}
 
 	if req.Email != "" {
 		user.Email = req.Email
+		auditParam(c, "email", req.Email)
+	}
+
+	switch {
+	case req.RemoveExpiry:
+		user.ExpiresAt = time.Time{}
+		auditParam(c, "expiresAt", "never")
+	case req.ExpiresAt != nil:
+		user.ExpiresAt = req.ExpiresAt.UTC()
+		auditParam(c, "expiresAt", user.ExpiresAt.Format(time.RFC3339))
 	}
 
 	err = app.userStorer.UpdateUser(user)
@@ -434,6 +872,8 @@ func (app *ReactAppWrapper) createUser(c *gin.Context) {
 		return
 	}
 
+	auditTarget(c, req.ID)
+	auditParam(c, "email", req.Email)
 	user, err := model.NewUser(req.ID, req.NewPassword)
 
 	if err != nil {
@@ -442,6 +882,16 @@ func (app *ReactAppWrapper) createUser(c *gin.Context) {
 		return
 	}
 	user.Email = req.Email
+	switch {
+	case req.NoExpiry:
+	case req.ExpiresAt != nil:
+		user.ExpiresAt = req.ExpiresAt.UTC()
+	default:
+		user.ExpiresAt = app.newAccountExpiry()
+	}
+	if !user.ExpiresAt.IsZero() {
+		auditParam(c, "expiresAt", user.ExpiresAt.Format(time.RFC3339))
+	}
 
 	err = app.userStorer.UpdateUser(user)
 	if err != nil {
@@ -451,3 +901,56 @@ func (app *ReactAppWrapper) createUser(c *gin.Context) {
 	}
 	c.Status(http.StatusCreated)
 }
+
+func (app *ReactAppWrapper) startGarbageCollection(c *gin.Context) {
+	uid := common.ParamS(useridParam, c)
+
+	if _, err := app.userStorer.GetUser(uid); err != nil {
+		log.Error(err)
+		c.AbortWithStatusJSON(http.StatusNotFound, "Invalid user")
+		return
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultQuery(dryRunQuery, "false"))
+	if err != nil {
+		badReq(c, "invalid "+dryRunQuery+": "+c.Query(dryRunQuery))
+		return
+	}
+	// it needs the files of the data dir, the job would only fail
+	if app.cfg.StorageProvider == config.StorageProviderS3 {
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": storage.ErrorNotSupported.Error()})
+		return
+	}
+
+	job, err := app.jobs.Start(jobs.KindGC, uid, func(update func(interface{})) error {
+		_, err := app.blobHandler.GarbageCollect(uid, dryRun, func(stats storage.GCStats) {
+			update(stats)
+		})
+		return err
+	})
+	if err == jobs.ErrorAlreadyRunning {
+		c.AbortWithStatusJSON(http.StatusConflict, job)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Info(uiLogger, "started gc for: ", uid, " job: ", job.ID, " dry run: ", dryRun)
+	auditParam(c, "job", job.ID)
+	auditParam(c, dryRunQuery, strconv.FormatBool(dryRun))
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (app *ReactAppWrapper) getJob(c *gin.Context) {
+	job, err := app.jobs.Get(c.Param(jobidParam))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (app *ReactAppWrapper) listJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, app.jobs.List())
+}
@@ -0,0 +1,183 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/email"
+	"github.com/ddvk/rmfakecloud/internal/oidc"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/fs"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAppConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name     string
+		cfg      *config.Config
+		sso      bool
+		features []string
+	}{
+		{"default", &config.Config{}, false, []string{}},
+		{"all", &config.Config{
+			RegistrationOpen:  true,
+			SMTPConfig:        &email.SMTPConfig{},
+			HWRApplicationKey: "key",
+			HWRHmac:           "hmac",
+		}, true, []string{featureRegistration, featureEmail, featureHWR, featureOIDC}},
+		{"hwr without hmac", &config.Config{HWRApplicationKey: "key"}, false, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Branding = config.Branding{InstanceName: "notes", LogoURL: "/logo.png", ThemeColor: "#112233"}
+			app := &ReactAppWrapper{cfg: tt.cfg}
+			if tt.sso {
+				app.oidc = oidc.New("https://sso.example.com", "rmfakecloud", "secret", "/callback")
+			}
+			router := gin.New()
+			router.GET("/config", app.appConfig)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d", w.Code)
+			}
+			var got viewmodel.AppConfig
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			want := viewmodel.AppConfig{InstanceName: "notes", LogoURL: "/logo.png", ThemeColor: "#112233", Features: tt.features}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("config %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// docsBackend has one document, "doc"
+type docsBackend struct {
+	backend
+}
+
+func (b *docsBackend) DeleteDocument(uid, docid, mode string) (*storage.Deletion, error) {
+	if docid != "doc" {
+		return nil, storage.ErrorNotFound
+	}
+	return &storage.Deletion{ID: docid, Moved: []string{}, Deleted: []string{docid}}, nil
+}
+
+func (b *docsBackend) MoveDocument(uid, docid, name, parent string) (*storage.Document, error) {
+	switch {
+	case docid != "doc":
+		return nil, storage.ErrorNotFound
+	case parent != "":
+		return nil, fmt.Errorf("%w: no folder %s", storage.ErrorInvalidMove, parent)
+	}
+	return &storage.Document{ID: docid, Name: name, Type: models.DocumentType}, nil
+}
+
+func (b *docsBackend) RestoreSnapshot(uid string, generation int64) (*storage.Snapshot, error) {
+	if generation != 3 {
+		return nil, storage.ErrorSnapshotUnavailable
+	}
+	return &storage.Snapshot{Generation: 4}, nil
+}
+
+func TestUpdateDocument(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := &ReactAppWrapper{}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(userIDContextKey, "test")
+		c.Set("backend", &docsBackend{})
+	})
+	router.PUT("/documents", app.updateDocument)
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{"documentId":"doc","name":"renamed","parentId":""}`, http.StatusOK},
+		{`{"documentId":"doc","name":"moved","parentId":"missing"}`, http.StatusBadRequest},
+		{`{"documentId":"missing","name":"renamed","parentId":""}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/documents", strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.body, w.Code, tt.code)
+		}
+	}
+}
+
+func TestDeleteDocument(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := &ReactAppWrapper{}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(userIDContextKey, "test")
+		c.Set("backend", &docsBackend{})
+	})
+	router.DELETE("/documents/:docid", app.deleteDocument)
+
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{"/documents/doc", http.StatusOK},
+		{"/documents/doc?mode=recursive", http.StatusOK},
+		{"/documents/doc?mode=bogus", http.StatusBadRequest},
+		{"/documents/missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, tt.url, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.url, w.Code, tt.code)
+		}
+	}
+}
+
+func TestAuditedRestore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{DataDir: t.TempDir()}
+	cfg.AuditLog = cfg.DataDir + "/audit.log"
+	storer := fs.NewStorage(cfg)
+	app := &ReactAppWrapper{cfg: cfg, auditStorer: storer}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(userIDContextKey, "test")
+		c.Set("backend", &docsBackend{})
+	})
+	router.POST("/snapshots/:"+generationParam+"/restore", app.audited(storage.AuditDocuments, "restore", app.restoreSnapshot))
+
+	for _, url := range []string{"/snapshots/3/restore", "/snapshots/9/restore"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, url, nil))
+	}
+
+	entries, err := storer.AuditLog(storage.AuditDocuments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entries %v", entries)
+	}
+	for i, want := range []struct {
+		generation string
+		status     int
+	}{{"3", http.StatusOK}, {"9", http.StatusNotFound}} {
+		e := entries[i]
+		if e.Actor != "test" || e.Target != "test" || e.Action != "restore" || e.Params["generation"] != want.generation || e.Status != want.status {
+			t.Errorf("entry %d: %+v", i, e)
+		}
+	}
+}
@@ -49,6 +49,28 @@ func (app *ReactAppWrapper) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		user, err := app.userStorer.GetUser(claims.UserID)
+		if err != nil || user == nil {
+			log.Warn("[ui-authmiddleware] user not found: ", claims.UserID, " ", err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or incorrect token"})
+			return
+		}
+		if user.Expired() {
+			log.Warn("[ui-authmiddleware] account expired: ", user.ID)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": accountExpired})
+			return
+		}
+		if user.Disabled {
+			log.Warn("[ui-authmiddleware] account disabled: ", user.ID)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": accountDisabled})
+			return
+		}
+		if user.TokenRevoked(claims.IssuedAt) {
+			log.Warn("[ui-authmiddleware] token revoked: ", user.ID)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or incorrect token"})
+			return
+		}
+
 		scopes := strings.Fields(claims.Scopes)
 		newsync := false
 		for _, s := range scopes {
@@ -69,12 +91,8 @@ func (app *ReactAppWrapper) authMiddleware() gin.HandlerFunc {
 		c.Set(userIDContextKey, uid)
 		c.Set(browserIDContextKey, brid)
 		c.Set(isSync15Key, newsync)
-		for _, r := range claims.Roles {
-			if r == AdminRole {
-				c.Set(AdminRole, true)
-				break
-			}
-		}
+		// the role claim is only a hint for the web ui, an admin demoted since the login is not one anymore
+		c.Set(AdminRole, user.IsAdmin)
 		log.Info("[ui-authmiddleware] User from token: ", uid)
 		c.Next()
 	}
@@ -0,0 +1,84 @@
+package ui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage/fs"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{DataDir: t.TempDir(), JWTSecretKey: []byte("secret")}
+	storer := fs.NewStorage(cfg)
+	app := &ReactAppWrapper{cfg: cfg, userStorer: storer}
+
+	now := time.Now()
+	for id, update := range map[string]func(*model.User){
+		"active":   func(u *model.User) {},
+		"disabled": func(u *model.User) { u.Disabled = true },
+		"revoked":  func(u *model.User) { u.TokensRevokedAt = now.Add(-time.Minute) },
+		"admin":    func(u *model.User) { u.IsAdmin = true },
+	} {
+		u, err := model.NewUser(id, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		update(u)
+		if err = storer.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/documents", app.authMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/users", app.authMiddleware(), app.adminMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		uid      string
+		url      string
+		issuedAt time.Time
+		code     int
+	}{
+		{"active", "/documents", now.Add(-time.Hour), http.StatusOK},
+		{"revoked", "/documents", now, http.StatusOK},
+		{"revoked", "/documents", now.Add(-time.Hour), http.StatusUnauthorized},
+		{"disabled", "/documents", now, http.StatusForbidden},
+		{"missing", "/documents", now, http.StatusUnauthorized},
+		{"admin", "/users", now, http.StatusOK},
+		// the role in the token is not enough
+		{"active", "/users", now, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		claims := &WebUserClaims{
+			UserID: tt.uid,
+			Roles:  []string{AdminRole},
+			StandardClaims: jwt.StandardClaims{
+				IssuedAt: tt.issuedAt.Unix(),
+				Audience: WebUsage,
+			},
+		}
+		token, err := common.SignClaims(claims, cfg.JWTSecretKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: token})
+		router.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s %s issued %s: status %d, want %d", tt.uid, tt.url, tt.issuedAt.Format(time.RFC3339), w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package ui
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	oidcPath         = "/ui/api/oidc"
+	oidcCallbackPath = oidcPath + "/callback"
+	// oidcStateCookie keeps the state and nonce of the login until the provider redirects back
+	oidcStateCookie = ".Authrmfakecloud-oidc"
+	oidcStateUsage  = "oidc-state"
+	oidcStateTTL    = 10 * time.Minute
+	// loginPage the web ui takes the token or the error of the sso login from the fragment
+	loginPage = "/login"
+)
+
+// errOIDCNoUser the identity isn't linked to a local user and none is created
+var errOIDCNoUser = errors.New("no user for this login")
+
+// oidcStateClaims the cookie of a login in progress
+type oidcStateClaims struct {
+	State string
+	Nonce string
+	jwt.StandardClaims
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// oidcLogin sends the browser to the provider
+func (app *ReactAppWrapper) oidcLogin(c *gin.Context) {
+	state, err := randomString()
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	authURL, err := app.oidc.AuthCodeURL(state, nonce)
+	if err != nil {
+		log.Error(uiLogger, "oidc: ", err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "the identity provider is not available"})
+		return
+	}
+	cookie, err := common.SignClaims(&oidcStateClaims{
+		State: state,
+		Nonce: nonce,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(oidcStateTTL).Unix(),
+			Audience:  oidcStateUsage,
+		},
+	}, app.cfg.JWTSecretKey)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.SetCookie(oidcStateCookie, cookie, int(oidcStateTTL.Seconds()), oidcPath, "", app.cfg.HTTPSCookie, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// oidcCallback the provider sends the browser back with the code, it is redirected to the
+// web ui with the token of the local user
+func (app *ReactAppWrapper) oidcCallback(c *gin.Context) {
+	fail := func(message string) {
+		c.Redirect(http.StatusFound, loginPage+"#"+url.Values{"error": {message}}.Encode())
+	}
+	stateCookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, oidcPath, "", app.cfg.HTTPSCookie, true)
+
+	if e := c.Query("error"); e != "" {
+		log.Warn(uiLogger, "oidc: the provider refused the login: ", e, " ", c.Query("error_description"))
+		fail("login refused: " + e)
+		return
+	}
+	state := &oidcStateClaims{}
+	err := common.ClaimsFromToken(state, stateCookie, app.cfg.JWTSecretKey)
+	if err != nil || state.Audience != oidcStateUsage ||
+		subtle.ConstantTimeCompare([]byte(state.State), []byte(c.Query("state"))) != 1 {
+		log.Warn(uiLogger, "oidc: missing or wrong state, ip: ", c.ClientIP())
+		fail("the login expired, try again")
+		return
+	}
+
+	identity, err := app.oidc.Exchange(c.Query("code"), state.Nonce)
+	if err != nil {
+		log.Error(uiLogger, "oidc: ", err)
+		fail("the login can't be verified")
+		return
+	}
+	user, err := app.oidcUser(identity)
+	if err != nil {
+		log.Warn(uiLogger, "oidc: subject ", identity.Subject, " (", identity.Email, "): ", err, ", ip: ", c.ClientIP())
+		if errors.Is(err, errOIDCNoUser) {
+			fail(err.Error())
+		} else {
+			fail("the login failed")
+		}
+		return
+	}
+	if user.Expired() {
+		log.Warn(uiLogger, "account expired: ", user.ID, ", login failed ip: ", c.ClientIP())
+		fail(accountExpired)
+		return
+	}
+	if user.Disabled {
+		log.Warn(uiLogger, "account disabled: ", user.ID, ", login failed ip: ", c.ClientIP())
+		fail(accountDisabled)
+		return
+	}
+
+	token, err := app.newSession(c, user)
+	if err != nil {
+		log.Error(err)
+		fail("the login failed")
+		return
+	}
+	log.Info(uiLogger, "oidc login: ", user.ID)
+	c.Redirect(http.StatusFound, loginPage+"#"+url.Values{"token": {token}}.Encode())
+}
+
+// oidcUser the local user of the identity, the one linked to the subject. On the first login
+// a user with the same verified email is linked, or with RM_OIDC_AUTO_CREATE a new one is created
+func (app *ReactAppWrapper) oidcUser(identity *oidc.Identity) (*model.User, error) {
+	users, err := app.userStorer.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if u.OIDCSubject == identity.Subject {
+			return u, nil
+		}
+	}
+	if identity.Email != "" && identity.EmailVerified {
+		for _, u := range users {
+			if u.OIDCSubject == "" && strings.EqualFold(u.Email, identity.Email) {
+				u.OIDCSubject = identity.Subject
+				if err = app.userStorer.UpdateUser(u); err != nil {
+					return nil, err
+				}
+				log.Info(uiLogger, "oidc: linked ", u.ID, " to subject ", identity.Subject)
+				return u, nil
+			}
+		}
+	}
+	if !app.cfg.OIDCAutoCreate {
+		return nil, errOIDCNoUser
+	}
+
+	id := identity.PreferredUsername
+	if id == "" {
+		id = identity.Email
+	}
+	// can't log in with a password until an admin resets it
+	password, err := model.GenPassword()
+	if err != nil {
+		return nil, err
+	}
+	user, err := model.NewUser(id, password)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == "" {
+		return nil, fmt.Errorf("%w: no username or email", errOIDCNoUser)
+	}
+	if _, err = app.userStorer.GetUser(user.ID); err == nil {
+		return nil, fmt.Errorf("%w: %s is taken", errOIDCNoUser, user.ID)
+	}
+	user.Email = identity.Email
+	user.EmailVerified = identity.EmailVerified
+	user.Name = identity.Name
+	user.OIDCSubject = identity.Subject
+	user.ExpiresAt = app.newAccountExpiry()
+	if err = app.userStorer.UpdateUser(user); err != nil {
+		return nil, err
+	}
+	log.Info(uiLogger, "oidc: created ", user.ID, " for subject ", identity.Subject)
+	return user, nil
+}
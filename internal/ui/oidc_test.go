@@ -0,0 +1,77 @@
+package ui
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/oidc"
+	"github.com/ddvk/rmfakecloud/internal/storage/fs"
+	"github.com/gin-gonic/gin"
+)
+
+func TestOIDCUser(t *testing.T) {
+	cfg := &config.Config{DataDir: t.TempDir()}
+	storer := fs.NewStorage(cfg)
+	app := &ReactAppWrapper{cfg: cfg, userStorer: storer}
+	local, err := model.NewUser("alice", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.Email = "Alice@example.com"
+	if err = storer.UpdateUser(local); err != nil {
+		t.Fatal(err)
+	}
+
+	// an unverified email isn't linked
+	alice := &oidc.Identity{Subject: "sub-alice", Email: "alice@example.com"}
+	if _, err = app.oidcUser(alice); !errors.Is(err, errOIDCNoUser) {
+		t.Errorf("unverified: %v", err)
+	}
+	alice.EmailVerified = true
+	user, err := app.oidcUser(alice)
+	if err != nil || user.ID != "alice" {
+		t.Fatalf("linked %v %v", user, err)
+	}
+	// by the subject from now on
+	alice.Email = "new@example.com"
+	if user, err = app.oidcUser(alice); err != nil || user.ID != "alice" || user.OIDCSubject != "sub-alice" {
+		t.Errorf("by subject %v %v", user, err)
+	}
+
+	bob := &oidc.Identity{Subject: "sub-bob", PreferredUsername: "bob", Email: "bob@example.com", Name: "Bob"}
+	if _, err = app.oidcUser(bob); !errors.Is(err, errOIDCNoUser) {
+		t.Errorf("created without auto create: %v", err)
+	}
+	cfg.OIDCAutoCreate = true
+	if user, err = app.oidcUser(bob); err != nil || user.ID != "bob" || user.Name != "Bob" {
+		t.Fatalf("created %v %v", user, err)
+	}
+	if user, err = storer.GetUser("bob"); err != nil || user.OIDCSubject != "sub-bob" {
+		t.Errorf("stored %v %v", user, err)
+	}
+
+	taken := &oidc.Identity{Subject: "sub-other", PreferredUsername: "bob"}
+	if _, err = app.oidcUser(taken); !errors.Is(err, errOIDCNoUser) {
+		t.Errorf("taken: %v", err)
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{DataDir: t.TempDir(), JWTSecretKey: []byte("secret")}
+	app := &ReactAppWrapper{cfg: cfg, oidc: oidc.New("https://idp", "client", "", "")}
+	router := gin.New()
+	router.GET(oidcCallbackPath, app.oidcCallback)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, oidcCallbackPath+"?code=code&state=forged", nil))
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusFound || !strings.HasPrefix(location, loginPage+"#error=") {
+		t.Errorf("without the state cookie: %d %s", w.Code, location)
+	}
+}
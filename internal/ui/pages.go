@@ -0,0 +1,55 @@
+package ui
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (app *ReactAppWrapper) getPages(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+	docid := c.Param("docid")
+
+	pages, err := getBackend(c).GetPages(uid, docid)
+	if err != nil {
+		log.Error(err)
+		if errors.Is(err, storage.ErrorInvalidPages) {
+			badReq(c, err.Error())
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, viewmodel.PageOrder{Pages: pages})
+}
+
+// reorderPages the new order has to have all the pages of the notebook, each once
+func (app *ReactAppWrapper) reorderPages(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+	docid := c.Param("docid")
+
+	req := viewmodel.PageOrder{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+
+	log.Info(uiLogger, "reordering the pages of: ", docid)
+	backend := getBackend(c)
+	err := backend.ReorderPages(uid, docid, req.Pages)
+	if err != nil {
+		log.Error(err)
+		if errors.Is(err, storage.ErrorInvalidPages) {
+			badReq(c, err.Error())
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
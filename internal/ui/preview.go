@@ -0,0 +1,211 @@
+package ui
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/exporter"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	previewFormat       = "preview"
+	pageImageFormat     = "page"
+	pageImageExt        = ".png"
+	pageNumberParam     = "page"
+	defaultPreviewWidth = 600
+	minPreviewWidth     = 100
+	maxPreviewWidth     = 2000
+)
+
+// clamp the value to min..max, def when it is not a number
+func clamp(value string, def, min, max int) int {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return def
+	}
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// exportPDF the pdf export of the document generation, from the cache if possible
+func (app *ReactAppWrapper) exportPDF(backend backend, uid, docid, generation string) ([]byte, error) {
+	var exportOption storage.ExportOption
+	key := exportKey(uid, docid, generation, "pdf", exportOption)
+	cache := app.exportCache
+	if cache.enabled() {
+		if data, ok := cache.Get(key); ok {
+			return data, nil
+		}
+	}
+
+	reader, err := backend.Export(uid, docid, "pdf", exportOption)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if cache.enabled() {
+		cache.Put(key, data)
+	}
+	return data, nil
+}
+
+// getPreview renders a page of the document as png
+// page starts at 1 and is clamped to the pages of the document, width to 100..2000
+func (app *ReactAppWrapper) getPreview(c *gin.Context) {
+	// the last page is only known from the pdf
+	page := clamp(c.Query("page"), 1, 1, 10000)
+	app.renderPage(c, page, false)
+}
+
+// getPageImage renders the page of the path (<n>.png) as png, 404 when the document doesn't have it
+func (app *ReactAppWrapper) getPageImage(c *gin.Context) {
+	page, ok := parsePage(c.Param(pageNumberParam))
+	if !ok {
+		badReq(c, "the page has to be <number>.png")
+		return
+	}
+	app.renderPage(c, page, true)
+}
+
+// parsePage the page number of "<n>.png"
+func parsePage(value string) (int, bool) {
+	if !strings.HasSuffix(value, pageImageExt) {
+		return 0, false
+	}
+	page, err := strconv.Atoi(strings.TrimSuffix(value, pageImageExt))
+	if err != nil || page < 1 {
+		return 0, false
+	}
+	return page, true
+}
+
+// renderPage renders the page of the pdf export, both are cached by the document generation
+// exact doesn't clamp the page to the pages of the document, otherwise the page rendered is the key
+func (app *ReactAppWrapper) renderPage(c *gin.Context, page int, exact bool) {
+	uid := c.GetString(userIDContextKey)
+	docid := common.ParamS(docIDParam, c)
+	width := clamp(c.Query("width"), defaultPreviewWidth, minPreviewWidth, maxPreviewWidth)
+	backend := getBackend(c)
+
+	generation, err := backend.DocumentGeneration(uid, docid)
+	if err != nil {
+		abortDocumentError(c, err)
+		return
+	}
+	format := pageImageFormat
+	var pdf []byte
+	if !exact {
+		format = previewFormat
+		pdf, err = app.exportPDF(backend, uid, docid, generation)
+		if err != nil {
+			log.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		pages, err := exporter.PageCount(bytes.NewReader(pdf))
+		if err != nil {
+			log.Error(uiLogger, "can't count the pages of ", docid, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if page > pages {
+			page = pages
+		}
+	}
+	key := exportKey(uid, docid, generation, format, page, width)
+	tag := etag(key)
+	c.Header("ETag", tag)
+	if c.GetHeader("If-None-Match") == tag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	cache := app.exportCache
+	if cache.enabled() {
+		if data, ok := cache.Get(key); ok {
+			c.Data(http.StatusOK, "image/png", data)
+			return
+		}
+	}
+
+	if pdf == nil {
+		pdf, err = app.exportPDF(backend, uid, docid, generation)
+		if err != nil {
+			log.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+	var img image.Image
+	if exact {
+		img, err = exporter.RenderExistingPage(bytes.NewReader(pdf), page, width)
+	} else {
+		img, err = exporter.RenderPage(bytes.NewReader(pdf), page, width)
+	}
+	if errors.Is(err, exporter.ErrNoPage) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error(uiLogger, "can't render the preview of ", docid, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if cache.enabled() {
+		cache.Put(key, buf.Bytes())
+	}
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
+}
+
+// getPDF the whole document rendered with its annotations as pdf
+func (app *ReactAppWrapper) getPDF(c *gin.Context) {
+	uid := c.GetString(userIDContextKey)
+	docid := common.ParamS(docIDParam, c)
+	backend := getBackend(c)
+
+	generation, err := backend.DocumentGeneration(uid, docid)
+	if err != nil {
+		abortDocumentError(c, err)
+		return
+	}
+	tag := etag(exportKey(uid, docid, generation, "pdf", storage.ExportWithAnnotations))
+	c.Header("ETag", tag)
+	if c.GetHeader("If-None-Match") == tag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	pdf, err := app.exportPDF(backend, uid, docid, generation)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
@@ -0,0 +1,118 @@
+package ui
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/unidoc/unipdf/v3/creator"
+)
+
+func TestParsePage(t *testing.T) {
+	for value, want := range map[string]int{
+		"1.png":  1,
+		"12.png": 12,
+		"0.png":  0,
+		"-1.png": 0,
+		"1":      0,
+		"1.jpg":  0,
+		"a.png":  0,
+		".png":   0,
+	} {
+		page, ok := parsePage(value)
+		if ok != (want > 0) || page != want {
+			t.Errorf("%s: %d %v", value, page, ok)
+		}
+	}
+}
+
+// pdfBackend has one document, which exports as the pdf, and a broken one
+type pdfBackend struct {
+	backend
+	pdf []byte
+}
+
+func (b *pdfBackend) DocumentGeneration(uid, docid string) (string, error) {
+	switch docid {
+	case "doc":
+		return "1", nil
+	case "broken":
+		return "", errors.New("unreadable")
+	}
+	return "", storage.ErrorNotFound
+}
+
+func (b *pdfBackend) Export(uid, docid, exporttype string, opt storage.ExportOption) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(b.pdf)), nil
+}
+
+func TestRenderPage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := creator.New()
+	c.NewPage()
+	c.NewPage()
+	var pdf bytes.Buffer
+	if err := c.Write(&pdf); err != nil {
+		t.Fatal(err)
+	}
+	app := &ReactAppWrapper{exportCache: newExportCache(1 << 20)}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(userIDContextKey, "test")
+		c.Set("backend", &pdfBackend{pdf: pdf.Bytes()})
+	})
+	router.GET("/documents/:docid/preview", app.getPreview)
+	router.GET("/documents/:docid/pages/:"+pageNumberParam, app.getPageImage)
+
+	get := func(url, tag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if tag != "" {
+			req.Header.Set("If-None-Match", tag)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("/documents/doc/preview?page=1&width=100", "")
+	last := get("/documents/doc/preview?page=2&width=100", "")
+	for _, w := range []*httptest.ResponseRecorder{first, last} {
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("status %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+	if first.Header().Get("ETag") == last.Header().Get("ETag") {
+		t.Error("the pages have the same etag")
+	}
+
+	// past the end is the last page, with its etag
+	w := get("/documents/doc/preview?page=50&width=100", "")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != last.Header().Get("ETag") {
+		t.Errorf("past the end: status %d etag %s", w.Code, w.Header().Get("ETag"))
+	}
+	if w = get("/documents/doc/preview?page=99&width=100", last.Header().Get("ETag")); w.Code != http.StatusNotModified {
+		t.Errorf("past the end, not modified: status %d", w.Code)
+	}
+
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{"/documents/doc/pages/2.png?width=100", http.StatusOK},
+		{"/documents/doc/pages/3.png?width=100", http.StatusNotFound},
+		{"/documents/doc/pages/two.png", http.StatusBadRequest},
+		{"/documents/other/preview", http.StatusNotFound},
+		{"/documents/broken/preview", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if w = get(tt.url, ""); w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.url, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package ui
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	robotsTagHeader = "X-Robots-Tag"
+	robotsNoIndex   = "noindex, nofollow"
+)
+
+// basePath the path of the public url, when served behind a proxy in a subfolder
+func basePath(storageURL string) string {
+	u, err := url.Parse(storageURL)
+	if err != nil {
+		log.Warn("can't parse the storage url: ", err)
+		return "/"
+	}
+	return strings.TrimSuffix(u.Path, "/") + "/"
+}
+
+// generateRobots disallows everything under the base path
+func generateRobots(base string) []byte {
+	return []byte(fmt.Sprintf("User-agent: *\nDisallow: %s\n", base))
+}
+
+// noIndex asks search engines not to index the web ui
+func (app *ReactAppWrapper) noIndex(c *gin.Context) {
+	if app.cfg.Robots == config.RobotsNoIndex {
+		c.Header(robotsTagHeader, robotsNoIndex)
+	}
+}
+
+func (app *ReactAppWrapper) robots(c *gin.Context) {
+	if app.cfg.Robots == config.RobotsOff {
+		c.FileFromFS("/robots.txt", app.fs)
+		return
+	}
+	robots := app.cfg.RobotsTxt
+	if robots == nil {
+		robots = generateRobots(basePath(app.cfg.StorageURL))
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", robots)
+}
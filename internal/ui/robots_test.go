@@ -0,0 +1,27 @@
+package ui
+
+import "testing"
+
+func TestBasePath(t *testing.T) {
+	tests := []struct {
+		storageURL string
+		want       string
+	}{
+		{"https://local.appspot.com", "/"},
+		{"https://example.com/", "/"},
+		{"https://example.com/rm", "/rm/"},
+		{"https://example.com/rm/", "/rm/"},
+	}
+	for _, tt := range tests {
+		if got := basePath(tt.storageURL); got != tt.want {
+			t.Errorf("basePath(%s) = %s, want %s", tt.storageURL, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateRobots(t *testing.T) {
+	want := "User-agent: *\nDisallow: /rm/\n"
+	if got := string(generateRobots("/rm/")); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -4,21 +4,22 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
 
 // RegisterRoutes the apps routes
 func (app *ReactAppWrapper) RegisterRoutes(router *gin.Engine) {
-	router.StaticFS(app.prefix, app)
+	// the web ui, not the storage routes
+	web := router.Group("", app.noIndex)
+	web.StaticFS(app.prefix, app)
 
-	router.GET("/favicon.ico", func(c *gin.Context) {
+	web.GET("/favicon.ico", func(c *gin.Context) {
 		c.FileFromFS("/favicon.ico", app.fs)
 	})
-	router.GET("/robots.txt", func(c *gin.Context) {
-		c.FileFromFS("/robots.txt", app.fs)
-	})
-	router.GET("/pdf.worker.js", func(c *gin.Context) {
+	web.GET("/robots.txt", app.robots)
+	web.GET("/pdf.worker.js", func(c *gin.Context) {
 		c.FileFromFS("/pdf.worker.js", app.fs)
 	})
 
@@ -34,12 +35,18 @@ func (app *ReactAppWrapper) RegisterRoutes(router *gin.Engine) {
 			return
 		}
 
+		app.noIndex(c)
 		c.FileFromFS(indexReplacement, app)
 	})
 
-	r := router.Group("/ui/api")
+	r := web.Group("/ui/api")
+	r.GET("config", app.appConfig)
 	r.POST("register", app.register)
 	r.POST("login", app.login)
+	if app.oidc != nil {
+		r.GET("oidc/login", app.oidcLogin)
+		r.GET("oidc/callback", app.oidcCallback)
+	}
 	r.GET("logout", func(c *gin.Context) {
 		c.SetCookie(cookieName, "/", -1, "", "", false, true)
 		c.Status(http.StatusOK)
@@ -63,18 +70,47 @@ func (app *ReactAppWrapper) RegisterRoutes(router *gin.Engine) {
 	auth.POST("changeEmail", app.changePassword)
 
 	auth.GET("documents", app.listDocuments)
+	auth.GET("folders", app.folderTree)
+	auth.GET("export", app.exportDocuments)
+	auth.POST("import", app.audited(storage.AuditDocuments, "import", app.importAccount))
 	auth.GET("documents/:docid", app.getDocument)
-	auth.POST("documents/upload", app.createDocument)
+	auth.GET("documents/:docid/preview", app.getPreview)
+	auth.GET("documents/:docid/pdf", app.getPDF)
+	auth.POST("documents/upload", app.idempotency.Middleware(userIDContextKey), app.createDocument)
 	auth.DELETE("documents/:docid", app.deleteDocument)
+	auth.GET("documents/:docid/pages", app.getPages)
+	auth.GET("documents/:docid/pages/:"+pageNumberParam, app.getPageImage)
+	auth.PUT("documents/:docid/pages", app.reorderPages)
 	//move, rename
 	auth.PUT("documents", app.updateDocument)
 
+	auth.GET("sync/devices", app.syncStatus)
+	auth.GET("snapshots", app.listSnapshots)
+	auth.POST("snapshots/:"+generationParam+"/restore", app.audited(storage.AuditDocuments, "restore", app.restoreSnapshot))
+	auth.GET("users/:userid/usage", app.getUserUsage)
+
+	auth.GET("orphans", app.listOrphans)
+	auth.POST("orphans/resolve", app.audited(storage.AuditDocuments, "resolve-orphans", app.resolveOrphans))
+	auth.POST("metadata/repair", app.audited(storage.AuditDocuments, "repair-metadata", app.repairMetadata))
+
 	//admin
 	admin := auth.Group("")
 	admin.Use(app.adminMiddleware())
 	admin.GET("users/:userid", app.getUser)
-	admin.DELETE("users/:userid", app.deleteUser)
-	admin.PUT("users", app.updateUser)
-	admin.POST("users", app.createUser)
+	admin.DELETE("users/:userid", app.audited(storage.AuditUser, "delete", app.deleteUser))
+	admin.PUT("users", app.audited(storage.AuditUser, "update", app.updateUser))
+	admin.POST("users", app.audited(storage.AuditUser, "create", app.createUser))
 	admin.GET("users", app.getAppUsers)
+	admin.POST("users/:userid/disable", app.audited(storage.AuditUser, "disable", app.setUserDisabled(true)))
+	admin.POST("users/:userid/enable", app.audited(storage.AuditUser, "enable", app.setUserDisabled(false)))
+	admin.POST("users/:userid/password", app.audited(storage.AuditUser, "password", app.resetUserPassword))
+	admin.POST("users/:userid/revoke", app.audited(storage.AuditUser, "revoke", app.revokeUserTokens))
+	admin.POST("users/:userid/gc", app.audited(storage.AuditMaintenance, "gc", app.startGarbageCollection))
+	admin.GET("jobs", app.listJobs)
+	admin.GET("jobs/:jobid", app.getJob)
+	admin.GET("audit", app.listAudit)
+	admin.GET("usage", app.getUsage)
+
+	admin.GET("admin/webhooks/failed", app.listFailedWebhooks)
+	admin.POST("admin/webhooks/failed/:"+deliveryParam+"/replay", app.audited(storage.AuditMaintenance, "webhook-replay", app.replayWebhook))
 }
@@ -8,19 +8,48 @@ import (
 
 	"github.com/ddvk/rmfakecloud/internal/app/hub"
 	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/idempotency"
+	"github.com/ddvk/rmfakecloud/internal/jobs"
 	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/oidc"
 	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/models"
 	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/ddvk/rmfakecloud/internal/webhook"
 	webui "github.com/ddvk/rmfakecloud/ui"
 	"github.com/gin-gonic/gin"
 )
 
 type backend interface {
 	GetDocumentTree(uid string) (tree *viewmodel.DocumentTree, err error)
+	GetFolderTree(uid string) (tree *viewmodel.FolderNode, err error)
+	// DocumentGeneration changes whenever the document changes
+	DocumentGeneration(uid, docid string) (string, error)
+	// RootGeneration the current generation of the sync15 root, 0 for sync10
+	RootGeneration(uid string) (int64, error)
 	Export(uid, doc, exporttype string, opt storage.ExportOption) (stream io.ReadCloser, err error)
 	CreateDocument(uid, name, parent string, stream io.Reader) (doc *storage.Document, err error)
 	Sync(uid string)
+	FindOrphans(uid string) ([]*storage.Orphan, error)
+	ResolveOrphans(uid string) ([]*storage.Orphan, error)
+	DeleteDocument(uid, docid, mode string) (*storage.Deletion, error)
+	// GetPages the page ids of a notebook, in order
+	GetPages(uid, docid string) ([]string, error)
+	ReorderPages(uid, docid string, pages []string) error
+	// MoveDocument renames the document or moves it to another folder, an empty parent is the root
+	MoveDocument(uid, docid, name, parent string) (*storage.Document, error)
+	// DocumentTags the tags of the documents, by id
+	DocumentTags(uid string) (map[string][]string, error)
+	// RepairMetadata rewrites the metadata which doesn't match the schema, dryRun only reports it
+	RepairMetadata(uid string, dryRun bool) ([]*storage.MetadataRepair, error)
+	// Snapshots the past generations of the root which are kept, the newest first
+	Snapshots(uid string) ([]*storage.Snapshot, error)
+	// RestoreSnapshot makes the generation the current one again
+	RestoreSnapshot(uid string, generation int64) (*storage.Snapshot, error)
+	// ExportAccount writes a tar with all the documents of the user
+	ExportAccount(uid string, w io.Writer) error
+	// ImportAccount restores a tar of ExportAccount, replacing the documents with the same id
+	ImportAccount(uid string, r io.Reader) (*storage.AccountImport, error)
 }
 type codeGenerator interface {
 	NewCode(string) (string, error)
@@ -29,13 +58,46 @@ type codeGenerator interface {
 type documentHandler interface {
 	CreateDocument(uid, name, parent string, stream io.Reader) (doc *storage.Document, err error)
 	GetAllMetadata(uid string) (do []*messages.RawMetadata, err error)
+	GetMetadata(uid, docid string) (*messages.RawMetadata, error)
 	ExportDocument(uid, id, format string, exportOption storage.ExportOption) (stream io.ReadCloser, err error)
+	GetOriginal(uid, docid string) (io.ReadCloser, error)
+	DeleteDocument(uid, docid, mode string) (*storage.Deletion, error)
+	GetPages(uid, docid string) ([]string, error)
+	ReorderPages(uid, docid string, pages []string) error
+	MoveDocument(uid, docid, name, parent string) (*storage.Document, error)
+	DocumentTags(uid string) (map[string][]string, error)
+	RepairMetadata(uid string, dryRun bool) ([]*storage.MetadataRepair, error)
+	// TrashedDocuments where the documents in the trash were, by id
+	TrashedDocuments(uid string) (map[string]*storage.TrashedDocument, error)
+	ExportAccount(uid string, w io.Writer) error
+	ImportAccount(uid string, r io.Reader) (*storage.AccountImport, error)
 }
 
 type blobHandler interface {
 	GetTree(uid string) (tree *models.HashTree, err error)
 	CreateBlobDocument(uid, name, parent string, reader io.Reader) (doc *storage.Document, err error)
 	Export(uid, docid string) (io.ReadCloser, error)
+	ExportNative(uid, docid string) (io.ReadCloser, error)
+	FindOrphans(uid string) ([]*storage.Orphan, error)
+	ResolveOrphans(uid string) ([]*storage.Orphan, error)
+	GarbageCollect(uid string, dryRun bool, progress func(storage.GCStats)) (storage.GCStats, error)
+	DeleteBlobDocument(uid, docid, mode string) (*storage.Deletion, error)
+	GetBlobPages(uid, docid string) ([]string, error)
+	ReorderBlobPages(uid, docid string, pages []string) error
+	MoveBlobDocument(uid, docid, name, parent string) (*storage.Document, error)
+	BlobDocumentTags(uid string) (map[string][]string, error)
+	RepairBlobMetadata(uid string, dryRun bool) ([]*storage.MetadataRepair, error)
+	TrashedDocuments(uid string) (map[string]*storage.TrashedDocument, error)
+	Snapshots(uid string) ([]*storage.Snapshot, error)
+	RestoreSnapshot(uid string, generation int64) (*storage.Snapshot, error)
+	ExportBlobAccount(uid string, w io.Writer) error
+	ImportBlobAccount(uid string, r io.Reader) (*storage.AccountImport, error)
+}
+
+// webhookQueue the deliveries of the webhook given up
+type webhookQueue interface {
+	Failed() ([]*webhook.Delivery, error)
+	Replay(id string) error
 }
 
 // ReactAppWrapper encapsulates an app
@@ -44,11 +106,21 @@ type ReactAppWrapper struct {
 	prefix          string
 	cfg             *config.Config
 	userStorer      storage.UserStorer
+	auditStorer     storage.AuditStorer
+	usageReporter   storage.UsageReporter
 	codeConnector   codeGenerator
 	h               *hub.Hub
 	documentHandler documentHandler
+	blobHandler     blobHandler
+	jobs            *jobs.Registry
 	backend15       backend
 	backend10       backend
+	exportCache     *exportCache
+	idempotency     *idempotency.Store
+	// webhooks nil unless a webhook is configured
+	webhooks webhookQueue
+	// oidc nil unless single sign-on is configured
+	oidc *oidc.Provider
 }
 
 //hack for serving index.html on /
@@ -60,7 +132,9 @@ func New(cfg *config.Config,
 	codeConnector codeGenerator,
 	h *hub.Hub,
 	docHandler documentHandler,
-	blobHandler blobHandler) *ReactAppWrapper {
+	blobHandler blobHandler,
+	auditStorer storage.AuditStorer,
+	usageReporter storage.UsageReporter) *ReactAppWrapper {
 
 	sub, err := fs.Sub(webui.Assets, "build")
 	if err != nil {
@@ -71,9 +145,13 @@ func New(cfg *config.Config,
 		prefix:          "/static",
 		cfg:             cfg,
 		userStorer:      userStorer,
+		auditStorer:     auditStorer,
+		usageReporter:   usageReporter,
 		codeConnector:   codeConnector,
 		h:               h,
 		documentHandler: docHandler,
+		blobHandler:     blobHandler,
+		jobs:            jobs.NewRegistry(),
 		backend15: &backend15{
 			blobHandler: blobHandler,
 			h:           h,
@@ -82,10 +160,25 @@ func New(cfg *config.Config,
 			documentHandler: docHandler,
 			h:               h,
 		},
+		exportCache: newExportCache(cfg.ExportCacheSize),
+		idempotency: idempotency.NewStore(cfg.IdempotencyWindow),
+	}
+	if cfg.OIDCIssuer != "" {
+		staticWrapper.oidc = oidc.New(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.StorageURL+oidcCallbackPath)
 	}
 	return &staticWrapper
 }
 
+// SetWebhooks lets the admins list and replay the failed webhook deliveries
+func (w *ReactAppWrapper) SetWebhooks(webhooks webhookQueue) {
+	w.webhooks = webhooks
+}
+
+// SetJobs shares the background jobs with the app, a gc it schedules and one started here don't overlap
+func (w *ReactAppWrapper) SetJobs(registry *jobs.Registry) {
+	w.jobs = registry
+}
+
 // Open opens a file from the fs (virtual)
 func (w ReactAppWrapper) Open(filepath string) (http.File, error) {
 	fullpath := filepath
@@ -0,0 +1,96 @@
+package ui
+
+import (
+	"net/http"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func usageViewModel(u *storage.Usage) viewmodel.Usage {
+	return viewmodel.Usage{
+		Total:    u.Total,
+		ByType:   u.ByType,
+		Other:    u.Other,
+		Computed: u.Computed,
+	}
+}
+
+// getUsage the storage used by each user, broken down by document type
+func (app *ReactAppWrapper) getUsage(c *gin.Context) {
+	users, err := app.userStorer.GetUsers()
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	report := viewmodel.UsageReport{
+		Users: make([]viewmodel.UserUsage, 0, len(users)),
+	}
+	total := storage.Usage{ByType: make(map[string]int64)}
+	for _, u := range users {
+		usage, err := app.usageReporter.Usage(u.ID)
+		if err != nil {
+			log.Error("usage of ", u.ID, ": ", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		total.Add(usage)
+		userUsage, err := app.userUsage(u.ID, usage)
+		if err != nil {
+			log.Error("quota of ", u.ID, ": ", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		report.Users = append(report.Users, userUsage)
+	}
+	report.Total = usageViewModel(&total)
+	c.JSON(http.StatusOK, report)
+}
+
+func (app *ReactAppWrapper) userUsage(uid string, usage *storage.Usage) (viewmodel.UserUsage, error) {
+	quota, err := app.usageReporter.Quota(uid)
+	if err != nil {
+		return viewmodel.UserUsage{}, err
+	}
+	userUsage := viewmodel.UserUsage{
+		UserID: uid,
+		Usage:  usageViewModel(usage),
+		Quota:  quota,
+	}
+	if quota > 0 && usage.Total < quota {
+		userUsage.Left = quota - usage.Total
+	}
+	return userUsage, nil
+}
+
+// getUserUsage the storage used by one user and the quota, users can only query their own
+func (app *ReactAppWrapper) getUserUsage(c *gin.Context) {
+	uid := c.Param(useridParam)
+	if uid != c.GetString(userIDContextKey) && !IsAdmin(c) {
+		log.Warn("Only admins can query other users")
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	if _, err := app.userStorer.GetUser(uid); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, "Invalid user")
+		return
+	}
+
+	usage, err := app.usageReporter.Usage(uid)
+	if err != nil {
+		log.Error("usage of ", uid, ": ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	userUsage, err := app.userUsage(uid, usage)
+	if err != nil {
+		log.Error("quota of ", uid, ": ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, userUsage)
+}
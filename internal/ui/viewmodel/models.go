@@ -1,10 +1,13 @@
 package viewmodel
 
 import (
+	"encoding/json"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/models"
 	log "github.com/sirupsen/logrus"
 )
@@ -35,6 +38,76 @@ type DocumentTree struct {
 	Trash   []Entry
 }
 
+const (
+	// TrashExclude the documents without the trash
+	TrashExclude = "exclude"
+	// TrashInclude the documents and the trash
+	TrashInclude = "include"
+	// TrashOnly only the trash
+	TrashOnly = "only"
+)
+
+// FilterTrash drops the trash or everything but the trash
+func (t *DocumentTree) FilterTrash(filter string) {
+	switch filter {
+	case TrashExclude:
+		t.Trash = make([]Entry, 0)
+	case TrashOnly:
+		t.Entries = make([]Entry, 0)
+	}
+}
+
+// SetTrashed adds where the trashed entries were and when they were trashed, from the recorded ones
+func (t *DocumentTree) SetTrashed(trashed map[string]*storage.TrashedDocument) {
+	for _, e := range t.Trash {
+		var info *TrashInfo
+		var id string
+		switch entry := e.(type) {
+		case *Document:
+			info, id = &entry.TrashInfo, entry.ID
+		case *Directory:
+			info, id = &entry.TrashInfo, entry.ID
+		default:
+			continue
+		}
+		if r, ok := trashed[id]; ok {
+			deletedAt := r.DeletedAt
+			info.DeletedAt = &deletedAt
+			info.OriginalParent = r.OriginalParent
+		}
+	}
+}
+
+// TrashInfo set on the entries in the trash
+type TrashInfo struct {
+	Trashed bool `json:"trashed,omitempty"`
+	// DeletedAt when it was moved to the trash, the last modification if it wasn't recorded
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// OriginalParent the folder to restore to, empty for the root or if unknown
+	OriginalParent string `json:"originalParent,omitempty"`
+}
+
+// modifiedTime the modification time of the metadata, nil if there is none
+func modifiedTime(d *messages.RawMetadata) *time.Time {
+	t, err := time.Parse(time.RFC3339Nano, d.ModifiedClient)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// lastModified the lastModified of sync15 metadata, the tablet writes milliseconds
+func lastModified(value string) string {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n == 0 {
+		return ""
+	}
+	if n > 1e11 {
+		return time.Unix(0, n*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+	}
+	return time.Unix(n, 0).UTC().Format(time.RFC3339Nano)
+}
+
 func makeFolder(d *messages.RawMetadata) (entry *Directory) {
 	entry = &Directory{
 		ID:   d.ID,
@@ -56,29 +129,24 @@ func makeDocument(d *messages.RawMetadata) (entry Entry) {
 
 const trashID = "trash"
 
-// DocTreeFromHashTree from hash tree
-func DocTreeFromHashTree(tree *models.HashTree) *DocumentTree {
+// rawMetadataFromHashTree the metadata of the documents in the tree
+func rawMetadataFromHashTree(tree *models.HashTree) []*messages.RawMetadata {
 	docs := make([]*messages.RawMetadata, 0)
 	for _, d := range tree.Docs {
 		docs = append(docs, &messages.RawMetadata{
-			ID:           d.EntryName,
-			Parent:       d.MetadataFile.Parent,
-			VissibleName: d.MetadataFile.DocumentName,
-			Type:         d.MetadataFile.CollectionType,
+			ID:             d.EntryName,
+			Parent:         d.MetadataFile.Parent,
+			VissibleName:   d.MetadataFile.DocumentName,
+			Type:           d.MetadataFile.CollectionType,
+			ModifiedClient: lastModified(d.MetadataFile.LastModified),
 		})
 
 	}
-
-	return DocTreeFromRawMetadata(docs)
+	return docs
 }
 
-// DocTreeFromRawMetadata from raw metadata
-func DocTreeFromRawMetadata(documents []*messages.RawMetadata) *DocumentTree {
-	childParent := make(map[string]string)
-	folders := make(map[string]*Directory)
-	rootEntries := make([]Entry, 0)
-	trashEntries := make([]Entry, 0)
-
+// sortDocuments folders first, then by name
+func sortDocuments(documents []*messages.RawMetadata) {
 	sort.Slice(documents, func(i, j int) bool {
 		a, b := documents[i], documents[j]
 		if a.Type != b.Type {
@@ -87,6 +155,45 @@ func DocTreeFromRawMetadata(documents []*messages.RawMetadata) *DocumentTree {
 
 		return a.VissibleName < b.VissibleName
 	})
+}
+
+// resolveParents maps each document to its parent
+// folder loops (a->b->c->a) are broken by moving the folder to the root
+func resolveParents(documents []*messages.RawMetadata) map[string]string {
+	parents := make(map[string]string, len(documents))
+	for _, d := range documents {
+		parents[d.ID] = d.Parent
+	}
+
+	for _, d := range documents {
+		seen := map[string]bool{d.ID: true}
+		for p := parents[d.ID]; p != "" && p != trashID; p = parents[p] {
+			if seen[p] {
+				if p == d.ID {
+					log.Warn("loop detected: ", d.VissibleName, " moved to root")
+					parents[d.ID] = ""
+				}
+				break
+			}
+			seen[p] = true
+		}
+	}
+	return parents
+}
+
+// DocTreeFromHashTree from hash tree
+func DocTreeFromHashTree(tree *models.HashTree) *DocumentTree {
+	return DocTreeFromRawMetadata(rawMetadataFromHashTree(tree))
+}
+
+// DocTreeFromRawMetadata from raw metadata
+func DocTreeFromRawMetadata(documents []*messages.RawMetadata) *DocumentTree {
+	folders := make(map[string]*Directory)
+	rootEntries := make([]Entry, 0)
+	trashEntries := make([]Entry, 0)
+
+	sortDocuments(documents)
+	parents := resolveParents(documents)
 
 	// add all folders
 	for _, d := range documents {
@@ -106,9 +213,19 @@ func DocTreeFromRawMetadata(documents []*messages.RawMetadata) *DocumentTree {
 			entry = makeDocument(d)
 		}
 
-		parent := d.Parent
+		parent := parents[d.ID]
 
 		if parent == trashID {
+			info := TrashInfo{
+				Trashed:   true,
+				DeletedAt: modifiedTime(d),
+			}
+			switch e := entry.(type) {
+			case *Document:
+				e.TrashInfo = info
+			case *Directory:
+				e.TrashInfo = info
+			}
 			trashEntries = append(trashEntries, entry)
 			continue
 		}
@@ -120,20 +237,7 @@ func DocTreeFromRawMetadata(documents []*messages.RawMetadata) *DocumentTree {
 		}
 
 		if parent, ok := folders[parent]; ok {
-
-			//check for  loops and cross adds (a->b->c  c->a)
-			// if parentId, ok := childParent[parentId]; ok {
-			// 	//todo forloop
-			// 	if parentId == d.ID {
-			// 		log.Warn("loop detected: ", parentId, " -> ", d.ID)
-			// 		rootEntries = append(rootEntries, entry)
-			// 		continue
-			// 	}
-			// } else {
-			// }
-
 			parent.Entries = append(parent.Entries, entry)
-			childParent[d.ID] = d.Parent
 			continue
 		}
 
@@ -149,6 +253,68 @@ func DocTreeFromRawMetadata(documents []*messages.RawMetadata) *DocumentTree {
 	return &tree
 }
 
+// FolderNode a folder with its subfolders and documents
+type FolderNode struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	Folders   []*FolderNode `json:"folders"`
+	Documents []Document    `json:"documents"`
+}
+
+// FolderTreeFromHashTree from hash tree
+func FolderTreeFromHashTree(tree *models.HashTree) *FolderNode {
+	return FolderTreeFromRawMetadata(rawMetadataFromHashTree(tree))
+}
+
+// FolderTreeFromRawMetadata the folder hierarchy starting at the root, without the trash
+func FolderTreeFromRawMetadata(documents []*messages.RawMetadata) *FolderNode {
+	root := &FolderNode{
+		Folders:   make([]*FolderNode, 0),
+		Documents: make([]Document, 0),
+	}
+	folders := make(map[string]*FolderNode)
+
+	sortDocuments(documents)
+	parents := resolveParents(documents)
+
+	for _, d := range documents {
+		if d.Type == models.CollectionType {
+			folders[d.ID] = &FolderNode{
+				ID:        d.ID,
+				Name:      d.VissibleName,
+				Folders:   make([]*FolderNode, 0),
+				Documents: make([]Document, 0),
+			}
+		}
+	}
+
+	for _, d := range documents {
+		parentID := parents[d.ID]
+		if parentID == trashID {
+			continue
+		}
+		parent := root
+		if parentID != "" {
+			var ok bool
+			if parent, ok = folders[parentID]; !ok {
+				log.Warn(d.VissibleName, " parent not found: ", parentID)
+				parent = root
+			}
+		}
+
+		if folder, ok := folders[d.ID]; ok {
+			parent.Folders = append(parent.Folders, folder)
+			continue
+		}
+		parent.Documents = append(parent.Documents, Document{
+			ID:           d.ID,
+			Name:         d.VissibleName,
+			DocumentType: d.Type,
+		})
+	}
+	return root
+}
+
 // Entry just an entry
 type Entry interface {
 }
@@ -159,6 +325,7 @@ type Directory struct {
 	Name         string  `json:"name"`
 	Entries      []Entry `json:"children"`
 	LastModified time.Time
+	TrashInfo
 }
 
 // Document is a single document
@@ -168,6 +335,7 @@ type Document struct {
 	DocumentType string `json:"type"` //notebook, pdf, epub
 	LastModified time.Time
 	Size         int
+	TrashInfo
 }
 
 // DocumentList is a list of documents
@@ -183,6 +351,23 @@ type User struct {
 	NewPassword  string `json:"newpassword,omitempty"`
 	CreatedAt    time.Time
 	Integrations []string `json:"integrations,omitempty"`
+	// ExpiresAt sets a new expiry, RemoveExpiry removes it
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	Expired      bool       `json:"expired,omitempty"`
+	RemoveExpiry bool       `json:"removeExpiry,omitempty"`
+	Disabled     bool       `json:"disabled,omitempty"`
+	// TokensRevokedAt the device tokens issued before this are rejected
+	TokensRevokedAt *time.Time `json:"tokensRevokedAt,omitempty"`
+}
+
+// PasswordReset sets the password of a user, an empty one generates it
+type PasswordReset struct {
+	NewPassword string `json:"newpassword"`
+}
+
+// PasswordResetResult the generated password, only shown once
+type PasswordResetResult struct {
+	Password string `json:"password,omitempty"`
 }
 
 // NewUser new user creation
@@ -190,11 +375,145 @@ type NewUser struct {
 	ID          string `json:"userid" binding:"required"`
 	Email       string `json:"email" binding:"email"`
 	NewPassword string `json:"newpassword" binding:"required"`
+	// ExpiresAt overrides the default expiry of new accounts, NoExpiry never expires
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	NoExpiry  bool       `json:"noExpiry,omitempty"`
 }
 
-// UpdateDoc with somethin
+// UpdateDoc moves or renames a document
 type UpdateDoc struct {
 	DocumentID string `json:"documentId" binding:"required"`
-	ParentID   string `json:"parentId"`
-	Name       string `json:"name"`
+	// ParentID the target folder, empty for the root
+	ParentID string `json:"parentId"`
+	Name     string `json:"name" binding:"required"`
+}
+
+// MetadataRepair a document with broken metadata
+type MetadataRepair struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Problems []string `json:"problems"`
+	Repaired bool     `json:"repaired"`
+}
+
+// Orphan a document not referenced by the root index
+type Orphan struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Modified time.Time `json:"modified"`
+	Size     int64     `json:"size"`
+	Action   string    `json:"action,omitempty"`
+}
+
+// UploadResult what happened to an uploaded file
+type UploadResult struct {
+	FileName string `json:"fileName"`
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Action   string `json:"action"`
+	// BytesSaved by downscaling images of pdfs
+	BytesSaved int64 `json:"bytesSaved,omitempty"`
+	// Warning the file was stored, but might not open on the tablet
+	Warning string `json:"warning,omitempty"`
+}
+
+// DeleteResult what happened to a deleted document and its children
+type DeleteResult struct {
+	ID string `json:"id"`
+	// Parent where the children were moved to, empty for the root
+	Parent  string   `json:"parent"`
+	Moved   []string `json:"moved"`
+	Deleted []string `json:"deleted"`
+}
+
+// PageOrder the page ids of a notebook, in order
+type PageOrder struct {
+	Pages []string `json:"pages"`
+}
+
+// AuditEntry an admin action
+type AuditEntry struct {
+	Time     time.Time         `json:"time"`
+	Actor    string            `json:"actor"`
+	Category string            `json:"category"`
+	Action   string            `json:"action"`
+	Target   string            `json:"target,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	Status   int               `json:"status"`
+}
+
+// SyncStatus the current root generation and what each device has seen
+type SyncStatus struct {
+	Generation int64        `json:"generation"`
+	Devices    []DeviceSync `json:"devices"`
+}
+
+// Snapshot a past generation of the root
+type Snapshot struct {
+	Generation int64     `json:"generation"`
+	Hash       string    `json:"hash"`
+	Time       time.Time `json:"time"`
+	Documents  int       `json:"documents"`
+	// Complete false when the blobs were collected, it can't be restored
+	Complete bool `json:"complete"`
+	Current  bool `json:"current"`
+}
+
+// DeviceSync the generation a device last observed
+type DeviceSync struct {
+	DeviceID   string    `json:"deviceId"`
+	Generation int64     `json:"generation"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Lagging    bool      `json:"lagging"`
+}
+
+// AppConfig instance branding and features the ui fetches on load
+type AppConfig struct {
+	InstanceName string   `json:"instanceName"`
+	LogoURL      string   `json:"logoUrl,omitempty"`
+	ThemeColor   string   `json:"themeColor,omitempty"`
+	Features     []string `json:"features"`
+}
+
+// Usage bytes used, by document type
+type Usage struct {
+	Total    int64            `json:"total"`
+	ByType   map[string]int64 `json:"byType"`
+	Other    int64            `json:"other"`
+	Computed time.Time        `json:"computed,omitempty"`
+}
+
+// UserUsage the usage of one user
+type UserUsage struct {
+	UserID string `json:"userid"`
+	Usage
+	// Quota bytes the user can store, 0 is unlimited
+	Quota int64 `json:"quota"`
+	// Left bytes until the quota is reached, only with one
+	Left int64 `json:"left,omitempty"`
+}
+
+// UsageReport the usage of each user and of all of them
+type UsageReport struct {
+	Users []UserUsage `json:"users"`
+	Total Usage       `json:"total"`
+}
+
+// WebhookDelivery a webhook event which couldn't be delivered
+type WebhookDelivery struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Created   time.Time       `json:"created"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"lastError,omitempty"`
+}
+
+// AccountImport what the import of an account archive restored
+type AccountImport struct {
+	Documents int `json:"documents"`
+	Blobs     int `json:"blobs"`
+	// Generation the new generation of the root, sync15 only
+	Generation int64 `json:"generation,omitempty"`
 }
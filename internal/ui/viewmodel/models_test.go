@@ -0,0 +1,101 @@
+package viewmodel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+)
+
+func TestFolderTreeLoop(t *testing.T) {
+	docs := []*messages.RawMetadata{
+		{ID: "a", VissibleName: "a", Type: models.CollectionType, Parent: "c"},
+		{ID: "b", VissibleName: "b", Type: models.CollectionType, Parent: "a"},
+		{ID: "c", VissibleName: "c", Type: models.CollectionType, Parent: "b"},
+		{ID: "doc", VissibleName: "doc", Type: models.DocumentType, Parent: "c"},
+		{ID: "deleted", VissibleName: "deleted", Type: models.DocumentType, Parent: trashID},
+	}
+
+	root := FolderTreeFromRawMetadata(docs)
+
+	if len(root.Folders) != 1 || root.Folders[0].ID != "a" {
+		t.Fatalf("expected the loop to be broken at a, got %+v", root.Folders)
+	}
+	b := root.Folders[0].Folders
+	if len(b) != 1 || b[0].ID != "b" || len(b[0].Folders) != 1 {
+		t.Fatalf("expected a/b/c, got %+v", b)
+	}
+	c := b[0].Folders[0]
+	if len(c.Documents) != 1 || c.Documents[0].ID != "doc" {
+		t.Errorf("expected doc in c, got %+v", c.Documents)
+	}
+	if len(root.Documents) != 0 {
+		t.Errorf("trash should not be in the tree")
+	}
+}
+
+func TestDocTreeTrash(t *testing.T) {
+	docs := []*messages.RawMetadata{
+		{ID: "folder", VissibleName: "folder", Type: models.CollectionType},
+		{ID: "doc", VissibleName: "doc", Type: models.DocumentType, Parent: "folder"},
+		{ID: "deleted", VissibleName: "deleted", Type: models.DocumentType, Parent: trashID, ModifiedClient: "2022-01-02T03:04:05Z"},
+		{ID: "unknown", VissibleName: "unknown", Type: models.DocumentType, Parent: trashID},
+	}
+	deletedAt := time.Date(2022, 2, 3, 4, 5, 6, 0, time.UTC)
+	trashed := map[string]*storage.TrashedDocument{
+		"deleted": {OriginalParent: "folder", DeletedAt: deletedAt},
+	}
+
+	tree := DocTreeFromRawMetadata(docs)
+	tree.SetTrashed(trashed)
+	if len(tree.Entries) != 1 || len(tree.Trash) != 2 {
+		t.Fatalf("expected 1 entry and 2 in the trash, got %d %d", len(tree.Entries), len(tree.Trash))
+	}
+	for _, e := range tree.Trash {
+		d := e.(*Document)
+		if !d.Trashed {
+			t.Errorf("%s should be flagged", d.ID)
+		}
+		switch d.ID {
+		case "deleted":
+			if d.OriginalParent != "folder" || d.DeletedAt == nil || !d.DeletedAt.Equal(deletedAt) {
+				t.Errorf("wrong trash info %+v", d.TrashInfo)
+			}
+		case "unknown":
+			if d.OriginalParent != "" || d.DeletedAt != nil {
+				t.Errorf("wrong trash info %+v", d.TrashInfo)
+			}
+		}
+	}
+	if tree.Entries[0].(*Directory).Trashed {
+		t.Error("folder is not trashed")
+	}
+
+	tree.FilterTrash(TrashOnly)
+	if len(tree.Entries) != 0 || len(tree.Trash) != 2 {
+		t.Errorf("expected only the trash, got %d %d", len(tree.Entries), len(tree.Trash))
+	}
+	tree = DocTreeFromRawMetadata(docs)
+	tree.FilterTrash(TrashExclude)
+	if len(tree.Entries) != 1 || len(tree.Trash) != 0 {
+		t.Errorf("expected no trash, got %d %d", len(tree.Entries), len(tree.Trash))
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"", ""},
+		{"1641092645", "2022-01-02T03:04:05Z"},
+		{"1641092645123", "2022-01-02T03:04:05.123Z"},
+	}
+	for _, tt := range tests {
+		if got := lastModified(tt.value); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.value, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package ui
+
+import (
+	"net/http"
+
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/ddvk/rmfakecloud/internal/webhook"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const deliveryParam = "deliveryid"
+
+// listFailedWebhooks the webhook deliveries given up, the oldest first
+func (app *ReactAppWrapper) listFailedWebhooks(c *gin.Context) {
+	result := make([]viewmodel.WebhookDelivery, 0)
+	if app.webhooks == nil {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	deliveries, err := app.webhooks.Failed()
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	for _, d := range deliveries {
+		result = append(result, viewmodel.WebhookDelivery{
+			ID:        d.ID,
+			Event:     d.Event,
+			Payload:   d.Body,
+			Created:   d.Created,
+			Attempts:  d.Attempts,
+			LastError: d.LastError,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// replayWebhook queues a failed delivery again
+func (app *ReactAppWrapper) replayWebhook(c *gin.Context) {
+	id := c.Param(deliveryParam)
+	auditTarget(c, id)
+	if app.webhooks == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no webhook configured"})
+		return
+	}
+	err := app.webhooks.Replay(id)
+	if err == webhook.ErrorNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Info(uiLogger, "replaying the webhook delivery ", id)
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,371 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SignatureHeader sha256= and the hex encoded hmac-sha256 of the body with the secret
+	SignatureHeader = "X-Rmfakecloud-Signature"
+	// EventHeader the type of the event
+	EventHeader = "X-Rmfakecloud-Event"
+	// DeliveryHeader the id of the delivery, the same for all the attempts
+	DeliveryHeader = "X-Rmfakecloud-Delivery"
+
+	queueDir  = "webhooks/queue"
+	failedDir = "webhooks/failed"
+	fileExt   = ".json"
+
+	timeout      = 10 * time.Second
+	firstBackoff = 30 * time.Second
+	maxBackoff   = time.Hour
+)
+
+// ErrorNotFound no such failed delivery
+var ErrorNotFound = errors.New("delivery not found")
+
+// Metadata of the document of the event
+type Metadata struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Parent  string `json:"parent"`
+	Version int    `json:"version"`
+}
+
+// Payload the body of the POST
+type Payload struct {
+	// ID of the delivery, receivers can drop the ones they already got
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	UserID     string    `json:"userId"`
+	DocumentID string    `json:"documentId,omitempty"`
+	Metadata   *Metadata `json:"metadata,omitempty"`
+	// Generation of the sync15 root
+	Generation int64 `json:"generation,omitempty"`
+}
+
+// Delivery an event to send, kept on disk until the receiver took it or it is given up
+type Delivery struct {
+	ID          string          `json:"id"`
+	Event       string          `json:"event"`
+	Body        json.RawMessage `json:"body"`
+	Created     time.Time       `json:"created"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+}
+
+// Dispatcher sends the storage events to the webhook, retrying with backoff
+type Dispatcher struct {
+	url         string
+	secret      []byte
+	events      map[string]bool
+	maxAttempts int
+	queue       string
+	failed      string
+	client      *http.Client
+	// lock the deliveries are moved between the queue and the failed ones
+	lock sync.Mutex
+	wake chan struct{}
+	// backoff the wait after the attempts failed
+	backoff func(attempts int) time.Duration
+}
+
+// New a dispatcher for the configured webhook, the deliveries not sent yet are kept in the data dir
+func New(cfg *config.Config) (*Dispatcher, error) {
+	d := &Dispatcher{
+		url:         cfg.WebhookURL,
+		secret:      cfg.WebhookSecret,
+		maxAttempts: cfg.WebhookMaxAttempts,
+		queue:       filepath.Join(cfg.DataDir, queueDir),
+		failed:      filepath.Join(cfg.DataDir, failedDir),
+		client:      &http.Client{Timeout: timeout},
+		wake:        make(chan struct{}, 1),
+		backoff:     backoff,
+	}
+	if cfg.WebhookEvents != nil {
+		d.events = make(map[string]bool)
+		for _, e := range cfg.WebhookEvents {
+			d.events[e] = true
+		}
+	}
+	for _, dir := range []string{d.queue, d.failed} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// backoff doubles from firstBackoff up to maxBackoff
+func backoff(attempts int) time.Duration {
+	wait := firstBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// Sign the value of the SignatureHeader
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Event queues the event, storage.EventListener
+func (d *Dispatcher) Event(e *storage.Event) {
+	if d.events != nil && !d.events[e.Type] {
+		return
+	}
+	payload := Payload{
+		ID:         uuid.NewString(),
+		Event:      e.Type,
+		Time:       e.Time,
+		UserID:     e.UserID,
+		DocumentID: e.DocumentID,
+		Generation: e.Generation,
+	}
+	if e.DocumentID != "" {
+		payload.Metadata = &Metadata{
+			Name:    e.Name,
+			Type:    e.DocumentType,
+			Parent:  e.Parent,
+			Version: e.Version,
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Error("webhook: ", err)
+		return
+	}
+	delivery := &Delivery{
+		ID:          payload.ID,
+		Event:       e.Type,
+		Body:        body,
+		Created:     time.Now().UTC(),
+		NextAttempt: time.Now().UTC(),
+	}
+
+	d.lock.Lock()
+	err = d.save(d.queue, delivery)
+	d.lock.Unlock()
+	if err != nil {
+		log.Error("webhook: can't queue ", e.Type, " of ", e.UserID, ": ", err)
+		return
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save writes the delivery to the dir, replacing it
+func (d *Dispatcher) save(dir string, delivery *Delivery) error {
+	content, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, delivery.ID+fileExt))
+}
+
+// load the deliveries of the dir, the oldest first
+func load(dir string) ([]*Delivery, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]*Delivery, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		delivery := &Delivery{}
+		if err = json.Unmarshal(content, delivery); err != nil {
+			log.Warn("webhook: skipping ", f.Name(), ": ", err)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].Created.Before(deliveries[j].Created)
+	})
+	return deliveries, nil
+}
+
+// send posts the delivery once
+func (d *Dispatcher) send(delivery *Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(d.secret, delivery.Body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deliverDue sends the deliveries due at now, returns when the next one is due, zero if none is left
+func (d *Dispatcher) deliverDue(now time.Time) time.Time {
+	d.lock.Lock()
+	deliveries, err := load(d.queue)
+	d.lock.Unlock()
+	if err != nil {
+		log.Error("webhook: ", err)
+		return now.Add(firstBackoff)
+	}
+
+	var next time.Time
+	for _, delivery := range deliveries {
+		if delivery.NextAttempt.After(now) {
+			if next.IsZero() || delivery.NextAttempt.Before(next) {
+				next = delivery.NextAttempt
+			}
+			continue
+		}
+		err = d.send(delivery)
+
+		d.lock.Lock()
+		queued := filepath.Join(d.queue, delivery.ID+fileExt)
+		switch {
+		case err == nil:
+			log.Debug("webhook: delivered ", delivery.Event, " ", delivery.ID)
+			os.Remove(queued)
+		case delivery.Attempts+1 >= d.maxAttempts:
+			delivery.Attempts++
+			delivery.LastError = err.Error()
+			log.Error("webhook: giving up ", delivery.Event, " ", delivery.ID, " after ", delivery.Attempts, " attempts: ", err)
+			if err1 := d.save(d.failed, delivery); err1 != nil {
+				log.Error("webhook: ", err1)
+			} else {
+				os.Remove(queued)
+			}
+		default:
+			delivery.Attempts++
+			delivery.LastError = err.Error()
+			delivery.NextAttempt = now.Add(d.backoff(delivery.Attempts))
+			log.Warn("webhook: ", delivery.Event, " ", delivery.ID, " failed, retrying at ", delivery.NextAttempt.Format(time.RFC3339), ": ", err)
+			if err1 := d.save(d.queue, delivery); err1 != nil {
+				log.Error("webhook: ", err1)
+			}
+			if next.IsZero() || delivery.NextAttempt.Before(next) {
+				next = delivery.NextAttempt
+			}
+		}
+		d.lock.Unlock()
+	}
+	return next
+}
+
+// Run sends the queued deliveries until stop is closed, including the ones of before a restart
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+	log.Info("Sending the document events to the webhook: ", d.url)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-d.wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-stop:
+			return
+		}
+		now := time.Now()
+		next := d.deliverDue(now)
+		if next.IsZero() {
+			// until the next event
+			next = now.Add(maxBackoff)
+		}
+		timer.Reset(next.Sub(now))
+	}
+}
+
+// Failed the deliveries given up, the oldest first
+func (d *Dispatcher) Failed() ([]*Delivery, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return load(d.failed)
+}
+
+// Replay queues a failed delivery again, it gets all the attempts again
+func (d *Dispatcher) Replay(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	failed := filepath.Join(d.failed, filepath.Base(id)+fileExt)
+	content, err := ioutil.ReadFile(failed)
+	if os.IsNotExist(err) {
+		return ErrorNotFound
+	}
+	if err != nil {
+		return err
+	}
+	delivery := &Delivery{}
+	if err = json.Unmarshal(content, delivery); err != nil {
+		return err
+	}
+	delivery.Attempts = 0
+	delivery.NextAttempt = time.Now().UTC()
+	if err = d.save(d.queue, delivery); err != nil {
+		return err
+	}
+	os.Remove(failed)
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+func TestDispatcher(t *testing.T) {
+	secret := []byte("secret")
+	var failures, received int32
+	var payload Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign(secret, body) {
+			t.Error("wrong signature")
+		}
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		atomic.AddInt32(&received, 1)
+		json.Unmarshal(body, &payload)
+		if r.Header.Get(DeliveryHeader) != payload.ID || r.Header.Get(EventHeader) != payload.Event {
+			t.Errorf("headers %v", r.Header)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		DataDir:            t.TempDir(),
+		WebhookURL:         server.URL,
+		WebhookSecret:      secret,
+		WebhookEvents:      []string{storage.EventDocumentUploaded},
+		WebhookMaxAttempts: 3,
+	}
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.backoff = func(int) time.Duration { return time.Minute }
+
+	d.Event(&storage.Event{Type: storage.EventRootGeneration, UserID: "test", Generation: 2})
+	d.Event(&storage.Event{Type: storage.EventDocumentUploaded, UserID: "test", DocumentID: "doc", Name: "notes"})
+
+	// fails once, retried after the backoff
+	atomic.StoreInt32(&failures, 1)
+	now := time.Now()
+	next := d.deliverDue(now)
+	if received != 0 || !next.Equal(now.Add(time.Minute)) {
+		t.Fatalf("received %d, next %v", received, next)
+	}
+	if next = d.deliverDue(now.Add(30 * time.Second)); received != 0 {
+		t.Fatal("retried before the backoff")
+	}
+	if next = d.deliverDue(now.Add(time.Minute)); received != 1 || !next.IsZero() {
+		t.Fatalf("received %d, next %v", received, next)
+	}
+	if payload.UserID != "test" || payload.DocumentID != "doc" || payload.Metadata == nil || payload.Metadata.Name != "notes" {
+		t.Errorf("payload %+v", payload)
+	}
+
+	// given up after the max attempts
+	atomic.StoreInt32(&failures, 3)
+	d.Event(&storage.Event{Type: storage.EventDocumentUploaded, UserID: "test", DocumentID: "other"})
+	for i := 0; i < 3; i++ {
+		d.deliverDue(now.Add(time.Duration(i+1) * time.Hour))
+	}
+	failed, err := d.Failed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].Attempts != 3 || failed[0].LastError == "" {
+		t.Fatalf("failed %+v", failed)
+	}
+
+	if err = d.Replay("missing"); err != ErrorNotFound {
+		t.Errorf("replay missing: %v", err)
+	}
+	if err = d.Replay(failed[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	d.deliverDue(time.Now().Add(time.Second))
+	if received != 2 || payload.DocumentID != "other" {
+		t.Errorf("replayed %d %+v", received, payload)
+	}
+	if failed, _ = d.Failed(); len(failed) != 0 {
+		t.Errorf("still failed %+v", failed)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  firstBackoff,
+		2:  2 * firstBackoff,
+		4:  8 * firstBackoff,
+		20: maxBackoff,
+	} {
+		if got := backoff(attempts); got != want {
+			t.Errorf("%d attempts: %v, want %v", attempts, got, want)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import NoMatch from "./components/NoMatch";
 
 import { BrowserRouter as Router, Route, Switch } from "react-router-dom";
 import { AuthProvider } from "./common/useAuthContext";
+import { AppConfigProvider } from "./common/useAppConfig";
 import { PrivateRoute } from "./components/PrivateRoute";
 import CodeGenerator from "./components/CodeGenerator";
 import ResetPassword from "./components/ResetPassword";
@@ -24,7 +25,7 @@ export default function App() {
     apiService.checkLogin()
   }, [])
   return (
-    <>
+    <AppConfigProvider>
     <AuthProvider>
       <Router>
         <Navigationbar />
@@ -43,6 +44,6 @@ export default function App() {
       </Router>
     </AuthProvider>
     <ToastContainer autoClose={2000} />
-    </>
+    </AppConfigProvider>
   );
 }
@@ -17,6 +17,18 @@ export async function loginUser(dispatch, loginPayload) {
   }
 }
 
+export function ssoLogin(dispatch, token) {
+  try {
+    let user = apiService.ssoLogin(token);
+    dispatch({
+      type: "LOGIN_SUCCESS",
+      payload: { user: user },
+    });
+  } catch (error) {
+    dispatch({ type: "LOGIN_ERROR", error: "Can't login: " + error.message });
+  }
+}
+
 export async function logout(dispatch) {
   await apiService.logout()
   dispatch({ type: "LOGOUT" });
@@ -0,0 +1,48 @@
+import React, { useEffect, useState } from "react";
+import apiService from "../services/api.service";
+
+// the branding and features of the instance, until /config answered
+export const defaultConfig = {
+  instanceName: "rmfakecloud",
+  logoUrl: "",
+  themeColor: "",
+  features: [],
+};
+
+const AppConfigContext = React.createContext(defaultConfig);
+
+export function useAppConfig() {
+  return React.useContext(AppConfigContext);
+}
+
+// hasFeature whether the instance has the feature enabled, e.g. "oidc"
+export function hasFeature(config, feature) {
+  return (config.features || []).includes(feature);
+}
+
+export const AppConfigProvider = ({ children }) => {
+  const [config, setConfig] = useState(defaultConfig);
+
+  useEffect(() => {
+    apiService
+      .config()
+      .then((loaded) => setConfig({ ...defaultConfig, ...loaded }))
+      .catch((e) => console.error("can't load the config: ", e));
+  }, []);
+
+  useEffect(() => {
+    document.title = config.instanceName;
+    if (config.themeColor) {
+      const meta = document.querySelector('meta[name="theme-color"]');
+      if (meta) {
+        meta.setAttribute("content", config.themeColor);
+      }
+    }
+  }, [config]);
+
+  return (
+    <AppConfigContext.Provider value={config}>
+      {children}
+    </AppConfigContext.Provider>
+  );
+};
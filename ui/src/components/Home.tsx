@@ -1,11 +1,23 @@
 import React from "react";
 import Container from "react-bootstrap/Container";
+import { useAppConfig, hasFeature } from "../common/useAppConfig";
 
 const Home = () => {
+  const config = useAppConfig();
   return (
     <Container>
       <div>
-        <h3>Welcome to your own rm cloud</h3>
+        <h3>Welcome to {config.instanceName}</h3>
+        <ul>
+          <li>
+            Sending documents by email from the tablet is{" "}
+            {hasFeature(config, "email") ? "enabled" : "not configured"}
+          </li>
+          <li>
+            Handwriting recognition is{" "}
+            {hasFeature(config, "hwr") ? "enabled" : "not configured"}
+          </li>
+        </ul>
         <h3>About</h3>
         <p>This is still a work in progress</p>
         <h3>TODO</h3>
@@ -1,6 +1,7 @@
-import React, { useState } from "react";
+import React, { useEffect, useState } from "react";
 import { useAuthState } from "../../common/useAuthContext";
-import { loginUser } from "../../common/actions";
+import { loginUser, ssoLogin } from "../../common/actions";
+import { useAppConfig, hasFeature } from "../../common/useAppConfig";
 import styles from "./Login.module.css";
 import { useHistory } from "react-router";
 
@@ -8,9 +9,26 @@ const Login = () => {
   let history = useHistory();
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
+  const [ssoError, setSsoError] = useState(null);
 
   const { state, dispatch } = useAuthState(); //read the values of loading and errorMessage from context
   const { errorMessage, loading } = state;
+  const sso = hasFeature(useAppConfig(), "oidc");
+
+  useEffect(() => {
+    // the single sign-on redirects back with the token or the error in the fragment
+    const params = new URLSearchParams(window.location.hash.substring(1));
+    if (!params.has("token") && !params.has("error")) {
+      return;
+    }
+    window.history.replaceState(null, "", window.location.pathname);
+    if (params.has("token")) {
+      ssoLogin(dispatch, params.get("token"));
+      history.push("/");
+    } else {
+      setSsoError("Can't login: " + params.get("error"));
+    }
+  }, [dispatch, history]);
 
   const handleLogin = async (e) => {
     e.preventDefault();
@@ -28,6 +46,7 @@ const Login = () => {
     <div className={styles.container}>
       <div style={{ width: 200 }}>
         {errorMessage ? <p className={styles.error}>{errorMessage}</p> : null}
+        {ssoError ? <p className={styles.error}>{ssoError}</p> : null}
         <form>
           <div className={styles.loginForm}>
             <div className={styles.loginFormItem}>
@@ -56,6 +75,11 @@ const Login = () => {
             login
           </button>
         </form>
+        {sso ? (
+          <p>
+            <a href="/ui/api/oidc/login">login with single sign-on</a>
+          </p>
+        ) : null}
       </div>
     </div>
   );
@@ -2,10 +2,12 @@ import React from "react";
 import { Nav, Navbar, Button, NavDropdown } from "react-bootstrap";
 import { logout } from "../common/actions";
 import { useAuthState } from "../common/useAuthContext";
+import { useAppConfig } from "../common/useAppConfig";
 import { NavLink } from "react-router-dom";
 
 const NavigationBar = () => {
   const { state:{user}, dispatch } = useAuthState();
+  const config = useAppConfig();
 
   function isAdmin(user) {
     return user && user.Roles && user.Roles[0] === "Admin";
@@ -16,8 +18,22 @@ const NavigationBar = () => {
   }
 
   return (
-    <Navbar bg="dark" variant="dark">
-      <Navbar.Brand href="/">rmfakecloud</Navbar.Brand>
+    <Navbar
+      bg={config.themeColor ? undefined : "dark"}
+      style={config.themeColor ? { backgroundColor: config.themeColor } : undefined}
+      variant="dark"
+    >
+      <Navbar.Brand href="/">
+        {config.logoUrl && (
+          <img
+            src={config.logoUrl}
+            alt=""
+            height="30"
+            className="d-inline-block align-top mr-2"
+          />
+        )}
+        {config.instanceName}
+      </Navbar.Brand>
       <Navbar.Toggle />
       {user && (
         <>
@@ -74,6 +74,45 @@ export default function UserList() {
         toast.error('Error:'+ e)
     }
   }
+  const disable = async (e, user) => {
+    e.preventDefault()
+    e.stopPropagation()
+    try{
+      await apiService.disableuser(user.userid, !user.disabled)
+      refresh()
+    } catch(e){
+        toast.error('Error:'+ e)
+    }
+  }
+
+  const resetPassword = async (e, id) => {
+    e.preventDefault()
+    e.stopPropagation()
+    if (!window.confirm(`Generate a new password for user: ${id}?`))
+      return false
+
+    try{
+      const result = await apiService.resetpassword(id)
+      window.prompt(`New password of ${id}, it is only shown once:`, result.password)
+    } catch(e){
+        toast.error('Error:'+ e)
+    }
+  }
+
+  const revoke = async (e, id) => {
+    e.preventDefault()
+    e.stopPropagation()
+    if (!window.confirm(`Revoke the device tokens of user: ${id}? The devices have to be paired again.`))
+      return false
+
+    try{
+      await apiService.revoketokens(id)
+      toast.success('Device tokens revoked')
+      refresh()
+    } catch(e){
+        toast.error('Error:'+ e)
+    }
+  }
   // const handleSave = async e => {
   //   e.preventDefault()
   //   try {
@@ -106,6 +145,7 @@ export default function UserList() {
           <th>Email</th>
           <th>Name</th>
           <th>Created At</th>
+          <th>Status</th>
             <th><Button onClick={newUser}>New User</Button></th>
         </tr>
         </thead>
@@ -117,7 +157,13 @@ export default function UserList() {
               <td>{x.email}</td>
               <td>{x.Name}</td>
               <td>{formatDate(x.CreatedAt)}</td>
-              <td><Button variant="danger" onClick={(e) => remove(e,x.userid)}>Delete</Button></td>
+              <td>{x.disabled ? "Disabled" : x.expired ? "Expired" : "Active"}</td>
+              <td>
+                <Button variant="secondary" onClick={(e) => disable(e,x)}>{x.disabled ? "Enable" : "Disable"}</Button>{" "}
+                <Button variant="secondary" onClick={(e) => resetPassword(e,x.userid)}>Reset Password</Button>{" "}
+                <Button variant="warning" onClick={(e) => revoke(e,x.userid)}>Revoke Devices</Button>{" "}
+                <Button variant="danger" onClick={(e) => remove(e,x.userid)}>Delete</Button>
+              </td>
             </tr>
           ))}
         </tbody>
@@ -33,6 +33,22 @@ class ApiServices {
       });
   }
 
+  config() {
+    return fetch(`${constants.ROOT_URL}/config`).then((r) => {
+      if (!r.ok) {
+        throw new Error(r.statusText);
+      }
+      return r.json();
+    });
+  }
+
+  // ssoLogin keeps the token the single sign-on redirected back with
+  ssoLogin(token) {
+    let user = jwt_decode(token);
+    localStorage.setItem("currentUser", JSON.stringify(user));
+    return user;
+  }
+
   logout() {
     removeUser();
     fetch(`${constants.ROOT_URL}/logout`);
@@ -109,6 +125,28 @@ class ApiServices {
       headers: this.header(),
     }).then((r) => handleError(r));
   }
+  disableuser(userid, disabled) {
+    const action = disabled ? "disable" : "enable";
+    return fetch(`${constants.ROOT_URL}/users/${userid}/${action}`, {
+      method: "POST",
+      headers: this.header(),
+    }).then((r) => handleError(r));
+  }
+  resetpassword(userid) {
+    return fetch(`${constants.ROOT_URL}/users/${userid}/password`, {
+      method: "POST",
+      headers: this.header(),
+    }).then((r) => {
+      handleError(r);
+      return r.json();
+    });
+  }
+  revoketokens(userid) {
+    return fetch(`${constants.ROOT_URL}/users/${userid}/revoke`, {
+      method: "POST",
+      headers: this.header(),
+    }).then((r) => handleError(r));
+  }
 }
 
 function removeUser(){